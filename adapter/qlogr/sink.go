@@ -0,0 +1,108 @@
+// Package qlogr provides logr.LogSink implementation writing to a qlog
+// Notepad:
+//
+//	log := logr.New(qlogr.New(np))
+package qlogr
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/karantin2020/qlog"
+)
+
+// Sink is logr.LogSink implementation. V(0) is logged at qlog Info level,
+// any greater verbosity at qlog Debug level.
+type Sink struct {
+	np     *qlog.Notepad
+	values []qlog.F
+	depth  int
+}
+
+// New returns new Sink writing to np
+func New(np *qlog.Notepad) *Sink {
+	return &Sink{np: np}
+}
+
+// Init receives runtime info from logr
+func (s *Sink) Init(info logr.RuntimeInfo) {
+	s.depth = info.CallDepth
+}
+
+// Enabled reports whether messages at verbosity level are logged
+func (s *Sink) Enabled(level int) bool {
+	return s.logger(level).Enabled()
+}
+
+// Info logs non-error message with the given key/value pairs
+func (s *Sink) Info(level int, msg string, keysAndValues ...interface{}) {
+	l := s.logger(level)
+	if !l.Enabled() {
+		return
+	}
+	e := l.Fields(s.values...)
+	e.Fields(Fields(keysAndValues)...)
+	e.Msg(msg)
+}
+
+// Error logs error message with the given key/value pairs. The error is
+// stored under LogConfig.ErrorFieldName of the notepad.
+func (s *Sink) Error(err error, msg string, keysAndValues ...interface{}) {
+	l := s.np.ERROR
+	if !l.Enabled() {
+		return
+	}
+	e := l.Fields(s.values...)
+	e.Fields(Fields(keysAndValues)...)
+	if err != nil {
+		e.Err(err)
+	}
+	e.Msg(msg)
+}
+
+// WithValues returns new Sink with additional key/value pairs
+func (s *Sink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	ns := *s
+	ns.values = make([]qlog.F, 0, len(s.values)+len(keysAndValues)/2)
+	ns.values = append(ns.values, s.values...)
+	ns.values = append(ns.values, Fields(keysAndValues)...)
+	return &ns
+}
+
+// WithName returns new Sink with name appended to the notepad name.
+// Names are joined with a dot.
+func (s *Sink) WithName(name string) logr.LogSink {
+	ns := *s
+	ns.np = s.np.WithFields()
+	if cur := s.np.Snapshot().Name; len(cur) > 0 {
+		ns.np.SetName(string(cur) + "." + name)
+	} else {
+		ns.np.SetName(name)
+	}
+	return &ns
+}
+
+func (s *Sink) logger(level int) *qlog.Logger {
+	if level > 0 {
+		return s.np.DEBUG
+	}
+	return s.np.INFO
+}
+
+// Fields converts logr key/value pairs to qlog fields. Non-string keys
+// are formatted with fmt, a key without value gets nil value.
+func Fields(keysAndValues []interface{}) []qlog.F {
+	flds := make([]qlog.F, 0, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keysAndValues[i])
+		}
+		var val interface{}
+		if i+1 < len(keysAndValues) {
+			val = keysAndValues[i+1]
+		}
+		flds = append(flds, qlog.F{Key: key, Value: val})
+	}
+	return flds
+}
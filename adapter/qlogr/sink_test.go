@@ -0,0 +1,89 @@
+package qlogr_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/adapter/qlogr"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSink(lvl uint8) (*qlogr.Sink, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	np := qlog.New("app", lvl).SetOutput(qlog.MustJson(func(o *qlog.JsonOptions) error {
+		o.OutHandle = out
+		o.ErrHandle = out
+		o.OutLevel = lvl
+		return nil
+	}))
+	return qlogr.New(np), out
+}
+
+func decode(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	var res []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(b.Bytes()), []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(line, &m); err != nil {
+			t.Fatalf("invalid json line %q: %v", line, err)
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func TestSink_Conformance(t *testing.T) {
+	var _ logr.LogSink = qlogr.New(qlog.New("", qlog.InfoLevel))
+}
+
+func TestSink_Levels(t *testing.T) {
+	sink, out := newSink(qlog.InfoLevel)
+	l := logr.New(sink)
+	assert.True(t, l.Enabled())
+	assert.False(t, l.V(1).Enabled())
+
+	l.Info("info msg", "k", 1)
+	l.V(1).Info("debug msg")
+	l.Error(errors.New("boom"), "error msg", "odd")
+
+	lines := decode(t, out)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "info", lines[0]["l"])
+		assert.Equal(t, "info msg", lines[0]["m"])
+		assert.Equal(t, float64(1), lines[0]["k"])
+		assert.Equal(t, "error", lines[1]["l"])
+		assert.Equal(t, "boom", lines[1]["error"])
+		assert.Contains(t, lines[1], "odd")
+	}
+	assert.Equal(t, 1, strings.Count(out.String(), `"boom"`))
+
+	sink, out = newSink(qlog.DebugLevel)
+	logr.New(sink).V(2).Info("debug msg")
+	lines = decode(t, out)
+	if assert.Len(t, lines, 1) {
+		assert.Equal(t, "debug", lines[0]["l"])
+	}
+}
+
+func TestSink_WithValuesAndName(t *testing.T) {
+	sink, out := newSink(qlog.InfoLevel)
+	l := logr.New(sink)
+	child := l.WithName("db").WithName("pool").WithValues("conn", "primary")
+	child.Info("connected")
+	l.Info("plain")
+
+	lines := decode(t, out)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "app.db.pool", lines[0]["n"])
+		assert.Equal(t, "primary", lines[0]["conn"])
+		assert.Equal(t, "app", lines[1]["n"])
+		assert.NotContains(t, lines[1], "conn")
+	}
+}
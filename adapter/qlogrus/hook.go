@@ -0,0 +1,119 @@
+// Package qlogrus bridges logrus entries to qlog.
+//
+// Hook forwards entries of a logrus Logger to a qlog Notepad, Formatter
+// renders them with qlog Json output:
+//
+//	logger.AddHook(qlogrus.NewHook(np))
+//	logger.SetFormatter(qlogrus.NewFormatter("app"))
+package qlogrus
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/karantin2020/qlog"
+	"github.com/sirupsen/logrus"
+)
+
+type hook struct {
+	np *qlog.Notepad
+}
+
+// NewHook returns Hook sending logrus entries to np. Trace entries
+// are logged at Debug level. Panic and Fatal entries are logged at
+// their levels without panicking or exiting, logrus does that itself.
+func NewHook(np *qlog.Notepad) logrus.Hook {
+	return &hook{np: np}
+}
+
+func (h *hook) Levels() []logrus.Level {
+	lvls := make([]logrus.Level, 0, len(logrus.AllLevels))
+	for _, lvl := range logrus.AllLevels {
+		if logger(h.np, lvl).Enabled() {
+			lvls = append(lvls, lvl)
+		}
+	}
+	return lvls
+}
+
+func (h *hook) Fire(e *logrus.Entry) error {
+	write(h.np, e)
+	return nil
+}
+
+type formatter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	np  *qlog.Notepad
+}
+
+// NewFormatter returns Formatter producing qlog Json lines or error of
+// an option. Options are applied to Json output, writers and levels set
+// in them are ignored.
+func NewFormatter(name string, opts ...func(*qlog.JsonOptions) error) (logrus.Formatter, error) {
+	f := &formatter{}
+	opts = append(opts, func(o *qlog.JsonOptions) error {
+		o.OutHandle = &f.buf
+		o.ErrHandle = &f.buf
+		o.OutLevel = qlog.DebugLevel
+		return nil
+	})
+	out, err := qlog.Json(opts...)
+	if err != nil {
+		return nil, err
+	}
+	f.np = qlog.New(name, qlog.DebugLevel).SetOutput(out)
+	return f, nil
+}
+
+func (f *formatter) Format(e *logrus.Entry) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf.Reset()
+	write(f.np, e)
+	return append([]byte(nil), f.buf.Bytes()...), nil
+}
+
+func logger(np *qlog.Notepad, lvl logrus.Level) *qlog.Logger {
+	switch lvl {
+	case logrus.PanicLevel:
+		return np.PANIC
+	case logrus.FatalLevel:
+		return np.FATAL
+	case logrus.ErrorLevel:
+		return np.ERROR
+	case logrus.WarnLevel:
+		return np.WARN
+	case logrus.InfoLevel:
+		return np.INFO
+	default:
+		return np.DEBUG
+	}
+}
+
+// write logs e with np. Data keys are sorted to keep output stable,
+// logrus ErrorKey is renamed to LogConfig.ErrorFieldName.
+func write(np *qlog.Notepad, e *logrus.Entry) {
+	l := logger(np, e.Level)
+	if !l.Enabled() {
+		return
+	}
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	qe := l.NewEntry()
+	for _, k := range keys {
+		v := e.Data[k]
+		if k == logrus.ErrorKey {
+			if err, ok := v.(error); ok {
+				qe.Err(err)
+				continue
+			}
+		}
+		qe.AddField(qlog.F{Key: k, Value: v})
+	}
+	qe.Msg(e.Message)
+}
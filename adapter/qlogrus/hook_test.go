@@ -0,0 +1,95 @@
+package qlogrus_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/adapter/qlogrus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	var res []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(b.Bytes()), []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(line, &m); err != nil {
+			t.Fatalf("invalid json line %q: %v", line, err)
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func TestConformance(t *testing.T) {
+	var _ logrus.Hook = qlogrus.NewHook(qlog.New("", qlog.InfoLevel))
+	f, err := qlogrus.NewFormatter("")
+	assert.NoError(t, err)
+	var _ logrus.Formatter = f
+}
+
+func TestHook(t *testing.T) {
+	out := &bytes.Buffer{}
+	np := qlog.New("app", qlog.InfoLevel).SetOutput(qlog.MustJson(func(o *qlog.JsonOptions) error {
+		o.OutHandle = out
+		o.ErrHandle = out
+		return nil
+	}))
+	h := qlogrus.NewHook(np)
+	assert.Equal(t, []logrus.Level{logrus.PanicLevel, logrus.FatalLevel,
+		logrus.ErrorLevel, logrus.WarnLevel, logrus.InfoLevel}, h.Levels())
+
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	l.SetLevel(logrus.TraceLevel)
+	l.AddHook(h)
+	l.Debug("skipped")
+	l.WithFields(logrus.Fields{"b": 2, "a": "x"}).Info("info msg")
+	// Entry.Log does not exit at Fatal level
+	l.WithError(errors.New("boom")).Log(logrus.FatalLevel, "fatal msg")
+
+	assert.Equal(t, `{"n":"app","t":`, out.String()[:15])
+	lines := decode(t, out)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "info", lines[0]["l"])
+		assert.Equal(t, "x", lines[0]["a"])
+		assert.Equal(t, float64(2), lines[0]["b"])
+		assert.Equal(t, "fatal", lines[1]["l"])
+		assert.Equal(t, "boom", lines[1]["error"])
+	}
+	assert.Equal(t, 1, strings.Count(out.String(), `"boom"`))
+}
+
+func TestFormatter(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := logrus.New()
+	l.Out = out
+	l.SetLevel(logrus.TraceLevel)
+	f, err := qlogrus.NewFormatter("svc", func(o *qlog.JsonOptions) error {
+		o.MessageName = "msg"
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l.Formatter = f
+	l.WithField("k", "v").Trace("trace msg")
+	l.Error("error msg")
+
+	lines := decode(t, out)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "svc", lines[0]["n"])
+		assert.Equal(t, "debug", lines[0]["l"])
+		assert.Equal(t, "trace msg", lines[0]["msg"])
+		assert.Equal(t, "v", lines[0]["k"])
+		assert.Equal(t, "error", lines[1]["l"])
+	}
+}
@@ -0,0 +1,123 @@
+// Package qzap provides zapcore.Core implementation forwarding to a qlog
+// Notepad:
+//
+//	log := zap.New(qzap.NewCore(np))
+package qzap
+
+import (
+	"github.com/karantin2020/qlog"
+	"go.uber.org/zap/zapcore"
+)
+
+type core struct {
+	np     *qlog.Notepad
+	fields []qlog.F
+}
+
+// NewCore returns zapcore.Core writing to np. Zap levels are mapped onto
+// qlog levels with DPanic logged as Critical. Core never panics or exits
+// itself, that is left to the zap Logger.
+func NewCore(np *qlog.Notepad) zapcore.Core {
+	return &core{np: np}
+}
+
+func (c *core) logger(lvl zapcore.Level) *qlog.Logger {
+	switch {
+	case lvl <= zapcore.DebugLevel:
+		return c.np.DEBUG
+	case lvl == zapcore.InfoLevel:
+		return c.np.INFO
+	case lvl == zapcore.WarnLevel:
+		return c.np.WARN
+	case lvl == zapcore.ErrorLevel:
+		return c.np.ERROR
+	case lvl == zapcore.DPanicLevel:
+		return c.np.CRITICAL
+	case lvl == zapcore.PanicLevel:
+		return c.np.PANIC
+	default:
+		return c.np.FATAL
+	}
+}
+
+func (c *core) Enabled(lvl zapcore.Level) bool {
+	return c.logger(lvl).Enabled()
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	nc := &core{np: c.np}
+	nc.fields = make([]qlog.F, 0, len(c.fields)+len(fields))
+	nc.fields = append(nc.fields, c.fields...)
+	nc.fields = appendFields(nc.fields, fields, &c.np.Snapshot().Options)
+	return nc
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	l := c.logger(ent.Level)
+	if !l.Enabled() {
+		return nil
+	}
+	opts := &c.np.Snapshot().Options
+	e := l.Fields(c.fields...)
+	e.Fields(appendFields(nil, fields, opts)...)
+	if ent.LoggerName != "" {
+		e.AddField(qlog.F{Key: "logger", Value: ent.LoggerName})
+	}
+	if ent.Caller.Defined {
+		e.AddField(qlog.F{Key: opts.CallerFieldName, Value: ent.Caller.String()})
+	}
+	if ent.Stack != "" {
+		e.AddField(qlog.F{Key: "stacktrace", Value: ent.Stack})
+	}
+	e.Msg(ent.Message)
+	return nil
+}
+
+func (c *core) Sync() error {
+	return nil
+}
+
+// appendFields converts zap fields to qlog fields in their order. Values
+// are encoded by zapcore.MapObjectEncoder, so every field type is
+// supported and namespaces become nested objects. Errors keep their
+// type, errors added with zap.Error use the "error" key which is renamed
+// to LogConfig.ErrorFieldName.
+func appendFields(dst []qlog.F, fields []zapcore.Field, opts *qlog.LogConfig) []qlog.F {
+	enc := zapcore.NewMapObjectEncoder()
+	order := make([]qlog.F, 0, len(fields))
+	for i := range fields {
+		f := &fields[i]
+		if err, ok := f.Interface.(error); ok && f.Type == zapcore.ErrorType {
+			key := f.Key
+			if key == "error" {
+				key = opts.ErrorFieldName
+			}
+			order = append(order, qlog.F{Key: key, Value: err})
+			continue
+		}
+		f.AddTo(enc)
+		if f.Type != zapcore.SkipType {
+			order = append(order, qlog.F{Key: f.Key})
+		}
+	}
+	seen := make(map[string]bool, len(order))
+	for _, f := range order {
+		if f.Value == nil {
+			v, ok := enc.Fields[f.Key]
+			if !ok || seen[f.Key] {
+				continue
+			}
+			f.Value = v
+		}
+		seen[f.Key] = true
+		dst = append(dst, f)
+	}
+	return dst
+}
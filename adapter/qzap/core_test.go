@@ -0,0 +1,144 @@
+package qzap_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/adapter/qzap"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newCore(lvl uint8) (zapcore.Core, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	np := qlog.New("app", lvl, func(lc *qlog.LogConfig) error {
+		lc.ErrorFieldName = "err"
+		lc.CallerFieldName = "src"
+		return nil
+	}).SetOutput(qlog.MustJson(func(o *qlog.JsonOptions) error {
+		o.OutHandle = out
+		o.ErrHandle = out
+		o.OutLevel = lvl
+		return nil
+	}))
+	return qzap.NewCore(np), out
+}
+
+func decode(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	var res []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(b.Bytes()), []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(line, &m); err != nil {
+			t.Fatalf("invalid json line %q: %v", line, err)
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func TestCore_Conformance(t *testing.T) {
+	var _ zapcore.Core = qzap.NewCore(qlog.New("", qlog.InfoLevel))
+}
+
+func TestCore_Levels(t *testing.T) {
+	c, out := newCore(qlog.InfoLevel)
+	assert.False(t, c.Enabled(zapcore.DebugLevel))
+	assert.True(t, c.Enabled(zapcore.InfoLevel))
+	assert.True(t, c.Enabled(zapcore.DPanicLevel))
+
+	l := zap.New(c)
+	l.Debug("skipped")
+	l.Warn("warn msg")
+	l.DPanic("dpanic msg")
+
+	lines := decode(t, out)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "warn", lines[0]["l"])
+		assert.Equal(t, "critical", lines[1]["l"])
+		assert.Equal(t, "dpanic msg", lines[1]["m"])
+	}
+}
+
+type point struct{ x, y int }
+
+func (p point) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("x", p.x)
+	enc.AddInt("y", p.y)
+	return nil
+}
+
+func TestCore_Fields(t *testing.T) {
+	c, out := newCore(qlog.DebugLevel)
+	l := zap.New(c).Named("db").With(zap.String("conn", "primary"))
+	l.Error("query failed",
+		zap.Error(errors.New("timeout")),
+		zap.Int64("rows", 3),
+		zap.Bool("ok", true),
+		zap.Float64("ratio", 0.5),
+		zap.Skip(),
+	)
+	zap.New(c, zap.AddCaller()).Info("with caller")
+	assert.NoError(t, c.Sync())
+
+	lines := decode(t, out)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "primary", lines[0]["conn"])
+		assert.Equal(t, "timeout", lines[0]["err"])
+		assert.Equal(t, float64(3), lines[0]["rows"])
+		assert.Equal(t, true, lines[0]["ok"])
+		assert.Equal(t, 0.5, lines[0]["ratio"])
+		assert.Equal(t, "db", lines[0]["logger"])
+		assert.NotContains(t, lines[0], "skip")
+		assert.Contains(t, lines[1]["src"], "core_test.go:")
+		assert.NotContains(t, lines[1], "conn")
+	}
+	assert.Equal(t, 1, strings.Count(out.String(), `"timeout"`))
+}
+
+func TestCore_FieldTypes(t *testing.T) {
+	c, out := newCore(qlog.DebugLevel)
+	zap.New(c).Info("types",
+		zap.Int32("i32", -32),
+		zap.Int16("i16", -16),
+		zap.Int8("i8", -8),
+		zap.Uint32("u32", 32),
+		zap.Uint16("u16", 16),
+		zap.Uint8("u8", 8),
+		zap.Uintptr("uptr", 1),
+		zap.Float32("f32", 0.25),
+		zap.Duration("d", time.Second),
+		zap.ByteString("bs", []byte("text")),
+		zap.Object("point", point{1, 2}),
+		zap.Strings("list", []string{"a", "b"}),
+		zap.Namespace("ns"),
+		zap.String("inner", "v"),
+	)
+	lines := decode(t, out)
+	if !assert.Len(t, lines, 1) {
+		return
+	}
+	line := lines[0]
+	assert.Equal(t, float64(-32), line["i32"])
+	assert.Equal(t, float64(-16), line["i16"])
+	assert.Equal(t, float64(-8), line["i8"])
+	assert.Equal(t, float64(32), line["u32"])
+	assert.Equal(t, float64(16), line["u16"])
+	assert.Equal(t, float64(8), line["u8"])
+	assert.Equal(t, float64(1), line["uptr"])
+	assert.Equal(t, 0.25, line["f32"])
+	assert.Equal(t, "text", line["bs"])
+	assert.Equal(t, map[string]interface{}{"x": float64(1), "y": float64(2)}, line["point"])
+	assert.Equal(t, []interface{}{"a", "b"}, line["list"])
+	assert.Equal(t, map[string]interface{}{"inner": "v"}, line["ns"])
+	assert.NotContains(t, line, "inner")
+	assert.Contains(t, line, "d")
+}
@@ -0,0 +1,473 @@
+package qlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditOptions configures tamper-evident audit output. Entries are
+// written as Json lines with sequence number and HMAC-SHA256 chain
+// value appended as the last fields.
+type AuditOptions struct {
+	OutHandle     io.Writer
+	Level         uint8
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+	SeqName       string
+	ChainName     string
+	// CheckpointName is the field marking checkpoint entries, its value
+	// is the number of entries written before the checkpoint
+	CheckpointName string
+	// CheckpointEvery writes checkpoint after every n entries, 0 disables
+	CheckpointEvery uint64
+	// CheckpointInterval writes checkpoint with the first entry after the
+	// interval passed since the last checkpoint, 0 disables
+	CheckpointInterval time.Duration
+	// Resume is an existing audit log the output appends to. Sequence,
+	// chain and entry count continue from its last line, so the file
+	// verifies as a whole. Without Resume every process must write a new
+	// file.
+	Resume io.Reader
+}
+
+func defaultAuditOptions() *AuditOptions {
+	jo := defaultJsonOptions()
+	return &AuditOptions{
+		OutHandle:       os.Stdout,
+		Level:           InfoLevel,
+		LogName:         jo.LogName,
+		TimestampName:   jo.TimestampName,
+		LevelName:       jo.LevelName,
+		MessageName:     jo.MessageName,
+		SeqName:         "seq",
+		ChainName:       "chain",
+		CheckpointName:  "checkpoint",
+		CheckpointEvery: 1000,
+	}
+}
+
+// AuditLog is a tamper-evident output. Every line is
+//
+//	{...entry fields...,"seq":N,"chain":"hex"}
+//
+// where chain is HMAC-SHA256(key, previous chain || line up to the chain
+// field). The first entry has empty previous chain.
+type AuditLog struct {
+	mu      sync.Mutex
+	opts    *AuditOptions
+	mac     hash.Hash
+	seq     uint64
+	entries uint64
+	chain   []byte
+	lastCP  time.Time
+	// checkpointed is true if the last written line is a checkpoint
+	checkpointed bool
+	np           *Notepad
+	buf          bytes.Buffer
+	counter      *outputCounter
+}
+
+// NewAuditLog returns AuditLog signing entries with key
+func NewAuditLog(key []byte, opts ...func(*AuditOptions) error) (*AuditLog, error) {
+	if len(key) == 0 {
+		return nil, errors.New("qlog: audit key is empty")
+	}
+	options := defaultAuditOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	if options.Level > _maxLevel {
+		return nil, errors.New("qlog: audit level is out of range")
+	}
+	a := &AuditLog{
+		opts: options,
+		mac:  hmac.New(sha256.New, key),
+	}
+	if options.Resume != nil {
+		if err := a.resume(options.Resume); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// resume verifies r and continues sequence, chain and entry count of
+// its last line. A log not ending with a checkpoint, e.g. of a crashed
+// process, is continued, any other problem is returned.
+func (a *AuditLog) resume(r io.Reader) error {
+	v := newAuditVerifier(a.mac, a.opts)
+	if err := v.scan(r); err != nil {
+		return fmt.Errorf("qlog: audit resume: %v", err)
+	}
+	if len(v.report.Problems) > 0 {
+		return fmt.Errorf("qlog: audit resume: %v", v.report.Problems[0])
+	}
+	a.seq, a.chain, a.checkpointed = v.report.LastSeq, v.chain, v.checkpointed
+	a.entries = a.seq - uint64(v.report.Checkpoints)
+	return nil
+}
+
+// Audit returns audit output for Notepad.SetOutput or error of
+// NewAuditLog
+func Audit(key []byte, opts ...func(*AuditOptions) error) (func(np *Notepad), error) {
+	a, err := NewAuditLog(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return a.Output(), nil
+}
+
+// MustAudit is like Audit but panics if NewAuditLog fails
+func MustAudit(key []byte, opts ...func(*AuditOptions) error) func(np *Notepad) {
+	fn, err := Audit(key, opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// Output returns function attaching audit log to the notepad loggers at
+// AuditOptions.Level and above. The log is closed before a fatal exit.
+// Checkpoints take name and time format of the notepad which wrote the
+// last entry.
+func (a *AuditLog) Output() func(np *Notepad) {
+	return func(np *Notepad) {
+		if a.opts.Level < np.Level.n {
+			np.handleError(errors.New("qlog: audit level is out of range"))
+			return
+		}
+		a.mu.Lock()
+		if a.np == nil {
+			a.np = np
+			a.lastCP = np.Options.TimestampFunc()
+			a.counter = &outputCounter{name: "audit"}
+		}
+		np.counters = append(np.counters, a.counter)
+		a.mu.Unlock()
+		out := Output(a.write)
+		for tlv, logger := range np.Loggers {
+			if *logger != nil && uint8(tlv) >= a.opts.Level {
+				(*logger).Output = append((*logger).Output, out)
+			}
+		}
+		np.AddFatalHook(func() {
+			_ = a.Close()
+		})
+	}
+}
+
+// Seq returns sequence number of the last written line
+func (a *AuditLog) Seq() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.seq
+}
+
+func (a *AuditLog) write(e *Entry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.np = e.Logger.Notepad
+	a.buf.Reset()
+	writeJsonEntry(&a.buf, e, a.opts.LogName, a.opts.TimestampName,
+		a.opts.LevelName, a.opts.MessageName)
+	if err := a.flush(); err != nil {
+		a.counter.fail(e, err)
+		return
+	}
+	atomic.AddUint64(&a.counter.written, 1)
+	a.entries++
+	if a.needCheckpoint(e.Time) {
+		if err := a.checkpoint(e.Time); err != nil {
+			a.counter.fail(e, err)
+		}
+	}
+}
+
+func (a *AuditLog) needCheckpoint(t time.Time) bool {
+	if a.opts.CheckpointEvery > 0 && a.entries%a.opts.CheckpointEvery == 0 {
+		return true
+	}
+	return a.opts.CheckpointInterval > 0 && t.Sub(a.lastCP) >= a.opts.CheckpointInterval
+}
+
+// Checkpoint writes checkpoint entry
+func (a *AuditLog) Checkpoint() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.np == nil {
+		return errors.New("qlog: audit log is not attached to notepad")
+	}
+	return a.checkpoint(a.np.Options.TimestampFunc())
+}
+
+// Close writes the final checkpoint unless the last line is one already.
+// VerifyAudit reports logs not ending with a checkpoint as truncated, so
+// the log must be closed before the process exits.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.checkpointed || a.seq == 0 {
+		return nil
+	}
+	if a.np == nil {
+		return errors.New("qlog: audit log is not attached to notepad")
+	}
+	return a.checkpoint(a.np.Options.TimestampFunc())
+}
+
+func (a *AuditLog) checkpoint(t time.Time) error {
+	a.lastCP = t
+	a.buf.Reset()
+	a.buf.Write(openBrac)
+	writeField(&a.buf, Str2Bytes(a.opts.LogName), a.np.Name)
+	tb := bytesPool.Get().(*[]byte)
+	*tb = appendEntryTime((*tb)[:0], t, a.np.Options.TimeFieldFormat)
+	writeFieldComma(&a.buf, Str2Bytes(a.opts.TimestampName), *tb)
+	bytesPool.Put(tb)
+	writeFieldComma(&a.buf, Str2Bytes(a.opts.LevelName), _info)
+	writeFieldComma(&a.buf, Str2Bytes(a.opts.MessageName), []byte("audit checkpoint"))
+	a.buf.Write(fieldsDelim)
+	a.buf.WriteString(a.opts.CheckpointName)
+	a.buf.Write(kvDelim)
+	a.buf.WriteString(strconv.FormatUint(a.entries, 10))
+	err := a.flush()
+	a.checkpointed = err == nil
+	return err
+}
+
+// flush appends sequence and chain to the buffered line and writes it
+func (a *AuditLog) flush() error {
+	a.seq++
+	a.buf.Write(fieldsDelim)
+	a.buf.WriteString(a.opts.SeqName)
+	a.buf.Write(kvDelim)
+	a.buf.WriteString(strconv.FormatUint(a.seq, 10))
+	a.chain = auditChain(a.mac, a.chain, a.buf.Bytes())
+	a.buf.Write(fieldsDelim)
+	a.buf.WriteString(a.opts.ChainName)
+	a.buf.Write(kvDelim)
+	a.buf.Write(quotes)
+	var hx [2 * sha256.Size]byte
+	hex.Encode(hx[:], a.chain)
+	a.buf.Write(hx[:])
+	a.buf.Write(quotes)
+	a.buf.Write(closeBrac)
+	a.checkpointed = false
+	_, err := a.opts.OutHandle.Write(a.buf.Bytes())
+	return err
+}
+
+func auditChain(mac hash.Hash, prev, line []byte) []byte {
+	mac.Reset()
+	mac.Write(prev)
+	mac.Write(line)
+	return mac.Sum(nil)
+}
+
+// Audit problem kinds
+const (
+	AuditMalformed = "malformed"
+	AuditGap       = "gap"
+	AuditReorder   = "reorder"
+	AuditModified  = "modified"
+	// AuditCheckpoint is reported for checkpoints whose value differs
+	// from the number of entries before them
+	AuditCheckpoint = "checkpoint"
+	// AuditTruncated is reported for logs not ending with a checkpoint,
+	// entries after the last checkpoint may have been removed
+	AuditTruncated = "truncated"
+)
+
+// AuditProblem describes a verification failure at a line
+type AuditProblem struct {
+	Line   int
+	Seq    uint64
+	Kind   string
+	Detail string
+}
+
+func (p AuditProblem) String() string {
+	return fmt.Sprintf("line %d: seq %d: %s: %s", p.Line, p.Seq, p.Kind, p.Detail)
+}
+
+// AuditReport is a result of audit log verification
+type AuditReport struct {
+	Lines       int
+	Checkpoints int
+	LastSeq     uint64
+	Problems    []AuditProblem
+}
+
+// OK reports whether no problems were found
+func (r *AuditReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// auditLine is a parsed line of audit log
+type auditLine struct {
+	line       int
+	seq        uint64
+	signed     []byte
+	chain      []byte
+	checkpoint bool
+	count      json.Number
+}
+
+// parseAuditLine parses sequence, chain and checkpoint fields of line
+func parseAuditLine(line []byte, options *AuditOptions) (*auditLine, error) {
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	idx := bytes.LastIndex(line, []byte(`,"`+options.ChainName+`":"`))
+	if err := dec.Decode(&fields); err != nil || idx < 0 {
+		return nil, errors.New("not an audit json line")
+	}
+	seqNum, _ := fields[options.SeqName].(json.Number)
+	seq, err := strconv.ParseUint(string(seqNum), 10, 64)
+	chainHex, _ := fields[options.ChainName].(string)
+	chain, cerr := hex.DecodeString(chainHex)
+	if err != nil || seq == 0 || cerr != nil || len(chain) != sha256.Size {
+		return nil, errors.New("invalid seq or chain field")
+	}
+	l := &auditLine{seq: seq, signed: append([]byte(nil), line[:idx]...), chain: chain}
+	if v, ok := fields[options.CheckpointName]; ok {
+		l.checkpoint = true
+		l.count, _ = v.(json.Number)
+	}
+	return l, nil
+}
+
+// auditVerifier verifies audit lines one by one. Only the chain value of
+// the last line in sequence and the state of the last checkpoint are
+// kept, so memory does not grow with the log.
+type auditVerifier struct {
+	opts   *AuditOptions
+	mac    hash.Hash
+	report AuditReport
+	// chain is the chain value of the line with report.LastSeq
+	chain   []byte
+	cpSeq   uint64
+	cpCount uint64
+	// cpKnown is false after a gap or an invalid checkpoint value
+	cpKnown bool
+	// checkpointed is true if the last line in sequence is a checkpoint
+	checkpointed bool
+}
+
+func newAuditVerifier(mac hash.Hash, options *AuditOptions) *auditVerifier {
+	return &auditVerifier{opts: options, mac: mac, cpKnown: true}
+}
+
+func (v *auditVerifier) problem(line int, seq uint64, kind, detail string) {
+	v.report.Problems = append(v.report.Problems, AuditProblem{
+		Line: line, Seq: seq, Kind: kind, Detail: detail})
+}
+
+// scan adds the lines of r
+func (v *auditVerifier) scan(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimRight(sc.Bytes(), "\r")
+		if len(line) != 0 {
+			v.add(line)
+		}
+	}
+	return sc.Err()
+}
+
+// add verifies line against the last line in sequence. Lines before it
+// are reported as reordered and skipped, lines after a gap are not
+// verified.
+func (v *auditVerifier) add(line []byte) {
+	v.report.Lines++
+	l, err := parseAuditLine(line, v.opts)
+	if err != nil {
+		v.problem(v.report.Lines, 0, AuditMalformed, err.Error())
+		return
+	}
+	l.line = v.report.Lines
+	if l.checkpoint {
+		v.report.Checkpoints++
+	}
+	last := v.report.LastSeq
+	switch {
+	case l.seq == last:
+		v.problem(l.line, l.seq, AuditReorder, "duplicate of the previous line")
+		return
+	case l.seq < last:
+		v.problem(l.line, l.seq, AuditReorder, fmt.Sprintf("after seq %d", last))
+		return
+	case l.seq > last+1:
+		v.problem(l.line, l.seq, AuditGap,
+			fmt.Sprintf("%d entries missing (seq %d-%d)", l.seq-last-1, last+1, l.seq-1))
+		v.cpKnown = false
+	default:
+		if !hmac.Equal(auditChain(v.mac, v.chain, l.signed), l.chain) {
+			v.problem(l.line, l.seq, AuditModified, "chain value mismatch")
+		}
+	}
+	v.report.LastSeq, v.chain, v.checkpointed = l.seq, l.chain, l.checkpoint
+	if l.checkpoint {
+		v.checkpoint(l)
+	}
+}
+
+// checkpoint checks that checkpoint l counts the entries since the
+// previous checkpoint. Checkpoints after a gap or an invalid checkpoint
+// are not checked, the gap is reported.
+func (v *auditVerifier) checkpoint(l *auditLine) {
+	count, err := strconv.ParseUint(string(l.count), 10, 64)
+	if err != nil {
+		v.problem(l.line, l.seq, AuditCheckpoint, "invalid checkpoint value")
+		v.cpSeq, v.cpKnown = l.seq, false
+		return
+	}
+	if want := v.cpCount + l.seq - v.cpSeq - 1; v.cpKnown && count != want {
+		v.problem(l.line, l.seq, AuditCheckpoint,
+			fmt.Sprintf("counts %d entries, want %d", count, want))
+		count = want
+	}
+	v.cpSeq, v.cpCount, v.cpKnown = l.seq, count, true
+}
+
+// VerifyAudit reads audit log from r and verifies sequence numbers and
+// chain values. Every line is verified against the chain value of the
+// line before it in sequence, so gaps and modifications are reported.
+// Lines moved after their successors are reported as reordered and the
+// entries they leave out as a gap. Checkpoint values are checked against
+// the number of entries before them, and a log not ending with a
+// checkpoint is reported as truncated. Error is returned only if
+// reading fails.
+func VerifyAudit(r io.Reader, key []byte, opts ...func(*AuditOptions) error) (*AuditReport, error) {
+	options := defaultAuditOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	v := newAuditVerifier(hmac.New(sha256.New, key), options)
+	err := v.scan(r)
+	if v.report.LastSeq > 0 && !v.checkpointed {
+		v.problem(v.report.Lines, v.report.LastSeq, AuditTruncated,
+			"log does not end with a checkpoint")
+	}
+	return &v.report, err
+}
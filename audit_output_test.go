@@ -0,0 +1,230 @@
+package qlog_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+var auditKey = []byte("audit secret key")
+
+func auditLines(t *testing.T, n int) []string {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	a, err := qlog.NewAuditLog(auditKey, func(o *qlog.AuditOptions) error {
+		o.OutHandle = out
+		o.CheckpointEvery = 3
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	np := qlog.New("audit", qlog.InfoLevel, qlog.Clock(clock.Now)).SetOutput(a.Output())
+	for i := 0; i < n; i++ {
+		np.INFO.Fields(qlog.F{Key: "i", Value: i}).Msg(`user "root" logged in`)
+	}
+	assert.NoError(t, a.Checkpoint())
+	return strings.SplitAfter(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func verifyLines(t *testing.T, lines []string) *qlog.AuditReport {
+	report, err := qlog.VerifyAudit(strings.NewReader(strings.Join(lines, "")), auditKey)
+	assert.NoError(t, err)
+	return report
+}
+
+func kinds(r *qlog.AuditReport) []string {
+	var res []string
+	for _, p := range r.Problems {
+		res = append(res, p.Kind)
+	}
+	return res
+}
+
+func TestAudit_Verify(t *testing.T) {
+	lines := auditLines(t, 5)
+	// 5 entries, checkpoint after 3rd and the explicit one
+	assert.Len(t, lines, 7)
+	assert.Equal(t, `{"n":"audit","t":"2018-01-02T03:04:06.000Z","l":"info","m":"user \"root\" logged in","i":0,"seq":1,"chain":"`,
+		lines[0][:strings.Index(lines[0], `"chain":"`)+9])
+	assert.Contains(t, lines[3], `"m":"audit checkpoint","checkpoint":3,"seq":4`)
+
+	report := verifyLines(t, lines)
+	assert.True(t, report.OK(), "%v", report.Problems)
+	assert.Equal(t, 7, report.Lines)
+	assert.Equal(t, 2, report.Checkpoints)
+	assert.Equal(t, uint64(7), report.LastSeq)
+
+	report, _ = qlog.VerifyAudit(strings.NewReader(strings.Join(lines, "")), []byte("wrong"))
+	assert.Len(t, report.Problems, 7)
+}
+
+func TestAudit_Tampering(t *testing.T) {
+	lines := auditLines(t, 5)
+
+	modified := append([]string(nil), lines...)
+	modified[1] = strings.Replace(modified[1], `"i":1`, `"i":9`, 1)
+	report := verifyLines(t, modified)
+	assert.Equal(t, []string{qlog.AuditModified}, kinds(report))
+	assert.Equal(t, 2, report.Problems[0].Line)
+
+	removed := append(append([]string(nil), lines[:2]...), lines[4:]...)
+	report = verifyLines(t, removed)
+	assert.Equal(t, []string{qlog.AuditGap}, kinds(report))
+	assert.Equal(t, uint64(5), report.Problems[0].Seq)
+
+	swapped := append([]string(nil), lines...)
+	swapped[1], swapped[2] = swapped[2], swapped[1]
+	report = verifyLines(t, swapped)
+	assert.Equal(t, []string{qlog.AuditGap, qlog.AuditReorder}, kinds(report))
+	assert.Equal(t, "line 3: seq 2: reorder: after seq 3", report.Problems[1].String())
+
+	duplicated := append(append([]string(nil), lines[:2]...), lines[1:]...)
+	report = verifyLines(t, duplicated)
+	assert.Equal(t, []string{qlog.AuditReorder}, kinds(report))
+
+	malformed := append([]string{"garbage\n"}, lines...)
+	report = verifyLines(t, malformed)
+	assert.Equal(t, []string{qlog.AuditMalformed}, kinds(report))
+
+	// entries after the last checkpoint are removed
+	report = verifyLines(t, lines[:5])
+	assert.Equal(t, []string{qlog.AuditTruncated}, kinds(report))
+	assert.Equal(t, "line 5: seq 5: truncated: log does not end with a checkpoint", report.Problems[0].String())
+}
+
+func TestAudit_Close(t *testing.T) {
+	out := &bytes.Buffer{}
+	a, err := qlog.NewAuditLog(auditKey, func(o *qlog.AuditOptions) error {
+		o.OutHandle = out
+		o.CheckpointEvery = 2
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, a.Close())
+	assert.Equal(t, 0, out.Len())
+
+	np := qlog.New("audit", qlog.InfoLevel).SetOutput(a.Output())
+	np.INFO.Msg("login")
+	assert.NoError(t, a.Close())
+	assert.Equal(t, uint64(2), a.Seq())
+	np.INFO.Msg("login")
+	// the checkpoint after the 2nd entry closes the log
+	assert.NoError(t, a.Close())
+	assert.Equal(t, uint64(4), a.Seq())
+
+	report, err := qlog.VerifyAudit(out, auditKey)
+	assert.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Problems)
+	assert.Equal(t, 2, report.Checkpoints)
+}
+
+// resign recomputes chain values of lines as the audit writer does
+func resign(lines []string) []string {
+	var (
+		res   = make([]string, len(lines))
+		chain []byte
+		mac   = hmac.New(sha256.New, auditKey)
+	)
+	for i, l := range lines {
+		signed := l[:strings.LastIndex(l, `,"chain":"`)]
+		mac.Reset()
+		mac.Write(chain)
+		mac.Write([]byte(signed))
+		chain = mac.Sum(nil)
+		res[i] = signed + `,"chain":"` + hex.EncodeToString(chain) + l[len(signed)+10+2*sha256.Size:]
+	}
+	return res
+}
+
+func TestAudit_CheckpointValue(t *testing.T) {
+	lines := auditLines(t, 5)
+	assert.Equal(t, lines, resign(lines))
+
+	forged := append([]string(nil), lines...)
+	forged[3] = strings.Replace(forged[3], `"checkpoint":3`, `"checkpoint":2`, 1)
+	report := verifyLines(t, resign(forged))
+	assert.Equal(t, []string{qlog.AuditCheckpoint}, kinds(report))
+	assert.Equal(t, 4, report.Problems[0].Line)
+	assert.Equal(t, "line 4: seq 4: checkpoint: counts 2 entries, want 3", report.Problems[0].String())
+
+	// the next checkpoint counts from the previous one
+	forged = append([]string(nil), lines...)
+	forged[6] = strings.Replace(forged[6], `"checkpoint":5`, `"checkpoint":6`, 1)
+	report = verifyLines(t, resign(forged))
+	assert.Equal(t, []string{qlog.AuditCheckpoint}, kinds(report))
+	assert.Equal(t, uint64(7), report.Problems[0].Seq)
+
+	// checkpoints after a gap are not checked
+	report = verifyLines(t, append(append([]string(nil), lines[:2]...), lines[4:]...))
+	assert.Equal(t, []string{qlog.AuditGap}, kinds(report))
+}
+
+func TestAudit_Resume(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	run := func(resume bool, name string, n int) {
+		a, err := qlog.NewAuditLog(auditKey, func(o *qlog.AuditOptions) error {
+			o.OutHandle = out
+			o.CheckpointEvery = 2
+			if resume {
+				o.Resume = bytes.NewReader(out.Bytes())
+			}
+			return nil
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		np := qlog.New("first", qlog.InfoLevel, qlog.Clock(clock.Now)).SetOutput(a.Output())
+		np.SetName(name)
+		for i := 0; i < n; i++ {
+			np.INFO.Msg("login")
+		}
+		assert.NoError(t, a.Checkpoint())
+	}
+	run(false, "audit", 3)
+	run(true, "restarted", 3)
+	lines := strings.SplitAfter(strings.TrimSuffix(out.String(), "\n"), "\n")
+	// checkpoints after 2, 3, 4 and twice after 6 entries
+	if !assert.Len(t, lines, 11) {
+		return
+	}
+	assert.Contains(t, lines[4], `"n":"audit","t":"2018-01-02T03:04:09.000Z","l":"info","m":"audit checkpoint","checkpoint":3,"seq":5`)
+	assert.Contains(t, lines[6], `"n":"restarted","t":"2018-01-02T03:04:11.000Z","l":"info","m":"audit checkpoint","checkpoint":4,"seq":7`)
+	assert.Contains(t, lines[10], `"checkpoint":6,"seq":11`)
+	report := verifyLines(t, lines)
+	assert.True(t, report.OK(), "%v", report.Problems)
+	assert.Equal(t, uint64(11), report.LastSeq)
+
+	_, err := qlog.NewAuditLog(auditKey, func(o *qlog.AuditOptions) error {
+		o.Resume = strings.NewReader(lines[0] + "garbage\n")
+		return nil
+	})
+	assert.EqualError(t, err, "qlog: audit resume: line 2: seq 0: malformed: not an audit json line")
+
+	// a tampered log is not signed as valid
+	tampered := append([]string(nil), lines[:4]...)
+	tampered[1] = strings.Replace(tampered[1], `"n":"audit"`, `"n":"forged"`, 1)
+	_, err = qlog.NewAuditLog(auditKey, func(o *qlog.AuditOptions) error {
+		o.Resume = strings.NewReader(strings.Join(tampered, ""))
+		return nil
+	})
+	assert.EqualError(t, err, "qlog: audit resume: line 2: seq 2: modified: chain value mismatch")
+
+	// a log of a crashed process does not end with a checkpoint
+	_, err = qlog.NewAuditLog(auditKey, func(o *qlog.AuditOptions) error {
+		o.Resume = strings.NewReader(strings.Join(lines[:4], ""))
+		return nil
+	})
+	assert.NoError(t, err)
+}
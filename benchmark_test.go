@@ -1,6 +1,7 @@
 package qlog
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"testing"
 )
@@ -22,7 +23,7 @@ func BenchmarkLogNoOutput(b *testing.B) {
 
 func BenchmarkLogEmpty(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -37,7 +38,7 @@ func BenchmarkLogEmpty(b *testing.B) {
 
 func BenchmarkLogDisabled(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -52,7 +53,7 @@ func BenchmarkLogDisabled(b *testing.B) {
 
 func BenchmarkInfo(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -67,7 +68,7 @@ func BenchmarkInfo(b *testing.B) {
 
 func BenchmarkError(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -82,7 +83,7 @@ func BenchmarkError(b *testing.B) {
 
 func BenchmarkInfoLower(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${level}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${level}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -115,7 +116,7 @@ func BenchmarkDiscard(b *testing.B) {
 
 func BenchmarkOneField(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -132,7 +133,7 @@ func BenchmarkOneField(b *testing.B) {
 
 func BenchmarkTwoFields(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -150,7 +151,7 @@ func BenchmarkTwoFields(b *testing.B) {
 
 func BenchmarkOneFieldLower(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${level}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${level}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -167,7 +168,7 @@ func BenchmarkOneFieldLower(b *testing.B) {
 
 func BenchmarkTwoFieldsLower(b *testing.B) {
 	log := New("testLog", InfoLevel).
-		SetOutput(Template("${time}\t${level}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${level}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -189,7 +190,7 @@ func BenchmarkWithFields(b *testing.B) {
 			F{Key: "service", Value: "new"},
 			F{Key: "source", Value: "after"},
 		).
-		SetOutput(Template("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
+		SetOutput(MustTemplate("${time}\t${LEVEL}\t${message}\t${fields}\n", func(topts *TemplateOptions) error {
 			topts.ErrHandle = ioutil.Discard
 			topts.OutHandle = ioutil.Discard
 			return nil
@@ -204,7 +205,7 @@ func BenchmarkWithFields(b *testing.B) {
 
 func BenchmarkJsonInfo(b *testing.B) {
 	log := New("testJson", InfoLevel, TimeFormat("UnixMicro")).
-		SetOutput(Json(func(jopts *JsonOptions) error {
+		SetOutput(MustJson(func(jopts *JsonOptions) error {
 			jopts.ErrHandle = ioutil.Discard
 			jopts.OutHandle = ioutil.Discard
 			return nil
@@ -216,3 +217,70 @@ func BenchmarkJsonInfo(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkCBORInfo(b *testing.B) {
+	log := New("testCBOR", InfoLevel, TimeFormat("UnixMicro")).
+		SetOutput(MustCBOR(func(copts *CBOROptions) error {
+			copts.ErrHandle = ioutil.Discard
+			copts.OutHandle = ioutil.Discard
+			return nil
+		}))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			log.Info(fakeMessage)
+		}
+	})
+}
+
+type benchUser struct {
+	ID       int               `json:"id"`
+	Name     string            `json:"name"`
+	Email    string            `json:"email"`
+	Roles    []string          `json:"roles"`
+	Active   bool              `json:"active"`
+	Score    float64           `json:"score"`
+	Settings map[string]string `json:"settings"`
+}
+
+var (
+	benchUserValue = benchUser{ID: 42, Name: "john", Email: "john@example.com",
+		Roles: []string{"admin", "dev"}, Active: true, Score: 0.75,
+		Settings: map[string]string{"theme": "dark", "lang": "en"}}
+	benchMapValue = map[string]interface{}{"id": 42, "name": "john",
+		"roles": []string{"admin", "dev"}, "nested": map[string]interface{}{"a": 1.5}}
+)
+
+func BenchmarkEncodeStructNative(b *testing.B) {
+	opts := &New("", InfoLevel).Snapshot().Options
+	buf := make([]byte, 0, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = appendValue(buf[:0], benchUserValue, opts, 0)
+	}
+}
+
+func BenchmarkEncodeStructReflect(b *testing.B) {
+	buf := make([]byte, 0, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendInterface(buf[:0], benchUserValue, json.Marshal)
+	}
+}
+
+func BenchmarkEncodeMapNative(b *testing.B) {
+	opts := &New("", InfoLevel).Snapshot().Options
+	buf := make([]byte, 0, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = appendValue(buf[:0], benchMapValue, opts, 0)
+	}
+}
+
+func BenchmarkEncodeMapReflect(b *testing.B) {
+	buf := make([]byte, 0, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendInterface(buf[:0], benchMapValue, json.Marshal)
+	}
+}
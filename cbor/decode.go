@@ -0,0 +1,469 @@
+// Package cbor decodes entries of qlog CBOR output back to Json output
+// lines.
+package cbor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/karantin2020/qlog"
+)
+
+const (
+	// maxDepth limits nesting of decoded arrays and maps
+	maxDepth = 64
+	// maxLen limits length of decoded strings
+	maxLen = 64 << 20
+)
+
+// ErrSyntax is returned for malformed or unsupported input
+var ErrSyntax = errors.New("qlog/cbor: invalid input")
+
+// Options configure Decoder. Timestamp and level names are the names
+// used by the CBOREncoder, time and duration options are the LogConfig
+// options of the logger.
+type Options struct {
+	TimestampName        string
+	LevelName            string
+	TimeFieldFormat      string
+	DurationFieldUnit    time.Duration
+	DurationFieldInteger bool
+	// Location is the location of decoded times
+	Location *time.Location
+}
+
+func defaultOptions() *Options {
+	return &Options{
+		TimestampName:        "t",
+		LevelName:            "l",
+		TimeFieldFormat:      "2006-01-02T15:04:05.000Z0700",
+		DurationFieldUnit:    time.Millisecond,
+		DurationFieldInteger: true,
+		Location:             time.Local,
+	}
+}
+
+// Decoder reads entries of CBOR output
+type Decoder struct {
+	r    *bufio.Reader
+	opts *Options
+	buf  []byte
+}
+
+// NewDecoder returns decoder reading from r
+func NewDecoder(r io.Reader, opts ...func(*Options) error) *Decoder {
+	options := defaultOptions()
+	for _, fn := range opts {
+		_ = fn(options)
+	}
+	return &Decoder{r: bufio.NewReader(r), opts: options}
+}
+
+// Convert writes Json output lines of CBOR entries read from r to w
+func Convert(w io.Writer, r io.Reader, opts ...func(*Options) error) error {
+	d := NewDecoder(r, opts...)
+	var line []byte
+	for {
+		var err error
+		if line, err = d.AppendJSON(line[:0]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if _, err = w.Write(line); err != nil {
+			return err
+		}
+	}
+}
+
+// AppendJSON decodes the next entry and appends it as Json output line.
+// It returns io.EOF if there are no more entries.
+func (d *Decoder) AppendJSON(dst []byte) ([]byte, error) {
+	if _, err := d.r.Peek(1); err != nil {
+		return dst, err
+	}
+	h, err := d.head()
+	if err != nil {
+		return dst, err
+	}
+	if h.major != majorMap {
+		return dst, fmt.Errorf("%v: entry is not a map", ErrSyntax)
+	}
+	if dst, err = d.appendMap(dst, h, 0); err != nil {
+		return dst, err
+	}
+	return append(dst, '\n'), nil
+}
+
+const (
+	majorUint = iota
+	majorNegInt
+	majorBytes
+	majorText
+	majorArray
+	majorMap
+	majorTag
+	majorSimple
+)
+
+// header values of entry map
+const (
+	valueField = iota
+	valueTime
+	valueLevel
+)
+
+// item is the initial byte and argument of a data item
+type item struct {
+	major, info byte
+	arg         uint64
+}
+
+func (h item) indef() bool { return h.info == 31 }
+
+func (h item) isBreak() bool { return h.major == majorSimple && h.info == 31 }
+
+// head reads initial byte and argument of the next item
+func (d *Decoder) head() (item, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return item{}, err
+	}
+	h := item{major: b >> 5, info: b & 31}
+	switch {
+	case h.info < 24:
+		h.arg = uint64(h.info)
+	case h.info <= 27:
+		n := 1 << (h.info - 24)
+		var p [8]byte
+		if _, err := io.ReadFull(d.r, p[:n]); err != nil {
+			return item{}, unexpected(err)
+		}
+		for _, c := range p[:n] {
+			h.arg = h.arg<<8 | uint64(c)
+		}
+	case h.info != 31 || h.major == majorUint || h.major == majorNegInt || h.major == majorTag:
+		return item{}, ErrSyntax
+	}
+	return h, nil
+}
+
+// next reads head of an item inside of another item
+func (d *Decoder) next() (item, error) {
+	h, err := d.head()
+	return h, unexpected(err)
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *Decoder) appendItem(dst []byte, depth int, value int) ([]byte, error) {
+	h, err := d.next()
+	if err != nil {
+		return dst, err
+	}
+	return d.appendValue(dst, h, depth, value)
+}
+
+func (d *Decoder) appendValue(dst []byte, h item, depth int, value int) ([]byte, error) {
+	if depth > maxDepth {
+		return dst, fmt.Errorf("%v: nesting too deep", ErrSyntax)
+	}
+	switch h.major {
+	case majorUint:
+		if value == valueLevel && h.arg <= math.MaxUint8 {
+			dst = append(dst, '"')
+			return append(append(dst, qlog.InitLevel(uint8(h.arg)).ToBytes()...), '"'), nil
+		}
+		return strconv.AppendUint(dst, h.arg, 10), nil
+	case majorNegInt:
+		if h.arg == math.MaxUint64 {
+			return append(dst, "-18446744073709551616"...), nil
+		}
+		return strconv.AppendUint(append(dst, '-'), h.arg+1, 10), nil
+	case majorBytes, majorText:
+		s, err := d.readString(h)
+		if err != nil {
+			return dst, err
+		}
+		return qlog.AppendBytes(dst, s), nil
+	case majorArray:
+		dst = append(dst, '[')
+		for i := uint64(0); h.indef() || i < h.arg; i++ {
+			e, err := d.next()
+			if err != nil {
+				return dst, err
+			}
+			if h.indef() && e.isBreak() {
+				break
+			}
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			if dst, err = d.appendValue(dst, e, depth+1, valueField); err != nil {
+				return dst, err
+			}
+		}
+		return append(dst, ']'), nil
+	case majorMap:
+		return d.appendMap(dst, h, depth+1)
+	case majorTag:
+		return d.appendTag(dst, h.arg, depth, value)
+	}
+	if f, ok := h.float(); ok {
+		if h.info == 27 {
+			return qlog.AppendFloat64(dst, f), nil
+		}
+		return qlog.AppendFloat32(dst, float32(f)), nil
+	}
+	switch {
+	case h.indef():
+		return dst, fmt.Errorf("%v: unexpected break", ErrSyntax)
+	case h.arg == 20:
+		return append(dst, "false"...), nil
+	case h.arg == 21:
+		return append(dst, "true"...), nil
+	}
+	return append(dst, "null"...), nil
+}
+
+// float returns value of float item
+func (h item) float() (float64, bool) {
+	if h.major != majorSimple {
+		return 0, false
+	}
+	switch h.info {
+	case 25:
+		return float64(halfFloat(uint16(h.arg))), true
+	case 26:
+		return float64(math.Float32frombits(uint32(h.arg))), true
+	case 27:
+		return math.Float64frombits(h.arg), true
+	}
+	return 0, false
+}
+
+// appendMap appends map with string keys as json object, top level keys
+// of the entry map are header keys
+func (d *Decoder) appendMap(dst []byte, h item, depth int) ([]byte, error) {
+	dst = append(dst, '{')
+	for i := uint64(0); h.indef() || i < h.arg; i++ {
+		k, err := d.next()
+		if err != nil {
+			return dst, err
+		}
+		if h.indef() && k.isBreak() {
+			break
+		}
+		if k.major != majorText && k.major != majorBytes {
+			return dst, fmt.Errorf("%v: map key is not a string", ErrSyntax)
+		}
+		key, err := d.readString(k)
+		if err != nil {
+			return dst, err
+		}
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(qlog.AppendBytes(dst, key), ':')
+		value := valueField
+		if depth == 0 {
+			switch string(key) {
+			case d.opts.TimestampName:
+				value = valueTime
+			case d.opts.LevelName:
+				value = valueLevel
+			}
+		}
+		if dst, err = d.appendItem(dst, depth, value); err != nil {
+			return dst, err
+		}
+	}
+	return append(dst, '}'), nil
+}
+
+// readString reads string or chunks of indefinite string. The result
+// is valid until the next read.
+func (d *Decoder) readString(h item) ([]byte, error) {
+	if !h.indef() {
+		if h.arg > maxLen {
+			return nil, fmt.Errorf("%v: string too long", ErrSyntax)
+		}
+		if uint64(cap(d.buf)) < h.arg {
+			d.buf = make([]byte, h.arg)
+		}
+		d.buf = d.buf[:h.arg]
+		_, err := io.ReadFull(d.r, d.buf)
+		return d.buf, unexpected(err)
+	}
+	var s []byte
+	for {
+		c, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		if c.isBreak() {
+			return s, nil
+		}
+		if c.major != h.major || c.indef() {
+			return nil, fmt.Errorf("%v: invalid string chunk", ErrSyntax)
+		}
+		chunk, err := d.readString(c)
+		if err != nil {
+			return nil, err
+		}
+		if len(s)+len(chunk) > maxLen {
+			return nil, fmt.Errorf("%v: string too long", ErrSyntax)
+		}
+		s = append(s, chunk...)
+	}
+}
+
+func (d *Decoder) appendTag(dst []byte, tag uint64, depth int, value int) ([]byte, error) {
+	h, err := d.next()
+	if err != nil {
+		return dst, err
+	}
+	switch tag {
+	case 0:
+		if h.major != majorText {
+			break
+		}
+		s, err := d.readString(h)
+		if err != nil {
+			return dst, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, string(s))
+		if err != nil {
+			return qlog.AppendBytes(dst, s), nil
+		}
+		return d.appendTime(dst, t, value), nil
+	case qlog.CBORTagEpoch:
+		if f, ok := h.float(); ok {
+			sec, frac := math.Modf(f)
+			return d.appendTime(dst, time.Unix(int64(sec), int64(frac*1e9)), value), nil
+		}
+		if n, ok := h.int(); ok {
+			return d.appendTime(dst, time.Unix(n, 0), value), nil
+		}
+	case qlog.CBORTagExtendedTime, qlog.CBORTagDuration:
+		if h.major != majorMap {
+			break
+		}
+		sec, nsec, err := d.readSecNano(h)
+		if err != nil {
+			return dst, err
+		}
+		if tag == qlog.CBORTagDuration {
+			return qlog.AppendDuration(dst, time.Duration(sec)*time.Second+time.Duration(nsec),
+				d.opts.DurationFieldUnit, d.opts.DurationFieldInteger), nil
+		}
+		return d.appendTime(dst, time.Unix(sec, nsec), value), nil
+	case qlog.CBORTagEmbeddedJSON:
+		if h.major != majorBytes && h.major != majorText {
+			break
+		}
+		js, err := d.readString(h)
+		if err != nil {
+			return dst, err
+		}
+		if !json.Valid(js) {
+			return qlog.AppendBytes(dst, js), nil
+		}
+		return append(dst, js...), nil
+	}
+	return d.appendValue(dst, h, depth+1, valueField)
+}
+
+// int returns value of integer item
+func (h item) int() (int64, bool) {
+	switch {
+	case h.major == majorUint && h.arg <= math.MaxInt64:
+		return int64(h.arg), true
+	case h.major == majorNegInt && h.arg <= math.MaxInt64:
+		return -1 - int64(h.arg), true
+	}
+	return 0, false
+}
+
+// readSecNano reads map {1: seconds, -9: nanoseconds}
+func (d *Decoder) readSecNano(h item) (sec, nsec int64, err error) {
+	for i := uint64(0); h.indef() || i < h.arg; i++ {
+		k, err := d.next()
+		if err != nil {
+			return 0, 0, err
+		}
+		if h.indef() && k.isBreak() {
+			break
+		}
+		v, err := d.next()
+		if err != nil {
+			return 0, 0, err
+		}
+		key, kok := k.int()
+		n, vok := v.int()
+		if !kok || !vok {
+			return 0, 0, fmt.Errorf("%v: invalid time", ErrSyntax)
+		}
+		switch key {
+		case 1:
+			sec = n
+		case -9:
+			nsec = n
+		}
+	}
+	return sec, nsec, nil
+}
+
+// appendTime appends t like the Json output, entry time is quoted
+func (d *Decoder) appendTime(dst []byte, t time.Time, value int) []byte {
+	if d.opts.Location != nil {
+		t = t.In(d.opts.Location)
+	}
+	if value != valueTime {
+		return qlog.AppendTime(dst, t, d.opts.TimeFieldFormat)
+	}
+	dst = append(dst, '"')
+	switch d.opts.TimeFieldFormat {
+	case "", "Unix":
+		dst = strconv.AppendInt(dst, t.Unix(), 10)
+	case "UnixNano":
+		dst = strconv.AppendInt(dst, t.UnixNano(), 10)
+	case "UnixMilli":
+		dst = strconv.AppendInt(dst, t.UnixNano()/1000000, 10)
+	case "UnixMicro":
+		dst = strconv.AppendInt(dst, t.UnixNano()/1000, 10)
+	default:
+		dst = t.AppendFormat(dst, d.opts.TimeFieldFormat)
+	}
+	return append(dst, '"')
+}
+
+// halfFloat converts IEEE 754 half precision number
+func halfFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := int(h>>10) & 0x1f
+	frac := uint32(h & 0x3ff)
+	switch exp {
+	case 0:
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	}
+	return math.Float32frombits(sign | uint32(exp-15+127)<<23 | frac<<13)
+}
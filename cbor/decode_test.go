@@ -0,0 +1,202 @@
+package cbor_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/cbor"
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct {
+	X, Y int
+}
+
+type name string
+
+func (n name) String() string { return "name:" + string(n) }
+
+func randomString(r *rand.Rand) string {
+	parts := []string{"a", "Z", " ", "\"", "\\", "\n", "\t", "\x01", "é", "日本", "😀", "\xff", "=", "{}"}
+	var b []byte
+	for i := r.Intn(8); i > 0; i-- {
+		b = append(b, parts[r.Intn(len(parts))]...)
+	}
+	return string(b)
+}
+
+func randomTime(r *rand.Rand) time.Time {
+	nsec := int64(0)
+	if r.Intn(2) == 0 {
+		nsec = r.Int63n(1e9)
+	}
+	return time.Unix(r.Int63n(4e9)-1e9, nsec).UTC()
+}
+
+func randomValue(r *rand.Rand, depth int) interface{} {
+	n := 24
+	if depth > 2 {
+		n = 12
+	}
+	switch r.Intn(n) {
+	case 0:
+		return randomString(r)
+	case 1:
+		return r.Int63() - r.Int63()
+	case 2:
+		return int8(r.Intn(256) - 128)
+	case 3:
+		return r.Uint64()
+	case 4:
+		return []float64{r.NormFloat64() * 1e6, math.NaN(), math.Inf(1), math.Inf(-1), 0}[r.Intn(5)]
+	case 5:
+		return float32(r.NormFloat64())
+	case 6:
+		return r.Intn(2) == 0
+	case 7:
+		return randomTime(r)
+	case 8:
+		return time.Duration(r.Int63n(1e13) - 5e12)
+	case 9:
+		return nil
+	case 10:
+		return []byte(randomString(r))
+	case 11:
+		return errors.New(randomString(r))
+	case 12:
+		return []string{randomString(r), randomString(r)}
+	case 13:
+		return []int{r.Int(), -r.Int()}
+	case 14:
+		return []float32{float32(r.NormFloat64()), 1.5}
+	case 15:
+		return []time.Duration{time.Duration(r.Int63()), -time.Second}
+	case 16:
+		return []time.Time{randomTime(r), randomTime(r)}
+	case 17:
+		return map[string]string{randomString(r): randomString(r), "k": "v"}
+	case 18:
+		m := map[string]interface{}{}
+		for i := r.Intn(4); i > 0; i-- {
+			m[randomString(r)] = randomValue(r, depth+1)
+		}
+		return m
+	case 19:
+		return qlog.FlatMapI{K: []string{"b", "a", "c"}, V: []interface{}{randomValue(r, depth+1), randomValue(r, depth+1)}}
+	case 20:
+		return point{r.Int(), -r.Int()}
+	case 21:
+		return json.Number("12.50")
+	case 22:
+		return net.IPv4(10, 0, 0, byte(r.Intn(256)))
+	}
+	return name(randomString(r))
+}
+
+// encode logs fields with Json and CBOR outputs
+func encode(opts []func(*qlog.LogConfig) error, msg string, fields []qlog.F) (js, cb []byte) {
+	var jsBuf, cbBuf bytes.Buffer
+	np := qlog.New("app.db", qlog.DebugLevel, opts...).SetOutput(
+		qlog.MustJson(func(o *qlog.JsonOptions) error {
+			o.OutHandle, o.ErrHandle, o.OutLevel = &jsBuf, &jsBuf, qlog.DebugLevel
+			return nil
+		}),
+		qlog.MustCBOR(func(o *qlog.CBOROptions) error {
+			o.OutHandle, o.ErrHandle, o.OutLevel = &cbBuf, &cbBuf, qlog.DebugLevel
+			return nil
+		}))
+	np.WARN.Fields(fields...).Msg(msg)
+	return jsBuf.Bytes(), cbBuf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	formats := []struct {
+		time    string
+		unit    time.Duration
+		integer bool
+	}{
+		{"2006-01-02T15:04:05.000Z0700", time.Millisecond, true},
+		{time.RFC3339Nano, time.Second, false},
+		{"", time.Microsecond, false},
+		{"UnixNano", time.Nanosecond, true},
+	}
+	for _, f := range formats {
+		f := f
+		check := func(seed int64) bool {
+			r := rand.New(rand.NewSource(seed))
+			start := randomTime(r)
+			var fields []qlog.F
+			for i := r.Intn(6); i >= 0; i-- {
+				fields = append(fields, qlog.F{Key: randomString(r), Value: randomValue(r, 0)})
+			}
+			js, cb := encode([]func(*qlog.LogConfig) error{
+				qlog.Clock(func() time.Time { return start }),
+				func(c *qlog.LogConfig) error {
+					c.TimeFieldFormat = f.time
+					c.DurationFieldUnit, c.DurationFieldInteger = f.unit, f.integer
+					return nil
+				},
+			}, randomString(r), fields)
+			var got bytes.Buffer
+			err := cbor.Convert(&got, bytes.NewReader(cb), func(o *cbor.Options) error {
+				o.TimeFieldFormat, o.Location = f.time, time.UTC
+				o.DurationFieldUnit, o.DurationFieldInteger = f.unit, f.integer
+				return nil
+			})
+			return assert.NoError(t, err) &&
+				assert.Equal(t, string(js), got.String(), "seed %d", seed)
+		}
+		assert.NoError(t, quick.Check(check, &quick.Config{MaxCount: 300}), f.time)
+	}
+}
+
+func TestDecoder_Stream(t *testing.T) {
+	var buf bytes.Buffer
+	np := qlog.New("app", qlog.DebugLevel, qlog.Clock(func() time.Time { return time.Unix(10, 0) })).SetOutput(
+		qlog.MustCBOR(func(o *qlog.CBOROptions) error {
+			o.OutHandle, o.ErrHandle, o.OutLevel = &buf, &buf, qlog.DebugLevel
+			return nil
+		}))
+	np.Debug("one")
+	np.ERROR.Fields(qlog.F{Key: "n", Value: 2}).Msg("two")
+
+	d := cbor.NewDecoder(bytes.NewReader(buf.Bytes()), func(o *cbor.Options) error {
+		o.TimeFieldFormat = "Unix"
+		return nil
+	})
+	line, err := d.AppendJSON(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"n":"app","t":"10","l":"debug","m":"one"}`+"\n", string(line))
+	line, err = d.AppendJSON(line[:0])
+	assert.NoError(t, err)
+	assert.Equal(t, `{"n":"app","t":"10","l":"error","m":"two","n":2}`+"\n", string(line))
+	_, err = d.AppendJSON(line[:0])
+	assert.Equal(t, io.EOF, err)
+
+	for _, tt := range []struct {
+		in  []byte
+		err error
+	}{
+		{buf.Bytes()[:5], io.ErrUnexpectedEOF},
+		{[]byte{0x01}, cbor.ErrSyntax},
+		{[]byte{0xbf, 0x01, 0x01, 0xff}, cbor.ErrSyntax},
+		{[]byte{0xbf, 0x61, 'a', 0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, cbor.ErrSyntax},
+		{append([]byte{0xbf, 0x61, 'a'}, bytes.Repeat([]byte{0x81}, 100)...), cbor.ErrSyntax},
+	} {
+		_, err := cbor.NewDecoder(bytes.NewReader(tt.in)).AppendJSON(nil)
+		if tt.err == cbor.ErrSyntax && assert.Error(t, err) {
+			assert.Contains(t, err.Error(), tt.err.Error())
+			continue
+		}
+		assert.Equal(t, tt.err, err)
+	}
+}
@@ -0,0 +1,488 @@
+package qlog
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// CBOR major types and tags of CBOR output
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+
+	cborFalse   = cborSimple | 20
+	cborTrue    = cborSimple | 21
+	cborNull    = cborSimple | 22
+	cborFloat32 = cborSimple | 26
+	cborFloat64 = cborSimple | 27
+	cborIndef   = 31
+	cborBreak   = 0xff
+
+	// CBORTagEpoch is epoch time in seconds (RFC 8949)
+	CBORTagEpoch = 1
+	// CBORTagEmbeddedJSON is json text in a byte string
+	CBORTagEmbeddedJSON = 262
+	// CBORTagExtendedTime is time as map {1: seconds, -9: nanoseconds}
+	// (RFC 9581)
+	CBORTagExtendedTime = 1001
+	// CBORTagDuration is duration as map {1: seconds, -9: nanoseconds}
+	// (RFC 9581)
+	CBORTagDuration = 1002
+)
+
+// CBOROptions configures CBOR output
+type CBOROptions struct {
+	ErrHandle     io.Writer
+	OutHandle     io.Writer
+	ErrLevel      uint8
+	OutLevel      uint8
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+}
+
+func defaultCBOROptions() *CBOROptions {
+	o := defaultJsonOptions()
+	return &CBOROptions{
+		ErrHandle:     os.Stderr,
+		OutHandle:     os.Stdout,
+		ErrLevel:      ErrorLevel,
+		OutLevel:      InfoLevel,
+		LogName:       o.LogName,
+		TimestampName: o.TimestampName,
+		LevelName:     o.LevelName,
+		MessageName:   o.MessageName,
+	}
+}
+
+// CBOR returns notepad option adding outputs of CBOREncoder, or error
+// of an option or invalid levels
+func CBOR(opts ...func(*CBOROptions) error) (func(np *Notepad), error) {
+	options := defaultCBOROptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	enc := &CBOREncoder{
+		LogName:       options.LogName,
+		TimestampName: options.TimestampName,
+		LevelName:     options.LevelName,
+		MessageName:   options.MessageName,
+	}
+	return Encode(enc, func(o *OutputOptions) error {
+		o.OutHandle, o.ErrHandle = AddSync(options.OutHandle), AddSync(options.ErrHandle)
+		o.OutLevel, o.ErrLevel = options.OutLevel, options.ErrLevel
+		return nil
+	})
+}
+
+// MustCBOR is like CBOR but panics if an option fails
+func MustCBOR(opts ...func(*CBOROptions) error) func(np *Notepad) {
+	fn, err := CBOR(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// CBOREncoder encodes entries as CBOR maps (RFC 8949) written one after
+// another. Field values are encoded from their typed values: times with
+// tag 1 or 1001, durations with tag 1002, levels as numbers. Values
+// without native encoding, such as structs and json.Marshaler, are json
+// embedded with tag 262. Strings that are not valid UTF-8 are byte
+// strings. Keys with empty names are omitted.
+//
+// The qlog/cbor package decodes the stream back to Json output lines.
+type CBOREncoder struct {
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+}
+
+// NewCBOREncoder returns CBOREncoder with the Json output key names
+func NewCBOREncoder() *CBOREncoder {
+	o := defaultCBOROptions()
+	return &CBOREncoder{
+		LogName:       o.LogName,
+		TimestampName: o.TimestampName,
+		LevelName:     o.LevelName,
+		MessageName:   o.MessageName,
+	}
+}
+
+func (c *CBOREncoder) Begin(dst []byte, e *Entry) []byte {
+	dst = append(dst, cborMap|cborIndef)
+	if c.LogName != "" {
+		dst = appendCBORBytes(appendCBORString(dst, c.LogName), e.Logger.Notepad.Name)
+	}
+	if c.TimestampName != "" {
+		dst = appendCBORTime(appendCBORString(dst, c.TimestampName), e.Time)
+	}
+	if c.LevelName != "" {
+		dst = appendCBORHead(appendCBORString(dst, c.LevelName), cborUint, uint64(e.Logger.Level.n))
+	}
+	if c.MessageName != "" {
+		dst = appendCBORBytes(appendCBORString(dst, c.MessageName), e.Message)
+	}
+	return dst
+}
+
+func (c *CBOREncoder) AddField(dst []byte, f *Field) []byte {
+	dst = appendCBORString(dst, f.Key)
+	if f.Value != nil || f.Buffer.Len() == 0 {
+		if b, ok := appendCBORValue(dst, f.Value, 0); ok {
+			return b
+		}
+	}
+	return appendCBORJSON(dst, f.Buffer.Bytes())
+}
+
+func (c *CBOREncoder) OpenObject(dst []byte, key string) []byte {
+	return append(appendCBORString(dst, key), cborMap|cborIndef)
+}
+
+func (c *CBOREncoder) CloseObject(dst []byte) []byte {
+	return append(dst, cborBreak)
+}
+
+func (c *CBOREncoder) End(dst []byte, e *Entry) []byte {
+	return append(dst, cborBreak)
+}
+
+// appendCBORHead appends initial byte of major type with argument n
+func appendCBORHead(dst []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(dst, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(dst, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(dst, major|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(dst, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(dst, major|27, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendCBORInt(dst []byte, n int64) []byte {
+	if n < 0 {
+		return appendCBORHead(dst, cborNegInt, uint64(-1-n))
+	}
+	return appendCBORHead(dst, cborUint, uint64(n))
+}
+
+// appendCBORString appends text string, byte string if s is not valid
+// UTF-8
+func appendCBORString(dst []byte, s string) []byte {
+	major := byte(cborText)
+	if !utf8.ValidString(s) {
+		major = cborBytes
+	}
+	return append(appendCBORHead(dst, major, uint64(len(s))), s...)
+}
+
+// appendCBORBytes appends text of s like appendCBORString
+func appendCBORBytes(dst []byte, s []byte) []byte {
+	major := byte(cborText)
+	if !utf8.Valid(s) {
+		major = cborBytes
+	}
+	return append(appendCBORHead(dst, major, uint64(len(s))), s...)
+}
+
+func appendCBORFloat64(dst []byte, f float64) []byte {
+	n := math.Float64bits(f)
+	return append(dst, cborFloat64, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendCBORFloat32(dst []byte, f float32) []byte {
+	n := math.Float32bits(f)
+	return append(dst, cborFloat32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// appendCBORTime appends t as epoch seconds, as extended time if it has
+// fractional seconds
+func appendCBORTime(dst []byte, t time.Time) []byte {
+	if t.Nanosecond() == 0 {
+		return appendCBORInt(appendCBORHead(dst, cborTag, CBORTagEpoch), t.Unix())
+	}
+	return appendCBORSecNano(appendCBORHead(dst, cborTag, CBORTagExtendedTime), t.Unix(), int64(t.Nanosecond()))
+}
+
+func appendCBORDuration(dst []byte, d time.Duration) []byte {
+	return appendCBORSecNano(appendCBORHead(dst, cborTag, CBORTagDuration),
+		int64(d/time.Second), int64(d%time.Second))
+}
+
+// appendCBORSecNano appends map {1: sec, -9: nsec}, nsec is omitted if
+// zero
+func appendCBORSecNano(dst []byte, sec, nsec int64) []byte {
+	if nsec == 0 {
+		return appendCBORInt(append(dst, cborMap|1, cborUint|1), sec)
+	}
+	dst = appendCBORInt(append(dst, cborMap|2, cborUint|1), sec)
+	return appendCBORInt(append(dst, cborNegInt|8), nsec)
+}
+
+// appendCBORJSON appends json text with embedded json tag
+func appendCBORJSON(dst []byte, js []byte) []byte {
+	dst = appendCBORHead(dst, cborTag, CBORTagEmbeddedJSON)
+	return append(appendCBORHead(dst, cborBytes, uint64(len(js))), js...)
+}
+
+// appendCBORValue appends CBOR encoding of v. It follows appendValue so
+// that decoded values have the same json encoding. It returns false if v
+// has no native encoding.
+func appendCBORValue(dst []byte, v interface{}, depth int) ([]byte, bool) {
+	switch val := v.(type) {
+	case string:
+		return appendCBORString(dst, val), true
+	case []byte:
+		return append(appendCBORHead(dst, cborBytes, uint64(len(val))), val...), true
+	case error:
+		return appendCBORString(dst, val.Error()), true
+	case []error:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, err := range val {
+			if err == nil {
+				dst = append(dst, cborNull)
+				continue
+			}
+			dst = appendCBORString(dst, err.Error())
+		}
+		return dst, true
+	case bool:
+		return appendCBORBool(dst, val), true
+	case int:
+		return appendCBORInt(dst, int64(val)), true
+	case int8:
+		return appendCBORInt(dst, int64(val)), true
+	case int16:
+		return appendCBORInt(dst, int64(val)), true
+	case int32:
+		return appendCBORInt(dst, int64(val)), true
+	case int64:
+		return appendCBORInt(dst, val), true
+	case uint:
+		return appendCBORHead(dst, cborUint, uint64(val)), true
+	case uint8:
+		return appendCBORHead(dst, cborUint, uint64(val)), true
+	case uint16:
+		return appendCBORHead(dst, cborUint, uint64(val)), true
+	case uint32:
+		return appendCBORHead(dst, cborUint, uint64(val)), true
+	case uint64:
+		return appendCBORHead(dst, cborUint, val), true
+	case float32:
+		return appendCBORFloat32(dst, val), true
+	case float64:
+		return appendCBORFloat64(dst, val), true
+	case time.Time:
+		return appendCBORTime(dst, val), true
+	case time.Duration:
+		return appendCBORDuration(dst, val), true
+	case []string:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, s := range val {
+			dst = appendCBORString(dst, s)
+		}
+		return dst, true
+	case []time.Time:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, t := range val {
+			dst = appendCBORTime(dst, t)
+		}
+		return dst, true
+	case []time.Duration:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, d := range val {
+			dst = appendCBORDuration(dst, d)
+		}
+		return dst, true
+	case []bool, []int, []int8, []int16, []int32, []int64, []uint, []uint16,
+		[]uint32, []uint64, []float32, []float64:
+		return appendCBORSlice(dst, val), true
+	case nil:
+		return append(dst, cborNull), true
+	case map[string]string:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dst = appendCBORHead(dst, cborMap, uint64(len(keys)))
+		for _, k := range keys {
+			dst = appendCBORString(appendCBORString(dst, k), val[k])
+		}
+		return dst, true
+	case map[string]interface{}:
+		if depth >= maxDepth {
+			return dst, false
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dst = appendCBORHead(dst, cborMap, uint64(len(keys)))
+		ok := true
+		for _, k := range keys {
+			if dst, ok = appendCBORValue(appendCBORString(dst, k), val[k], depth+1); !ok {
+				break
+			}
+		}
+		return dst, ok
+	case FlatMapS:
+		return appendCBORFlatMapS(dst, &val), true
+	case *FlatMapS:
+		if val == nil {
+			return append(dst, cborNull), true
+		}
+		return appendCBORFlatMapS(dst, val), true
+	case FlatMapI:
+		return appendCBORFlatMapI(dst, &val, depth)
+	case *FlatMapI:
+		if val == nil {
+			return append(dst, cborNull), true
+		}
+		return appendCBORFlatMapI(dst, val, depth)
+	case json.Number, json.Marshaler:
+		return dst, false
+	case encoding.TextMarshaler:
+		if isNilPtr(val) {
+			return append(dst, cborNull), true
+		}
+		text, err := val.MarshalText()
+		if err != nil {
+			return appendCBORString(dst, fmt.Sprintf("marshaling error: %v", err)), true
+		}
+		return appendCBORBytes(dst, text), true
+	case fmt.Stringer:
+		if isNilPtr(val) {
+			return append(dst, cborNull), true
+		}
+		return appendCBORString(dst, val.String()), true
+	}
+	return dst, false
+}
+
+func appendCBORBool(dst []byte, b bool) []byte {
+	if b {
+		return append(dst, cborTrue)
+	}
+	return append(dst, cborFalse)
+}
+
+// appendCBORSlice appends array of numbers or bools
+func appendCBORSlice(dst []byte, v interface{}) []byte {
+	switch val := v.(type) {
+	case []bool:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, b := range val {
+			dst = appendCBORBool(dst, b)
+		}
+	case []int:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORInt(dst, int64(n))
+		}
+	case []int8:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORInt(dst, int64(n))
+		}
+	case []int16:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORInt(dst, int64(n))
+		}
+	case []int32:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORInt(dst, int64(n))
+		}
+	case []int64:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORInt(dst, n)
+		}
+	case []uint:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORHead(dst, cborUint, uint64(n))
+		}
+	case []uint16:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORHead(dst, cborUint, uint64(n))
+		}
+	case []uint32:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORHead(dst, cborUint, uint64(n))
+		}
+	case []uint64:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, n := range val {
+			dst = appendCBORHead(dst, cborUint, n)
+		}
+	case []float32:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, f := range val {
+			dst = appendCBORFloat32(dst, f)
+		}
+	case []float64:
+		dst = appendCBORHead(dst, cborArray, uint64(len(val)))
+		for _, f := range val {
+			dst = appendCBORFloat64(dst, f)
+		}
+	}
+	return dst
+}
+
+func appendCBORFlatMapS(dst []byte, m *FlatMapS) []byte {
+	dst = appendCBORHead(dst, cborMap, uint64(len(m.K)))
+	for i := range m.K {
+		dst = appendCBORString(dst, m.K[i])
+		if i < len(m.V) {
+			dst = appendCBORString(dst, m.V[i])
+		} else {
+			dst = append(dst, cborNull)
+		}
+	}
+	return dst
+}
+
+func appendCBORFlatMapI(dst []byte, m *FlatMapI, depth int) ([]byte, bool) {
+	dst = appendCBORHead(dst, cborMap, uint64(len(m.K)))
+	ok := true
+	for i := range m.K {
+		dst = appendCBORString(dst, m.K[i])
+		if i >= len(m.V) {
+			dst = append(dst, cborNull)
+			continue
+		}
+		if dst, ok = appendCBORValue(dst, m.V[i], depth+1); !ok {
+			break
+		}
+	}
+	return dst, ok
+}
@@ -0,0 +1,284 @@
+package qlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+)
+
+// CEFOptions configures ArcSight CEF output
+type CEFOptions struct {
+	ErrHandle io.Writer
+	OutHandle io.Writer
+	ErrLevel  uint8
+	OutLevel  uint8
+	// Vendor, Product and Version are device header fields
+	Vendor  string
+	Product string
+	Version string
+	// EventIDKey is the key of the field used as Device Event Class ID,
+	// the level name is used if the entry has no such field
+	EventIDKey string
+	// ExtensionKeys maps field keys to CEF extension keys
+	ExtensionKeys map[string]string
+}
+
+func defaultCEFOptions() *CEFOptions {
+	return &CEFOptions{
+		ErrHandle:  os.Stderr,
+		OutHandle:  os.Stdout,
+		ErrLevel:   ErrorLevel,
+		OutLevel:   InfoLevel,
+		Vendor:     "qlog",
+		Product:    "qlog",
+		Version:    "1.0",
+		EventIDKey: "event_id",
+	}
+}
+
+// CEF returns notepad option adding outputs of CEFEncoder, or error of
+// an option or invalid levels
+func CEF(opts ...func(*CEFOptions) error) (func(np *Notepad), error) {
+	options := defaultCEFOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	enc := NewCEFEncoder()
+	enc.Vendor, enc.Product, enc.Version = options.Vendor, options.Product, options.Version
+	enc.EventIDKey, enc.ExtensionKeys = options.EventIDKey, options.ExtensionKeys
+	return Encode(enc, func(o *OutputOptions) error {
+		o.OutHandle, o.ErrHandle = AddSync(options.OutHandle), AddSync(options.ErrHandle)
+		o.OutLevel, o.ErrLevel = options.OutLevel, options.ErrLevel
+		return nil
+	})
+}
+
+// MustCEF is like CEF but panics if an option fails
+func MustCEF(opts ...func(*CEFOptions) error) func(np *Notepad) {
+	fn, err := CEF(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// securitySeverity maps levels to CEF and LEEF severity 0-10
+var securitySeverity = [7]int{
+	DebugLevel:    1,
+	InfoLevel:     3,
+	WarnLevel:     5,
+	ErrorLevel:    7,
+	CriticalLevel: 9,
+	PanicLevel:    10,
+	FatalLevel:    10,
+}
+
+// CEFEncoder encodes entries as CEF lines
+//
+//	CEF:0|Vendor|Product|Version|EventID|message|severity|rt=ms cat=name key=value...
+//
+// Fields are extensions with keys mapped by ExtensionKeys, other keys
+// are kept without characters which are not letters or digits. Keys of
+// nested objects are prefixed by the object keys joined by underscores,
+// e.g. parent_id.
+type CEFEncoder struct {
+	Vendor        string
+	Product       string
+	Version       string
+	EventIDKey    string
+	LogName       string
+	ExtensionKeys map[string]string
+	// Severity is CEF severity of levels
+	Severity [7]int
+}
+
+// NewCEFEncoder returns CEFEncoder with the CEF output defaults
+func NewCEFEncoder() *CEFEncoder {
+	o := defaultCEFOptions()
+	return &CEFEncoder{
+		Vendor:     o.Vendor,
+		Product:    o.Product,
+		Version:    o.Version,
+		EventIDKey: o.EventIDKey,
+		LogName:    "cat",
+		Severity:   securitySeverity,
+	}
+}
+
+func (c *CEFEncoder) Begin(dst []byte, e *Entry) []byte {
+	dst = append(dst, "CEF:0|"...)
+	for _, s := range [3]string{c.Vendor, c.Product, c.Version} {
+		dst = append(appendCEFHeader(dst, s), '|')
+	}
+	dst = append(appendEventID(dst, e, c.EventIDKey, appendCEFHeader), '|')
+	dst = append(appendCEFHeader(dst, Bytes2Str(e.Message)), '|')
+	dst = strconv.AppendInt(dst, int64(c.Severity[e.Logger.Level.n]), 10)
+	dst = strconv.AppendInt(append(dst, "|rt="...), e.Time.UnixNano()/1e6, 10)
+	if c.LogName != "" && len(e.Logger.Notepad.Name) > 0 {
+		dst = appendCEFValue(append(append(append(dst, ' '), c.LogName...), '='), Bytes2Str(e.Logger.Notepad.Name))
+	}
+	return dst
+}
+
+func (c *CEFEncoder) AddField(dst []byte, f *Field) []byte {
+	key := securityKey(securityPrefix(dst), f.Key, c.EventIDKey, c.ExtensionKeys)
+	if key == "" {
+		return dst
+	}
+	return appendEscapedText(append(append(append(dst, ' '), key...), '='), f, appendCEFValue)
+}
+
+func (c *CEFEncoder) OpenObject(dst []byte, key string) []byte {
+	return openSecurityObject(dst, key)
+}
+
+func (c *CEFEncoder) CloseObject(dst []byte) []byte {
+	return closeSecurityObject(dst)
+}
+
+func (c *CEFEncoder) End(dst []byte, e *Entry) []byte {
+	return append(dst, '\n')
+}
+
+// appendCEFHeader appends header field with escaped pipes and
+// backslashes, line breaks and zero bytes are replaced by spaces
+func appendCEFHeader(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '|', '\\':
+			dst = append(dst, '\\', c)
+		case '\n', '\r', securityObjectMark:
+			dst = append(dst, ' ')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// appendCEFValue appends extension value with escaped equal signs,
+// backslashes and line breaks, zero bytes are replaced by spaces
+func appendCEFValue(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '=', '\\':
+			dst = append(dst, '\\', c)
+		case securityObjectMark:
+			dst = append(dst, ' ')
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// appendEventID appends value of the event id field or the level name
+func appendEventID(dst []byte, e *Entry, key string, esc func([]byte, string) []byte) []byte {
+	if f, ok := lookupField(e, key); ok && key != "" {
+		return appendEscapedText(dst, f, esc)
+	}
+	return esc(dst, Bytes2Str(e.Logger.Level.ToBytes()))
+}
+
+// securityObjectMark encloses keys of open objects in CEF and LEEF
+// lines, values never contain it
+const securityObjectMark = 0
+
+// openSecurityObject appends key of the opened object enclosed in marks,
+// the key is removed by closeSecurityObject
+func openSecurityObject(dst []byte, key string) []byte {
+	return append(append(append(dst, securityObjectMark), key...), securityObjectMark)
+}
+
+// closeSecurityObject removes key of the innermost open object
+func closeSecurityObject(dst []byte) []byte {
+	end := bytes.LastIndexByte(dst, securityObjectMark)
+	if end < 0 {
+		return dst
+	}
+	start := bytes.LastIndexByte(dst[:end], securityObjectMark)
+	if start < 0 {
+		return dst
+	}
+	return append(dst[:start], dst[end+1:]...)
+}
+
+// securityPrefix returns keys of open objects each followed by an
+// underscore
+func securityPrefix(dst []byte) string {
+	i := bytes.IndexByte(dst, securityObjectMark)
+	if i < 0 {
+		return ""
+	}
+	var b []byte
+	for i >= 0 {
+		dst = dst[i+1:]
+		end := bytes.IndexByte(dst, securityObjectMark)
+		b = append(append(b, alnumKey(Bytes2Str(dst[:end]))...), '_')
+		dst = dst[end+1:]
+		i = bytes.IndexByte(dst, securityObjectMark)
+	}
+	return string(b)
+}
+
+// securityKey returns extension key of field key in objects of prefix,
+// empty for the event id
+func securityKey(prefix, key, eventID string, keys map[string]string) string {
+	if key == eventID {
+		return ""
+	}
+	if k, ok := keys[prefix+key]; ok {
+		return k
+	}
+	return prefix + alnumKey(key)
+}
+
+// alnumKey returns key without characters which are not letters or
+// digits
+func alnumKey(key string) string {
+	for i := 0; i < len(key); i++ {
+		if !isAlnum(key[i]) {
+			b := make([]byte, 0, len(key))
+			for j := 0; j < len(key); j++ {
+				if isAlnum(key[j]) {
+					b = append(b, key[j])
+				}
+			}
+			return string(b)
+		}
+	}
+	return key
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// appendEscapedText appends field value escaped by esc: strings, errors
+// and json strings unquoted, other values json encoded
+func appendEscapedText(dst []byte, f *Field, esc func([]byte, string) []byte) []byte {
+	switch v := f.Value.(type) {
+	case string:
+		return esc(dst, v)
+	case error:
+		if !isNilPtr(v) {
+			return esc(dst, v.Error())
+		}
+	}
+	b := f.Buffer.Bytes()
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if json.Unmarshal(b, &s) == nil {
+			return esc(dst, s)
+		}
+	}
+	return esc(dst, Bytes2Str(b))
+}
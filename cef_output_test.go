@@ -0,0 +1,145 @@
+package qlog_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+var securityTime = time.Date(2020, 1, 2, 10, 0, 0, 123456789, time.UTC)
+
+func TestCEF(t *testing.T) {
+	out := &bytes.Buffer{}
+	np := qlog.New("auth", qlog.DebugLevel, qlog.Clock(func() time.Time { return securityTime })).SetOutput(
+		qlog.MustCEF(func(o *qlog.CEFOptions) error {
+			o.OutHandle, o.ErrHandle, o.OutLevel = out, out, qlog.DebugLevel
+			o.Vendor, o.Product, o.Version = "Acme|Corp", `Gate\way`, "2.1"
+			o.ExtensionKeys = map[string]string{"src_ip": "src", "user": "suser"}
+			return nil
+		}))
+	np.WARN.Fields(qlog.F{Key: "event_id", Value: 4625}, qlog.F{Key: "src_ip", Value: "10.0.0.1"},
+		qlog.F{Key: "user", Value: "a=b\\c"}, qlog.F{Key: "req.path", Value: "/login\n"},
+		qlog.F{Key: "err", Value: errors.New("bad password")}).Msg("login | failed")
+	np.CRITICAL.Fields(qlog.F{Key: "ok", Value: false}).Msg("locked\nout")
+	assert.Equal(t, `CEF:0|Acme\|Corp|Gate\\way|2.1|4625|login \| failed|5|rt=1577959200123 cat=auth`+
+		` src=10.0.0.1 suser=a\=b\\c reqpath=/login\n err=bad password`+"\n"+
+		`CEF:0|Acme\|Corp|Gate\\way|2.1|critical|locked out|9|rt=1577959200123 cat=auth ok=false`+"\n",
+		out.String())
+}
+
+func TestLEEF(t *testing.T) {
+	out := &bytes.Buffer{}
+	np := qlog.New("auth", qlog.DebugLevel, qlog.Clock(func() time.Time { return securityTime })).SetOutput(
+		qlog.MustLEEF(func(o *qlog.LEEFOptions) error {
+			o.OutHandle, o.ErrHandle, o.OutLevel = out, out, qlog.DebugLevel
+			o.Vendor, o.Product = "Acme", "Gateway"
+			o.ExtensionKeys = map[string]string{"src_ip": "src"}
+			return nil
+		}))
+	np.ERROR.Fields(qlog.F{Key: "event_id", Value: "auth.fail"}, qlog.F{Key: "src_ip", Value: "10.0.0.1"},
+		qlog.F{Key: "note", Value: "a\tb\nc"}).Msg("login failed")
+	assert.Equal(t, "LEEF:1.0|Acme|Gateway|1.0|auth.fail|devTime=1577959200123\tsev=7\tcat=auth\t"+
+		"msg=login failed\tsrc=10.0.0.1\tnote=a b c\n", out.String())
+
+	out.Reset()
+	np = qlog.New("auth", qlog.DebugLevel, qlog.Clock(func() time.Time { return securityTime })).SetOutput(
+		qlog.MustLEEF(func(o *qlog.LEEFOptions) error {
+			o.OutHandle, o.ErrHandle = out, out
+			o.LEEFVersion, o.Delimiter = "2.0", '^'
+			return nil
+		}))
+	np.INFO.Fields(qlog.F{Key: "tags", Value: "x^y"}).Msg("ok")
+	assert.Equal(t, "LEEF:2.0|qlog|qlog|1.0|info|^|devTime=1577959200123^sev=3^cat=auth^msg=ok^tags=x y\n",
+		out.String())
+}
+
+// dottedEncoder writes fields with keys like a.b into object a
+type dottedEncoder struct {
+	qlog.Encoder
+}
+
+func (d dottedEncoder) AddField(dst []byte, f *qlog.Field) []byte {
+	i := strings.IndexByte(f.Key, '.')
+	if i < 0 {
+		return d.Encoder.AddField(dst, f)
+	}
+	nested := *f
+	nested.Key = f.Key[i+1:]
+	dst = d.Encoder.OpenObject(dst, f.Key[:i])
+	return d.Encoder.CloseObject(d.AddField(dst, &nested))
+}
+
+func TestSecurity_NestedObjects(t *testing.T) {
+	cef := qlog.NewCEFEncoder()
+	cef.ExtensionKeys = map[string]string{"req_parent_user": "suser"}
+	leef := qlog.NewLEEFEncoder()
+	for _, tc := range []struct {
+		enc  qlog.Encoder
+		want string
+	}{
+		{cef, `CEF:0|qlog|qlog|1.0|info|opened|3|rt=1577959200123 cat=auth` +
+			` req_id=1 req_parent_id=2 suser=bob req_parent_child_id=a\=b req_n=3` + "\n"},
+		{leef, "LEEF:1.0|qlog|qlog|1.0|info|devTime=1577959200123\tsev=3\tcat=auth\tmsg=opened" +
+			"\treq_id=1\treq_parent_id=2\treq_parent_user=bob\treq_parent_child_id=a=b\treq_n=3\n"},
+	} {
+		out := &bytes.Buffer{}
+		np := qlog.New("auth", qlog.InfoLevel, qlog.Clock(func() time.Time { return securityTime })).SetOutput(
+			qlog.MustEncode(qlog.NestFields(dottedEncoder{tc.enc}, "req"), func(o *qlog.OutputOptions) error {
+				o.OutHandle = qlog.AddSync(out)
+				return nil
+			}))
+		np.INFO.Fields(qlog.F{Key: "id", Value: 1}, qlog.F{Key: "parent.id", Value: 2},
+			qlog.F{Key: "parent.user", Value: "bob"}, qlog.F{Key: "parent.child.id", Value: "a=b"},
+			qlog.F{Key: "n", Value: 3}).Msg("opened")
+		assert.Equal(t, tc.want, out.String())
+	}
+}
+
+func TestFromConfig_CEF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "security.log")
+
+	np, err := qlog.FromConfig(strings.NewReader(`
+name: svc
+level: info
+outputs:
+  - type: file
+    path: ` + path + `
+    format: leef
+    leef_version: "2.0"
+    delimiter: "|"
+    vendor: Acme
+    keys: "src_ip:src, user:usrName"
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	np.WARN.Fields(qlog.F{Key: "src_ip", Value: "10.0.0.1"}, qlog.F{Key: "user", Value: "bob"}).Msg("denied")
+	if !assert.NoError(t, np.Close()) {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	line := string(data)
+	assert.True(t, strings.HasPrefix(line, "LEEF:2.0|Acme|qlog|1.0|warn|x7c|devTime="), line)
+	assert.True(t, strings.HasSuffix(line, "|sev=5|cat=svc|msg=denied|src=10.0.0.1|usrName=bob\n"), line)
+
+	for _, cfg := range []string{"type: cef\n    keys: bad", "type: leef\n    leef_version: \"3.0\""} {
+		_, err = qlog.FromConfig(strings.NewReader("outputs:\n  - " + cfg + "\n"))
+		assert.Error(t, err, cfg)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/karantin2020/qlog/cbor"
+)
+
+func decodeCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("qlog decode", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	build := filterFlags(fs)
+	output := outputFlags(fs)
+	var (
+		timeFormat = fs.String("time-field-format", "2006-01-02T15:04:05.000Z0700", "TimeFieldFormat of the logger")
+		unit       = fs.Duration("duration-unit", time.Millisecond, "DurationFieldUnit of the logger")
+		durFloat   = fs.Bool("duration-float", false, "durations are floats, DurationFieldInteger of the logger is false")
+		utc        = fs.Bool("utc", false, "decode times in UTC instead of local time")
+	)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	f, k, err := build()
+	if err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	out := bufio.NewWriter(stdout)
+	defer out.Flush()
+	w, sel, err := output(out, stdout, k)
+	if err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	opts := func(o *cbor.Options) error {
+		o.TimestampName, o.LevelName = k.time, k.level
+		o.TimeFieldFormat = *timeFormat
+		o.DurationFieldUnit, o.DurationFieldInteger = *unit, !*durFloat
+		if *utc {
+			o.Location = time.UTC
+		}
+		return nil
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		if err := decodeFile(name, opts, func(line []byte) error {
+			r, err := parseRecord(line, k)
+			if err != nil || !f.match(r) {
+				return err
+			}
+			return w.write(r, selectFields(r, k, sel))
+		}); err != nil {
+			fmt.Fprintf(stderr, "qlog: %s: %v\n", name, err)
+			return 2
+		}
+	}
+	if err := w.flush(); err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	return 0
+}
+
+// decodeFile calls fn with Json output lines of CBOR entries of file
+func decodeFile(name string, opts func(*cbor.Options) error, fn func(line []byte) error) error {
+	rc, err := openInput(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	d := cbor.NewDecoder(rc, opts)
+	var line []byte
+	for {
+		if line, err = d.AppendJSON(line[:0]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err = fn(line); err != nil {
+			return err
+		}
+	}
+}
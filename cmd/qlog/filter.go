@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// filter selects records
+type filter struct {
+	level int
+	names []string
+	since time.Time
+	until time.Time
+	where expr
+}
+
+func (f *filter) match(r *record) bool {
+	if f.level >= 0 && r.level < f.level {
+		return false
+	}
+	if len(f.names) > 0 && !matchName(r.name, f.names) {
+		return false
+	}
+	if !f.since.IsZero() && (r.time.IsZero() || r.time.Before(f.since)) {
+		return false
+	}
+	if !f.until.IsZero() && (r.time.IsZero() || !r.time.Before(f.until)) {
+		return false
+	}
+	return f.where == nil || f.where.eval(r)
+}
+
+// active reports if the filter drops anything
+func (f *filter) active() bool {
+	return f.level >= 0 || len(f.names) > 0 || !f.since.IsZero() || !f.until.IsZero() || f.where != nil
+}
+
+// matchName reports if name is one of names or their dotted descendant
+func matchName(name string, names []string) bool {
+	for _, n := range names {
+		if name == n || strings.HasPrefix(name, n+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// expr is a compiled field expression
+type expr interface {
+	eval(r *record) bool
+}
+
+type andExpr struct{ l, r expr }
+type orExpr struct{ l, r expr }
+type notExpr struct{ e expr }
+
+// cmpExpr compares field with value, empty op tests presence
+type cmpExpr struct {
+	key   string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (e andExpr) eval(r *record) bool { return e.l.eval(r) && e.r.eval(r) }
+func (e orExpr) eval(r *record) bool  { return e.l.eval(r) || e.r.eval(r) }
+func (e notExpr) eval(r *record) bool { return !e.e.eval(r) }
+
+func (e *cmpExpr) eval(r *record) bool {
+	raw, ok := r.get(e.key)
+	if e.op == "" || !ok {
+		return ok && e.op == "" || !ok && e.op == "!="
+	}
+	text := rawText(raw)
+	if e.op == "~" {
+		return e.re.MatchString(text)
+	}
+	c := 0
+	a, aok := rawNumber(raw)
+	b, bok := rawNumber([]byte(e.value))
+	switch {
+	case aok && bok:
+		if a < b {
+			c = -1
+		} else if a > b {
+			c = 1
+		}
+	default:
+		c = strings.Compare(text, e.value)
+	}
+	switch e.op {
+	case "=", "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+// parseExpr compiles expression of comparisons joined by && and ||,
+// negated by ! and grouped by parentheses:
+//
+//	user_id=42 && latency>100
+//	!(level=debug) || msg~"^conn.*closed$"
+//	user.id          (field is present)
+//
+// Operators are = == != > >= < <= and ~ (regexp). Values are numbers,
+// bare words or quoted strings. Numbers compare numerically.
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{}
+	if err := p.lex(s); err != nil {
+		return nil, err
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	return e, nil
+}
+
+type token struct {
+	text  string
+	op    bool
+	quote bool
+}
+
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+var exprOps = []string{"&&", "||", "==", "!=", ">=", "<=", "=", ">", "<", "~", "!", "(", ")"}
+
+func (p *exprParser) lex(s string) error {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+			continue
+		case c == '"' || c == '\'':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string at %d", i)
+			}
+			p.toks = append(p.toks, token{text: b.String(), quote: true})
+			i = j + 1
+			continue
+		}
+		if op := matchOp(s[i:]); op != "" {
+			p.toks = append(p.toks, token{text: op, op: true})
+			i += len(op)
+			continue
+		}
+		j := i
+		for j < len(s) && !unicode.IsSpace(rune(s[j])) && matchOp(s[j:]) == "" && s[j] != '"' && s[j] != '\'' {
+			j++
+		}
+		p.toks = append(p.toks, token{text: s[i:j]})
+		i = j
+	}
+	return nil
+}
+
+func matchOp(s string) string {
+	for _, op := range exprOps {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *exprParser) peek(op string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].op && p.toks[p.pos].text == op
+}
+
+func (p *exprParser) or() (expr, error) {
+	l, err := p.and()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var r expr
+		if r, err = p.and(); err == nil {
+			l = orExpr{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) and() (expr, error) {
+	l, err := p.unary()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var r expr
+		if r, err = p.unary(); err == nil {
+			l = andExpr{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) unary() (expr, error) {
+	switch {
+	case p.peek("!"):
+		p.pos++
+		e, err := p.unary()
+		return notExpr{e}, err
+	case p.peek("("):
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	}
+	return p.cmp()
+}
+
+func (p *exprParser) cmp() (expr, error) {
+	if p.pos >= len(p.toks) || p.toks[p.pos].op {
+		return nil, fmt.Errorf("expected field name")
+	}
+	e := &cmpExpr{key: p.toks[p.pos].text}
+	p.pos++
+	if p.pos >= len(p.toks) || !p.toks[p.pos].op {
+		return e, nil
+	}
+	switch op := p.toks[p.pos].text; op {
+	case "=", "==", "!=", ">", ">=", "<", "<=", "~":
+		e.op = op
+	default:
+		return e, nil
+	}
+	p.pos++
+	if p.pos >= len(p.toks) || p.toks[p.pos].op {
+		return nil, fmt.Errorf("expected value after %s%s", e.key, e.op)
+	}
+	e.value = p.toks[p.pos].text
+	p.pos++
+	if e.op == "~" {
+		re, err := regexp.Compile(e.value)
+		if err != nil {
+			return nil, err
+		}
+		e.re = re
+	}
+	return e, nil
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karantin2020/qlog"
+)
+
+const (
+	// indexVersion is the version of index format
+	indexVersion = 2
+	// indexSuffix is appended to log file name to name its index
+	indexSuffix = ".qidx"
+	// headLen is the number of leading bytes of data checksummed to
+	// detect replaced files
+	headLen = 4096
+)
+
+// fileIndex is a sidecar index of a log file. Lines are grouped in
+// blocks by time bucket, blocks are referred to by their number.
+type fileIndex struct {
+	Version int `json:"version"`
+	// Keys are header keys of the indexed file
+	Keys [4]string `json:"keys"`
+	// Fields are indexed field keys, the name key included
+	Fields []string `json:"fields"`
+	Bucket int64    `json:"bucket"`
+	// Size is the number of indexed bytes of uncompressed data
+	Size int64 `json:"size"`
+	// File is the size of the file
+	File   int64        `json:"file"`
+	Head   uint32       `json:"head"`
+	Lines  int          `json:"lines"`
+	Levels [7]int       `json:"levels"`
+	Blocks []indexBlock `json:"blocks"`
+	// Terms are block numbers by field and value
+	Terms map[string]map[string][]int `json:"terms"`
+}
+
+// indexBlock is a range of lines, times are unix nanoseconds of timed
+// lines and are zero if there are none. Off and Size are offsets of
+// uncompressed data, Member is the offset of the gzip member holding
+// the block in compressed files.
+type indexBlock struct {
+	Off    int64  `json:"off"`
+	Size   int64  `json:"size"`
+	Member int64  `json:"member"`
+	Lines  int    `json:"lines"`
+	Min    int64  `json:"min"`
+	Max    int64  `json:"max"`
+	Levels [7]int `json:"levels"`
+}
+
+func indexKeys(k *keys) [4]string {
+	return [4]string{k.name, k.time, k.level, k.message}
+}
+
+// buildIndex indexes file name. Blocks end at bucket boundaries or after
+// maxLines lines.
+func buildIndex(name string, k *keys, fields []string, bucket time.Duration, maxLines int) (*fileIndex, error) {
+	st, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := openInput(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	x := &fileIndex{
+		Version: indexVersion,
+		Keys:    indexKeys(k),
+		Fields:  append([]string{k.name}, fields...),
+		Bucket:  int64(bucket),
+		File:    st.Size(),
+		Terms:   make(map[string]map[string][]int),
+	}
+	head := crc32.NewIEEE()
+	var b *indexBlock
+	var cur int64
+	x.Size, err = eachLineAt(io.TeeReader(r, &limitWriter{w: head, n: headLen}), func(off int64, line []byte) error {
+		x.Lines++
+		rec, err := parseRecord(line, k)
+		var t int64
+		if err == nil && !rec.time.IsZero() {
+			t = rec.time.UnixNano()
+		}
+		if b == nil || b.Lines >= maxLines || t != 0 && bucket > 0 && t/int64(bucket) != cur {
+			if b != nil {
+				b.Size = off - b.Off
+			}
+			x.Blocks = append(x.Blocks, indexBlock{Off: off})
+			b = &x.Blocks[len(x.Blocks)-1]
+			if t != 0 && bucket > 0 {
+				cur = t / int64(bucket)
+			}
+		}
+		b.Lines++
+		if err != nil {
+			return nil
+		}
+		if t != 0 {
+			if b.Min == 0 || t < b.Min {
+				b.Min = t
+			}
+			if t > b.Max {
+				b.Max = t
+			}
+		}
+		if rec.level >= 0 && rec.level < len(b.Levels) {
+			b.Levels[rec.level]++
+			x.Levels[rec.level]++
+		}
+		n := len(x.Blocks) - 1
+		for _, key := range x.Fields {
+			raw, ok := rec.get(key)
+			if !ok {
+				continue
+			}
+			terms := x.Terms[key]
+			if terms == nil {
+				terms = make(map[string][]int)
+				x.Terms[key] = terms
+			}
+			v := termValue(rawText(raw))
+			if l := terms[v]; len(l) == 0 || l[len(l)-1] != n {
+				terms[v] = append(l, n)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		b.Size = x.Size - b.Off
+	}
+	x.Head = head.Sum32()
+	return x, nil
+}
+
+// segmentGzip rewrites gzip file name with a gzip member per block of x
+// and records member offsets, so that blocks are decompressed without
+// the data before them. The file stays a valid gzip stream.
+func segmentGzip(name string, x *fileIndex) error {
+	st, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	r, err := openInput(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode())
+	if err != nil {
+		return err
+	}
+	cw := &countWriter{w: f}
+	zw := gzip.NewWriter(cw)
+	for i := range x.Blocks {
+		b := &x.Blocks[i]
+		zw.Reset(cw)
+		b.Member = cw.n
+		if _, err = io.CopyN(zw, r, b.Size); err != nil {
+			break
+		}
+		if err = zw.Close(); err != nil {
+			break
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	x.File = cw.n
+	return nil
+}
+
+// countWriter counts bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// limitWriter writes first n bytes to w and discards the rest
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		q := p
+		if int64(len(q)) > l.n {
+			q = q[:l.n]
+		}
+		l.n -= int64(len(q))
+		l.w.Write(q)
+	}
+	return len(p), nil
+}
+
+// termValue normalizes numbers so that equal numbers have equal terms
+func termValue(s string) string {
+	if f, ok := rawNumber([]byte(s)); ok {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return s
+}
+
+// writeIndex writes gzipped json index next to file name
+func writeIndex(name string, x *fileIndex) error {
+	tmp := name + indexSuffix + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	err = json.NewEncoder(zw).Encode(x)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+indexSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+var errStaleIndex = errors.New("stale index")
+
+// readIndex reads index of file name and checks that it is up to date.
+// Plain files may have grown since indexing.
+func readIndex(name string, k *keys) (*fileIndex, error) {
+	f, err := os.Open(name + indexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileIndex{}
+	if err := json.NewDecoder(zr).Decode(x); err != nil {
+		return nil, err
+	}
+	if x.Version != indexVersion || x.Keys != indexKeys(k) {
+		return nil, errStaleIndex
+	}
+	st, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if gz := strings.HasSuffix(name, ".gz"); gz && st.Size() != x.File || !gz && st.Size() < x.Size {
+		return nil, errStaleIndex
+	}
+	r, err := openInput(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	head := crc32.NewIEEE()
+	n := x.Size
+	if n > headLen {
+		n = headLen
+	}
+	if _, err := io.CopyN(head, r, n); err != nil || head.Sum32() != x.Head {
+		return nil, errStaleIndex
+	}
+	return x, nil
+}
+
+// candidates returns blocks that may have records matching f
+func (x *fileIndex) candidates(f *filter) []int {
+	mask := make([]bool, len(x.Blocks))
+	for i := range mask {
+		mask[i] = true
+	}
+	if len(f.names) > 0 {
+		names := make([]bool, len(x.Blocks))
+		for v, l := range x.Terms[x.Keys[0]] {
+			if matchName(v, f.names) {
+				for _, n := range l {
+					names[n] = true
+				}
+			}
+		}
+		andMask(mask, names)
+	}
+	if f.where != nil {
+		if m, ok := x.exprBlocks(f.where); ok {
+			andMask(mask, m)
+		}
+	}
+	var blocks []int
+	for i, b := range x.Blocks {
+		if !mask[i] {
+			continue
+		}
+		if !f.since.IsZero() && (b.Max == 0 || b.Max < f.since.UnixNano()) {
+			continue
+		}
+		if !f.until.IsZero() && (b.Min == 0 || b.Min >= f.until.UnixNano()) {
+			continue
+		}
+		if f.level >= 0 {
+			n := 0
+			for _, c := range b.Levels[f.level:] {
+				n += c
+			}
+			if n == 0 {
+				continue
+			}
+		}
+		blocks = append(blocks, i)
+	}
+	return blocks
+}
+
+// exprBlocks returns blocks that may match e, ok is false if the index
+// can't tell
+func (x *fileIndex) exprBlocks(e expr) ([]bool, bool) {
+	switch e := e.(type) {
+	case andExpr:
+		l, lok := x.exprBlocks(e.l)
+		r, rok := x.exprBlocks(e.r)
+		switch {
+		case lok && rok:
+			andMask(l, r)
+			return l, true
+		case lok:
+			return l, true
+		}
+		return r, rok
+	case orExpr:
+		l, lok := x.exprBlocks(e.l)
+		r, rok := x.exprBlocks(e.r)
+		if !lok || !rok {
+			return nil, false
+		}
+		for i := range l {
+			l[i] = l[i] || r[i]
+		}
+		return l, true
+	case *cmpExpr:
+		if e.op != "=" && e.op != "==" {
+			return nil, false
+		}
+		terms, ok := x.Terms[e.key]
+		if !ok && !x.indexed(e.key) {
+			return nil, false
+		}
+		m := make([]bool, len(x.Blocks))
+		for _, n := range terms[termValue(e.value)] {
+			m[n] = true
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+func (x *fileIndex) indexed(key string) bool {
+	for _, k := range x.Fields {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func andMask(dst, m []bool) {
+	for i := range dst {
+		dst[i] = dst[i] && m[i]
+	}
+}
+
+func indexCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("qlog index", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		keyList  = fs.String("keys", "n,t,l,m", "name, time, level and message keys")
+		fields   = fs.String("fields", "", "comma separated fields to index values of")
+		bucket   = fs.Duration("bucket", 5*time.Minute, "time bucket of blocks")
+		maxLines = fs.Int("lines", 10000, "maximum lines of block")
+		force    = fs.Bool("f", false, "rebuild up to date indexes")
+	)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	k, err := parseKeys(*keyList)
+	if err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	var sel []string
+	if *fields != "" {
+		sel = strings.Split(*fields, ",")
+	}
+	code := 0
+	for _, name := range fs.Args() {
+		if !*force {
+			if x, err := readIndex(name, k); err == nil && x.Size == fileSize(name, x) && sameFields(x.Fields[1:], sel) {
+				continue
+			}
+		}
+		x, err := buildIndex(name, k, sel, *bucket, *maxLines)
+		if err == nil && strings.HasSuffix(name, ".gz") {
+			err = segmentGzip(name, x)
+		}
+		if err == nil {
+			err = writeIndex(name, x)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "qlog: %s: %v\n", name, err)
+			code = 2
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: %d lines, %d blocks", name, x.Lines, len(x.Blocks))
+		for lvl, n := range x.Levels {
+			if n > 0 {
+				fmt.Fprintf(stdout, ", %s %d", qlog.InitLevel(uint8(lvl)), n)
+			}
+		}
+		fmt.Fprintln(stdout)
+	}
+	return code
+}
+
+// fileSize returns the size of uncompressed data of plain files and the
+// indexed size of compressed files, which can't grow
+func fileSize(name string, x *fileIndex) int64 {
+	if strings.HasSuffix(name, ".gz") {
+		return x.Size
+	}
+	st, err := os.Stat(name)
+	if err != nil {
+		return -1
+	}
+	return st.Size()
+}
+
+func sameFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSegment writes n lines a second apart starting at start
+func writeSegment(t *testing.T, name string, start time.Time, n int) {
+	var buf bytes.Buffer
+	levels := []string{"debug", "info", "info", "warn", "error"}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `{"n":"api","t":%q,"l":%q,"m":"request","request_id":"r%d","status":%d}`+"\n",
+			start.Add(time.Duration(i)*time.Second).Format(time.RFC3339), levels[i%len(levels)], i, 200+i%3)
+	}
+	data := buf.Bytes()
+	if strings.HasSuffix(name, ".gz") {
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		zw.Write(data)
+		zw.Close()
+		data = zbuf.Bytes()
+	}
+	if !assert.NoError(t, ioutil.WriteFile(name, data, 0644)) {
+		t.FailNow()
+	}
+}
+
+// readAll returns decompressed data of file name
+func readAll(name string) ([]byte, error) {
+	r, err := openInput(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// corruptMember overwrites deflate data of the gzip member at off
+func corruptMember(t *testing.T, name string, off int64) {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+	_, err = f.WriteAt(bytes.Repeat([]byte{0xff}, 64), off+10)
+	assert.NoError(t, err)
+}
+
+func TestIndexQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	start := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	files := []string{filepath.Join(dir, "api.log.1.gz"), filepath.Join(dir, "api.log")}
+	writeSegment(t, files[0], start, 600)
+	writeSegment(t, files[1], start.Add(time.Hour), 600)
+	data, err := readAll(files[0])
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var out, errOut bytes.Buffer
+	code := run(append([]string{"index", "-fields", "request_id,status", "-bucket", "1m"}, files...), &out, &errOut)
+	if !assert.Equal(t, 0, code, errOut.String()) {
+		return
+	}
+	assert.Equal(t, files[0]+": 600 lines, 10 blocks, debug 120, info 240, warn 120, error 120\n"+
+		files[1]+": 600 lines, 10 blocks, debug 120, info 240, warn 120, error 120\n", out.String())
+
+	queries := []struct {
+		args    []string
+		scanned string
+	}{
+		{[]string{"-level", "error", "-where", "request_id=r9"}, "scanned 1 of 10"},
+		{[]string{"-where", "status=201 && request_id=r301"}, "scanned 1 of 10"},
+		{[]string{"-where", "request_id=r1 || request_id=r599"}, "scanned 2 of 10"},
+		{[]string{"-since", "2020-01-02T11:02:30Z", "-until", "2020-01-02T11:04:00Z"}, "scanned 2 of 10"},
+		{[]string{"-level", "warn", "-name", "other"}, "scanned 0 of 10"},
+		{[]string{"-where", "status>201"}, "scanned 10 of 10"},
+	}
+	for _, q := range queries {
+		var want, got, errOut bytes.Buffer
+		assert.Equal(t, 0, run(append(append([]string{"-o", "json"}, q.args...), files...), &want, &errOut))
+		assert.Equal(t, 0, run(append(append([]string{"query", "-v", "-o", "json"}, q.args...), files...), &got, &errOut))
+		assert.Equal(t, want.String(), got.String(), "%v", q.args)
+		assert.Contains(t, errOut.String(), q.scanned, "%v", q.args)
+	}
+
+	// gzip file is rewritten with a member per block
+	seg, err := readAll(files[0])
+	assert.NoError(t, err)
+	assert.Equal(t, data, seg)
+	x, err := readIndex(files[0], &keys{"n", "t", "l", "m"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(0), x.Blocks[0].Member)
+	assert.True(t, x.Blocks[1].Member > 0)
+
+	// members before the candidate block are not read
+	corruptMember(t, files[0], x.Blocks[1].Member)
+	out.Reset()
+	errOut.Reset()
+	assert.Equal(t, 0, run([]string{"query", "-v", "-o", "logfmt", "-where", "request_id=r599", files[0]}, &out, &errOut))
+	assert.Equal(t, "time=2020-01-02T10:09:59Z level=error name=api msg=request request_id=r599 status=202\n", out.String())
+	assert.Equal(t, files[0]+": scanned 1 of 10 blocks\n", errOut.String())
+	assert.NotEqual(t, 0, run([]string{"-o", "logfmt", files[0]}, &out, &errOut))
+
+	// lines appended to a plain file are scanned
+	f, err := os.OpenFile(files[1], os.O_APPEND|os.O_WRONLY, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fmt.Fprintln(f, `{"n":"api","t":"2020-01-02T12:00:00Z","l":"error","m":"late","request_id":"r9"}`)
+	f.Close()
+	out.Reset()
+	errOut.Reset()
+	assert.Equal(t, 0, run([]string{"query", "-v", "-o", "logfmt", "-where", "request_id=r9", "-level", "error", files[1]}, &out, &errOut))
+	assert.Equal(t, "time=2020-01-02T11:00:09Z level=error name=api msg=request request_id=r9 status=200\n"+
+		"time=2020-01-02T12:00:00Z level=error name=api msg=late request_id=r9\n", out.String())
+	assert.Equal(t, files[1]+": scanned 1 of 10 blocks\n", errOut.String())
+
+	// replaced files are scanned in full
+	writeSegment(t, files[1], start.Add(2*time.Hour), 700)
+	out.Reset()
+	errOut.Reset()
+	assert.Equal(t, 0, run([]string{"query", "-v", "-o", "logfmt", "-where", "request_id=r650", files[1]}, &out, &errOut))
+	assert.Equal(t, "time=2020-01-02T12:10:50Z level=debug name=api msg=request request_id=r650 status=202\n", out.String())
+	assert.Equal(t, files[1]+": not indexed, scanned\n", errOut.String())
+}
+
+func TestQueryUsage(t *testing.T) {
+	var out, errOut bytes.Buffer
+	assert.Equal(t, 2, run([]string{"query", "-h"}, &out, &errOut))
+	assert.Contains(t, errOut.String(), "gzip member per block")
+	assert.Contains(t, errOut.String(), "-where string")
+}
@@ -0,0 +1,211 @@
+// Command qlog pretty-prints, filters and converts qlog Json output.
+//
+//	qlog [-o pretty|json|logfmt|csv|template] [-template tmpl]
+//	     [-level warn] [-name app.db] [-since 1h] [-until time]
+//	     [-where 'user_id=42 && latency>100'] [-fields a,b] [file ...]
+//
+//	qlog index [-fields request_id,user_id] [-bucket 5m] file ...
+//	qlog query [view flags] file ...
+//	qlog decode [view flags] [-time-field-format layout] [file ...]
+//
+// Files ending in .gz are decompressed, standard input is read if no
+// files are given. The index command writes a sidecar index file.qidx
+// of time buckets, level counts and values of selected fields, query
+// reads only blocks of indexed files that may match. The decode command
+// reads CBOR output instead of Json output lines. Header keys
+// default to the short Json output names n, t, l and m and are set by
+// -keys. Exit status is 2 on usage or read errors.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/karantin2020/qlog"
+)
+
+// commands are subcommands by name, the default command views logs
+var commands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"index":  indexCommand,
+	"query":  queryCommand,
+	"decode": decodeCommand,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			return cmd(args[1:], stdout, stderr)
+		}
+	}
+	return view(args, stdout, stderr)
+}
+
+// filterFlags registers flags of filter and keys. The returned function
+// builds them after flags are parsed.
+func filterFlags(fs *flag.FlagSet) func() (*filter, *keys, error) {
+	var (
+		level   = fs.String("level", "", "minimum level")
+		names   = fs.String("name", "", "comma separated names, dotted descendants included")
+		since   = fs.String("since", "", "entries at or after time or duration ago")
+		until   = fs.String("until", "", "entries before time or duration ago")
+		where   = fs.String("where", "", "field expression, e.g. 'user_id=42 && latency>100'")
+		keyList = fs.String("keys", "n,t,l,m", "name, time, level and message keys")
+	)
+	return func() (*filter, *keys, error) {
+		k, err := parseKeys(*keyList)
+		if err != nil {
+			return nil, nil, err
+		}
+		f := &filter{level: -1}
+		if *level != "" {
+			lvl, err := qlog.ParseLevel(*level)
+			if err != nil {
+				return nil, nil, err
+			}
+			f.level = int(lvl)
+		}
+		if *names != "" {
+			f.names = strings.Split(*names, ",")
+		}
+		now := time.Now()
+		if *since != "" {
+			if f.since, err = parseTimeArg(*since, now); err != nil {
+				return nil, nil, err
+			}
+		}
+		if *until != "" {
+			if f.until, err = parseTimeArg(*until, now); err != nil {
+				return nil, nil, err
+			}
+		}
+		if *where != "" {
+			if f.where, err = parseExpr(*where); err != nil {
+				return nil, nil, fmt.Errorf("-where: %v", err)
+			}
+		}
+		return f, k, nil
+	}
+}
+
+// parseKeys parses comma separated name, time, level and message keys
+func parseKeys(s string) (*keys, error) {
+	ks := strings.Split(s, ",")
+	if len(ks) != 4 {
+		return nil, fmt.Errorf("-keys needs 4 comma separated names")
+	}
+	return &keys{name: ks[0], time: ks[1], level: ks[2], message: ks[3]}, nil
+}
+
+// outputFlags registers flags of output format. The returned function
+// creates the writer and returns selected fields after flags are parsed.
+func outputFlags(fs *flag.FlagSet) func(out, stdout io.Writer, k *keys) (writer, []string, error) {
+	var (
+		format     = fs.String("o", "pretty", "output format: pretty, json, logfmt, csv or template")
+		tmpl       = fs.String("template", "", "qlog template of template format")
+		fields     = fs.String("fields", "", "comma separated fields to keep")
+		color      = fs.String("color", "auto", "pretty colors: auto, always or never")
+		sortFields = fs.Bool("sort", false, "sort pretty fields by key")
+		timeFormat = fs.String("time-format", time.RFC3339, "time layout of template format")
+	)
+	return func(out, stdout io.Writer, k *keys) (writer, []string, error) {
+		mode, err := qlog.ParseColorMode(*color)
+		if err != nil {
+			return nil, nil, err
+		}
+		// colors are decided for stdout, not for the buffer
+		if mode == qlog.ColorAuto && !qlog.IsTerminal(stdout) {
+			mode = qlog.ColorNever
+		}
+		if mode == qlog.ColorAuto {
+			mode = qlog.ColorAlways
+		}
+		var sel []string
+		if *fields != "" {
+			sel = strings.Split(*fields, ",")
+		}
+		w, err := newWriter(*format, out, k, sel, *tmpl, *timeFormat, mode, *sortFields)
+		return w, sel, err
+	}
+}
+
+// passRaw reports if writer passes through lines that are not json
+func passRaw(w writer) bool {
+	switch w.(type) {
+	case *prettyWriter, *jsonWriter, *logfmtWriter:
+		return true
+	}
+	return false
+}
+
+func view(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("qlog", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	build := filterFlags(fs)
+	output := outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	f, k, err := build()
+	if err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	out := bufio.NewWriter(stdout)
+	defer out.Flush()
+	w, sel, err := output(out, stdout, k)
+	if err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	raw := !f.active() && passRaw(w)
+	err = eachInput(fs.Args(), func(line []byte) error {
+		r, err := parseRecord(line, k)
+		if err != nil {
+			if raw {
+				_, err = out.Write(append(line, '\n'))
+				return err
+			}
+			return nil
+		}
+		if !f.match(r) {
+			return nil
+		}
+		return w.write(r, selectFields(r, k, sel))
+	})
+	if err == nil {
+		err = w.flush()
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	return 0
+}
+
+// eachInput calls fn with lines of files, stdin if files is empty
+func eachInput(files []string, fn func(line []byte) error) error {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		r, err := openInput(name)
+		if err != nil {
+			return err
+		}
+		err = eachLine(r, fn)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
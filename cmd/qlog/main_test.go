@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+var testKeys = &keys{name: "n", time: "t", level: "l", message: "m"}
+
+const testLog = `{"n":"app","t":"2020-01-02T10:00:00Z","l":"info","m":"request done","user_id":42,"latency":150}
+{"n":"app.db","t":"2020-01-02T10:00:01Z","l":"warn","m":"slow query","user_id":7,"latency":50,"req":{"id":"a1"}}
+not json
+{"n":"other","t":"2020-01-02T10:00:02Z","l":"error","m":"failed","err":"boom"}
+`
+
+func TestParseExpr(t *testing.T) {
+	r, err := parseRecord([]byte(`{"n":"app","user_id":42,"latency":"150","path":"/api/v1","req":{"id":"a1"}}`), testKeys)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"user_id=42 && latency>100", true},
+		{"user_id==42 && latency<100", false},
+		{"user_id!=42 || latency>=150", true},
+		{"!(user_id=42)", false},
+		{`path~"^/api/"`, true},
+		{"req.id=a1", true},
+		{"req.id", true},
+		{"missing", false},
+		{"missing!=1", true},
+		{"n='app' && (latency<=100 || user_id>40)", true},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.expr)
+		if !assert.NoError(t, err, tt.expr) {
+			continue
+		}
+		assert.Equal(t, tt.want, e.eval(r), tt.expr)
+	}
+	for _, s := range []string{"a=", "(a=1", "a=1 b", `a="x`, "&&", "a~("} {
+		_, err := parseExpr(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "app.log")
+	if !assert.NoError(t, ioutil.WriteFile(file, []byte(testLog), 0644)) {
+		return
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"pretty", []string{"-color", "never"},
+			"10:00:00.000 INF [app] request done user_id=42 latency=150\n" +
+				"10:00:01.000 WRN [app.db] slow query user_id=7 latency=50 req={id=a1}\n" +
+				"not json\n" +
+				"10:00:02.000 ERR [other] failed err=boom\n"},
+		{"where", []string{"-o", "json", "-where", "user_id=42 && latency>100"},
+			`{"n":"app","t":"2020-01-02T10:00:00Z","l":"info","m":"request done","user_id":42,"latency":150}` + "\n"},
+		{"level and name", []string{"-o", "logfmt", "-level", "warn", "-name", "app"},
+			`time=2020-01-02T10:00:01Z level=warn name=app.db msg="slow query" user_id=7 latency=50 req={"id":"a1"}` + "\n"},
+		{"since", []string{"-o", "logfmt", "-fields", "err", "-since", "2020-01-02T10:00:02Z"},
+			"time=2020-01-02T10:00:02Z level=error name=other msg=failed err=boom\n"},
+		{"csv", []string{"-o", "csv", "-fields", "user_id,req.id", "-until", "2020-01-02T10:00:02Z"},
+			"time,level,name,message,user_id,req.id\n" +
+				"2020-01-02T10:00:00Z,info,app,request done,42,\n" +
+				"2020-01-02T10:00:01Z,warn,app.db,slow query,7,a1\n"},
+		{"template", []string{"-o", "template", "-template", "${time} ${LEVEL} ${name}: ${message} ${fields}", "-level", "error"},
+			`2020-01-02T10:00:02Z ERROR other: failed {"err":"boom"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			code := run(append(tt.args, file), &out, &errOut)
+			assert.Equal(t, 0, code, errOut.String())
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+	var out, errOut bytes.Buffer
+	assert.Equal(t, 2, run([]string{"-o", "xml", file}, &out, &errOut))
+	assert.Equal(t, 2, run([]string{filepath.Join(dir, "missing.log")}, &out, &errOut))
+}
+
+func TestDecode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	var js, cb bytes.Buffer
+	now := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	np := qlog.New("app", qlog.InfoLevel, qlog.Clock(func() time.Time { return now })).SetOutput(
+		qlog.MustJson(func(o *qlog.JsonOptions) error {
+			o.OutHandle, o.ErrHandle = &js, &js
+			return nil
+		}),
+		qlog.MustCBOR(func(o *qlog.CBOROptions) error {
+			o.OutHandle, o.ErrHandle = &cb, &cb
+			return nil
+		}))
+	np.INFO.Fields(qlog.F{Key: "user_id", Value: 42}, qlog.F{Key: "took", Value: 1500 * time.Millisecond}).Msg("done")
+	np.ERROR.Fields(qlog.F{Key: "user_id", Value: 7}).Msg("failed")
+	file := filepath.Join(dir, "app.cbor")
+	if !assert.NoError(t, ioutil.WriteFile(file, cb.Bytes(), 0644)) {
+		return
+	}
+
+	var out, errOut bytes.Buffer
+	assert.Equal(t, 0, run([]string{"decode", "-utc", "-o", "json", file}, &out, &errOut), errOut.String())
+	assert.Equal(t, js.String(), out.String())
+
+	out.Reset()
+	assert.Equal(t, 0, run([]string{"decode", "-where", "user_id=7", "-o", "template",
+		"-template", "${LEVEL} ${message} ${fields}", file}, &out, &errOut), errOut.String())
+	assert.Equal(t, `ERROR failed {"user_id":7}`+"\n", out.String())
+
+	assert.Equal(t, 2, run([]string{"decode", filepath.Join(dir, "missing.cbor")}, &out, &errOut))
+}
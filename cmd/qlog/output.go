@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/karantin2020/qlog"
+)
+
+// writer writes selected records in an output format
+type writer interface {
+	write(r *record, fields []field) error
+	flush() error
+}
+
+// selectFields returns header fields of r and fields named by sel, all
+// fields if sel is empty
+func selectFields(r *record, k *keys, sel []string) []field {
+	if len(sel) == 0 {
+		return r.fields
+	}
+	var fields []field
+	for _, f := range r.fields {
+		if k.isHeader(f.key) {
+			fields = append(fields, f)
+		}
+	}
+	for _, key := range sel {
+		if raw, ok := r.get(key); ok {
+			fields = append(fields, field{key: key, raw: raw})
+		}
+	}
+	return fields
+}
+
+// appendObject appends json object of fields
+func appendObject(dst []byte, fields []field) []byte {
+	dst = append(dst, '{')
+	for i, f := range fields {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(qlog.AppendString(dst, f.key), ':')
+		dst = append(dst, f.raw...)
+	}
+	return append(dst, '}')
+}
+
+type jsonWriter struct {
+	out io.Writer
+	buf []byte
+}
+
+func (w *jsonWriter) write(r *record, fields []field) error {
+	w.buf = append(appendObject(w.buf[:0], fields), '\n')
+	_, err := w.out.Write(w.buf)
+	return err
+}
+
+func (w *jsonWriter) flush() error { return nil }
+
+type prettyWriter struct {
+	out io.Writer
+	enc *qlog.ConsoleEncoder
+	buf []byte
+	obj []byte
+}
+
+func (w *prettyWriter) write(r *record, fields []field) error {
+	w.obj = appendObject(w.obj[:0], fields)
+	var err error
+	if w.buf, err = w.enc.AppendJSON(w.buf[:0], w.obj); err != nil {
+		return err
+	}
+	_, err = w.out.Write(w.buf)
+	return err
+}
+
+func (w *prettyWriter) flush() error { return nil }
+
+// logfmtWriter writes time=... level=... name=... msg=... key=value lines
+type logfmtWriter struct {
+	out  io.Writer
+	keys *keys
+	buf  []byte
+}
+
+func (w *logfmtWriter) write(r *record, fields []field) error {
+	b := w.buf[:0]
+	for _, h := range [4][2]string{{w.keys.time, "time"}, {w.keys.level, "level"}, {w.keys.name, "name"}, {w.keys.message, "msg"}} {
+		for _, f := range fields {
+			if f.key == h[0] {
+				b = appendLogfmt(b, h[1], f.raw)
+			}
+		}
+	}
+	for _, f := range fields {
+		if !w.keys.isHeader(f.key) {
+			b = appendLogfmt(b, f.key, f.raw)
+		}
+	}
+	w.buf = append(b, '\n')
+	_, err := w.out.Write(w.buf)
+	return err
+}
+
+func (w *logfmtWriter) flush() error { return nil }
+
+func appendLogfmt(dst []byte, key string, raw json.RawMessage) []byte {
+	if len(dst) > 0 {
+		dst = append(dst, ' ')
+	}
+	dst = append(append(dst, key...), '=')
+	if len(raw) > 0 && raw[0] == '"' {
+		s := rawText(raw)
+		if s != "" && !bytes.ContainsAny([]byte(s), " \t\r\n\"=\\") {
+			return append(dst, s...)
+		}
+		return qlog.AppendString(dst, s)
+	}
+	return append(dst, raw...)
+}
+
+// csvWriter writes time, level, name and message columns followed by
+// selected fields or by a json column of all other fields
+type csvWriter struct {
+	w      *csv.Writer
+	keys   *keys
+	sel    []string
+	header bool
+	buf    []byte
+}
+
+func (w *csvWriter) write(r *record, fields []field) error {
+	if !w.header {
+		w.header = true
+		cols := []string{"time", "level", "name", "message"}
+		if len(w.sel) > 0 {
+			cols = append(cols, w.sel...)
+		} else {
+			cols = append(cols, "fields")
+		}
+		if err := w.w.Write(cols); err != nil {
+			return err
+		}
+	}
+	row := make([]string, 4, 4+len(w.sel)+1)
+	var rest []field
+	for _, f := range fields {
+		switch f.key {
+		case w.keys.time:
+			row[0] = rawText(f.raw)
+		case w.keys.level:
+			row[1] = rawText(f.raw)
+		case w.keys.name:
+			row[2] = rawText(f.raw)
+		case w.keys.message:
+			row[3] = rawText(f.raw)
+		default:
+			rest = append(rest, f)
+		}
+	}
+	if len(w.sel) == 0 {
+		w.buf = appendObject(w.buf[:0], rest)
+		row = append(row, string(w.buf))
+	} else {
+		for _, key := range w.sel {
+			v := ""
+			for _, f := range rest {
+				if f.key == key {
+					v = rawText(f.raw)
+				}
+			}
+			row = append(row, v)
+		}
+	}
+	return w.w.Write(row)
+}
+
+func (w *csvWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// templateWriter renders records with qlog Template
+type templateWriter struct {
+	keys *keys
+	out  func(*qlog.Notepad)
+	opts []func(*qlog.LogConfig) error
+	nps  map[string]*qlog.Notepad
+	now  time.Time
+}
+
+func newTemplateWriter(out io.Writer, k *keys, tmpl, timeFormat string) (*templateWriter, error) {
+	if !strings.HasSuffix(tmpl, "\n") {
+		tmpl += "\n"
+	}
+	fn, err := qlog.Template(tmpl, func(o *qlog.TemplateOptions) error {
+		o.OutHandle, o.ErrHandle = out, out
+		o.OutLevel, o.ErrLevel = qlog.DebugLevel, qlog.FatalLevel
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	w := &templateWriter{keys: k, out: fn, nps: make(map[string]*qlog.Notepad)}
+	w.opts = []func(*qlog.LogConfig) error{
+		qlog.Clock(func() time.Time { return w.now }),
+		qlog.TimeFormat(timeFormat),
+	}
+	return w, nil
+}
+
+func (w *templateWriter) write(r *record, fields []field) error {
+	np, ok := w.nps[r.name]
+	if !ok {
+		np = qlog.New(r.name, qlog.DebugLevel, w.opts...).SetOutput(w.out)
+		w.nps[r.name] = np
+	}
+	lvl := r.level
+	if lvl < 0 {
+		lvl = int(qlog.InfoLevel)
+	}
+	w.now = r.time
+	e := (*np.Loggers[lvl]).NewEntry()
+	for _, f := range fields {
+		if !w.keys.isHeader(f.key) {
+			e.AddField(qlog.F{Key: f.key, Value: rawValue(f.raw)})
+		}
+	}
+	e.Message = append(e.Message, r.msg...)
+	e.Process()
+	return nil
+}
+
+func (w *templateWriter) flush() error { return nil }
+
+// rawValue returns strings and numbers as values, other json as is
+func rawValue(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	switch c := raw[0]; {
+	case c == '"':
+		return rawText(raw)
+	case c == '-' || c >= '0' && c <= '9':
+		return json.Number(raw)
+	}
+	return raw
+}
+
+func newWriter(format string, out io.Writer, k *keys, sel []string, tmpl, timeFormat string, color qlog.ColorMode, sortFields bool) (writer, error) {
+	switch format {
+	case "pretty":
+		enc, err := qlog.NewConsoleEncoder(func(o *qlog.ConsoleOptions) error {
+			o.Color, o.SortFields = color, sortFields
+			o.LogName, o.TimestampName, o.LevelName, o.MessageName = k.name, k.time, k.level, k.message
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &prettyWriter{out: out, enc: enc.ForWriter(out)}, nil
+	case "json":
+		return &jsonWriter{out: out}, nil
+	case "logfmt":
+		return &logfmtWriter{out: out, keys: k}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(out), keys: k, sel: sel}, nil
+	case "template":
+		if tmpl == "" {
+			return nil, fmt.Errorf("-template is required for template format")
+		}
+		return newTemplateWriter(out, k, tmpl, timeFormat)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
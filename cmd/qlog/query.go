@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const queryUsage = `usage: qlog query [view flags] file ...
+
+Query reads only the blocks of indexed files that may match. Index
+rewrites gzip files with a gzip member per block, so only the members
+of those blocks are decompressed.
+
+`
+
+func queryCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("qlog query", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, queryUsage)
+		fs.PrintDefaults()
+	}
+	build := filterFlags(fs)
+	output := outputFlags(fs)
+	verbose := fs.Bool("v", false, "report scanned blocks of files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	f, k, err := build()
+	if err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	out := bufio.NewWriter(stdout)
+	defer out.Flush()
+	w, sel, err := output(out, stdout, k)
+	if err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	for _, name := range fs.Args() {
+		scanned, total, err := queryFile(name, f, k, func(r *record) error {
+			return w.write(r, selectFields(r, k, sel))
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "qlog: %s: %v\n", name, err)
+			return 2
+		}
+		if *verbose {
+			if total < 0 {
+				fmt.Fprintf(stderr, "%s: not indexed, scanned\n", name)
+			} else {
+				fmt.Fprintf(stderr, "%s: scanned %d of %d blocks\n", name, scanned, total)
+			}
+		}
+	}
+	if err := w.flush(); err != nil {
+		fmt.Fprintln(stderr, "qlog:", err)
+		return 2
+	}
+	return 0
+}
+
+// queryFile calls fn with records of file name matching f. Only
+// candidate blocks of indexed files are read, gzip files are read by
+// the members of the blocks. Lines appended to plain files after
+// indexing are scanned. total is -1 if the file is not indexed.
+func queryFile(name string, f *filter, k *keys, fn func(r *record) error) (scanned, total int, err error) {
+	each := func(line []byte) error {
+		r, err := parseRecord(line, k)
+		if err != nil || !f.match(r) {
+			return nil
+		}
+		return fn(r)
+	}
+	x, err := readIndex(name, k)
+	if err != nil {
+		return 0, -1, eachInput([]string{name}, each)
+	}
+	blocks := x.candidates(f)
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	gz := strings.HasSuffix(name, ".gz")
+	var zr *gzip.Reader
+	for _, n := range blocks {
+		b := x.Blocks[n]
+		var r io.Reader = file
+		if gz {
+			if _, err := file.Seek(b.Member, io.SeekStart); err != nil {
+				return 0, 0, err
+			}
+			if zr == nil {
+				zr, err = gzip.NewReader(bufio.NewReader(file))
+			} else {
+				err = zr.Reset(bufio.NewReader(file))
+			}
+			if err != nil {
+				return 0, 0, err
+			}
+			zr.Multistream(false)
+			r = zr
+		} else if _, err := file.Seek(b.Off, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		if err := eachLine(io.LimitReader(r, b.Size), each); err != nil {
+			return 0, 0, err
+		}
+	}
+	if !gz {
+		if _, err := file.Seek(x.Size, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		if err := eachLine(file, each); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(blocks), len(x.Blocks), nil
+}
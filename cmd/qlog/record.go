@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karantin2020/qlog"
+)
+
+// keys are names of Json output header fields
+type keys struct {
+	name, time, level, message string
+}
+
+// field is a field of a record with raw json value
+type field struct {
+	key string
+	raw json.RawMessage
+}
+
+// record is a parsed Json output line
+type record struct {
+	line []byte
+	// fields are all fields of the line in order, header included
+	fields []field
+	name   string
+	msg    string
+	level  int
+	time   time.Time
+}
+
+var errNotObject = errors.New("not a json object")
+
+// parseRecord parses Json output line keeping the order of fields
+func parseRecord(line []byte, k *keys) (*record, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return nil, errNotObject
+	}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	r := &record{line: line, level: -1}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		r.fields = append(r.fields, field{key: key, raw: raw})
+		switch key {
+		case k.name:
+			r.name = rawText(raw)
+		case k.message:
+			r.msg = rawText(raw)
+		case k.level:
+			if lvl, err := qlog.ParseLevel(rawText(raw)); err == nil {
+				r.level = int(lvl)
+			}
+		case k.time:
+			r.time, _ = parseTime(rawText(raw))
+		}
+	}
+	return r, nil
+}
+
+// get returns value of key, a dotted key looks into nested objects
+func (r *record) get(key string) (json.RawMessage, bool) {
+	for _, f := range r.fields {
+		if f.key == key {
+			return f.raw, true
+		}
+	}
+	for i := strings.IndexByte(key, '.'); i > 0; i = nextDot(key, i) {
+		raw, ok := r.get(key[:i])
+		if !ok {
+			continue
+		}
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) != nil {
+			continue
+		}
+		sub := &record{}
+		for k, v := range obj {
+			sub.fields = append(sub.fields, field{key: k, raw: v})
+		}
+		if v, ok := sub.get(key[i+1:]); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func nextDot(s string, i int) int {
+	if j := strings.IndexByte(s[i+1:], '.'); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
+
+// isHeader reports if key is a header key
+func (k *keys) isHeader(key string) bool {
+	return key == k.name || key == k.time || key == k.level || key == k.message
+}
+
+// rawText returns json strings unquoted, other values as is
+func rawText(raw json.RawMessage) string {
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+	}
+	return string(raw)
+}
+
+// rawNumber returns numeric value of raw number or numeric string
+func rawNumber(raw json.RawMessage) (float64, bool) {
+	f, err := strconv.ParseFloat(rawText(raw), 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+// parseTime parses RFC3339 time or unix time in seconds, milliseconds,
+// microseconds or nanoseconds
+func parseTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		switch {
+		case n > 1e17 || n < -1e17:
+			return time.Unix(0, n), nil
+		case n > 1e14 || n < -1e14:
+			return time.Unix(0, n*1e3), nil
+		case n > 1e11 || n < -1e11:
+			return time.Unix(0, n*1e6), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time " + strconv.Quote(s))
+}
+
+// parseTimeArg parses time flag, durations are relative to now
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return parseTime(s)
+}
+
+// openInput opens file, "-" is stdin. Files ending in .gz are
+// decompressed.
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, f}, nil
+}
+
+// eachLine calls fn with every line of input
+func eachLine(r io.Reader, fn func(line []byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if err := fn(sc.Bytes()); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// eachLineAt calls fn with every line of input and its offset, it
+// returns the number of bytes read
+func eachLineAt(r io.Reader, fn func(off int64, line []byte) error) (int64, error) {
+	var off, next int64
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		adv, tok, err := bufio.ScanLines(data, atEOF)
+		next += int64(adv)
+		return adv, tok, err
+	})
+	for sc.Scan() {
+		if err := fn(off, sc.Bytes()); err != nil {
+			return next, err
+		}
+		off = next
+	}
+	return next, sc.Err()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeAudit(t *testing.T, dir string, key []byte, opts func(*qlog.AuditOptions) error) string {
+	var out bytes.Buffer
+	a, err := qlog.NewAuditLog(key, opts, func(o *qlog.AuditOptions) error {
+		o.OutHandle, o.CheckpointEvery = &out, 2
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	np := qlog.New("audit", qlog.InfoLevel).SetOutput(a.Output())
+	for _, user := range []string{"root", "admin", "guest"} {
+		np.INFO.Fields(qlog.F{Key: "user", Value: user}).Msg("login")
+	}
+	assert.NoError(t, a.Close())
+	name := filepath.Join(dir, "audit.log")
+	if !assert.NoError(t, ioutil.WriteFile(name, out.Bytes(), 0600)) {
+		t.FailNow()
+	}
+	return name
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("secret")
+	opts := func(o *qlog.AuditOptions) error {
+		o.SeqName, o.ChainName, o.CheckpointName = "s", "c", "cp"
+		return nil
+	}
+	dir, err := ioutil.TempDir("", "qlogaudit")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	name := writeAudit(t, dir, key, opts)
+	var out bytes.Buffer
+	ok, err := verify(&out, name, key, opts)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, name+": 5 lines, 2 checkpoints, last seq 5: OK\n", out.String())
+
+	out.Reset()
+	ok, err = verify(&out, name, []byte("wrong"), opts)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, out.String(), name+": line 1: seq 1: modified: chain value mismatch\n")
+	assert.True(t, strings.HasSuffix(out.String(), "last seq 5: FAILED (5 problems)\n"), out.String())
+
+	// default field names do not match the log
+	out.Reset()
+	ok, err = verify(&out, name, key, func(*qlog.AuditOptions) error { return nil })
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, out.String(), "malformed: not an audit json line")
+
+	_, err = verify(&out, filepath.Join(dir, "missing.log"), key, opts)
+	assert.Error(t, err)
+}
+
+func TestReadKey(t *testing.T) {
+	key, err := readKey("736563726574", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key)
+
+	dir, err := ioutil.TempDir("", "qlogaudit")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.key")
+	if !assert.NoError(t, ioutil.WriteFile(name, []byte("raw key"), 0600)) {
+		return
+	}
+	key, err = readKey("", name)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("raw key"), key)
+
+	os.Setenv("QLOG_AUDIT_KEY", " 6b6579\n")
+	defer os.Unsetenv("QLOG_AUDIT_KEY")
+	key, err = readKey("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("key"), key)
+
+	_, err = readKey("zz", "")
+	assert.Error(t, err)
+	os.Unsetenv("QLOG_AUDIT_KEY")
+	_, err = readKey("", "")
+	assert.EqualError(t, err, "no key given, use -key, -keyfile or QLOG_AUDIT_KEY")
+}
@@ -0,0 +1,108 @@
+package qlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ColorMode selects when outputs are colored
+type ColorMode uint8
+
+const (
+	// ColorNever writes no colors
+	ColorNever ColorMode = iota
+	// ColorAuto colors output if it is a terminal. NO_COLOR or TERM=dumb
+	// disable colors, FORCE_COLOR enables them for stdout and stderr
+	// redirected to pipes. Regular files are never colored.
+	ColorAuto
+	// ColorAlways colors any output
+	ColorAlways
+)
+
+// ParseColorMode parses "never", "auto" or "always"
+func ParseColorMode(s string) (ColorMode, error) {
+	switch strings.ToLower(s) {
+	case "never", "":
+		return ColorNever, nil
+	case "auto":
+		return ColorAuto, nil
+	case "always":
+		return ColorAlways, nil
+	}
+	return ColorNever, fmt.Errorf("qlog: unknown color mode %q", s)
+}
+
+// Theme holds ANSI SGR parameters of colored parts, e.g. "31" is red and
+// "1;31" bold red. Empty parameter leaves the part uncolored.
+type Theme struct {
+	// Levels are colors of level names by level, also of messages of
+	// error and above if Message is empty
+	Levels  [7]string
+	Time    string
+	Name    string
+	Message string
+	Error   string
+	// Key is color of field keys
+	Key string
+}
+
+// DefaultTheme is dim debug, green info, yellow warn and red error and
+// above
+var DefaultTheme = Theme{
+	Levels: [7]string{"2", "32", "33", "31", "1;31", "1;31", "1;35"},
+	Time:   "90",
+	Name:   "34",
+	Error:  "31",
+	Key:    "36",
+}
+
+// IsTerminal reports if w is a terminal
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && isTerminalFd(f.Fd())
+}
+
+// colorEnabled reports if output to w is colored in mode
+func colorEnabled(w io.Writer, mode ColorMode) bool {
+	switch mode {
+	case ColorNever:
+		return false
+	case ColorAlways:
+		return true
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	if isTerminalFd(f.Fd()) {
+		return true
+	}
+	if force := os.Getenv("FORCE_COLOR"); force == "" || force == "0" || force == "false" {
+		return false
+	}
+	if f != os.Stdout && f != os.Stderr {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && !fi.Mode().IsRegular()
+}
+
+// appendColored wraps dst[start:] in color code
+func appendColored(dst []byte, start int, code string) []byte {
+	if code == "" || len(dst) == start {
+		return dst
+	}
+	n := len(code) + 3
+	end := len(dst)
+	dst = append(dst, make([]byte, n)...)
+	copy(dst[start+n:], dst[start:end])
+	copy(dst[start:], "\x1b[")
+	copy(dst[start+2:], code)
+	dst[start+n-1] = 'm'
+	return append(dst, "\x1b[0m"...)
+}
@@ -0,0 +1,61 @@
+package qlog_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate_Color(t *testing.T) {
+	out := &bytes.Buffer{}
+	np := qlog.New("app", qlog.DebugLevel).SetOutput(qlog.MustTemplate("${LEVEL:<5}|${message}|${fields}\n",
+		func(o *qlog.TemplateOptions) error {
+			o.OutHandle, o.ErrHandle = out, out
+			o.OutLevel = qlog.DebugLevel
+			o.Color = qlog.ColorAlways
+			return nil
+		}))
+	np.DEBUG.Fields(qlog.F{Key: "k", Value: 1}).Msg("dim")
+	np.Warn("careful")
+	np.Error("failed")
+
+	assert.Equal(t, "\x1b[2mDEBUG\x1b[0m|dim|{\x1b[36m\"k\"\x1b[0m:1}\n"+
+		"\x1b[33mWARN \x1b[0m|careful|{}\n"+
+		"\x1b[31mERROR\x1b[0m|\x1b[31mfailed\x1b[0m|{}\n", out.String())
+}
+
+func TestTemplate_ColorAuto(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "log"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	os.Setenv("FORCE_COLOR", "1")
+	defer os.Unsetenv("FORCE_COLOR")
+
+	assert.False(t, qlog.IsTerminal(f))
+	buf := &bytes.Buffer{}
+	np := qlog.New("app", qlog.InfoLevel).SetOutput(qlog.MustTemplate("${LEVEL} ${message} ${fields}\n",
+		func(o *qlog.TemplateOptions) error {
+			o.OutHandle, o.ErrHandle = f, buf
+			o.Color = qlog.ColorAuto
+			return nil
+		}))
+	np.INFO.Fields(qlog.F{Key: "k", Value: 1}).Msg("file")
+	np.Error("buffer")
+
+	data, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "INFO file {\"k\":1}\n", string(data))
+	assert.Equal(t, "ERROR buffer {}\n", buf.String())
+}
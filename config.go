@@ -0,0 +1,1000 @@
+package qlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is a declarative notepad specification. It is decoded from
+// JSON or YAML by ParseConfig, keys are snake_case.
+type Config struct {
+	Name       string                 `json:"name"`
+	Level      ConfigLevel            `json:"level"`
+	TimeFormat string                 `json:"time_format"`
+	FieldNames ConfigFieldNames       `json:"field_names"`
+	Outputs    []ConfigItem           `json:"outputs"`
+	Hooks      []ConfigItem           `json:"hooks"`
+	Sampling   *ConfigSampling        `json:"sampling"`
+	Redact     *ConfigRedact          `json:"redact"`
+	Context    map[string]interface{} `json:"context"`
+	// NameLevels are added to level overrides of named notepads, other
+	// overrides are kept, see SetNameLevel
+	NameLevels map[string]ConfigLevel `json:"name_levels"`
+}
+
+// ConfigFieldNames overrides LogConfig field names
+type ConfigFieldNames struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	Error     string `json:"error"`
+	Caller    string `json:"caller"`
+	Fields    string `json:"fields"`
+}
+
+// ConfigSampling configures NewSampler
+type ConfigSampling struct {
+	Tick       string `json:"tick"`
+	First      int    `json:"first"`
+	Thereafter int    `json:"thereafter"`
+}
+
+// ConfigRedact configures Redactor. Keys are exact keys or globs of
+// redacted fields, Values are names of value patterns: emails,
+// bearer_tokens, credit_cards and aws_keys. Mode is mask, hash or drop,
+// KeepLast masks values keeping their last chars.
+type ConfigRedact struct {
+	Keys     []string `json:"keys"`
+	Values   []string `json:"values"`
+	Mode     string   `json:"mode"`
+	KeepLast int      `json:"keep_last"`
+}
+
+// redactor returns Redactor of the config
+func (c *ConfigRedact) redactor() (*Redactor, error) {
+	red := MaskFull
+	switch c.Mode {
+	case "", "mask":
+		if c.KeepLast > 0 {
+			red = MaskKeepLast(c.KeepLast)
+		}
+	case "hash":
+		red = HashSHA256
+	case "drop":
+		red = DropField
+	default:
+		return nil, fmt.Errorf("qlog: unknown redact mode %q", c.Mode)
+	}
+	r := NewRedactor()
+	for _, key := range c.Keys {
+		if strings.ContainsAny(key, "*?[") {
+			if _, err := path.Match(key, ""); err != nil {
+				return nil, fmt.Errorf("qlog: invalid redact key %q: %v", key, err)
+			}
+			r.KeyGlob(key, red)
+		} else {
+			r.Key(key, red)
+		}
+	}
+	for _, name := range c.Values {
+		switch name {
+		case "emails":
+			r.Emails(red)
+		case "bearer_tokens":
+			r.BearerTokens(red)
+		case "credit_cards":
+			r.CreditCards(red)
+		case "aws_keys":
+			r.AWSKeys(red)
+		default:
+			return nil, fmt.Errorf("qlog: unknown redact value pattern %q", name)
+		}
+	}
+	return r, nil
+}
+
+// ConfigLevel is a level decoded from its name or number
+type ConfigLevel uint8
+
+// UnmarshalJSON decodes level from name or number
+func (l *ConfigLevel) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	lvl, err := ParseLevel(fmt.Sprint(v))
+	if err != nil {
+		return err
+	}
+	*l = ConfigLevel(lvl)
+	return nil
+}
+
+// MarshalJSON encodes level name
+func (l ConfigLevel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(InitLevel(uint8(l)).String())
+}
+
+// ConfigItem is an output or hook spec. Type selects registered factory,
+// all other keys are its options.
+type ConfigItem struct {
+	Type    string
+	Options ConfigOptions
+}
+
+// UnmarshalJSON decodes flat object with "type" key
+func (c *ConfigItem) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return err
+	}
+	t, ok := m["type"].(string)
+	if !ok || t == "" {
+		return fmt.Errorf("qlog: config item without type: %s", b)
+	}
+	delete(m, "type")
+	c.Type = t
+	c.Options = ConfigOptions(m)
+	return nil
+}
+
+// MarshalJSON encodes item as flat object
+func (c ConfigItem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(c.Options)+1)
+	for k, v := range c.Options {
+		m[k] = v
+	}
+	m["type"] = c.Type
+	return json.Marshal(m)
+}
+
+// ConfigOptions are options of a config item
+type ConfigOptions map[string]interface{}
+
+// String returns string option or def if it is not set
+func (o ConfigOptions) String(key, def string) (string, error) {
+	v, ok := o[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case json.Number:
+		return val.String(), nil
+	}
+	return "", fmt.Errorf("qlog: option %q must be a string", key)
+}
+
+// Int returns int option or def if it is not set
+func (o ConfigOptions) Int(key string, def int) (int, error) {
+	v, ok := o[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	n, err := strconv.Atoi(fmt.Sprint(v))
+	if err != nil {
+		return 0, fmt.Errorf("qlog: option %q must be an integer", key)
+	}
+	return n, nil
+}
+
+// Bool returns bool option or def if it is not set
+func (o ConfigOptions) Bool(key string, def bool) (bool, error) {
+	v, ok := o[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch val := v.(type) {
+	case bool:
+		return val, nil
+	case string:
+		return strconv.ParseBool(val)
+	}
+	return false, fmt.Errorf("qlog: option %q must be a boolean", key)
+}
+
+// Duration returns duration option or def if it is not set
+func (o ConfigOptions) Duration(key string, def time.Duration) (time.Duration, error) {
+	s, err := o.String(key, "")
+	if err != nil || s == "" {
+		return def, err
+	}
+	return time.ParseDuration(s)
+}
+
+// Level returns level option or def if it is not set
+func (o ConfigOptions) Level(key string, def uint8) (uint8, error) {
+	s, err := o.String(key, "")
+	if err != nil || s == "" {
+		return def, err
+	}
+	return ParseLevel(s)
+}
+
+// OutputSpec is passed to output factories
+type OutputSpec struct {
+	Type    string
+	Options ConfigOptions
+	// Level is the notepad level
+	Level uint8
+
+	build *configBuild
+}
+
+// configBuild holds resources opened while building a notepad
+type configBuild struct {
+	closers []io.Closer
+	// writers are files opened or writers registered by name
+	writers map[string]io.Writer
+}
+
+// Writer returns writer named by option key: "stdout", "stderr",
+// "discard" or a file path opened for appending. Files are closed by
+// Notepad.Close.
+func (s *OutputSpec) Writer(key, def string) (io.Writer, error) {
+	name, err := s.Options.String(key, def)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "stdout", "":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	case "discard":
+		return ioutil.Discard, nil
+	}
+	if w, ok := s.build.writers[name]; ok {
+		return w, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.AddCloser(f)
+	s.build.writers[name] = f
+	return f, nil
+}
+
+// AddCloser registers resource closed by Notepad.Close
+func (s *OutputSpec) AddCloser(c io.Closer) {
+	s.build.closers = append(s.build.closers, c)
+}
+
+// OutputFactory builds output from its spec
+type OutputFactory func(spec *OutputSpec) (func(*Notepad), error)
+
+// HookFactory builds hook from its options
+type HookFactory func(opts ConfigOptions) (Hook, error)
+
+var (
+	registryMu sync.RWMutex
+	outputs    = make(map[string]OutputFactory)
+	hooks      = make(map[string]HookFactory)
+)
+
+// RegisterOutput registers output factory under name. Registering the
+// same name twice replaces the factory.
+func RegisterOutput(name string, fn OutputFactory) {
+	registryMu.Lock()
+	outputs[name] = fn
+	registryMu.Unlock()
+}
+
+// RegisterHook registers hook factory under name. Hooks from config are
+// added to loggers at "level" option and above.
+func RegisterHook(name string, fn HookFactory) {
+	registryMu.Lock()
+	hooks[name] = fn
+	registryMu.Unlock()
+}
+
+func init() {
+	RegisterOutput("json", jsonFromSpec)
+	RegisterOutput("template", templateFromSpec)
+	RegisterOutput("text", textFromSpec)
+	RegisterOutput("console", consoleFromSpec)
+	RegisterOutput("cbor", cborFromSpec)
+	RegisterOutput("msgpack", msgpackFromSpec)
+	RegisterOutput("protobuf", protobufFromSpec)
+	RegisterOutput("forward", forwardFromSpec)
+	RegisterOutput("cef", cefFromSpec)
+	RegisterOutput("leef", leefFromSpec)
+	RegisterOutput("file", fileFromSpec)
+}
+
+// ParseConfig decodes Config from JSON or YAML document
+func ParseConfig(r io.Reader) (*Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		tree, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(tree); err != nil {
+			return nil, err
+		}
+	}
+	c := &Config{Level: ConfigLevel(InfoLevel)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("qlog: invalid config: %v", err)
+	}
+	return c, nil
+}
+
+// FromConfig builds notepad from JSON or YAML config
+func FromConfig(r io.Reader) (*Notepad, error) {
+	c, err := ParseConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.Build()
+}
+
+// FromEnv builds notepad from environment variables with prefix:
+//
+//	PREFIX_CONFIG       path of JSON or YAML config used as a base
+//	PREFIX_NAME         notepad name
+//	PREFIX_LEVEL        notepad level
+//	PREFIX_TIME_FORMAT  time format
+//	PREFIX_OUTPUTS      comma separated output types with default options
+//	PREFIX_CONTEXT      comma separated key=value static fields
+//	PREFIX_NAME_LEVELS  comma separated name=level overrides
+//
+// Json output is used if no outputs are configured.
+func FromEnv(prefix string) (*Notepad, error) {
+	c, err := ConfigFromEnv(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return c.Build()
+}
+
+// ConfigFromEnv returns Config described by environment, see FromEnv
+func ConfigFromEnv(prefix string) (*Config, error) {
+	env := func(key string) string {
+		return strings.TrimSpace(os.Getenv(prefix + "_" + key))
+	}
+	c := &Config{Level: ConfigLevel(InfoLevel)}
+	if path := env("CONFIG"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		c, err = ParseConfig(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v := env("NAME"); v != "" {
+		c.Name = v
+	}
+	if v := env("LEVEL"); v != "" {
+		lvl, err := ParseLevel(v)
+		if err != nil {
+			return nil, err
+		}
+		c.Level = ConfigLevel(lvl)
+	}
+	if v := env("TIME_FORMAT"); v != "" {
+		c.TimeFormat = v
+	}
+	if v := env("OUTPUTS"); v != "" {
+		c.Outputs = c.Outputs[:0]
+		for _, t := range strings.Split(v, ",") {
+			c.Outputs = append(c.Outputs, ConfigItem{Type: strings.TrimSpace(t)})
+		}
+	}
+	if v := env("CONTEXT"); v != "" {
+		if c.Context == nil {
+			c.Context = make(map[string]interface{})
+		}
+		for _, kv := range strings.Split(v, ",") {
+			i := strings.IndexByte(kv, '=')
+			if i < 0 {
+				return nil, fmt.Errorf("qlog: invalid %s_CONTEXT item %q", prefix, kv)
+			}
+			c.Context[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+		}
+	}
+	if v := env("NAME_LEVELS"); v != "" {
+		c.NameLevels = make(map[string]ConfigLevel)
+		for _, kv := range strings.Split(v, ",") {
+			i := strings.LastIndexByte(kv, '=')
+			if i < 0 {
+				return nil, fmt.Errorf("qlog: invalid %s_NAME_LEVELS item %q", prefix, kv)
+			}
+			lvl, err := ParseLevel(strings.TrimSpace(kv[i+1:]))
+			if err != nil {
+				return nil, err
+			}
+			c.NameLevels[strings.TrimSpace(kv[:i])] = ConfigLevel(lvl)
+		}
+	}
+	if len(c.Outputs) == 0 {
+		c.Outputs = []ConfigItem{{Type: "json"}}
+	}
+	return c, nil
+}
+
+// Build creates notepad described by config. Resources opened for
+// outputs are released by Notepad.Close. Outputs, hooks and levels are
+// validated before the notepad is created and name level overrides are
+// added only if Build succeeds.
+func (c *Config) Build() (np *Notepad, err error) {
+	lvl := uint8(c.Level)
+	if lvl > _maxLevel {
+		return nil, fmt.Errorf("qlog: level %d is out of range", lvl)
+	}
+	opts := []func(*LogConfig) error{c.applyLogConfig}
+	if c.Sampling != nil {
+		tick := time.Second
+		if c.Sampling.Tick != "" {
+			if tick, err = time.ParseDuration(c.Sampling.Tick); err != nil {
+				return nil, fmt.Errorf("qlog: invalid sampling tick: %v", err)
+			}
+		}
+		opts = append(opts, Sampling(tick, c.Sampling.First, c.Sampling.Thereafter))
+	}
+	if c.Redact != nil {
+		r, err := c.Redact.redactor()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, Redact(r))
+	}
+	var rules map[string]uint8
+	if c.NameLevels != nil {
+		rules = make(map[string]uint8, len(c.NameLevels))
+		for name, lvl := range c.NameLevels {
+			if uint8(lvl) > _maxLevel {
+				return nil, fmt.Errorf("qlog: level %d of %q is out of range", lvl, name)
+			}
+			rules[name] = uint8(lvl)
+		}
+	}
+	build := &configBuild{writers: make(map[string]io.Writer)}
+	defer func() {
+		if err != nil {
+			closeAll(build.closers)
+		}
+	}()
+	outs, err := c.buildOutputs(lvl, build)
+	if err != nil {
+		return nil, err
+	}
+	type levelHook struct {
+		lvl  uint8
+		hook Hook
+	}
+	hs := make([]levelHook, 0, len(c.Hooks))
+	for _, item := range c.Hooks {
+		registryMu.RLock()
+		fn, ok := hooks[item.Type]
+		registryMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("qlog: unknown hook type %q", item.Type)
+		}
+		hlvl, err := item.Options.Level("level", lvl)
+		if err != nil {
+			return nil, fmt.Errorf("qlog: hook %q: %v", item.Type, err)
+		}
+		h, err := fn(item.Options)
+		if err != nil {
+			return nil, fmt.Errorf("qlog: hook %q: %v", item.Type, err)
+		}
+		hs = append(hs, levelHook{hlvl, h})
+	}
+
+	notepad := New(c.Name, lvl, opts...)
+	for i, out := range outs {
+		if err := applyOutput(notepad, out); err != nil {
+			return nil, fmt.Errorf("qlog: output %q: %v", c.Outputs[i].Type, err)
+		}
+	}
+	for _, h := range hs {
+		notepad.AddHooks(h.lvl, h.hook)
+	}
+	for _, k := range c.contextKeys() {
+		notepad.AddField(F{Key: k, Value: c.Context[k]})
+	}
+	notepad.addCloser(build.closers...)
+	if len(rules) > 0 {
+		mergeNameLevels(rules, nil)
+	}
+	return notepad, nil
+}
+
+// buildOutputs creates outputs of the config with their filters
+func (c *Config) buildOutputs(lvl uint8, build *configBuild) ([]func(*Notepad), error) {
+	outs := make([]func(*Notepad), 0, len(c.Outputs))
+	for _, item := range c.Outputs {
+		registryMu.RLock()
+		fn, ok := outputs[item.Type]
+		registryMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("qlog: unknown output type %q", item.Type)
+		}
+		spec := &OutputSpec{Type: item.Type, Options: item.Options, Level: lvl, build: build}
+		out, err := fn(spec)
+		if err != nil {
+			return nil, fmt.Errorf("qlog: output %q: %v", item.Type, err)
+		}
+		filter, err := filterFromSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("qlog: output %q: %v", item.Type, err)
+		}
+		if filter != nil {
+			out = Filtered(filter, out)
+		}
+		if _, ok := spec.Options["min_level"]; ok {
+			minLevel, err := spec.Options.Level("min_level", spec.Level)
+			if err != nil {
+				return nil, fmt.Errorf("qlog: output %q: %v", item.Type, err)
+			}
+			out = FromLevel(minLevel, out)
+		}
+		outs = append(outs, out)
+	}
+	return outs, nil
+}
+
+// closeAll closes cs ignoring errors
+func closeAll(cs []io.Closer) {
+	for _, c := range cs {
+		c.Close()
+	}
+}
+
+// contextKeys returns sorted keys of context fields
+func (c *Config) contextKeys() []string {
+	keys := make([]string, 0, len(c.Context))
+	for k := range c.Context {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Config) applyLogConfig(lc *LogConfig) error {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&lc.TimeFieldFormat, c.TimeFormat)
+	set(&lc.TimestampFieldName, c.FieldNames.Timestamp)
+	set(&lc.LevelFieldName, c.FieldNames.Level)
+	set(&lc.MessageFieldName, c.FieldNames.Message)
+	set(&lc.ErrorFieldName, c.FieldNames.Error)
+	set(&lc.CallerFieldName, c.FieldNames.Caller)
+	set(&lc.FieldsName, c.FieldNames.Fields)
+	return nil
+}
+
+// applyOutput applies output to notepad returning the first error
+// passed to the error handler while it is added
+func applyOutput(np *Notepad, out func(*Notepad)) (err error) {
+	np.SetOutput(func(s *Notepad) {
+		handler := s.Options.ErrorHandler
+		s.Options.ErrorHandler = func(e error) {
+			if err == nil {
+				err = e
+			}
+		}
+		out(s)
+		s.Options.ErrorHandler = handler
+	})
+	return err
+}
+
+// outputNames holds names of output keys shared by json and template
+type outputNames struct {
+	out, err           io.Writer
+	outLevel, errLevel uint8
+	names              [4]string
+}
+
+func readOutputNames(spec *OutputSpec, def [4]string) (*outputNames, error) {
+	var (
+		on  = &outputNames{}
+		err error
+	)
+	if on.out, err = spec.Writer("out", "stdout"); err != nil {
+		return nil, err
+	}
+	if on.err, err = spec.Writer("err", "stderr"); err != nil {
+		return nil, err
+	}
+	outDef := spec.Level
+	if outDef < InfoLevel {
+		outDef = InfoLevel
+	}
+	if on.outLevel, err = spec.Options.Level("level", outDef); err != nil {
+		return nil, err
+	}
+	errDef := uint8(ErrorLevel)
+	if errDef < on.outLevel {
+		errDef = on.outLevel
+	}
+	if on.errLevel, err = spec.Options.Level("err_level", errDef); err != nil {
+		return nil, err
+	}
+	for i, key := range [4]string{"log_name", "timestamp_name", "level_name", "message_name"} {
+		if on.names[i], err = spec.Options.String(key, def[i]); err != nil {
+			return nil, err
+		}
+	}
+	return on, nil
+}
+
+func jsonFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	d := defaultJsonOptions()
+	on, err := readOutputNames(spec, [4]string{d.LogName, d.TimestampName, d.LevelName, d.MessageName})
+	if err != nil {
+		return nil, err
+	}
+	layout, err := layoutFromSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	return Json(func(o *JsonOptions) error {
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		o.LogName, o.TimestampName, o.LevelName, o.MessageName = on.names[0], on.names[1], on.names[2], on.names[3]
+		o.Layout = layout
+		return nil
+	})
+}
+
+// layoutFromSpec returns Json "layout": gcp with "project_id", ecs or emf
+// with "namespace" and comma separated "dimensions"
+func layoutFromSpec(spec *OutputSpec) (Encoder, error) {
+	layout, err := spec.Options.String("layout", "")
+	if err != nil {
+		return nil, err
+	}
+	switch layout {
+	case "":
+		return nil, nil
+	case "gcp":
+		enc := NewGCPEncoder()
+		if enc.ProjectID, err = spec.Options.String("project_id", ""); err != nil {
+			return nil, err
+		}
+		return enc, nil
+	case "ecs":
+		return NewECSEncoder(), nil
+	case "emf":
+		enc := NewEMFEncoder()
+		if enc.Namespace, err = spec.Options.String("namespace", enc.Namespace); err != nil {
+			return nil, err
+		}
+		dims, err := spec.Options.String("dimensions", "")
+		if err != nil {
+			return nil, err
+		}
+		if dims != "" {
+			enc.Dimensions = [][]string{strings.Split(dims, ",")}
+		}
+		return enc, nil
+	}
+	return nil, fmt.Errorf("unknown json layout %q", layout)
+}
+
+func templateFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	d := defaultTemplateOptions()
+	on, err := readOutputNames(spec, [4]string{d.LogName, d.TimestampName, d.LevelName, d.MessageName})
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := spec.Options.String("template", "[${name}] ${time}\t${LEVEL}\t${message}\t${fields}\n")
+	if err != nil {
+		return nil, err
+	}
+	color, err := spec.Options.String("color", "never")
+	if err != nil {
+		return nil, err
+	}
+	mode, err := ParseColorMode(color)
+	if err != nil {
+		return nil, err
+	}
+	return Template(tmpl, func(o *TemplateOptions) error {
+		o.Color = mode
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		o.LogName, o.TimestampName, o.LevelName, o.MessageName = on.names[0], on.names[1], on.names[2], on.names[3]
+		return nil
+	})
+}
+
+func textFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	d := defaultTextOptions()
+	on, err := readOutputNames(spec, [4]string{d.LogName, d.TimestampName, d.LevelName, d.MessageName})
+	if err != nil {
+		return nil, err
+	}
+	return Text(func(o *TextOptions) error {
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		o.LogName, o.TimestampName, o.LevelName, o.MessageName = on.names[0], on.names[1], on.names[2], on.names[3]
+		return nil
+	})
+}
+
+func consoleFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	on, err := readOutputNames(spec, [4]string{})
+	if err != nil {
+		return nil, err
+	}
+	d := defaultConsoleOptions()
+	timeFormat, err := spec.Options.String("time_format", d.TimeFormat)
+	if err != nil {
+		return nil, err
+	}
+	sortFields, err := spec.Options.Bool("sort", false)
+	if err != nil {
+		return nil, err
+	}
+	color, err := spec.Options.String("color", "auto")
+	if err != nil {
+		return nil, err
+	}
+	mode, err := ParseColorMode(color)
+	if err != nil {
+		return nil, err
+	}
+	return Console(func(o *ConsoleOptions) error {
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		o.TimeFormat, o.SortFields, o.Color = timeFormat, sortFields, mode
+		return nil
+	})
+}
+
+func cborFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	d := defaultCBOROptions()
+	on, err := readOutputNames(spec, [4]string{d.LogName, d.TimestampName, d.LevelName, d.MessageName})
+	if err != nil {
+		return nil, err
+	}
+	return CBOR(func(o *CBOROptions) error {
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		o.LogName, o.TimestampName, o.LevelName, o.MessageName = on.names[0], on.names[1], on.names[2], on.names[3]
+		return nil
+	})
+}
+
+func msgpackFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	d := defaultMessagePackOptions()
+	on, err := readOutputNames(spec, [4]string{d.LogName, d.TimestampName, d.LevelName, d.MessageName})
+	if err != nil {
+		return nil, err
+	}
+	tag, err := spec.Options.String("tag", "")
+	if err != nil {
+		return nil, err
+	}
+	return MessagePack(func(o *MessagePackOptions) error {
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		o.Tag = tag
+		o.LogName, o.TimestampName, o.LevelName, o.MessageName = on.names[0], on.names[1], on.names[2], on.names[3]
+		return nil
+	})
+}
+
+func protobufFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	on, err := readOutputNames(spec, [4]string{})
+	if err != nil {
+		return nil, err
+	}
+	return Protobuf(func(o *ProtobufOptions) error {
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		return nil
+	})
+}
+
+// forwardFromSpec sends entries to Fluentd Forward server at "addr", the
+// client is closed by Notepad.Close
+func forwardFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	addr, err := spec.Options.String("addr", "")
+	if err != nil {
+		return nil, err
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("option \"addr\" is required")
+	}
+	d := defaultForwardOptions()
+	lvl := spec.Level
+	if lvl < InfoLevel {
+		lvl = InfoLevel
+	}
+	if d.Level, err = spec.Options.Level("level", lvl); err != nil {
+		return nil, err
+	}
+	if d.Tag, err = spec.Options.String("tag", ""); err != nil {
+		return nil, err
+	}
+	if d.RequireAck, err = spec.Options.Bool("require_ack", false); err != nil {
+		return nil, err
+	}
+	if d.FlushInterval, err = spec.Options.Duration("flush_interval", d.FlushInterval); err != nil {
+		return nil, err
+	}
+	for _, o := range []struct {
+		key string
+		v   *int
+	}{{"buffer_size", &d.BufferSize}, {"batch_size", &d.BatchSize}, {"max_retries", &d.MaxRetries}} {
+		if *o.v, err = spec.Options.Int(o.key, *o.v); err != nil {
+			return nil, err
+		}
+	}
+	names := [4]*string{&d.LogName, &d.TimestampName, &d.LevelName, &d.MessageName}
+	for i, key := range [4]string{"log_name", "timestamp_name", "level_name", "message_name"} {
+		if *names[i], err = spec.Options.String(key, *names[i]); err != nil {
+			return nil, err
+		}
+	}
+	c, err := NewForwardClient(addr, func(o *ForwardOptions) error {
+		*o = *d
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	spec.AddCloser(c)
+	return c.Output(), nil
+}
+
+// securityHeader is read from "vendor", "product", "version",
+// "event_id_key" and comma separated field:key pairs of "keys"
+type securityHeader struct {
+	vendor, product, version, eventID string
+	keys                              map[string]string
+}
+
+func securityFromSpec(spec *OutputSpec, h *securityHeader) error {
+	var err error
+	for _, o := range []struct {
+		key string
+		v   *string
+	}{{"vendor", &h.vendor}, {"product", &h.product}, {"version", &h.version}, {"event_id_key", &h.eventID}} {
+		if *o.v, err = spec.Options.String(o.key, *o.v); err != nil {
+			return err
+		}
+	}
+	keys, err := spec.Options.String("keys", "")
+	if err != nil || keys == "" {
+		return err
+	}
+	h.keys = make(map[string]string)
+	for _, pair := range strings.Split(keys, ",") {
+		i := strings.IndexByte(pair, ':')
+		if i <= 0 || i == len(pair)-1 {
+			return fmt.Errorf("option \"keys\": invalid pair %q", pair)
+		}
+		h.keys[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return nil
+}
+
+func cefFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	on, err := readOutputNames(spec, [4]string{})
+	if err != nil {
+		return nil, err
+	}
+	d := defaultCEFOptions()
+	h := securityHeader{vendor: d.Vendor, product: d.Product, version: d.Version, eventID: d.EventIDKey}
+	if err = securityFromSpec(spec, &h); err != nil {
+		return nil, err
+	}
+	return CEF(func(o *CEFOptions) error {
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		o.Vendor, o.Product, o.Version = h.vendor, h.product, h.version
+		o.EventIDKey, o.ExtensionKeys = h.eventID, h.keys
+		return nil
+	})
+}
+
+// leefFromSpec also reads "leef_version" and single byte "delimiter"
+func leefFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	on, err := readOutputNames(spec, [4]string{})
+	if err != nil {
+		return nil, err
+	}
+	d := defaultLEEFOptions()
+	h := securityHeader{vendor: d.Vendor, product: d.Product, version: d.Version, eventID: d.EventIDKey}
+	if err = securityFromSpec(spec, &h); err != nil {
+		return nil, err
+	}
+	version, err := spec.Options.String("leef_version", d.LEEFVersion)
+	if err != nil {
+		return nil, err
+	}
+	if version != "1.0" && version != "2.0" {
+		return nil, fmt.Errorf("unknown leef version %q", version)
+	}
+	delim, err := spec.Options.String("delimiter", string(d.Delimiter))
+	if err != nil {
+		return nil, err
+	}
+	if len(delim) != 1 {
+		return nil, fmt.Errorf("option \"delimiter\" must be a single byte")
+	}
+	return LEEF(func(o *LEEFOptions) error {
+		o.OutHandle, o.ErrHandle = on.out, on.err
+		o.OutLevel, o.ErrLevel = on.outLevel, on.errLevel
+		o.Vendor, o.Product, o.Version = h.vendor, h.product, h.version
+		o.EventIDKey, o.ExtensionKeys = h.eventID, h.keys
+		o.LEEFVersion, o.Delimiter = version, delim[0]
+		return nil
+	})
+}
+
+// fileFromSpec writes json, template, text, cbor, msgpack, protobuf, cef
+// or leef format to "path"
+func fileFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	path, err := spec.Options.String("path", "")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("option \"path\" is required")
+	}
+	format, err := spec.Options.String("format", "json")
+	if err != nil {
+		return nil, err
+	}
+	opts := make(ConfigOptions, len(spec.Options)+2)
+	for k, v := range spec.Options {
+		if k != "path" && k != "format" {
+			opts[k] = v
+		}
+	}
+	opts["out"], opts["err"] = path, path
+	fspec := &OutputSpec{Type: format, Options: opts, Level: spec.Level, build: spec.build}
+	switch format {
+	case "json":
+		return jsonFromSpec(fspec)
+	case "template":
+		return templateFromSpec(fspec)
+	case "text":
+		return textFromSpec(fspec)
+	case "cbor":
+		return cborFromSpec(fspec)
+	case "msgpack":
+		return msgpackFromSpec(fspec)
+	case "protobuf":
+		return protobufFromSpec(fspec)
+	case "cef":
+		return cefFromSpec(fspec)
+	case "leef":
+		return leefFromSpec(fspec)
+	}
+	return nil, fmt.Errorf("unknown file format %q", format)
+}
@@ -0,0 +1,67 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package qlog
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+func init() {
+	RegisterOutput("syslog", syslogFromSpec)
+}
+
+// syslogWriter writes lines with fixed severity
+type syslogWriter func(m string) error
+
+func (w syslogWriter) Write(p []byte) (int, error) {
+	return len(p), w(string(p))
+}
+
+// syslogFromSpec writes json, template, cef or leef "format" to syslog
+// at "network" and "addr", local syslog by default. Entries below
+// err_level are sent with info severity, others with err severity.
+func syslogFromSpec(spec *OutputSpec) (func(*Notepad), error) {
+	var opts [4]string
+	for i, key := range [4]string{"network", "addr", "tag", "format"} {
+		v, err := spec.Options.String(key, "")
+		if err != nil {
+			return nil, err
+		}
+		opts[i] = v
+	}
+	w, err := syslog.Dial(opts[0], opts[1], syslog.LOG_INFO|syslog.LOG_USER, opts[2])
+	if err != nil {
+		return nil, err
+	}
+	spec.AddCloser(w)
+	fopts := make(ConfigOptions, len(spec.Options))
+	for k, v := range spec.Options {
+		switch k {
+		case "network", "addr", "tag", "format":
+		default:
+			fopts[k] = v
+		}
+	}
+	// Writers are passed to the format output under reserved names
+	outName, errName := "syslog:"+opts[2]+":out", "syslog:"+opts[2]+":err"
+	spec.build.writers[outName] = syslogWriter(w.Info)
+	spec.build.writers[errName] = syslogWriter(w.Err)
+	fopts["out"], fopts["err"] = outName, errName
+	fspec := &OutputSpec{Type: opts[3], Options: fopts, Level: spec.Level, build: spec.build}
+	switch opts[3] {
+	case "template":
+		if _, ok := fopts["template"]; !ok {
+			fopts["template"] = "${LEVEL} ${message}\t${fields}"
+		}
+		return templateFromSpec(fspec)
+	case "json", "":
+		return jsonFromSpec(fspec)
+	case "cef":
+		return cefFromSpec(fspec)
+	case "leef":
+		return leefFromSpec(fspec)
+	}
+	return nil, fmt.Errorf("unknown syslog format %q", opts[3])
+}
@@ -0,0 +1,226 @@
+package qlog_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	jsonCfg := `{
+		"name": "svc",
+		"level": "debug",
+		"time_format": "2006",
+		"field_names": {"timestamp": "ts"},
+		"outputs": [{"type": "json", "out": "discard", "level": "info"}],
+		"sampling": {"tick": "2s", "first": 10, "thereafter": 5},
+		"context": {"region": "eu"}
+	}`
+	yamlCfg := `
+# service logging
+name: svc
+level: debug
+time_format: "2006"
+field_names:
+  timestamp: ts
+outputs:
+- type: json
+  out: discard
+  level: info
+sampling:
+  tick: 2s
+  first: 10
+  thereafter: 5
+context:
+  region: eu
+`
+	for name, doc := range map[string]string{"json": jsonCfg, "yaml": yamlCfg} {
+		t.Run(name, func(t *testing.T) {
+			c, err := qlog.ParseConfig(strings.NewReader(doc))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, "svc", c.Name)
+			assert.Equal(t, qlog.ConfigLevel(qlog.DebugLevel), c.Level)
+			assert.Equal(t, "2006", c.TimeFormat)
+			assert.Equal(t, "ts", c.FieldNames.Timestamp)
+			if !assert.Len(t, c.Outputs, 1) {
+				return
+			}
+			assert.Equal(t, "json", c.Outputs[0].Type)
+			out, _ := c.Outputs[0].Options.String("out", "")
+			assert.Equal(t, "discard", out)
+			assert.Equal(t, &qlog.ConfigSampling{Tick: "2s", First: 10, Thereafter: 5}, c.Sampling)
+			assert.Equal(t, map[string]interface{}{"region": "eu"}, c.Context)
+		})
+	}
+}
+
+func TestParseConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"unknown key", `{"nam": "svc"}`},
+		{"bad level", `{"level": "loud"}`},
+		{"item without type", `{"outputs": [{"out": "stdout"}]}`},
+		{"yaml indentation", "name: svc\n  level: info\n"},
+		{"yaml duplicate", "name: a\nname: b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := qlog.ParseConfig(strings.NewReader(tt.doc))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFromConfig_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+
+	np, err := qlog.FromConfig(strings.NewReader(`
+name: svc
+level: info
+outputs:
+  - type: file
+    path: ` + path + `
+    format: template
+    template: "${name} ${LEVEL} ${message} ${fields}\n"
+context:
+  b: 2
+  a: x
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	np.INFO.Msg("started")
+	np.ERROR.Msg("failed")
+	np.Debug("hidden")
+	if !assert.NoError(t, np.Close()) {
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "svc INFO started {\"a\":\"x\",\"b\":2}\n"+
+		"svc ERROR failed {\"a\":\"x\",\"b\":2}\n", string(data))
+}
+
+func TestFromConfig_Registry(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		seen []string
+	)
+	qlog.RegisterOutput("test-buffer", func(spec *qlog.OutputSpec) (func(*qlog.Notepad), error) {
+		prefix, err := spec.Options.String("prefix", "")
+		if err != nil {
+			return nil, err
+		}
+		return func(np *qlog.Notepad) {
+			for _, logger := range np.Loggers {
+				if *logger != nil {
+					(*logger).Output = append((*logger).Output, func(e *qlog.Entry) {
+						buf.WriteString(prefix + string(e.Message) + "\n")
+					})
+				}
+			}
+		}, nil
+	})
+	qlog.RegisterHook("test-hook", func(opts qlog.ConfigOptions) (qlog.Hook, error) {
+		return qlog.HookFunc(func(e *qlog.Entry) error {
+			seen = append(seen, string(e.Message))
+			return nil
+		}), nil
+	})
+	np, err := qlog.FromConfig(strings.NewReader(`{
+		"level": "info",
+		"outputs": [{"type": "test-buffer", "prefix": "> "}],
+		"hooks": [{"type": "test-hook", "level": "warn"}]
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	np.INFO.Msg("one")
+	np.WARN.Msg("two")
+	assert.Equal(t, "> one\n> two\n", buf.String())
+	assert.Equal(t, []string{"two"}, seen)
+
+	_, err = qlog.FromConfig(strings.NewReader(`{"outputs": [{"type": "nope"}]}`))
+	assert.EqualError(t, err, `qlog: unknown output type "nope"`)
+	_, err = qlog.FromConfig(strings.NewReader(`{"hooks": [{"type": "nope"}]}`))
+	assert.EqualError(t, err, `qlog: unknown hook type "nope"`)
+	_, err = qlog.FromConfig(strings.NewReader(`{"level": "error", "outputs": [{"type": "json", "level": "info"}]}`))
+	assert.Error(t, err)
+}
+
+func TestFromConfig_FailedBuild(t *testing.T) {
+	defer qlog.SetNameLevels("")
+	qlog.SetNameLevel("failed.db", qlog.WarnLevel)
+	for _, cfg := range []string{
+		`{"name": "failed", "name_levels": {"failed.db": "debug"}, "hooks": [{"type": "nope"}]}`,
+		`{"name": "failed", "name_levels": {"failed.db": "debug"},
+			"outputs": [{"type": "json"}, {"type": "json", "level": "debug"}]}`,
+	} {
+		_, err := qlog.FromConfig(strings.NewReader(cfg))
+		assert.Error(t, err)
+		assert.Nil(t, qlog.Lookup("failed"))
+		assert.Equal(t, map[string]uint8{"failed.db": qlog.WarnLevel}, qlog.NameLevels())
+	}
+}
+
+func TestFromConfig_NameLevelsMerge(t *testing.T) {
+	defer qlog.SetNameLevels("")
+	qlog.SetNameLevel("merged.http", qlog.WarnLevel)
+	np, err := qlog.FromConfig(strings.NewReader(
+		`{"name": "merged", "outputs": [{"type": "json", "out": "discard"}], "name_levels": {"merged.db": "debug"}}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer np.Close()
+	assert.Equal(t, map[string]uint8{"merged.http": qlog.WarnLevel, "merged.db": qlog.DebugLevel},
+		qlog.NameLevels())
+}
+
+func TestFromEnv(t *testing.T) {
+	env := map[string]string{
+		"TESTQLOG_NAME":        "envsvc",
+		"TESTQLOG_LEVEL":       "warn",
+		"TESTQLOG_OUTPUTS":     "json, template",
+		"TESTQLOG_CONTEXT":     "dc=eu1, rack=7",
+		"TESTQLOG_NAME_LEVELS": "envsvc.db=debug",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	c, err := qlog.ConfigFromEnv("TESTQLOG")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "envsvc", c.Name)
+	assert.Equal(t, qlog.ConfigLevel(qlog.WarnLevel), c.Level)
+	if !assert.Len(t, c.Outputs, 2) {
+		return
+	}
+	assert.Equal(t, "json", c.Outputs[0].Type)
+	assert.Equal(t, "template", c.Outputs[1].Type)
+	assert.Equal(t, map[string]interface{}{"dc": "eu1", "rack": "7"}, c.Context)
+	assert.Equal(t, map[string]qlog.ConfigLevel{"envsvc.db": qlog.ConfigLevel(qlog.DebugLevel)}, c.NameLevels)
+
+	os.Setenv("TESTQLOG_LEVEL", "loud")
+	_, err = qlog.FromEnv("TESTQLOG")
+	assert.Error(t, err)
+}
@@ -0,0 +1,287 @@
+package qlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a significant line of yaml document
+type yamlLine struct {
+	n      int
+	indent int
+	text   string
+}
+
+// parseYAML parses the subset of YAML used by logging configs: block
+// mappings and sequences, plain, single and double quoted scalars and
+// flow sequences of scalars. Anchors, tags and multi-line scalars are
+// not supported.
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	n := 0
+	for sc.Scan() {
+		n++
+		raw := strings.TrimRight(stripYAMLComment(sc.Text()), " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed in indentation", n)
+		}
+		lines = append(lines, yamlLine{n: n, indent: len(raw) - len(text), text: text})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.node(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("yaml: line %d: unexpected indentation", p.lines[p.i].n)
+	}
+	return v, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) node(indent int) (interface{}, error) {
+	if isYAMLSeqItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.i < len(p.lines) {
+		l := &p.lines[p.i]
+		if l.indent != indent || !isYAMLSeqItem(l.text) {
+			break
+		}
+		item := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if item == "" {
+			p.i++
+			v, err := p.child(indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		if _, _, ok := splitYAMLKey(item); ok || isYAMLSeqItem(item) {
+			// Item starts nested block, continue it at the item column
+			l.indent += len(l.text) - len(item)
+			l.text = item
+			v, err := p.node(l.indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		v, err := yamlScalar(item, l.n)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+		p.i++
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("yaml: line %d: unexpected indentation", l.n)
+		}
+		if isYAMLSeqItem(l.text) {
+			break
+		}
+		key, val, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("yaml: line %d: expected key: value", l.n)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("yaml: line %d: duplicate key %q", l.n, key)
+		}
+		p.i++
+		if val != "" {
+			v, err := yamlScalar(val, l.n)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		// Sequence may be indented at the same level as its key
+		if p.i < len(p.lines) && p.lines[p.i].indent == indent && isYAMLSeqItem(p.lines[p.i].text) {
+			v, err := p.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		v, err := p.child(indent)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// child parses block nested deeper than indent, nil if there is none
+func (p *yamlParser) child(indent int) (interface{}, error) {
+	if p.i >= len(p.lines) || p.lines[p.i].indent <= indent {
+		return nil, nil
+	}
+	return p.node(p.lines[p.i].indent)
+}
+
+// splitYAMLKey splits "key: value" outside of quotes
+func splitYAMLKey(text string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 {
+				quote = c
+			}
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			key := strings.TrimSpace(text[:i])
+			if k, err := yamlScalar(key, 0); err == nil {
+				if s, ok := k.(string); ok {
+					key = s
+				}
+			}
+			return key, strings.TrimSpace(text[i+1:]), key != ""
+		case c == '[' || c == '{':
+			if i == 0 {
+				return "", "", false
+			}
+		}
+	}
+	return "", "", false
+}
+
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func yamlScalar(s string, line int) (interface{}, error) {
+	switch {
+	case s == "" || s == "~" || s == "null":
+		return nil, nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s == "{}":
+		return map[string]interface{}{}, nil
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("yaml: line %d: invalid double quoted string %s", line, s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("yaml: line %d: invalid single quoted string %s", line, s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case s[0] == '[':
+		if s[len(s)-1] != ']' {
+			return nil, fmt.Errorf("yaml: line %d: invalid flow sequence %s", line, s)
+		}
+		seq := []interface{}{}
+		for _, item := range splitYAMLFlow(s[1 : len(s)-1]) {
+			v, err := yamlScalar(item, line)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
+
+func splitYAMLFlow(s string) []string {
+	var (
+		items []string
+		quote byte
+		start int
+	)
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(s[start:]))
+}
@@ -0,0 +1,524 @@
+package qlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ConsoleOptions configures Console output and ConsoleCopy
+type ConsoleOptions struct {
+	ErrHandle io.Writer
+	OutHandle io.Writer
+	ErrLevel  uint8
+	OutLevel  uint8
+	// TimeFormat is the layout of entry time
+	TimeFormat string
+	// SortFields writes fields sorted by key instead of insertion order
+	SortFields bool
+	// ErrorKeys are keys of highlighted fields, the notepad error field
+	// name is always highlighted
+	ErrorKeys []string
+	Color     ColorMode
+	// Theme sets colors, nil is DefaultTheme
+	Theme *Theme
+	// LogName, TimestampName, LevelName and MessageName are keys of Json
+	// lines read by ConsoleCopy
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+}
+
+func defaultConsoleOptions() *ConsoleOptions {
+	jo := defaultJsonOptions()
+	return &ConsoleOptions{
+		ErrHandle:     os.Stderr,
+		OutHandle:     os.Stdout,
+		ErrLevel:      ErrorLevel,
+		OutLevel:      InfoLevel,
+		TimeFormat:    "15:04:05.000",
+		ErrorKeys:     []string{"error", "err"},
+		Color:         ColorAuto,
+		LogName:       jo.LogName,
+		TimestampName: jo.TimestampName,
+		LevelName:     jo.LevelName,
+		MessageName:   jo.MessageName,
+	}
+}
+
+var consoleLevels = [7]string{"DBG", "INF", "WRN", "ERR", "CRT", "PNC", "FTL"}
+
+// Console returns notepad option adding human-friendly outputs of
+// ConsoleEncoder for development, or error of an option or invalid
+// levels
+func Console(opts ...func(*ConsoleOptions) error) (func(np *Notepad), error) {
+	enc, err := NewConsoleEncoder(opts...)
+	if err != nil {
+		return nil, err
+	}
+	options := enc.opts
+	if err := checkLevels(options.OutLevel, options.ErrLevel); err != nil {
+		return nil, fmt.Errorf("qlog: output *qlog.ConsoleEncoder: %v", err)
+	}
+	return func(np *Notepad) {
+		routeOutputs(np, "*qlog.ConsoleEncoder", options.OutLevel, options.ErrLevel, func(c *outputCounter) (Output, Output) {
+			return newOutput(enc.ForWriter(options.OutHandle), AddSync(options.OutHandle), c),
+				newOutput(enc.ForWriter(options.ErrHandle), AddSync(options.ErrHandle), c)
+		})
+	}, nil
+}
+
+// MustConsole is like Console but panics if an option fails
+func MustConsole(opts ...func(*ConsoleOptions) error) func(np *Notepad) {
+	fn, err := Console(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// ConsoleEncoder writes entries as
+//
+//	15:04:05.000 INF [name] message key=value obj={k=v list=[1 2]}
+//	    stack:
+//	        multi-line values indented underneath
+//
+// Level is colored by Theme, error fields are highlighted.
+type ConsoleEncoder struct {
+	opts  *ConsoleOptions
+	color bool
+	theme *Theme
+}
+
+// NewConsoleEncoder returns ConsoleEncoder or error of an option
+func NewConsoleEncoder(opts ...func(*ConsoleOptions) error) (*ConsoleEncoder, error) {
+	options := defaultConsoleOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	enc := &ConsoleEncoder{opts: options, color: options.Color == ColorAlways, theme: options.Theme}
+	if enc.theme == nil {
+		enc.theme = &DefaultTheme
+	}
+	return enc, nil
+}
+
+// ForWriter returns encoder colored if colors are enabled for w
+func (c *ConsoleEncoder) ForWriter(w io.Writer) *ConsoleEncoder {
+	enc := *c
+	enc.color = colorEnabled(w, c.opts.Color)
+	return &enc
+}
+
+// Begin opens json object of fields, the line is rendered in End
+func (c *ConsoleEncoder) Begin(dst []byte, e *Entry) []byte {
+	return append(dst, '{')
+}
+
+func (c *ConsoleEncoder) AddField(dst []byte, f *Field) []byte {
+	dst = appendJsonKey(dst, f.Key)
+	if f.Buffer.Len() == 0 {
+		return append(dst, "null"...)
+	}
+	return append(dst, f.Buffer.Bytes()...)
+}
+
+func (c *ConsoleEncoder) OpenObject(dst []byte, key string) []byte {
+	return append(appendJsonKey(dst, key), '{')
+}
+
+func (c *ConsoleEncoder) CloseObject(dst []byte) []byte {
+	return append(dst, '}')
+}
+
+// End renders the line replacing dst with it
+func (c *ConsoleEncoder) End(dst []byte, e *Entry) []byte {
+	fields := append(dst, '}')
+	out := bytesPool.Get().(*[]byte)
+	l := consoleLine{
+		level:    int(e.Logger.Level.n),
+		name:     e.Logger.Notepad.Name,
+		message:  e.Message,
+		errorKey: e.Logger.Notepad.Options.ErrorFieldName,
+	}
+	l.time = e.Time.AppendFormat(l.st[:0], c.opts.TimeFormat)
+	*out = c.render((*out)[:0], &l, fields)
+	dst = append(fields[:0], *out...)
+	bytesPool.Put(out)
+	return dst
+}
+
+// consoleLine is the header of console line
+type consoleLine struct {
+	time     []byte
+	level    int
+	name     []byte
+	message  []byte
+	errorKey string
+	st       [32]byte
+}
+
+type consoleField struct {
+	key string
+	raw []byte
+}
+
+func (c *ConsoleEncoder) render(dst []byte, l *consoleLine, fields []byte) []byte {
+	dst = c.appendColored(dst, l.time, c.theme.Time)
+	dst = append(dst, ' ')
+	if l.level >= 0 && l.level < len(consoleLevels) {
+		dst = c.appendColored(dst, Str2Bytes(consoleLevels[l.level]), c.theme.Levels[l.level])
+	} else {
+		dst = append(dst, "???"...)
+	}
+	if len(l.name) > 0 {
+		dst = append(dst, ' ', '[')
+		dst = c.appendColored(dst, l.name, c.theme.Name)
+		dst = append(dst, ']')
+	}
+	if len(l.message) > 0 {
+		dst = append(dst, ' ')
+		code := c.theme.Message
+		if code == "" && l.level >= int(ErrorLevel) {
+			code = c.theme.Levels[l.level]
+		}
+		dst = c.appendColored(dst, l.message, code)
+	}
+	var flds []consoleField
+	_ = eachJSONField(fields, func(key string, raw []byte) {
+		flds = append(flds, consoleField{key: key, raw: raw})
+	})
+	if c.opts.SortFields {
+		sort.SliceStable(flds, func(i, j int) bool { return flds[i].key < flds[j].key })
+	}
+	var multi []consoleField
+	for _, f := range flds {
+		if s, ok := jsonString(f.raw); ok && bytes.IndexByte(s, '\n') >= 0 {
+			multi = append(multi, consoleField{key: f.key, raw: s})
+			continue
+		}
+		keyCode, valCode := c.theme.Key, ""
+		if c.isErrorKey(f.key, l.errorKey) {
+			keyCode, valCode = c.theme.Error, c.theme.Error
+		}
+		dst = append(dst, ' ')
+		dst = c.appendColored(dst, Str2Bytes(f.key), keyCode)
+		dst = append(dst, '=')
+		start := len(dst)
+		dst = appendConsoleValue(dst, f.raw, 0)
+		if c.color {
+			dst = appendColored(dst, start, valCode)
+		}
+	}
+	dst = append(dst, '\n')
+	for _, f := range multi {
+		code := c.theme.Key
+		if c.isErrorKey(f.key, l.errorKey) {
+			code = c.theme.Error
+		}
+		dst = append(dst, "    "...)
+		dst = c.appendColored(dst, Str2Bytes(f.key), code)
+		dst = append(dst, ':', '\n')
+		for _, line := range bytes.Split(bytes.TrimRight(f.raw, "\n"), []byte{'\n'}) {
+			dst = append(append(append(dst, "        "...), line...), '\n')
+		}
+	}
+	return dst
+}
+
+func (c *ConsoleEncoder) appendColored(dst, v []byte, code string) []byte {
+	start := len(dst)
+	dst = append(dst, v...)
+	if c.color {
+		dst = appendColored(dst, start, code)
+	}
+	return dst
+}
+
+func (c *ConsoleEncoder) isErrorKey(key, errorKey string) bool {
+	if key == errorKey {
+		return true
+	}
+	for _, k := range c.opts.ErrorKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// appendConsoleValue appends json value raw as simple strings unquoted,
+// objects as {k=v} and arrays as [v v]
+func appendConsoleValue(dst, raw []byte, depth int) []byte {
+	if len(raw) == 0 {
+		return dst
+	}
+	start := len(dst)
+	switch raw[0] {
+	case '"':
+		if s, ok := jsonString(raw); ok && !needsQuote(s) {
+			return append(dst, s...)
+		}
+	case '{':
+		if depth < maxDepth {
+			dst = append(dst, '{')
+			first := true
+			err := eachJSONField(raw, func(key string, v []byte) {
+				if !first {
+					dst = append(dst, ' ')
+				}
+				first = false
+				dst = appendConsoleValue(append(append(dst, key...), '='), v, depth+1)
+			})
+			if err == nil {
+				return append(dst, '}')
+			}
+			dst = dst[:start]
+		}
+	case '[':
+		if depth < maxDepth {
+			dst = append(dst, '[')
+			first := true
+			err := eachJSONElem(raw, func(v []byte) {
+				if !first {
+					dst = append(dst, ' ')
+				}
+				first = false
+				dst = appendConsoleValue(dst, v, depth+1)
+			})
+			if err == nil {
+				return append(dst, ']')
+			}
+			dst = dst[:start]
+		}
+	}
+	return append(dst, raw...)
+}
+
+// ConsoleCopy rerenders Json output lines read from r as console lines
+// written to w. Lines that are not json objects are copied as is.
+func ConsoleCopy(w io.Writer, r io.Reader, opts ...func(*ConsoleOptions) error) error {
+	enc, err := NewConsoleEncoder(opts...)
+	if err != nil {
+		return err
+	}
+	enc = enc.ForWriter(w)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var out []byte
+	for sc.Scan() {
+		if out, err = enc.AppendJSON(out[:0], sc.Bytes()); err != nil {
+			out = append(append(out[:0], sc.Bytes()...), '\n')
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// AppendJSON appends console line of Json output line, it returns error
+// if line is not a json object
+func (c *ConsoleEncoder) AppendJSON(dst, line []byte) ([]byte, error) {
+	o := c.opts
+	l := consoleLine{level: -1}
+	fields := bytesPool.Get().(*[]byte)
+	defer bytesPool.Put(fields)
+	*fields = append((*fields)[:0], '{')
+	err := eachJSONField(line, func(key string, raw []byte) {
+		switch key {
+		case o.LogName:
+			l.name, _ = jsonString(raw)
+		case o.TimestampName:
+			l.time = consoleTime(l.st[:0], raw, o.TimeFormat)
+		case o.LevelName:
+			s, _ := jsonString(raw)
+			if lvl, err := ParseLevel(string(s)); err == nil {
+				l.level = int(lvl)
+			}
+		case o.MessageName:
+			l.message, _ = jsonString(raw)
+		default:
+			if len(*fields) > 1 {
+				*fields = append(*fields, ',')
+			}
+			*fields = append(append(AppendString(*fields, key), ':'), raw...)
+		}
+	})
+	if err != nil {
+		return dst, err
+	}
+	return c.render(dst, &l, append(*fields, '}')), nil
+}
+
+// consoleTime reformats RFC3339 or unix time of json value raw
+func consoleTime(dst, raw []byte, layout string) []byte {
+	s, ok := jsonString(raw)
+	if !ok {
+		s = raw
+	}
+	if t, err := time.Parse(time.RFC3339Nano, string(s)); err == nil {
+		return t.AppendFormat(dst, layout)
+	}
+	if sec, err := strconv.ParseInt(string(s), 10, 64); err == nil {
+		return time.Unix(sec, 0).AppendFormat(dst, layout)
+	}
+	return append(dst, s...)
+}
+
+var errJSONSyntax = errors.New("qlog: invalid json")
+
+// jsonString returns unquoted json string raw
+func jsonString(raw []byte) ([]byte, bool) {
+	if len(raw) < 2 || raw[0] != '"' {
+		return nil, false
+	}
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return raw[1 : len(raw)-1], true
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, false
+	}
+	return []byte(s), true
+}
+
+// eachJSONField calls fn with key and raw value of every field of json
+// object obj in order
+func eachJSONField(obj []byte, fn func(key string, raw []byte)) error {
+	i := skipJSONSpace(obj, 0)
+	if i >= len(obj) || obj[i] != '{' {
+		return errJSONSyntax
+	}
+	i = skipJSONSpace(obj, i+1)
+	if i < len(obj) && obj[i] == '}' {
+		return nil
+	}
+	for i < len(obj) {
+		n, err := scanJSONValue(obj[i:])
+		if err != nil || obj[i] != '"' {
+			return errJSONSyntax
+		}
+		key, ok := jsonString(obj[i : i+n])
+		if !ok {
+			return errJSONSyntax
+		}
+		i = skipJSONSpace(obj, i+n)
+		if i >= len(obj) || obj[i] != ':' {
+			return errJSONSyntax
+		}
+		i = skipJSONSpace(obj, i+1)
+		if n, err = scanJSONValue(obj[i:]); err != nil {
+			return err
+		}
+		fn(string(key), obj[i:i+n])
+		if i = skipJSONSpace(obj, i+n); i >= len(obj) {
+			break
+		}
+		switch obj[i] {
+		case ',':
+			i = skipJSONSpace(obj, i+1)
+		case '}':
+			return nil
+		default:
+			return errJSONSyntax
+		}
+	}
+	return errJSONSyntax
+}
+
+// eachJSONElem calls fn with every element of json array arr
+func eachJSONElem(arr []byte, fn func(raw []byte)) error {
+	i := skipJSONSpace(arr, 0)
+	if i >= len(arr) || arr[i] != '[' {
+		return errJSONSyntax
+	}
+	i = skipJSONSpace(arr, i+1)
+	if i < len(arr) && arr[i] == ']' {
+		return nil
+	}
+	for i < len(arr) {
+		n, err := scanJSONValue(arr[i:])
+		if err != nil {
+			return err
+		}
+		fn(arr[i : i+n])
+		if i = skipJSONSpace(arr, i+n); i >= len(arr) {
+			break
+		}
+		switch arr[i] {
+		case ',':
+			i = skipJSONSpace(arr, i+1)
+		case ']':
+			return nil
+		default:
+			return errJSONSyntax
+		}
+	}
+	return errJSONSyntax
+}
+
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// scanJSONValue returns length of json value at the start of data
+func scanJSONValue(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, errJSONSyntax
+	}
+	switch data[0] {
+	case '"':
+		for i := 1; i < len(data); i++ {
+			switch data[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, nil
+			}
+		}
+		return 0, errJSONSyntax
+	case '{', '[':
+		depth := 0
+		for i := 0; i < len(data); i++ {
+			switch data[i] {
+			case '"':
+				n, err := scanJSONValue(data[i:])
+				if err != nil {
+					return 0, err
+				}
+				i += n - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return i + 1, nil
+				}
+			}
+		}
+		return 0, errJSONSyntax
+	}
+	for i, c := range data {
+		switch c {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			if i == 0 {
+				return 0, errJSONSyntax
+			}
+			return i, nil
+		}
+	}
+	return len(data), nil
+}
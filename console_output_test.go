@@ -0,0 +1,62 @@
+package qlog_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsole(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	np := qlog.New("api", qlog.InfoLevel, qlog.Clock(clock.Now)).
+		SetOutput(qlog.MustConsole(func(o *qlog.ConsoleOptions) error {
+			o.OutHandle, o.ErrHandle = out, out
+			o.SortFields = true
+			return nil
+		}))
+	np.AddField(qlog.F{Key: "region", Value: "eu west"})
+	np.INFO.Fields(
+		qlog.F{Key: "user", Value: map[string]interface{}{"id": 1, "tags": []string{"a", "b"}}},
+		qlog.F{Key: "error", Value: errors.New("boom")},
+		qlog.F{Key: "stack", Value: "main.main()\n\tmain.go:10\n"},
+	).Msg("request done")
+
+	assert.Equal(t, "03:04:05.000 INF [api] request done error=boom region=\"eu west\" user={id=1 tags=[a b]}\n"+
+		"    stack:\n"+
+		"        main.main()\n"+
+		"        \tmain.go:10\n", out.String())
+}
+
+func TestConsole_Color(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	np := qlog.New("", qlog.InfoLevel, qlog.Clock(clock.Now)).
+		SetOutput(qlog.MustConsole(func(o *qlog.ConsoleOptions) error {
+			o.OutHandle, o.ErrHandle = out, out
+			o.Color = qlog.ColorAlways
+			return nil
+		}))
+	np.ERROR.Fields(qlog.F{Key: "error", Value: "boom"}, qlog.F{Key: "k", Value: 1}).Msg("failed")
+
+	assert.Equal(t, "\x1b[90m03:04:05.000\x1b[0m \x1b[31mERR\x1b[0m \x1b[31mfailed\x1b[0m "+
+		"\x1b[31merror\x1b[0m=\x1b[31mboom\x1b[0m \x1b[36mk\x1b[0m=1\n", out.String())
+}
+
+func TestConsoleCopy(t *testing.T) {
+	in := strings.NewReader(`{"n":"golden","t":"2018-01-02T03:04:05.000Z","l":"warn","m":"second","app":"api","dur":1500}
+not json
+{"n":"", "t":"1514862245", "l":"error", "m":"third", "obj": {"a": [1, "x y"]}}
+`)
+	out := &bytes.Buffer{}
+	assert.NoError(t, qlog.ConsoleCopy(out, in))
+	assert.Equal(t, "03:04:05.000 WRN [golden] second app=api dur=1500\n"+
+		"not json\n"+
+		time.Unix(1514862245, 0).Format("15:04:05.000")+" ERR third obj={a=[1 \"x y\"]}\n", out.String())
+}
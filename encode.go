@@ -0,0 +1,381 @@
+package qlog
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+func appendMapS(dst []byte, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	dst = append(dst, '{')
+	for i, k := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(AppendString(dst, k), ':')
+		dst = AppendString(dst, m[k])
+	}
+	return append(dst, '}')
+}
+
+func appendMapI(dst []byte, m map[string]interface{}, opts *LogConfig, depth int) []byte {
+	if depth >= maxDepth {
+		return appendMarshaled(dst, m, opts)
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	dst = append(dst, '{')
+	for i, k := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(AppendString(dst, k), ':')
+		dst = appendValue(dst, m[k], opts, depth+1)
+	}
+	return append(dst, '}')
+}
+
+// appendFlatMapS encodes map keeping insertion order of keys
+func appendFlatMapS(dst []byte, m *FlatMapS) []byte {
+	dst = append(dst, '{')
+	for i := range m.K {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(AppendString(dst, m.K[i]), ':')
+		if i < len(m.V) {
+			dst = AppendString(dst, m.V[i])
+		} else {
+			dst = append(dst, "null"...)
+		}
+	}
+	return append(dst, '}')
+}
+
+// appendFlatMapI encodes map keeping insertion order of keys
+func appendFlatMapI(dst []byte, m *FlatMapI, opts *LogConfig, depth int) []byte {
+	dst = append(dst, '{')
+	for i := range m.K {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(AppendString(dst, m.K[i]), ':')
+		if i < len(m.V) {
+			dst = appendValue(dst, m.V[i], opts, depth+1)
+		} else {
+			dst = append(dst, "null"...)
+		}
+	}
+	return append(dst, '}')
+}
+
+// appendReflect encodes structs, maps with string keys and slices
+// natively, other values with LogConfig.InterfaceMarshaler
+func appendReflect(dst []byte, v interface{}, opts *LogConfig, depth int) []byte {
+	rv := reflect.ValueOf(v)
+	if depth >= maxDepth {
+		return appendMarshaled(dst, v, opts)
+	}
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return append(dst, "null"...)
+		}
+		if rv.Elem().Kind() == reflect.Struct {
+			return appendStruct(dst, rv.Elem(), opts, depth)
+		}
+	case reflect.Struct:
+		return appendStruct(dst, rv, opts, depth)
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			return appendMapReflect(dst, rv, opts, depth)
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return append(dst, "null"...)
+		}
+		dst = append(dst, '[')
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendValue(dst, rv.Index(i).Interface(), opts, depth+1)
+		}
+		return append(dst, ']')
+	}
+	return appendMarshaled(dst, v, opts)
+}
+
+func appendMarshaled(dst []byte, v interface{}, opts *LogConfig) []byte {
+	if opts.InterfaceMarshaler == nil {
+		return AppendInterface(dst, v, json.Marshal)
+	}
+	return AppendInterface(dst, v, opts.InterfaceMarshaler)
+}
+
+func appendMapReflect(dst []byte, rv reflect.Value, opts *LogConfig, depth int) []byte {
+	if rv.IsNil() {
+		return append(dst, "null"...)
+	}
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	dst = append(dst, '{')
+	for i, k := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(AppendString(dst, k.String()), ':')
+		dst = appendValue(dst, rv.MapIndex(k).Interface(), opts, depth+1)
+	}
+	return append(dst, '}')
+}
+
+// structField is an encoded field of a struct type
+type structField struct {
+	name      string
+	index     []int
+	key       []byte // json encoded name with colon
+	tagged    bool
+	omitEmpty bool
+	quoted    bool // encoded as json string, the ",string" option
+	redact    bool
+}
+
+// structEncoders caches fields of struct types
+var structEncoders sync.Map // map[reflect.Type][]structField
+
+// structFields returns fields of struct type t encoded like
+// encoding/json does. Fields tagged log:"-" are skipped, values of
+// fields tagged log:"redact" are masked.
+func structFields(t reflect.Type) []structField {
+	if fields, ok := structEncoders.Load(t); ok {
+		return fields.([]structField)
+	}
+	fields := collectFields(t)
+	structEncoders.Store(t, fields)
+	return fields
+}
+
+// collectFields walks embedded structs breadth first like encoding/json
+// typeFields. Of the fields with the same name the shallowest wins, a
+// tagged one among equally shallow fields; other conflicts drop the
+// name.
+func collectFields(t reflect.Type) []structField {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+	var (
+		fields  []structField
+		current []embedded
+		next    = []embedded{{typ: t}}
+		// struct types walked at this or upper depths
+		visited = map[reflect.Type]bool{}
+	)
+	for len(next) > 0 {
+		current, next = next, nil
+		// fields of this depth by name in order of appearance
+		level := make(map[string][]structField)
+		var order []string
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if sf.PkgPath != "" && ft.Kind() != reflect.Struct {
+						continue
+					}
+					// exported fields of unexported embedded structs are
+					// promoted
+				} else if sf.PkgPath != "" {
+					continue
+				}
+				logTag := sf.Tag.Get("log")
+				tag := sf.Tag.Get("json")
+				if logTag == "-" || tag == "-" {
+					continue
+				}
+				name, opts := tag, ""
+				if j := strings.IndexByte(tag, ','); j >= 0 {
+					name, opts = tag[:j], tag[j+1:]
+				}
+				idx := append(append(make([]int, 0, len(e.index)+1), e.index...), i)
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{typ: ft, index: idx})
+					continue
+				}
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+				}
+				quoted := false
+				if hasTagOption(opts, "string") {
+					switch ft.Kind() {
+					case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+						reflect.Float32, reflect.Float64, reflect.String:
+						quoted = true
+					}
+				}
+				if _, ok := level[name]; !ok {
+					order = append(order, name)
+				}
+				level[name] = append(level[name], structField{
+					name:      name,
+					index:     idx,
+					key:       append(AppendString(nil, name), ':'),
+					tagged:    tagged,
+					omitEmpty: hasTagOption(opts, "omitempty"),
+					quoted:    quoted,
+					redact:    logTag == "redact",
+				})
+			}
+		}
+		for _, name := range order {
+			if hasField(fields, name) {
+				// a shallower field hides this one
+				continue
+			}
+			if f, ok := dominantField(level[name]); ok {
+				fields = append(fields, f)
+			} else {
+				// the name is taken, deeper fields don't get it
+				fields = append(fields, structField{name: name})
+			}
+		}
+	}
+	res := fields[:0]
+	for _, f := range fields {
+		if f.index != nil {
+			res = append(res, f)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i].index, res[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return res
+}
+
+// dominantField returns the field of fields at the same depth which
+// gets the name: the only one or the only tagged one
+func dominantField(fields []structField) (structField, bool) {
+	if len(fields) == 1 {
+		return fields[0], true
+	}
+	var res structField
+	n := 0
+	for _, f := range fields {
+		if f.tagged {
+			res = f
+			n++
+		}
+	}
+	return res, n == 1
+}
+
+func hasField(fields []structField, name string) bool {
+	for i := range fields {
+		if fields[i].name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTagOption(opts, opt string) bool {
+	return strings.Contains(","+opts+",", ","+opt+",")
+}
+
+func appendStruct(dst []byte, rv reflect.Value, opts *LogConfig, depth int) []byte {
+	dst = append(dst, '{')
+	first := true
+	for _, f := range structFields(rv.Type()) {
+		fv, ok := fieldByIndex(rv, f.index)
+		if !ok || !fv.CanInterface() || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if !first {
+			dst = append(dst, ',')
+		}
+		first = false
+		dst = append(dst, f.key...)
+		if f.redact {
+			mask := "[REDACTED]"
+			if opts.Redactor != nil {
+				mask = opts.Redactor.Mask
+			}
+			dst = AppendString(dst, mask)
+			continue
+		}
+		if f.quoted && !(fv.Kind() == reflect.Ptr && fv.IsNil()) {
+			buf := bytesPool.Get().(*[]byte)
+			*buf = appendValue((*buf)[:0], fv.Interface(), opts, depth+1)
+			dst = AppendString(dst, Bytes2Str(*buf))
+			bytesPool.Put(buf)
+			continue
+		}
+		dst = appendValue(dst, fv.Interface(), opts, depth+1)
+	}
+	return append(dst, '}')
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex stopping at nil embedded
+// pointers
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
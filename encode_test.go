@@ -0,0 +1,163 @@
+package qlog_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+type Base struct {
+	ID      int    `json:"id"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type account struct {
+	Base
+	Name     string            `json:"name"`
+	Password string            `json:"password" log:"redact"`
+	Internal string            `log:"-"`
+	Skipped  string            `json:"-"`
+	Dash     string            `json:"-,"`
+	Tags     []string          `json:"tags,omitempty"`
+	Owner    *account          `json:"owner,omitempty"`
+	Labels   map[string]int    `json:"labels"`
+	Created  time.Time         `json:"created"`
+	Extra    map[string]string `json:"extra,omitempty"`
+	private  string
+}
+
+func TestAddField_Native(t *testing.T) {
+	flatS := qlog.NewMapS()
+	flatS.Add("z", "1")
+	flatS.Add("a", "2")
+	flatI := qlog.NewMapI()
+	flatI.Add("z", 1)
+	flatI.Add("a", []string{"x"})
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"map string", map[string]string{"b": "2", "a": "1"}, `{"a":"1","b":"2"}`},
+		{"map interface", map[string]interface{}{"b": 2, "a": map[string]interface{}{"c": nil}},
+			`{"a":{"c":null},"b":2}`},
+		{"flat map s", flatS, `{"z":"1","a":"2"}`},
+		{"flat map i", *flatI, `{"z":1,"a":["x"]}`},
+		{"map reflect", map[string]int{"y": 1, "x": 2}, `{"x":2,"y":1}`},
+		{"slice of structs", []Base{{ID: 1}, {ID: 2, Comment: "c"}},
+			`[{"id":1},{"id":2,"comment":"c"}]`},
+		{"struct", account{
+			Base:     Base{ID: 7},
+			Name:     "john",
+			Password: "hunter2",
+			Internal: "i",
+			Skipped:  "s",
+			Dash:     "d",
+			Owner:    &account{Name: "root", Created: created},
+			Labels:   map[string]int{"b": 1, "a": 2},
+			Created:  created,
+			private:  "p",
+		}, `{"id":7,"name":"john","password":"[REDACTED]","-":"d",` +
+			`"owner":{"id":0,"name":"root","password":"[REDACTED]","-":"","labels":null,"created":"2020-01-02T03:04:05Z"},` +
+			`"labels":{"a":2,"b":1},"created":"2020-01-02T03:04:05Z"}`},
+		{"nil struct pointer", (*account)(nil), `null`},
+		{"int keys fallback", map[int]string{2: "b", 1: "a"}, `{"1":"a","2":"b"}`},
+	}
+	opts := &qlog.LogConfig{TimeFieldFormat: time.RFC3339}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data []qlog.Field
+			qlog.AddField(qlog.F{Key: "k", Value: tt.value}, &data, opts)
+			if assert.Len(t, data, 1) {
+				assert.Equal(t, tt.want, data[0].Buffer.String())
+			}
+		})
+	}
+}
+
+type node struct {
+	Name string `json:"name"`
+	Next *node  `json:"next"`
+}
+
+func TestAddField_Cycle(t *testing.T) {
+	n := &node{Name: "loop"}
+	n.Next = n
+	var data []qlog.Field
+	qlog.AddField(qlog.F{Key: "k", Value: n}, &data, &qlog.LogConfig{})
+	assert.Contains(t, data[0].Buffer.String(), `marshaling error`)
+}
+
+type inner struct {
+	Promoted string `json:"promoted"`
+	hidden   string
+}
+
+type left struct {
+	Same   int
+	Tagged int `json:"Tagged"`
+	Deep   int
+}
+
+type right struct {
+	Same   int
+	Tagged int
+}
+
+type deeper struct {
+	Deep int
+}
+
+type middle struct {
+	deeper
+}
+
+type embedding struct {
+	inner
+	*left
+	right
+	Wrap struct{ deeper } `json:"wrap"`
+	middle
+	Count   int     `json:"count,string"`
+	Ratio   float64 `json:"ratio,string"`
+	Flag    *bool   `json:"flag,string"`
+	Text    string  `json:"text,string"`
+	Nothing *int    `json:"nothing,string"`
+	Tags    []int   `json:"tags,string"`
+}
+
+func TestAddField_StructFields(t *testing.T) {
+	flag := true
+	v := embedding{
+		inner: inner{Promoted: "p", hidden: "h"},
+		left:  &left{Same: 1, Tagged: 2, Deep: 3},
+		right: right{Same: 4, Tagged: 5},
+		Count: 6, Ratio: 0.5, Flag: &flag, Text: `a"b`,
+		Tags: []int{7},
+	}
+	v.Wrap.Deep = 8
+	v.middle.Deep = 9
+	want, err := json.Marshal(v)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var data []qlog.Field
+	qlog.AddField(qlog.F{Key: "k", Value: v}, &data, &qlog.LogConfig{})
+	// promoted fields of unexported embedded structs are kept, Same
+	// conflicts and is dropped, tagged field wins over untagged one,
+	// the shallower Deep hides the deeper
+	assert.Equal(t, `{"promoted":"p","Tagged":2,"Deep":3,"wrap":{"Deep":8},`+
+		`"count":"6","ratio":"0.5","flag":"true","text":"\"a\\\"b\"","nothing":null,"tags":[7]}`,
+		data[0].Buffer.String())
+	assert.JSONEq(t, string(want), data[0].Buffer.String())
+
+	// nil embedded pointer hides its fields
+	v.left = nil
+	data = data[:0]
+	qlog.AddField(qlog.F{Key: "k", Value: v}, &data, &qlog.LogConfig{})
+	assert.NotContains(t, data[0].Buffer.String(), `"Tagged"`)
+}
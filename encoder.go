@@ -0,0 +1,207 @@
+package qlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// WriteSyncer is a writer that can flush buffered data
+type WriteSyncer interface {
+	io.Writer
+	Sync() error
+}
+
+type writerSyncer struct {
+	io.Writer
+}
+
+func (writerSyncer) Sync() error {
+	return nil
+}
+
+// AddSync returns w as WriteSyncer. Sync is no-op if w has no Sync
+// method.
+func AddSync(w io.Writer) WriteSyncer {
+	if ws, ok := w.(WriteSyncer); ok {
+		return ws
+	}
+	return writerSyncer{w}
+}
+
+// Encoder encodes entries for outputs. An entry is encoded by Begin,
+// AddField for every field, OpenObject and CloseObject around fields of
+// nested objects, and End. Every method appends to dst which holds only
+// the encoding of the current entry. Encoders are used concurrently and
+// must not keep per-entry state.
+type Encoder interface {
+	// Begin starts entry, it usually writes name, time, level and message
+	Begin(dst []byte, e *Entry) []byte
+	// AddField appends field. Value is the typed field value, Buffer is
+	// its json encoding.
+	AddField(dst []byte, f *Field) []byte
+	// OpenObject starts nested object with the key
+	OpenObject(dst []byte, key string) []byte
+	// CloseObject ends the last opened object
+	CloseObject(dst []byte) []byte
+	// End finishes entry
+	End(dst []byte, e *Entry) []byte
+}
+
+// EncodeEntry appends e encoded by enc with notepad, logger and entry
+// fields
+func EncodeEntry(enc Encoder, dst []byte, e *Entry) []byte {
+	dst = enc.Begin(dst, e)
+	dst = encodeFields(enc, dst, e.Logger.Notepad.Context)
+	dst = encodeFields(enc, dst, e.Logger.Context)
+	dst = encodeFields(enc, dst, e.Data)
+	return enc.End(dst, e)
+}
+
+func encodeFields(enc Encoder, dst []byte, data []Field) []byte {
+	for i := range data {
+		dst = enc.AddField(dst, &data[i])
+	}
+	return dst
+}
+
+// NestFields returns encoder writing entry fields into object with the
+// key
+func NestFields(enc Encoder, key string) Encoder {
+	return nestedEncoder{Encoder: enc, key: key}
+}
+
+type nestedEncoder struct {
+	Encoder
+	key string
+}
+
+func (n nestedEncoder) Begin(dst []byte, e *Entry) []byte {
+	return n.Encoder.OpenObject(n.Encoder.Begin(dst, e), n.key)
+}
+
+func (n nestedEncoder) End(dst []byte, e *Entry) []byte {
+	return n.Encoder.End(n.Encoder.CloseObject(dst), e)
+}
+
+// NewOutput returns output writing entries encoded by enc to w. The
+// writer is synced after panic and fatal entries. Write errors are
+// passed to LogConfig.ErrorHandler.
+func NewOutput(enc Encoder, w WriteSyncer) Output {
+	return newOutput(enc, w, &outputCounter{name: fmt.Sprintf("%T", enc)})
+}
+
+func newOutput(enc Encoder, w WriteSyncer, c *outputCounter) Output {
+	return func(e *Entry) {
+		buf := bytesPool.Get().(*[]byte)
+		*buf = EncodeEntry(enc, (*buf)[:0], e)
+		_, err := w.Write(*buf)
+		bytesPool.Put(buf)
+		if err != nil {
+			c.fail(e, err)
+			return
+		}
+		atomic.AddUint64(&c.written, 1)
+		if e.Logger.Level.n >= PanicLevel {
+			if err = w.Sync(); err != nil {
+				c.fail(e, err)
+			}
+		}
+	}
+}
+
+// OutputOptions configures level routing of Encode outputs
+type OutputOptions struct {
+	ErrHandle WriteSyncer
+	OutHandle WriteSyncer
+	ErrLevel  uint8
+	OutLevel  uint8
+}
+
+func defaultOutputOptions() *OutputOptions {
+	return &OutputOptions{
+		ErrHandle: os.Stderr,
+		OutHandle: os.Stdout,
+		ErrLevel:  ErrorLevel,
+		OutLevel:  InfoLevel,
+	}
+}
+
+// Encode returns notepad option adding outputs of enc. Entries of
+// OutLevel and above are written to OutHandle, of ErrLevel and above to
+// ErrHandle. Error is returned if an option fails or levels are out of
+// range; levels below the notepad level are passed to
+// LogConfig.ErrorHandler when the option is applied and no output is
+// added.
+func Encode(enc Encoder, opts ...func(*OutputOptions) error) (func(np *Notepad), error) {
+	options := defaultOutputOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkLevels(options.OutLevel, options.ErrLevel); err != nil {
+		return nil, fmt.Errorf("qlog: output %T: %v", enc, err)
+	}
+	return func(np *Notepad) {
+		routeOutputs(np, fmt.Sprintf("%T", enc), options.OutLevel, options.ErrLevel, func(c *outputCounter) (Output, Output) {
+			return newOutput(enc, options.OutHandle, c), newOutput(enc, options.ErrHandle, c)
+		})
+	}, nil
+}
+
+// MustEncode is like Encode but panics if an option fails
+func MustEncode(enc Encoder, opts ...func(*OutputOptions) error) func(np *Notepad) {
+	fn, err := Encode(enc, opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// routeOutputs appends out to loggers from outLevel below errLevel and
+// errOut to loggers from errLevel. Outputs built by fn share the
+// counter of the output name.
+func routeOutputs(np *Notepad, name string, outLevel, errLevel uint8, fn func(c *outputCounter) (out, errOut Output)) {
+	if err := checkRoute(np, outLevel, errLevel); err != nil {
+		np.handleError(fmt.Errorf("qlog: output %s: %v", name, err))
+		return
+	}
+	out, errOut := fn(np.addCounter(name))
+	for tlv, logger := range np.Loggers {
+		level := uint8(tlv)
+		switch {
+		case level >= errLevel:
+			(*logger).Output = append((*logger).Output, errOut)
+
+		case level >= outLevel:
+			(*logger).Output = append((*logger).Output, out)
+		}
+	}
+}
+
+func checkRoute(np *Notepad, outLevel, errLevel uint8) error {
+	if outLevel < np.Level.n {
+		return errors.New("OutLevel is out of range")
+	}
+	if errLevel < np.Level.n {
+		return errors.New("ErrLevel is out of range")
+	}
+	return checkLevels(outLevel, errLevel)
+}
+
+// checkLevels checks output levels regardless of the notepad level
+func checkLevels(outLevel, errLevel uint8) error {
+	if outLevel > _maxLevel || outLevel < _minLevel {
+		return errors.New("OutLevel is out of range")
+	}
+	if errLevel > _maxLevel || errLevel < _minLevel {
+		return errors.New("ErrLevel is out of range")
+	}
+	if outLevel > errLevel {
+		return errors.New("OutLevel is higher than errLevel")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package qlog_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncBuffer struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (s *syncBuffer) Sync() error {
+	s.syncs++
+	return nil
+}
+
+// keysEncoder writes only field keys, nested objects in parentheses
+type keysEncoder struct{}
+
+func (keysEncoder) Begin(dst []byte, e *qlog.Entry) []byte {
+	return append(append(dst, e.Message...), ':')
+}
+
+func (keysEncoder) AddField(dst []byte, f *qlog.Field) []byte {
+	return append(append(dst, ' '), f.Key...)
+}
+
+func (keysEncoder) OpenObject(dst []byte, key string) []byte {
+	return append(append(append(dst, ' '), key...), '(')
+}
+
+func (keysEncoder) CloseObject(dst []byte) []byte {
+	return append(dst, ')')
+}
+
+func (keysEncoder) End(dst []byte, e *qlog.Entry) []byte {
+	return append(dst, '\n')
+}
+
+func TestText_Golden(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	np := qlog.New("golden", qlog.InfoLevel, qlog.Clock(clock.Now), qlog.TimeFormat("15:04:05")).
+		SetOutput(qlog.MustText(func(o *qlog.TextOptions) error {
+			o.OutHandle = out
+			o.ErrHandle = out
+			return nil
+		}))
+	np.AddField(qlog.F{Key: "app", Value: "api"})
+	np.Info("first")
+	np.WARN.Fields(qlog.F{Key: "user", Value: "john doe"}, qlog.F{Key: "n", Value: 3},
+		qlog.F{Key: "tags", Value: map[string]string{"a": "b"}}).Msg("second one")
+
+	assert.Equal(t, "time=03:04:05 level=info name=golden message=first app=api\n"+
+		"time=03:04:06 level=warn name=golden message=\"second one\" app=api user=\"john doe\" n=3 tags={\"a\":\"b\"}\n",
+		out.String())
+}
+
+func TestEncode_Custom(t *testing.T) {
+	out, errOut := &syncBuffer{}, &syncBuffer{}
+	np := qlog.New("custom", qlog.InfoLevel).
+		SetOutput(qlog.MustEncode(qlog.NestFields(keysEncoder{}, "f"), func(o *qlog.OutputOptions) error {
+			o.OutHandle, o.ErrHandle = out, errOut
+			o.ErrLevel = qlog.PanicLevel
+			return nil
+		}))
+	np.AddField(qlog.F{Key: "app", Value: "api"})
+	np.INFO.Fields(qlog.F{Key: "a", Value: 1}, qlog.F{Key: "b", Value: 2}).Msg("first")
+	assert.Panics(t, func() { np.Panic("second") })
+
+	assert.Equal(t, "first: f( app a b)\n", out.String())
+	assert.Equal(t, "second: f( app)\n", errOut.String())
+	assert.Equal(t, 0, out.syncs)
+	assert.Equal(t, 1, errOut.syncs)
+}
+
+func TestNewOutput_JSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	enc := &qlog.JSONEncoder{MessageName: "msg"}
+	np := qlog.New("", qlog.InfoLevel)
+	np.SetOutput(func(s *qlog.Notepad) {
+		s.INFO.Output = append(s.INFO.Output, qlog.NewOutput(qlog.NestFields(enc, "fields"), qlog.AddSync(out)))
+	})
+	np.INFO.Fields(qlog.F{Key: "k", Value: "v"}).Msg("hi")
+	assert.Equal(t, "{\"msg\":\"hi\",\"fields\":{\"k\":\"v\"}}\n", out.String())
+}
+
+func TestCBOR_Golden(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	np := qlog.New("g", qlog.InfoLevel, qlog.Clock(clock.Now)).
+		SetOutput(qlog.MustCBOR(func(o *qlog.CBOROptions) error {
+			o.OutHandle = out
+			o.ErrHandle = out
+			return nil
+		}))
+	np.INFO.Fields(qlog.F{Key: "d", Value: 1500 * time.Millisecond}, qlog.F{Key: "s", Value: "x"},
+		qlog.F{Key: "v", Value: struct{ A int }{1}}).Msg("hi")
+
+	assert.Equal(t, "bf"+
+		"616e"+"6167"+ // n: "g"
+		"6174"+"c11a5a4af6a5"+ // t: 1(1514862245)
+		"616c"+"01"+ // l: 1
+		"616d"+"626869"+ // m: "hi"
+		"6164"+"d903eaa20101281a1dcd6500"+ // d: 1002({1: 1, -9: 500000000})
+		"6173"+"6178"+ // s: "x"
+		"6176"+"d90106"+"477b2241223a317d"+ // v: 262('{"A":1}')
+		"ff", hex.EncodeToString(out.Bytes()))
+}
+
+func TestMessagePack_Golden(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	np := qlog.New("g", qlog.InfoLevel, qlog.Clock(clock.Now)).
+		SetOutput(qlog.MustMessagePack(func(o *qlog.MessagePackOptions) error {
+			o.OutHandle = out
+			o.ErrHandle = out
+			return nil
+		}))
+	np.INFO.Fields(qlog.F{Key: "d", Value: 1500 * time.Millisecond}, qlog.F{Key: "s", Value: "x"},
+		qlog.F{Key: "v", Value: struct{ A int }{1}}).Msg("hi")
+
+	assert.Equal(t, "93"+"a167"+ // ["g",
+		"d700"+"5a4af6a5"+"00000000"+ // EventTime(1514862245, 0),
+		"df00000006"+ // {
+		"a46e616d65"+"a167"+ // name: "g"
+		"a56c6576656c"+"a4696e666f"+ // level: "info"
+		"a76d657373616765"+"a26869"+ // message: "hi"
+		"a164"+"cd05dc"+ // d: 1500
+		"a173"+"a178"+ // s: "x"
+		"a176"+"df00000001"+"a14101", // v: {A: 1}}]
+		hex.EncodeToString(out.Bytes()))
+
+	out.Reset()
+	np = qlog.New("g", qlog.InfoLevel, qlog.Clock(clock.Now)).
+		SetOutput(qlog.MustEncode(qlog.NestFields(qlog.NestFields(qlog.NewMessagePackEncoder(), "a"), "b"),
+			func(o *qlog.OutputOptions) error {
+				o.OutHandle, o.ErrHandle = qlog.AddSync(out), qlog.AddSync(out)
+				return nil
+			}))
+	np.INFO.Fields(qlog.F{Key: "s", Value: "x"}, qlog.F{Key: "n", Value: -3}).Msg("hi")
+	assert.Equal(t, "93"+"a167"+"d700"+"5a4af6a6"+"00000000"+
+		"df00000004"+"a46e616d65"+"a167"+"a56c6576656c"+"a4696e666f"+"a76d657373616765"+"a26869"+
+		"a161"+"df00000001"+ // a: {
+		"a162"+"df00000002"+ // b: {
+		"a173"+"a178"+"a16e"+"fd", // s: "x", n: -3}}}]
+		hex.EncodeToString(out.Bytes()))
+}
+
+func TestProtobuf_Golden(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	np := qlog.New("g", qlog.InfoLevel, qlog.Clock(clock.Now)).
+		SetOutput(qlog.MustEncode(qlog.NestFields(qlog.NestFields(qlog.ProtobufEncoder{}, "a"), "b"),
+			func(o *qlog.OutputOptions) error {
+				o.OutHandle, o.ErrHandle = qlog.AddSync(out), qlog.AddSync(out)
+				return nil
+			}))
+	np.WARN.Fields(qlog.F{Key: "s", Value: "x"}, qlog.F{Key: "n", Value: -3},
+		qlog.F{Key: "v", Value: struct{ A int }{1}}, qlog.F{Key: "d", Value: time.Second}).Msg("hi")
+	np.INFO.Msg("")
+
+	assert.Equal(t, "4d"+ // length 77
+		"08"+"80e480e7f8e5f78215"+ // time_unix_nano: 1514862245000000000
+		"1002"+ // level: 2
+		"1a0167"+ // name: "g"
+		"22026869"+ // message: "hi"
+		"2ab480808000"+"0a0161"+ // fields {key: "a"
+		"2aab80808000"+"0a0162"+ // fields {key: "b"
+		"2a06"+"0a0173"+"120178"+ // fields {key: "s" string_value: "x"}
+		"2a05"+"0a016e"+"1805"+ // fields {key: "n" int_value: -3}
+		"2a0c"+"0a0176"+"5a077b2241223a317d"+ // fields {key: "v" json_value: `{"A":1}`}
+		"2a09"+"0a0164"+"508094ebdc03"+ // fields {key: "d" duration_nanos: 1e9}}}
+		"21"+"08"+"80f8ebc3fce5f78215"+"1001"+"1a0167"+ // length 33, time_unix_nano, level: 1, name: "g"
+		"2a8c80808000"+"0a0161"+"2a8380808000"+"0a0162", // fields {key: "a" fields {key: "b"}}
+		hex.EncodeToString(out.Bytes()))
+}
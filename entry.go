@@ -3,7 +3,6 @@ package qlog
 import (
 	"bytes"
 	"fmt"
-	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -48,6 +47,8 @@ type Entry struct {
 	ErrorFld error
 
 	bufferTime []byte
+	// lazy is set if Data has unresolved lazy values
+	lazy bool
 
 	st_data       [fieldsLen]Field
 	st_message    [messageLen]byte
@@ -72,49 +73,71 @@ func (f Field) MarshalJSON() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// NewEntry returns entry of the logger, nil if the logger is disabled.
+// Entry methods are no-op on nil entry.
 func (l *Logger) NewEntry() *Entry {
+	if l = l.current(); l == nil {
+		return nil
+	}
 	entry, _ := entryPool.Get().(*Entry)
 	entry.Reset()
-	entry.Time = time.Now()
+	entry.Time = l.Notepad.Options.TimestampFunc()
 	entry.Logger = l
-	switch entry.Logger.Notepad.Options.TimeFieldFormat {
+	entry.bufferTime = appendEntryTime(entry.bufferTime, entry.Time, l.Notepad.Options.TimeFieldFormat)
+	return entry
+}
+
+// appendEntryTime formats entry time t with LogConfig.TimeFieldFormat
+func appendEntryTime(dst []byte, t time.Time, format string) []byte {
+	switch format {
 	case "", "Unix":
-		entry.bufferTime = strconv.AppendInt(entry.bufferTime, entry.Time.Unix(), 10)
+		return strconv.AppendInt(dst, t.Unix(), 10)
 	case "UnixNano":
-		entry.bufferTime = strconv.AppendInt(entry.bufferTime, entry.Time.UnixNano(), 10)
+		return strconv.AppendInt(dst, t.UnixNano(), 10)
 	case "UnixMilli":
-		entry.bufferTime = strconv.AppendInt(entry.bufferTime, entry.Time.UnixNano()/1000000, 10)
+		return strconv.AppendInt(dst, t.UnixNano()/1000000, 10)
 	case "UnixMicro":
-		entry.bufferTime = strconv.AppendInt(entry.bufferTime, entry.Time.UnixNano()/1000, 10)
+		return strconv.AppendInt(dst, t.UnixNano()/1000, 10)
 	default:
-		entry.bufferTime = entry.Time.AppendFormat(entry.bufferTime, entry.Logger.Notepad.Options.TimeFieldFormat)
+		return t.AppendFormat(dst, format)
 	}
-	// entry.bufferTime = entry.Time.AppendFormat(entry.bufferTime, entry.Logger.Notepad.Options.TimeFieldFormat)
-	return entry
 }
 
 func (e *Entry) Reset() {
 	e.ErrorFld = nil
+	e.lazy = false
 	e.Data = e.st_data[:0]
 	e.Message = e.st_message[:0]
 	e.bufferTime = e.st_bufferTime[:0]
 }
 
 func (e *Entry) Free() {
+	if e == nil {
+		return
+	}
 	entryPool.Put(e)
 }
 
 func (e *Entry) Fields(fields ...F) {
+	if e == nil {
+		return
+	}
 	for _, fld := range fields {
 		e.AddField(fld)
 	}
 }
 
 func (e *Entry) Err(err error) {
+	if e == nil {
+		return
+	}
 	e.AddField(F{Key: e.Logger.Notepad.Options.ErrorFieldName, Value: err})
 }
 
 func (e *Entry) Timestamp() {
+	if e == nil {
+		return
+	}
 	e.AddField(F{Key: e.Logger.Notepad.Options.TimestampFieldName,
 		Value: e.Logger.Notepad.Options.TimestampFunc()})
 }
@@ -130,80 +153,82 @@ func (e *Entry) Timestamp() {
 // }
 
 func (e *Entry) Debug(msg string) {
-	if e.Logger == nil || e.Logger != e.Logger.Notepad.DEBUG {
+	if e == nil || e.Logger != e.Logger.Notepad.DEBUG {
 		return
 	}
 	e.Msg(msg)
 }
 
 func (e *Entry) Info(msg string) {
-	if e.Logger == nil || e.Logger != e.Logger.Notepad.INFO {
+	if e == nil || e.Logger != e.Logger.Notepad.INFO {
 		return
 	}
 	e.Msg(msg)
 }
 
 func (e *Entry) Warn(msg string) {
-	if e.Logger == nil || e.Logger != e.Logger.Notepad.WARN {
+	if e == nil || e.Logger != e.Logger.Notepad.WARN {
 		return
 	}
 	e.Msg(msg)
 }
 
 func (e *Entry) errMsg(msg string, panicErr, exitErr bool) {
-	e.ErrorFld = e.Logger.Notepad.Options.ErrorFunc(msg)
+	// Entry returns to the pool in Process, so keep options beforehand
+	opts := &e.Logger.Notepad.Options
+	e.ErrorFld = opts.ErrorFunc(msg)
 	// e.AddField(F{Key: e.Logger.Notepad.Options.ErrorFieldName, Value: e.ErrorFld})
 	e.Message = append(e.Message, Str2Bytes(e.ErrorFld.Error())...)
 	e.Process()
 	if panicErr {
-		panic(msg)
+		opts.PanicFunc(msg)
 	} else if exitErr {
-		os.Exit(1)
+		for _, fn := range opts.FatalHooks {
+			fn()
+		}
+		opts.ExitFunc(1)
 	}
 
 }
 
 func (e *Entry) Error(msg string) {
-	if e.Logger == nil || e.Logger != e.Logger.Notepad.ERROR {
+	if e == nil || e.Logger != e.Logger.Notepad.ERROR {
 		return
 	}
 	e.errMsg(msg, false, false)
 }
 
 func (e *Entry) Critical(msg string) {
-	if e.Logger == nil || e.Logger != e.Logger.Notepad.CRITICAL {
+	if e == nil || e.Logger != e.Logger.Notepad.CRITICAL {
 		return
 	}
-	if e.Logger.Notepad.DEBUG != nil {
-		e.errMsg(msg, true, false)
-	} else {
-		e.errMsg(msg, false, false)
-	}
+	// development notepads log debug entries
+	e.errMsg(msg, e.Logger.Notepad.gateLevel() == DebugLevel, false)
 }
 
 func (e *Entry) Panic(msg string) {
-	if e.Logger == nil || e.Logger != e.Logger.Notepad.PANIC {
+	if e == nil || e.Logger != e.Logger.Notepad.PANIC {
 		return
 	}
 	e.errMsg(msg, true, false)
 }
 
 func (e *Entry) Fatal(msg string) {
-	if e.Logger == nil || e.Logger != e.Logger.Notepad.FATAL {
+	if e == nil || e.Logger != e.Logger.Notepad.FATAL {
 		return
 	}
 	e.errMsg(msg, false, true)
 }
 
 func (e *Entry) Log(msg string) {
-	if e.Logger == nil || e.Logger != e.Logger.Notepad.LOG {
+	if e == nil || e.Logger != e.Logger.Notepad.LOG {
 		return
 	}
 	e.Msg(msg)
 }
 
 func (e *Entry) Msg(msg string) {
-	if e.Logger == nil {
+	if e == nil {
 		return
 	}
 	e.Message = append(e.Message, Str2Bytes(msg)...)
@@ -211,18 +236,52 @@ func (e *Entry) Msg(msg string) {
 }
 
 func (e *Entry) Msgf(format string, a ...interface{}) {
-	if e.Logger == nil {
+	if e == nil {
 		return
 	}
 	e.Msg(fmt.Sprintf(format, a...))
 }
 
+// resolveLazy computes lazy field values and encodes them with opts
+func (e *Entry) resolveLazy(opts *LogConfig) {
+	for i := 0; i < len(e.Data); i++ {
+		if isLazy(e.Data[i].Value) {
+			n := len(e.Data)
+			AddField(F{Key: e.Data[i].Key, Value: e.Data[i].Value}, &e.Data, opts)
+			if len(e.Data) < n {
+				i--
+			}
+		}
+	}
+	e.lazy = false
+}
+
+// prepare resolves lazy fields and redacts message and error with opts
+func (e *Entry) prepare(opts *LogConfig) {
+	if e.lazy {
+		e.resolveLazy(opts)
+	}
+	if r := opts.Redactor; r != nil {
+		r.redact(e)
+	}
+}
+
 func (e *Entry) Process() {
+	if e == nil {
+		return
+	}
+	if s := e.Logger.Notepad.Options.Sampler; s != nil && e.Logger.Level.n < PanicLevel && !s.Sample(e) {
+		entryPool.Put(e)
+		return
+	}
+	e.prepare(&e.Logger.Notepad.Options)
 	for _, frmt := range e.Logger.Notepad.Formatter {
 		frmt(e)
 	}
-	for _, hook := range e.Logger.Hooks {
-		hook(e)
+	if len(e.Logger.Hooks) > 0 {
+		fireHooks(e.Logger.Hooks, e)
+		// hooks may add lazy fields and change message or error
+		e.prepare(&e.Logger.Notepad.Options)
 	}
 	for i, _ := range e.Logger.Output {
 		e.Logger.Output[i](e)
@@ -1,9 +1,11 @@
 package qlog_test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -67,11 +69,90 @@ func TestLogger_NewEntry(t *testing.T) {
 func TestEntry_Info(t *testing.T) {
 	np := qlog.New("", qlog.InfoLevel)
 	msg := "Check entry message"
-	hook := qlog.Hook(func(e *qlog.Entry) {
+	hook := qlog.HookFunc(func(e *qlog.Entry) error {
 		assert.Equal(t, e.Message, []byte(msg))
+		return nil
 	})
 	np.AddHook(qlog.InfoLevel, hook)
 	t.Run("Check entry message", func(t *testing.T) {
 		np.Info(msg)
 	})
 }
+
+func TestEntry_Fields(t *testing.T) {
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel)
+	np.INFO.Fields(
+		qlog.F{Key: "foo", Value: "bar"},
+		qlog.F{Key: "num", Value: 1},
+		qlog.F{Key: "foo", Value: "baz"},
+	).Msg("Check entry fields")
+	entries := logs.FilterMessage("Check entry fields").All()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, []qlog.F{{Key: "foo", Value: "baz"}, {Key: "num", Value: 1}}, entries[0].Fields)
+	}
+}
+
+func TestEntry_FatalPanic(t *testing.T) {
+	var calls []string
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel,
+		qlog.ExitFunc(func(code int) {
+			calls = append(calls, fmt.Sprintf("exit %d", code))
+		}),
+		qlog.PanicFunc(func(msg string) {
+			calls = append(calls, "panic "+msg)
+		}),
+		qlog.OnFatal(func() {
+			calls = append(calls, "flush")
+		}),
+	)
+	np.AddFatalHook(func() {
+		calls = append(calls, "close")
+	})
+	np.Panic("panic message")
+	np.Fatal("fatal message")
+	assert.Equal(t, []string{"panic panic message", "flush", "close", "exit 1"}, calls)
+	assert.Equal(t, 1, logs.FilterLevel(qlog.FatalLevel).FilterMessage("fatal message").Len())
+	assert.Equal(t, 1, logs.FilterLevel(qlog.PanicLevel).Len())
+}
+
+// dropSampler drops all entries
+type dropSampler struct{}
+
+func (dropSampler) Sample(e *qlog.Entry) bool { return false }
+
+func TestEntry_SampledFatalPanic(t *testing.T) {
+	var calls []string
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel,
+		func(c *qlog.LogConfig) error {
+			c.Sampler = dropSampler{}
+			return nil
+		},
+		qlog.ExitFunc(func(code int) {
+			calls = append(calls, fmt.Sprintf("exit %d", code))
+		}),
+		qlog.PanicFunc(func(msg string) {
+			calls = append(calls, "panic "+msg)
+		}))
+	np.Error("dropped")
+	np.Panic("panic message")
+	np.Fatal("fatal message")
+	assert.Equal(t, []string{"panic panic message", "exit 1"}, calls)
+	assert.Equal(t, 0, logs.FilterLevel(qlog.ErrorLevel).Len())
+	assert.Equal(t, 1, logs.FilterLevel(qlog.PanicLevel).Len())
+	assert.Equal(t, 1, logs.FilterLevel(qlog.FatalLevel).Len())
+}
+
+func TestEntry_Critical(t *testing.T) {
+	var calls []string
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel,
+		qlog.PanicFunc(func(msg string) {
+			calls = append(calls, "panic "+msg)
+		}))
+	np.Critical("production")
+	assert.Empty(t, calls)
+
+	np.SetLevel(qlog.DebugLevel)
+	np.Critical("development")
+	assert.Equal(t, []string{"panic development"}, calls)
+	assert.Equal(t, 2, logs.FilterLevel(qlog.CriticalLevel).Len())
+}
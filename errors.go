@@ -0,0 +1,106 @@
+package qlog
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewErrorHandler returns LogConfig.ErrorHandler writing errors to w at
+// most once per interval measured by now, time.Now if nil. The number of
+// errors dropped in between is written with the next error.
+func NewErrorHandler(w io.Writer, interval time.Duration, now func() time.Time) func(error) {
+	if now == nil {
+		now = time.Now
+	}
+	var (
+		mu      sync.Mutex
+		last    time.Time
+		dropped int
+	)
+	return func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		now := now()
+		if !last.IsZero() && now.Sub(last) < interval {
+			dropped++
+			return
+		}
+		last = now
+		if dropped > 0 {
+			fmt.Fprintf(w, "%v (%d more errors)\n", err, dropped)
+			dropped = 0
+			return
+		}
+		fmt.Fprintln(w, err)
+	}
+}
+
+// OutputError is passed to LogConfig.ErrorHandler when an output fails
+// to write an entry
+type OutputError struct {
+	// Output is the output name, see OutputStats
+	Output string
+	Err    error
+}
+
+func (e *OutputError) Error() string {
+	return "qlog: output " + e.Output + ": " + e.Err.Error()
+}
+
+// Unwrap returns the write error
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// OutputStats counts entries of an output
+type OutputStats struct {
+	// Output is the encoder type or the output kind, e.g. *qlog.JSONEncoder
+	Output   string
+	Written  uint64
+	Failures uint64
+}
+
+// outputCounter counts entries written by an output and its failures
+type outputCounter struct {
+	name     string
+	written  uint64
+	failures uint64
+}
+
+// addCounter returns counter of an output added to the snapshot
+func (n *Notepad) addCounter(name string) *outputCounter {
+	c := &outputCounter{name: name}
+	n.counters = append(n.counters, c)
+	return c
+}
+
+// fail counts failure of e and passes err to the error handler
+func (c *outputCounter) fail(e *Entry, err error) {
+	atomic.AddUint64(&c.failures, 1)
+	e.Logger.Notepad.handleError(&OutputError{Output: c.name, Err: err})
+}
+
+// OutputStats returns counters of outputs added by SetOutput in order
+// of adding
+func (np *Notepad) OutputStats() []OutputStats {
+	s := np.Snapshot()
+	stats := make([]OutputStats, len(s.counters))
+	for i, c := range s.counters {
+		stats[i] = OutputStats{
+			Output:   c.name,
+			Written:  atomic.LoadUint64(&c.written),
+			Failures: atomic.LoadUint64(&c.failures),
+		}
+	}
+	return stats
+}
+
+// handleError passes err to LogConfig.ErrorHandler
+func (n *Notepad) handleError(err error) {
+	if fn := n.Options.ErrorHandler; fn != nil {
+		fn(err)
+	}
+}
@@ -0,0 +1,117 @@
+package qlog_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// failWriter fails writes after n successful ones
+type failWriter struct {
+	n   int
+	buf bytes.Buffer
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errBrokenPipe
+	}
+	w.n--
+	return w.buf.Write(p)
+}
+
+func errorsNotepad(errs *[]error) *qlog.Notepad {
+	return qlog.New("app", qlog.InfoLevel, func(c *qlog.LogConfig) error {
+		c.ErrorHandler = func(err error) { *errs = append(*errs, err) }
+		return nil
+	})
+}
+
+func TestErrorHandler_Outputs(t *testing.T) {
+	var errs []error
+	w := &failWriter{n: 1}
+	np := errorsNotepad(&errs).SetOutput(
+		qlog.MustJson(func(o *qlog.JsonOptions) error {
+			o.OutHandle, o.ErrHandle, o.TimestampName = w, w, ""
+			return nil
+		}),
+		qlog.MustCBOR(func(o *qlog.CBOROptions) error {
+			o.OutLevel = qlog.DebugLevel
+			return nil
+		}))
+	assert.NotPanics(t, func() {
+		np.Info("one")
+		np.Info("two")
+		np.Error("three")
+	})
+	assert.Equal(t, `{"n":"app","l":"info","m":"one"}`+"\n", w.buf.String())
+	if assert.Len(t, errs, 3) {
+		assert.EqualError(t, errs[0], "qlog: output *qlog.CBOREncoder: OutLevel is out of range")
+		assert.EqualError(t, errs[1], "qlog: output *qlog.JSONEncoder: broken pipe")
+		var oerr *qlog.OutputError
+		if assert.True(t, errors.As(errs[2], &oerr)) {
+			assert.Equal(t, "*qlog.JSONEncoder", oerr.Output)
+		}
+		assert.True(t, errors.Is(errs[2], errBrokenPipe))
+	}
+	assert.Equal(t, []qlog.OutputStats{{Output: "*qlog.JSONEncoder", Written: 1, Failures: 2}},
+		np.OutputStats())
+	assert.Equal(t, np.OutputStats(), np.WithFields(qlog.F{Key: "k", Value: 1}).OutputStats())
+
+	errs = errs[:0]
+	np.SetOutput(qlog.MustForward("127.0.0.1:24224", func(o *qlog.ForwardOptions) error {
+		o.Level = qlog.DebugLevel
+		return nil
+	}))
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], "qlog: forward level is out of range")
+	}
+}
+
+func TestOutput_ConstructorErrors(t *testing.T) {
+	_, err := qlog.Text(func(o *qlog.TextOptions) error {
+		return errors.New("bad option")
+	})
+	assert.EqualError(t, err, "bad option")
+	_, err = qlog.Json(func(o *qlog.JsonOptions) error {
+		o.OutLevel = qlog.FatalLevel + 1
+		return nil
+	})
+	assert.EqualError(t, err, "qlog: output *qlog.JSONEncoder: OutLevel is out of range")
+	_, err = qlog.Console(func(o *qlog.ConsoleOptions) error {
+		o.OutLevel, o.ErrLevel = qlog.ErrorLevel, qlog.InfoLevel
+		return nil
+	})
+	assert.EqualError(t, err, "qlog: output *qlog.ConsoleEncoder: OutLevel is higher than errLevel")
+	_, err = qlog.Audit(nil)
+	assert.EqualError(t, err, "qlog: audit key is empty")
+
+	assert.Panics(t, func() {
+		qlog.MustAudit(nil)
+	})
+	assert.NotPanics(t, func() {
+		qlog.MustProtobuf()
+	})
+}
+
+func TestNewErrorHandler(t *testing.T) {
+	var out bytes.Buffer
+	clock := qlogtest.NewClock(time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC), 0)
+	h := qlog.NewErrorHandler(&out, 10*time.Second, clock.Now)
+	h(errors.New("first"))
+	clock.Add(5 * time.Second)
+	h(errors.New("second"))
+	h(errors.New("third"))
+	clock.Add(5 * time.Second)
+	h(errors.New("fourth"))
+	assert.Equal(t, "first\nfourth (2 more errors)\n", out.String())
+	assert.False(t, strings.Contains(out.String(), "second"))
+}
@@ -7,7 +7,7 @@ import (
 func json_new() {
 	fakeMessage := "failed to fetch 'URL'"
 	nlog := qlog.New("JSON", qlog.InfoLevel, qlog.TimeFormat("UnixMicro")).
-		SetOutput(qlog.Json())
+		SetOutput(qlog.MustJson())
 	nlog.ERROR.Msgf("failed to fetch %s", "URL")
 	nlog.INFO.Msg(fakeMessage)
 	nlog.Info(fakeMessage)
@@ -2,8 +2,11 @@ package qlog
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/json"
 	"fmt"
 	"math"
+	"reflect"
 	"strconv"
 	"time"
 	"unicode/utf8"
@@ -14,7 +17,50 @@ type F struct {
 	Value interface{}
 }
 
+// LazyValue is a field value computed only if the entry is written
+type LazyValue interface {
+	LogValue() interface{}
+}
+
+// Lazy is a function used as LazyValue
+type Lazy func() interface{}
+
+// LogValue calls the function
+func (fn Lazy) LogValue() interface{} {
+	return fn()
+}
+
+// lazyValue resolves LazyValue and func() interface{} values
+func lazyValue(v interface{}) (interface{}, bool) {
+	switch val := v.(type) {
+	case LazyValue:
+		return val.LogValue(), true
+	case func() interface{}:
+		return val(), true
+	}
+	return v, false
+}
+
+func isLazy(v interface{}) bool {
+	switch v.(type) {
+	case LazyValue, func() interface{}:
+		return true
+	}
+	return false
+}
+
+// AddField adds field to the entry. Lazy values are computed in Process
+// after the entry passed sampling.
 func (e *Entry) AddField(f F) {
+	if e == nil {
+		return
+	}
+	if isLazy(f.Value) {
+		removeField(f.Key, &e.Data)
+		e.Data = append(e.Data, Field{Key: f.Key, Value: f.Value})
+		e.lazy = true
+		return
+	}
 	AddField(f, &e.Data, &e.Logger.Notepad.Options)
 }
 
@@ -26,13 +72,26 @@ func (l *Logger) Fields(flds ...F) *Entry {
 	return e
 }
 
+// AddField encodes field into data replacing the field with the same key.
+// Lazy values are computed immediately.
 func AddField(f F, data *[]Field, opts *LogConfig) {
 	var (
 		dst   *bytes.Buffer
 		found bool
 	)
+	for i := 0; i < 8 && isLazy(f.Value); i++ {
+		f.Value, _ = lazyValue(f.Value)
+	}
+	if opts.Redactor != nil {
+		var keep bool
+		if f, keep = opts.Redactor.redactField(f, opts); !keep {
+			removeField(f.Key, data)
+			return
+		}
+	}
 	for i, fld := range *data {
 		if fld.Key == f.Key {
+			(*data)[i].Value = f.Value
 			dst = &(*data)[i].Buffer
 			found = true
 			break
@@ -40,93 +99,166 @@ func AddField(f F, data *[]Field, opts *LogConfig) {
 	}
 	if !found {
 		*data = append(*data, Field{Key: f.Key, Value: f.Value})
+		dst = &(*data)[len(*data)-1].Buffer
 	}
-	dst = &(*data)[len(*data)-1].Buffer
 	dst.Reset()
 	buf := bytesPool.Get().(*[]byte)
-	*buf = (*buf)[:0]
-	switch val := f.Value.(type) {
+	*buf = appendValue((*buf)[:0], f.Value, opts, 0)
+	dst.Write(*buf)
+	bytesPool.Put(buf)
+}
+
+// maxDepth limits nesting of natively encoded maps and structs
+const maxDepth = 32
+
+// appendValue appends json encoding of v
+func appendValue(dst []byte, v interface{}, opts *LogConfig, depth int) []byte {
+	switch val := v.(type) {
 	case string:
-		dst.Write(AppendString(*buf, val))
+		return AppendString(dst, val)
 	case []byte:
-		dst.Write(AppendBytes(*buf, val))
+		return AppendBytes(dst, val)
 	case error:
-		dst.Write(AppendError(*buf, val))
+		return AppendError(dst, val)
 	case []error:
-		dst.Write(AppendErrors(*buf, val))
+		return AppendErrors(dst, val)
 	case bool:
-		dst.Write(AppendBool(*buf, val))
+		return AppendBool(dst, val)
 	case int:
-		dst.Write(AppendInt(*buf, val))
+		return AppendInt(dst, val)
 	case int8:
-		dst.Write(AppendInt8(*buf, val))
+		return AppendInt8(dst, val)
 	case int16:
-		dst.Write(AppendInt16(*buf, val))
+		return AppendInt16(dst, val)
 	case int32:
-		dst.Write(AppendInt32(*buf, val))
+		return AppendInt32(dst, val)
 	case int64:
-		dst.Write(AppendInt64(*buf, val))
+		return AppendInt64(dst, val)
 	case uint:
-		dst.Write(AppendUint(*buf, val))
+		return AppendUint(dst, val)
 	case uint8:
-		dst.Write(AppendUint8(*buf, val))
+		return AppendUint8(dst, val)
 	case uint16:
-		dst.Write(AppendUint16(*buf, val))
+		return AppendUint16(dst, val)
 	case uint32:
-		dst.Write(AppendUint32(*buf, val))
+		return AppendUint32(dst, val)
 	case uint64:
-		dst.Write(AppendUint64(*buf, val))
+		return AppendUint64(dst, val)
 	case float32:
-		dst.Write(AppendFloat32(*buf, val))
+		return AppendFloat32(dst, val)
 	case float64:
-		dst.Write(AppendFloat64(*buf, val))
+		return AppendFloat64(dst, val)
 	case time.Time:
-		dst.Write(AppendTime(*buf, val, opts.TimeFieldFormat))
+		return AppendTime(dst, val, opts.TimeFieldFormat)
 	case time.Duration:
-		dst.Write(AppendDuration(*buf, val,
-			opts.DurationFieldUnit, opts.DurationFieldInteger))
+		return AppendDuration(dst, val,
+			opts.DurationFieldUnit, opts.DurationFieldInteger)
 	case []string:
-		dst.Write(AppendStrings(*buf, val))
+		return AppendStrings(dst, val)
 	case []bool:
-		dst.Write(AppendBools(*buf, val))
+		return AppendBools(dst, val)
 	case []int:
-		dst.Write(AppendInts(*buf, val))
+		return AppendInts(dst, val)
 	case []int8:
-		dst.Write(AppendInts8(*buf, val))
+		return AppendInts8(dst, val)
 	case []int16:
-		dst.Write(AppendInts16(*buf, val))
+		return AppendInts16(dst, val)
 	case []int32:
-		dst.Write(AppendInts32(*buf, val))
+		return AppendInts32(dst, val)
 	case []int64:
-		dst.Write(AppendInts64(*buf, val))
+		return AppendInts64(dst, val)
 	case []uint:
-		dst.Write(AppendUints(*buf, val))
+		return AppendUints(dst, val)
 	// case []uint8:
-	// 	dst.Write(AppendUints8(*buf, val))
+	// 	return AppendUints8(dst, val)
 	case []uint16:
-		dst.Write(AppendUints16(*buf, val))
+		return AppendUints16(dst, val)
 	case []uint32:
-		dst.Write(AppendUints32(*buf, val))
+		return AppendUints32(dst, val)
 	case []uint64:
-		dst.Write(AppendUints64(*buf, val))
+		return AppendUints64(dst, val)
 	case []float32:
-		dst.Write(AppendFloats32(*buf, val))
+		return AppendFloats32(dst, val)
 	case []float64:
-		dst.Write(AppendFloats64(*buf, val))
+		return AppendFloats64(dst, val)
 	case []time.Time:
-		dst.Write(AppendTimes(*buf, val, opts.TimeFieldFormat))
+		return AppendTimes(dst, val, opts.TimeFieldFormat)
 	case []time.Duration:
-		dst.Write(AppendDurations(*buf, val,
-			opts.DurationFieldUnit, opts.DurationFieldInteger))
+		return AppendDurations(dst, val,
+			opts.DurationFieldUnit, opts.DurationFieldInteger)
 	case nil:
-		dst.Write(append(*buf, "null"...))
-	default:
-		dst.Write(AppendInterface(*buf, val, opts.InterfaceMarshaler))
+		return append(dst, "null"...)
+	case map[string]string:
+		return appendMapS(dst, val)
+	case map[string]interface{}:
+		return appendMapI(dst, val, opts, depth)
+	case FlatMapS:
+		return appendFlatMapS(dst, &val)
+	case *FlatMapS:
+		if val == nil {
+			return append(dst, "null"...)
+		}
+		return appendFlatMapS(dst, val)
+	case FlatMapI:
+		return appendFlatMapI(dst, &val, opts, depth)
+	case *FlatMapI:
+		if val == nil {
+			return append(dst, "null"...)
+		}
+		return appendFlatMapI(dst, val, opts, depth)
+	case json.Number:
+		if val == "" {
+			return append(dst, '0')
+		}
+		return append(dst, val...)
+	case json.Marshaler:
+		return appendMarshaler(dst, val)
+	case encoding.TextMarshaler:
+		if isNilPtr(val) {
+			return append(dst, "null"...)
+		}
+		text, err := val.MarshalText()
+		if err != nil {
+			return AppendString(dst, fmt.Sprintf("marshaling error: %v", err))
+		}
+		return AppendBytes(dst, text)
+	case fmt.Stringer:
+		if isNilPtr(val) {
+			return append(dst, "null"...)
+		}
+		return AppendString(dst, val.String())
+	}
+	return appendReflect(dst, v, opts, depth)
+}
+
+// appendMarshaler appends compacted output of MarshalJSON
+func appendMarshaler(dst []byte, val json.Marshaler) []byte {
+	if isNilPtr(val) {
+		return append(dst, "null"...)
+	}
+	b, err := val.MarshalJSON()
+	if err != nil {
+		return AppendString(dst, fmt.Sprintf("marshaling error: %v", err))
+	}
+	var bb bytes.Buffer
+	if err := json.Compact(&bb, b); err != nil {
+		return AppendString(dst, fmt.Sprintf("marshaling error: %v", err))
+	}
+	return append(dst, bb.Bytes()...)
+}
+
+func isNilPtr(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func removeField(key string, data *[]Field) {
+	for i := range *data {
+		if (*data)[i].Key == key {
+			*data = append((*data)[:i], (*data)[i+1:]...)
+			return
+		}
 	}
-	// if !found {
-	// 	*buffer = append(*buffer, dst)
-	// }
-	bytesPool.Put(buf)
 }
 
 func AppendBool(dst []byte, val bool) []byte {
@@ -399,7 +531,7 @@ func AppendInterface(dst []byte, i interface{}, fn func(v interface{}) ([]byte,
 	return append(dst, marshaled...)
 }
 
-const hex = "0123456789abcdef"
+const hexDigits = "0123456789abcdef"
 
 func AppendStrings(dst []byte, vals []string) []byte {
 	if len(vals) == 0 {
@@ -442,8 +574,7 @@ func AppendStringNoQuotes(dst []byte, s string) []byte {
 		if s[i] < 0x20 || s[i] > 0x7e || s[i] == '\\' || s[i] == '"' {
 			// We encountered a character that needs to be encoded. Switch
 			// to complex version of the algorithm.
-			dst = appendStringComplex(dst, s, i)
-			return append(dst, '"')
+			return appendStringComplex(dst, s, i)
 		}
 	}
 	// The string has no need for encoding an therefore is directly
@@ -500,7 +631,7 @@ func appendStringComplex(dst []byte, s string, i int) []byte {
 		case '\t':
 			dst = append(dst, '\\', 't')
 		default:
-			dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
 		}
 		i++
 		start = i
@@ -569,7 +700,7 @@ func appendBytesComplex(dst, s []byte, i int) []byte {
 		case '\t':
 			dst = append(dst, '\\', 't')
 		default:
-			dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
 		}
 		i++
 		start = i
@@ -643,7 +774,7 @@ func appendUnixTimes(dst []byte, vals []time.Time) []byte {
 	dst = strconv.AppendInt(dst, vals[0].Unix(), 10)
 	if len(vals) > 1 {
 		for _, t := range vals[1:] {
-			dst = strconv.AppendInt(dst, t.Unix(), 10)
+			dst = strconv.AppendInt(append(dst, ','), t.Unix(), 10)
 		}
 	}
 	dst = append(dst, ']')
@@ -0,0 +1,138 @@
+package qlog_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntry_LazyFields(t *testing.T) {
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel,
+		qlog.Sampling(time.Minute, 1, 0),
+		qlog.Redact(qlog.NewRedactor().Key("secret", qlog.DropField)))
+	calls := 0
+	expensive := func() interface{} {
+		calls++
+		return []int{1, 2, 3}
+	}
+	assert.False(t, np.Enabled(qlog.DebugLevel))
+	assert.True(t, np.Enabled(qlog.InfoLevel))
+	assert.False(t, np.Enabled(100))
+
+	np.DEBUG.Fields(qlog.F{Key: "payload", Value: expensive}).Msg("disabled")
+	np.INFO.Fields(
+		qlog.F{Key: "payload", Value: expensive},
+		qlog.F{Key: "lazy", Value: qlog.Lazy(func() interface{} { return "computed" })},
+		qlog.F{Key: "secret", Value: qlog.Lazy(func() interface{} { return "s" })},
+		qlog.F{Key: "count", Value: 1},
+	).Msg("written")
+	np.INFO.Fields(qlog.F{Key: "payload", Value: expensive}).Msg("written")
+	assert.Equal(t, 1, calls)
+
+	entries := logs.All()
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"payload": []int{1, 2, 3},
+		"lazy":    "computed",
+		"count":   1,
+	}, entries[0].ContextMap())
+}
+
+type textValue struct{ s string }
+
+func (v *textValue) MarshalText() ([]byte, error) {
+	return []byte("text:" + v.s), nil
+}
+
+type jsonValue struct{}
+
+func (jsonValue) MarshalJSON() ([]byte, error) {
+	return []byte(`{ "a" : 1 }`), nil
+}
+
+type badJSON struct{}
+
+func (badJSON) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("boom")
+}
+
+type stringer int
+
+func (s stringer) String() string {
+	return "stringer"
+}
+
+func TestAddField_Marshalers(t *testing.T) {
+	var nilText *textValue
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"stringer", stringer(1), `"stringer"`},
+		{"text marshaler", &textValue{"x"}, `"text:x"`},
+		{"nil text marshaler", nilText, `null`},
+		{"ip", net.IPv4(10, 0, 0, 1), `"10.0.0.1"`},
+		{"json marshaler", jsonValue{}, `{"a":1}`},
+		{"json error", badJSON{}, `"marshaling error: boom"`},
+		{"error", errors.New("e"), `"e"`},
+		{"lazy", qlog.Lazy(func() interface{} { return 5 }), `5`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data []qlog.Field
+			qlog.AddField(qlog.F{Key: "k", Value: tt.value}, &data, &qlog.LogConfig{})
+			if assert.Len(t, data, 1) {
+				assert.Equal(t, tt.want, data[0].Buffer.String())
+			}
+		})
+	}
+}
+
+// TestAddField_DuplicateKey checks that a duplicate key replaces value
+// and encoding of the existing field, not the buffer of the last one
+func TestAddField_DuplicateKey(t *testing.T) {
+	var data []qlog.Field
+	opts := &qlog.LogConfig{}
+	qlog.AddField(qlog.F{Key: "a", Value: "old"}, &data, opts)
+	qlog.AddField(qlog.F{Key: "b", Value: 2}, &data, opts)
+	qlog.AddField(qlog.F{Key: "a", Value: "new"}, &data, opts)
+	if !assert.Len(t, data, 2) {
+		return
+	}
+	assert.Equal(t, "a", data[0].Key)
+	assert.Equal(t, "new", data[0].Value)
+	assert.Equal(t, `"new"`, data[0].Buffer.String())
+	assert.Equal(t, 2, data[1].Value)
+	assert.Equal(t, `2`, data[1].Buffer.String())
+}
+
+func TestAppendString_Escapes(t *testing.T) {
+	for in, want := range map[string]string{
+		"plain":     `"plain"`,
+		"a\"b":      `"a\"b"`,
+		"tab\there": `"tab\there"`,
+		"é\xff":     `"é\ufffd"`,
+	} {
+		assert.Equal(t, want, string(qlog.AppendString(nil, in)))
+		assert.Equal(t, want, string(qlog.AppendBytes(nil, []byte(in))))
+	}
+}
+
+func TestAppendStringNoQuotes(t *testing.T) {
+	assert.Equal(t, `plain`, string(qlog.AppendStringNoQuotes(nil, "plain")))
+	assert.Equal(t, `a\"b\n`, string(qlog.AppendStringNoQuotes(nil, "a\"b\n")))
+}
+
+func TestAppendTimes_Unix(t *testing.T) {
+	vals := []time.Time{time.Unix(1, 0), time.Unix(2, 0), time.Unix(3, 0)}
+	assert.Equal(t, `[1,2,3]`, string(qlog.AppendTimes(nil, vals, "")))
+	assert.Equal(t, `[]`, string(qlog.AppendTimes(nil, nil, "")))
+}
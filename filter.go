@@ -0,0 +1,197 @@
+package qlog
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Filter reports whether an output writes the entry
+type Filter func(e *Entry) bool
+
+// Filtered returns notepad option adding outputs of opt which write only
+// entries passing filter, e.g.
+//
+//	np.SetOutput(qlog.Filtered(qlog.HasFieldValue("component", "billing"),
+//		qlog.MustJson(billingFile)))
+//
+// Hooks and fatal hooks added by opt are kept as is.
+func Filtered(filter Filter, opt func(*Notepad)) func(np *Notepad) {
+	return func(np *Notepad) {
+		n := outputCounts(np)
+		opt(np)
+		if filter == nil {
+			return
+		}
+		for t, logger := range np.Loggers {
+			if *logger == nil {
+				continue
+			}
+			outs := (*logger).Output
+			for i := n[t]; i < len(outs); i++ {
+				outs[i] = filterOutput(filter, outs[i])
+			}
+		}
+	}
+}
+
+// FromLevel returns notepad option adding outputs of opt only to loggers
+// of lvl and above, entries below lvl never reach them. Use it instead of
+// the MinLevel filter to set minimum level of an output.
+func FromLevel(lvl uint8, opt func(*Notepad)) func(np *Notepad) {
+	return func(np *Notepad) {
+		n := outputCounts(np)
+		opt(np)
+		for t, logger := range np.Loggers {
+			if *logger != nil && uint8(t) < lvl {
+				(*logger).Output = (*logger).Output[:n[t]:n[t]]
+			}
+		}
+	}
+}
+
+// outputCounts returns the number of outputs of every logger
+func outputCounts(np *Notepad) [_maxLevel + 1]int {
+	var n [_maxLevel + 1]int
+	for t, logger := range np.Loggers {
+		if *logger != nil {
+			n[t] = len((*logger).Output)
+		}
+	}
+	return n
+}
+
+func filterOutput(filter Filter, out Output) Output {
+	return func(e *Entry) {
+		if filter(e) {
+			out(e)
+		}
+	}
+}
+
+// And returns filter passing entries which pass all filters
+func And(filters ...Filter) Filter {
+	return func(e *Entry) bool {
+		for _, f := range filters {
+			if !f(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns filter passing entries which pass any of filters
+func Or(filters ...Filter) Filter {
+	return func(e *Entry) bool {
+		for _, f := range filters {
+			if f(e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns filter passing entries which do not pass f
+func Not(f Filter) Filter {
+	return func(e *Entry) bool {
+		return !f(e)
+	}
+}
+
+// MinLevel returns filter passing entries of lvl and above
+func MinLevel(lvl uint8) Filter {
+	return func(e *Entry) bool {
+		return e.Logger.Level.n >= lvl
+	}
+}
+
+// LoggerName returns filter passing entries of notepad name and its
+// Named children, e.g. "app.db" and "app.db.pool" for "app.db"
+func LoggerName(name string) Filter {
+	return func(e *Entry) bool {
+		n := e.Logger.Notepad.Name
+		return len(n) >= len(name) && Bytes2Str(n[:len(name)]) == name &&
+			(len(n) == len(name) || n[len(name)] == '.')
+	}
+}
+
+// MessagePrefix returns filter passing entries with message starting
+// with prefix
+func MessagePrefix(prefix string) Filter {
+	return func(e *Entry) bool {
+		return bytes.HasPrefix(e.Message, Str2Bytes(prefix))
+	}
+}
+
+// HasField returns filter passing entries with field key in entry,
+// logger or notepad fields
+func HasField(key string) Filter {
+	return func(e *Entry) bool {
+		_, ok := lookupField(e, key)
+		return ok
+	}
+}
+
+// HasFieldValue returns filter passing entries with field key of value.
+// Strings and errors are compared as is, other values by their json
+// encoding, e.g. "42" or "true".
+func HasFieldValue(key, value string) Filter {
+	return func(e *Entry) bool {
+		f, ok := lookupField(e, key)
+		if !ok {
+			return false
+		}
+		switch v := f.Value.(type) {
+		case string:
+			return v == value
+		case error:
+			if !isNilPtr(v) {
+				return v.Error() == value
+			}
+		}
+		return Bytes2Str(f.Buffer.Bytes()) == value
+	}
+}
+
+// filterFromSpec returns filter of "filter_name", "filter_prefix" and
+// comma separated key or key=value "filter_fields" options, all of them
+// must pass. It returns nil if none is set.
+func filterFromSpec(spec *OutputSpec) (Filter, error) {
+	var filters []Filter
+	name, err := spec.Options.String("filter_name", "")
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		filters = append(filters, LoggerName(name))
+	}
+	prefix, err := spec.Options.String("filter_prefix", "")
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		filters = append(filters, MessagePrefix(prefix))
+	}
+	fields, err := spec.Options.String("filter_fields", "")
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range strings.Split(fields, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			filters = append(filters, HasFieldValue(strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])))
+		} else {
+			filters = append(filters, HasField(kv))
+		}
+	}
+	switch len(filters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return filters[0], nil
+	}
+	return And(filters...), nil
+}
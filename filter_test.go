@@ -0,0 +1,97 @@
+package qlog_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func messageTemplate(out *bytes.Buffer) func(*qlog.Notepad) {
+	return qlog.MustTemplate("${name} ${message}\n", func(o *qlog.TemplateOptions) error {
+		o.OutHandle, o.ErrHandle, o.OutLevel = out, out, qlog.DebugLevel
+		return nil
+	})
+}
+
+func TestFiltered(t *testing.T) {
+	var all, billing, errs bytes.Buffer
+	np := qlog.New("app", qlog.DebugLevel).SetOutput(
+		messageTemplate(&all),
+		qlog.Filtered(qlog.And(qlog.HasFieldValue("component", "billing"), qlog.Not(qlog.MessagePrefix("debug:"))),
+			messageTemplate(&billing)),
+		qlog.Filtered(qlog.Or(qlog.MinLevel(qlog.ErrorLevel), qlog.HasField("alert")), messageTemplate(&errs)))
+	bill := np.WithFields(qlog.F{Key: "component", Value: "billing"})
+	bill.INFO.Msg("charged")
+	bill.DEBUG.Msg("debug: retry")
+	np.INFO.Fields(qlog.F{Key: "component", Value: "shipping"}).Msg("shipped")
+	np.WARN.Fields(qlog.F{Key: "alert", Value: true}).Msg("disk")
+	np.ERROR.Msg("failed")
+
+	assert.Equal(t, "app charged\napp debug: retry\napp shipped\napp disk\napp failed\n", all.String())
+	assert.Equal(t, "app charged\n", billing.String())
+	assert.Equal(t, "app disk\napp failed\n", errs.String())
+}
+
+func TestFilter_Predicates(t *testing.T) {
+	var out bytes.Buffer
+	np := qlog.New("svc", qlog.DebugLevel).SetOutput(qlog.Filtered(
+		qlog.And(qlog.LoggerName("svc.db"), qlog.HasFieldValue("n", "2")), messageTemplate(&out)))
+	db := np.Named("db")
+	db.INFO.Fields(qlog.F{Key: "n", Value: 2}).Msg("db")
+	db.Named("pool").INFO.Fields(qlog.F{Key: "n", Value: 2}).Msg("pool")
+	db.INFO.Fields(qlog.F{Key: "n", Value: 3}).Msg("other value")
+	np.Named("dbx").INFO.Fields(qlog.F{Key: "n", Value: 2}).Msg("other name")
+	np.INFO.Fields(qlog.F{Key: "n", Value: 2}).Msg("parent")
+	assert.Equal(t, "svc.db db\nsvc.db.pool pool\n", out.String())
+}
+
+func TestFromLevel(t *testing.T) {
+	var out, prefixed bytes.Buffer
+	np := qlog.New("app", qlog.DebugLevel).SetOutput(
+		qlog.FromLevel(qlog.WarnLevel, messageTemplate(&out)),
+		qlog.Filtered(qlog.MessagePrefix("pay"), messageTemplate(&prefixed)))
+	s := np.Snapshot()
+	assert.Len(t, s.INFO.Output, 1)
+	assert.Len(t, s.WARN.Output, 2)
+	np.INFO.Msg("payment ok")
+	np.WARN.Msg("payment late")
+	np.ERROR.Msg("disk full")
+	assert.Equal(t, "app payment late\napp disk full\n", out.String())
+	assert.Equal(t, "app payment ok\napp payment late\n", prefixed.String())
+	assert.Equal(t, []qlog.OutputStats{
+		{Output: "*qlog.TemplateEncoder", Written: 2},
+		{Output: "*qlog.TemplateEncoder", Written: 2},
+	}, np.OutputStats())
+}
+
+func TestFromConfig_Filter(t *testing.T) {
+	var buf bytes.Buffer
+	qlog.RegisterOutput("test_filter", func(spec *qlog.OutputSpec) (func(*qlog.Notepad), error) {
+		return messageTemplate(&buf), nil
+	})
+	np, err := qlog.FromConfig(strings.NewReader(`
+name: app
+level: debug
+outputs:
+  - type: test_filter
+    min_level: warn
+    filter_prefix: pay
+    filter_fields: "component=billing, tenant"
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer np.Close()
+	bill := np.WithFields(qlog.F{Key: "component", Value: "billing"}, qlog.F{Key: "tenant", Value: 7})
+	bill.WARN.Msg("payment declined")
+	bill.INFO.Msg("payment ok")
+	bill.ERROR.Msg("refund failed")
+	np.ERROR.Msg("payment lost")
+	assert.Equal(t, "app payment declined\n", buf.String())
+
+	_, err = qlog.FromConfig(strings.NewReader("outputs:\n  - type: json\n    min_level: loud\n"))
+	assert.Error(t, err)
+}
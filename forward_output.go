@@ -0,0 +1,441 @@
+package qlog
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ForwardOptions configures Fluentd Forward protocol client
+type ForwardOptions struct {
+	// Tag is the Fluentd tag, the notepad name if empty
+	Tag           string
+	Level         uint8
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+	// RequireAck sends chunk option and waits for server ack of every
+	// message
+	RequireAck   bool
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	AckTimeout   time.Duration
+	// BufferSize is the number of queued entries, entries are dropped
+	// when the queue is full
+	BufferSize    int
+	BatchSize     int
+	FlushInterval time.Duration
+	// MaxRetries is the number of resends of a failed message, the
+	// client reconnects before every resend
+	MaxRetries int
+	RetryWait  time.Duration
+	// ErrorHandler is called with errors of dropped messages
+	ErrorHandler func(error)
+}
+
+func defaultForwardOptions() *ForwardOptions {
+	mo := defaultMessagePackOptions()
+	return &ForwardOptions{
+		Level:         InfoLevel,
+		LogName:       mo.LogName,
+		LevelName:     mo.LevelName,
+		MessageName:   mo.MessageName,
+		DialTimeout:   5 * time.Second,
+		WriteTimeout:  5 * time.Second,
+		AckTimeout:    10 * time.Second,
+		BufferSize:    8192,
+		BatchSize:     256,
+		FlushInterval: time.Second,
+		MaxRetries:    3,
+		RetryWait:     500 * time.Millisecond,
+		ErrorHandler: func(err error) {
+			fmt.Fprintln(os.Stderr, err)
+		},
+	}
+}
+
+// ForwardClient sends entries to Fluentd Forward protocol server like
+// fluentd or fluent-bit. Entries are queued and sent in batches by a
+// background goroutine as Forward mode messages
+// [tag, [[time, record], ...], option], records are encoded like
+// MessagePack output.
+type ForwardClient struct {
+	addr    string
+	opts    *ForwardOptions
+	enc     forwardEncoder
+	queue   chan forwardEvent
+	flushc  chan chan error
+	quit    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	closed  int32
+	dropped uint64
+	err     error
+	conn    net.Conn
+	rd      *bufio.Reader
+	buf     []byte
+	// clock is the time source of write and ack timeouts, the clock of
+	// the notepad the client is attached to
+	clock atomic.Value
+}
+
+type forwardEvent struct {
+	tag  string
+	data []byte
+}
+
+// forwardEncoder encodes entries as [time, record]
+type forwardEncoder struct {
+	*MessagePackEncoder
+}
+
+func (f forwardEncoder) Begin(dst []byte, e *Entry) []byte {
+	return f.appendRecord(appendEventTime(append(dst, msgpackFixArray|2), e.Time), e)
+}
+
+// NewForwardClient returns client sending entries to addr "host:port".
+// Connection is established with the first message.
+func NewForwardClient(addr string, opts ...func(*ForwardOptions) error) (*ForwardClient, error) {
+	options := defaultForwardOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	if options.Level > _maxLevel {
+		return nil, errors.New("qlog: forward level is out of range")
+	}
+	if options.BufferSize < 1 || options.BatchSize < 1 || options.FlushInterval <= 0 {
+		return nil, errors.New("qlog: forward buffer, batch size and flush interval must be positive")
+	}
+	c := &ForwardClient{
+		addr: addr,
+		opts: options,
+		enc: forwardEncoder{&MessagePackEncoder{
+			LogName:       options.LogName,
+			TimestampName: options.TimestampName,
+			LevelName:     options.LevelName,
+			MessageName:   options.MessageName,
+		}},
+		queue:  make(chan forwardEvent, options.BufferSize),
+		flushc: make(chan chan error),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	c.clock.Store(time.Now)
+	go c.run()
+	return c, nil
+}
+
+// Forward returns Forward client output for Notepad.SetOutput or error
+// of NewForwardClient
+func Forward(addr string, opts ...func(*ForwardOptions) error) (func(np *Notepad), error) {
+	c, err := NewForwardClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return c.Output(), nil
+}
+
+// MustForward is like Forward but panics if NewForwardClient fails
+func MustForward(addr string, opts ...func(*ForwardOptions) error) func(np *Notepad) {
+	fn, err := Forward(addr, opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// Output returns function attaching the client to the notepad loggers at
+// ForwardOptions.Level and above. Queued entries are sent before a fatal
+// exit. Write and ack timeouts are measured by the notepad clock.
+func (c *ForwardClient) Output() func(np *Notepad) {
+	return func(np *Notepad) {
+		if c.opts.Level < np.Level.n {
+			np.handleError(errors.New("qlog: forward level is out of range"))
+			return
+		}
+		c.clock.Store(np.Options.TimestampFunc)
+		out := Output(c.write)
+		for tlv, logger := range np.Loggers {
+			if *logger != nil && uint8(tlv) >= c.opts.Level {
+				(*logger).Output = append((*logger).Output, out)
+			}
+		}
+		np.AddFatalHook(func() {
+			_ = c.Close()
+		})
+	}
+}
+
+// Dropped returns the number of entries dropped because the queue was
+// full or sending failed
+func (c *ForwardClient) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Flush sends queued entries and returns error of the last failed
+// message
+func (c *ForwardClient) Flush() error {
+	ch := make(chan error, 1)
+	select {
+	case c.flushc <- ch:
+		return <-ch
+	case <-c.done:
+		return c.err
+	}
+}
+
+// Close sends queued entries and closes the connection. Entries written
+// after Close are dropped.
+func (c *ForwardClient) Close() error {
+	c.once.Do(func() {
+		atomic.StoreInt32(&c.closed, 1)
+		close(c.quit)
+	})
+	<-c.done
+	return c.err
+}
+
+func (c *ForwardClient) write(e *Entry) {
+	if atomic.LoadInt32(&c.closed) != 0 {
+		atomic.AddUint64(&c.dropped, 1)
+		return
+	}
+	tag := c.opts.Tag
+	if tag == "" {
+		tag = string(e.Logger.Notepad.Name)
+	}
+	ev := forwardEvent{tag: tag, data: EncodeEntry(c.enc, nil, e)}
+	select {
+	case c.queue <- ev:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+func (c *ForwardClient) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+	var batch []forwardEvent
+	for {
+		select {
+		case ev := <-c.queue:
+			batch = append(batch, ev)
+			if len(batch) >= c.opts.BatchSize {
+				batch = c.send(batch)
+			}
+		case <-ticker.C:
+			batch = c.send(batch)
+		case ch := <-c.flushc:
+			batch = c.send(c.drain(batch))
+			ch <- c.err
+		case <-c.quit:
+			c.send(c.drain(batch))
+			if c.conn != nil {
+				_ = c.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// drain appends queued entries to batch
+func (c *ForwardClient) drain(batch []forwardEvent) []forwardEvent {
+	for {
+		select {
+		case ev := <-c.queue:
+			batch = append(batch, ev)
+		default:
+			return batch
+		}
+	}
+}
+
+// send sends batch as messages of entries with the same tag and returns
+// the emptied batch
+func (c *ForwardClient) send(batch []forwardEvent) []forwardEvent {
+	for i := 0; i < len(batch); {
+		j := i + 1
+		for j < len(batch) && j-i < c.opts.BatchSize && batch[j].tag == batch[i].tag {
+			j++
+		}
+		if err := c.sendMessage(batch[i:j]); err != nil {
+			c.err = err
+			atomic.AddUint64(&c.dropped, uint64(j-i))
+			c.opts.ErrorHandler(err)
+		}
+		i = j
+	}
+	for i := range batch {
+		batch[i] = forwardEvent{}
+	}
+	return batch[:0]
+}
+
+func (c *ForwardClient) sendMessage(events []forwardEvent) error {
+	chunk := ""
+	if c.opts.RequireAck {
+		var id [16]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return fmt.Errorf("qlog forward: %s", err)
+		}
+		chunk = base64.StdEncoding.EncodeToString(id[:])
+	}
+	msg := appendMsgpackString(append(c.buf[:0], msgpackFixArray|3), events[0].tag)
+	msg = appendMsgpackArrayHead(msg, len(events))
+	for _, ev := range events {
+		msg = append(msg, ev.data...)
+	}
+	if chunk != "" {
+		msg = appendMsgpackString(append(msg, msgpackFixMap|2), "chunk")
+		msg = appendMsgpackString(msg, chunk)
+	} else {
+		msg = append(msg, msgpackFixMap|1)
+	}
+	msg = appendMsgpackUint(appendMsgpackString(msg, "size"), uint64(len(events)))
+	c.buf = msg
+
+	var err error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.opts.RetryWait << uint(attempt-1)):
+			case <-c.quit:
+			}
+		}
+		if err = c.writeMessage(msg, chunk); err == nil {
+			return nil
+		}
+		if c.conn != nil {
+			_ = c.conn.Close()
+			c.conn = nil
+		}
+	}
+	return fmt.Errorf("qlog forward: %d entries dropped: %s", len(events), err)
+}
+
+func (c *ForwardClient) writeMessage(msg []byte, chunk string) error {
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, c.opts.DialTimeout)
+		if err != nil {
+			return err
+		}
+		c.conn, c.rd = conn, bufio.NewReader(conn)
+	}
+	err := c.withTimeout(c.opts.WriteTimeout, c.conn.SetWriteDeadline, func() error {
+		n, err := c.conn.Write(msg)
+		msg = msg[n:]
+		return err
+	})
+	if err != nil || chunk == "" {
+		return err
+	}
+	err = c.withTimeout(c.opts.AckTimeout, c.conn.SetReadDeadline, func() error {
+		_, err := c.rd.Peek(1)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	ack, err := readForwardAck(c.rd)
+	if err != nil {
+		return err
+	}
+	if ack != chunk {
+		return fmt.Errorf("ack %q does not match chunk %q", ack, chunk)
+	}
+	return nil
+}
+
+// forwardPoll is the longest connection deadline, timeouts are checked
+// against the client clock after it passes
+const forwardPoll = 50 * time.Millisecond
+
+// withTimeout runs op with short connection deadlines set by setDeadline
+// until op succeeds, fails with other than timeout error or timeout
+// measured by the client clock passes
+func (c *ForwardClient) withTimeout(timeout time.Duration, setDeadline func(time.Time) error, op func() error) error {
+	now := c.clock.Load().(func() time.Time)
+	start := now()
+	for {
+		wait := timeout - now().Sub(start)
+		if wait > forwardPoll {
+			wait = forwardPoll
+		}
+		if err := setDeadline(time.Now().Add(wait)); err != nil {
+			return err
+		}
+		err := op()
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() || now().Sub(start) >= timeout {
+			return err
+		}
+	}
+}
+
+// readForwardAck reads server response {"ack": chunk}
+func readForwardAck(r *bufio.Reader) (string, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if c&0xf0 != msgpackFixMap {
+		return "", errors.New("invalid ack response")
+	}
+	ack := ""
+	for n := int(c & 0x0f); n > 0; n-- {
+		k, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		v, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		if k == "ack" {
+			ack = v
+		}
+	}
+	return ack, nil
+}
+
+func readMsgpackString(r *bufio.Reader) (string, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	n := 0
+	switch {
+	case c&0xe0 == msgpackFixStr:
+		n = int(c & 0x1f)
+	case c >= 0xd9 && c <= 0xdb:
+		var b [4]byte
+		sz := 1 << (c - 0xd9)
+		if _, err = io.ReadFull(r, b[:sz]); err != nil {
+			return "", err
+		}
+		for _, x := range b[:sz] {
+			n = n<<8 | int(x)
+		}
+	default:
+		return "", errors.New("invalid ack response")
+	}
+	if n > 1024 {
+		return "", errors.New("invalid ack response")
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,322 @@
+package qlog_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// readMsgpack decodes MessagePack value, EventTime is decoded to time
+func readMsgpack(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	be := func(n int) (uint64, error) {
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[8-n:]); err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b[:]), nil
+	}
+	str := func(n uint64) (string, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return string(b), err
+	}
+	seq := func(n uint64, pairs bool) (interface{}, error) {
+		if !pairs {
+			a := make([]interface{}, n)
+			for i := range a {
+				if a[i], err = readMsgpack(r); err != nil {
+					return nil, err
+				}
+			}
+			return a, nil
+		}
+		m := make(map[string]interface{}, n)
+		for ; n > 0; n-- {
+			k, err := readMsgpack(r)
+			if err != nil {
+				return nil, err
+			}
+			if m[fmt.Sprint(k)], err = readMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	switch {
+	case c < 0x80:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c < 0x90:
+		return seq(uint64(c&0x0f), true)
+	case c < 0xa0:
+		return seq(uint64(c&0x0f), false)
+	case c < 0xc0:
+		return str(uint64(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2, 0xc3:
+		return c == 0xc3, nil
+	case 0xca:
+		n, err := be(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := be(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := be(1 << (c - 0xcc))
+		return int64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		sz := 1 << (c - 0xd0)
+		n, err := be(sz)
+		return int64(n<<(64-8*uint(sz))) >> (64 - 8*uint(sz)), err
+	case 0xd7:
+		if typ, err := r.ReadByte(); err != nil || typ != 0 {
+			return nil, errors.New("unknown extension")
+		}
+		n, err := be(8)
+		return time.Unix(int64(n>>32), int64(uint32(n))).UTC(), err
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		base := byte(0xd9)
+		if c < 0xd9 {
+			base = 0xc4
+		}
+		n, err := be(1 << (c - base))
+		if err != nil {
+			return nil, err
+		}
+		return str(n)
+	case 0xdc, 0xdd, 0xde, 0xdf:
+		n, err := be(2 << ((c - 0xdc) & 1))
+		if err != nil {
+			return nil, err
+		}
+		return seq(n, c >= 0xde)
+	}
+	return nil, fmt.Errorf("unknown type 0x%x", c)
+}
+
+// forwardServer is a Forward protocol stand-in acking chunks. It closes
+// the first connection after the first message without ack.
+type forwardServer struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	messages []interface{}
+	conns    int
+}
+
+func newForwardServer(t *testing.T) *forwardServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &forwardServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			first := s.conns == 1
+			s.mu.Unlock()
+			go s.serve(conn, first)
+		}
+	}()
+	return s
+}
+
+func (s *forwardServer) serve(conn net.Conn, drop bool) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readMsgpack(r)
+		if err != nil || drop {
+			return
+		}
+		s.mu.Lock()
+		s.messages = append(s.messages, msg)
+		s.mu.Unlock()
+		a, _ := msg.([]interface{})
+		if len(a) != 3 {
+			return
+		}
+		if opt, _ := a[2].(map[string]interface{}); opt["chunk"] != nil {
+			ack := append([]byte{0x81, 0xa3, 'a', 'c', 'k', 0xa0 | byte(len(opt["chunk"].(string)))}, opt["chunk"].(string)...)
+			if _, err = conn.Write(ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *forwardServer) received() []interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages
+}
+
+func TestForwardClient(t *testing.T) {
+	srv := newForwardServer(t)
+	defer srv.ln.Close()
+	var errs []error
+	c, err := qlog.NewForwardClient(srv.ln.Addr().String(), func(o *qlog.ForwardOptions) error {
+		o.RequireAck, o.RetryWait, o.FlushInterval = true, time.Millisecond, time.Hour
+		o.ErrorHandler = func(err error) { errs = append(errs, err) }
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Date(2020, 1, 2, 10, 0, 0, 5, time.UTC)
+	np := qlog.New("app", qlog.InfoLevel, qlog.Clock(func() time.Time { return now })).SetOutput(c.Output())
+	np.INFO.Fields(qlog.F{Key: "user_id", Value: 42}, qlog.F{Key: "took", Value: 1500 * time.Millisecond}).Msg("done")
+	np.ERROR.Fields(qlog.F{Key: "req", Value: map[string]interface{}{"id": "a1"}}).Msg("failed")
+	np.DEBUG.Msg("skipped")
+
+	// the first connection is closed without ack and the message is resent
+	assert.NoError(t, c.Flush())
+	assert.NoError(t, c.Close())
+	assert.Empty(t, errs)
+	assert.Equal(t, uint64(0), c.Dropped())
+	msgs := srv.received()
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+	msg := msgs[0].([]interface{})
+	assert.Equal(t, "app", msg[0])
+	assert.Equal(t, []interface{}{
+		[]interface{}{now, map[string]interface{}{"name": "app", "level": "info", "message": "done",
+			"user_id": int64(42), "took": int64(1500)}},
+		[]interface{}{now, map[string]interface{}{"name": "app", "level": "error", "message": "failed",
+			"req": map[string]interface{}{"id": "a1"}}},
+	}, msg[1])
+	assert.Equal(t, int64(2), msg[2].(map[string]interface{})["size"])
+	assert.Len(t, msg[2].(map[string]interface{})["chunk"], 24)
+
+	np.INFO.Msg("after close")
+	assert.Equal(t, uint64(1), c.Dropped())
+}
+
+func TestForwardClient_Error(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	var errs []error
+	c, err := qlog.NewForwardClient(addr, func(o *qlog.ForwardOptions) error {
+		o.Tag, o.MaxRetries, o.RetryWait = "svc", 1, time.Millisecond
+		o.ErrorHandler = func(err error) { errs = append(errs, err) }
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	np := qlog.New("app", qlog.InfoLevel).SetOutput(c.Output())
+	np.INFO.Msg("lost")
+	np.WARN.Msg("lost")
+	assert.Error(t, c.Flush())
+	assert.Len(t, errs, 1)
+	assert.Equal(t, uint64(2), c.Dropped())
+	assert.Error(t, c.Close())
+
+	_, err = qlog.NewForwardClient(addr, func(o *qlog.ForwardOptions) error {
+		o.BatchSize = 0
+		return nil
+	})
+	assert.Error(t, err)
+}
+
+func TestForwardClient_ClockTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// read messages without ack
+		_, _ = io.Copy(ioutil.Discard, conn)
+	}()
+	var errs []error
+	c, err := qlog.NewForwardClient(ln.Addr().String(), func(o *qlog.ForwardOptions) error {
+		o.RequireAck, o.MaxRetries, o.AckTimeout, o.FlushInterval = true, 0, time.Minute, time.Hour
+		o.ErrorHandler = func(err error) { errs = append(errs, err) }
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	clock := qlogtest.NewClock(time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC), 30*time.Second)
+	np := qlog.New("app", qlog.InfoLevel, qlog.Clock(clock.Now)).SetOutput(c.Output())
+	np.INFO.Msg("unacked")
+	start := time.Now()
+	err = c.Flush()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "timeout")
+	}
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, uint64(1), c.Dropped())
+	assert.Error(t, c.Close())
+}
+
+func TestFromConfig_Forward(t *testing.T) {
+	srv := newForwardServer(t)
+	defer srv.ln.Close()
+	np, err := qlog.FromConfig(strings.NewReader(`
+name: svc
+level: info
+outputs:
+  - type: forward
+    addr: ` + srv.ln.Addr().String() + `
+    tag: app.svc
+    require_ack: true
+    level: warn
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	np.INFO.Msg("hidden")
+	np.WARN.Msg("slow")
+	if !assert.NoError(t, np.Close()) {
+		return
+	}
+	msgs := srv.received()
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+	msg := msgs[0].([]interface{})
+	assert.Equal(t, "app.svc", msg[0])
+	entries := msg[1].([]interface{})
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, map[string]interface{}{"name": "svc", "level": "warn", "message": "slow"},
+			entries[0].([]interface{})[1])
+	}
+}
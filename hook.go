@@ -0,0 +1,234 @@
+package qlog
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Hook is fired for entries of its levels before they are written. Fire
+// may add or change entry fields with Entry.Fields and the message,
+// outputs see the change with lazy values resolved and redacted. Fire
+// errors and panics are passed to LogConfig.HookErrorHandler or
+// ErrorHandler and never stop logging.
+type Hook interface {
+	Levels() LevelMask
+	Fire(e *Entry) error
+}
+
+// LevelMask is a set of levels, bit n is set for level n
+type LevelMask uint8
+
+// AllLevels is the mask of levels from debug to fatal
+const AllLevels LevelMask = 1<<(_maxLevel+1) - 1
+
+// Levels returns mask of lvls
+func Levels(lvls ...uint8) LevelMask {
+	var m LevelMask
+	for _, lvl := range lvls {
+		m |= 1 << lvl
+	}
+	return m & AllLevels
+}
+
+// LevelsFrom returns mask of lvl and above
+func LevelsFrom(lvl uint8) LevelMask {
+	return AllLevels &^ (1<<lvl - 1)
+}
+
+// Has reports whether lvl is in the mask
+func (m LevelMask) Has(lvl uint8) bool {
+	return lvl <= _maxLevel && m&(1<<lvl) != 0
+}
+
+// HookFunc is a Hook fired at all levels
+type HookFunc func(e *Entry) error
+
+func (HookFunc) Levels() LevelMask { return AllLevels }
+
+func (f HookFunc) Fire(e *Entry) error { return f(e) }
+
+// Prioritized is implemented by hooks with priority. Hooks with higher
+// priority fire first, hooks of the same priority in order of adding.
+// Hooks without Priority method have priority 0.
+type Prioritized interface {
+	Priority() int
+}
+
+// WithPriority returns h with priority p
+func WithPriority(h Hook, p int) Hook {
+	return priorityHook{Hook: h, priority: p}
+}
+
+type priorityHook struct {
+	Hook
+	priority int
+}
+
+func (h priorityHook) Priority() int { return h.priority }
+
+func hookPriority(h Hook) int {
+	if p, ok := h.(Prioritized); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+// insertHook adds h to the logger if h fires at its level, after hooks
+// of the same or higher priority
+func (l *Logger) insertHook(h Hook) {
+	if !h.Levels().Has(l.Level.n) {
+		return
+	}
+	p := hookPriority(h)
+	i := len(l.Hooks)
+	for i > 0 && hookPriority(l.Hooks[i-1]) < p {
+		i--
+	}
+	l.Hooks = append(l.Hooks, nil)
+	copy(l.Hooks[i+1:], l.Hooks[i:])
+	l.Hooks[i] = h
+}
+
+// fireHooks fires hooks of the entry level
+func fireHooks(hooks []Hook, e *Entry) {
+	for _, h := range hooks {
+		if !h.Levels().Has(e.Logger.Level.n) {
+			continue
+		}
+		if err := fireHook(h, e); err != nil {
+			handleHookError(e, err)
+		}
+	}
+}
+
+// fireHook fires h turning panics into errors
+func fireHook(h Hook, e *Entry) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("qlog: hook %T panic: %v", h, p)
+		}
+	}()
+	return h.Fire(e)
+}
+
+func handleHookError(e *Entry, err error) {
+	if fn := e.Logger.Notepad.Options.HookErrorHandler; fn != nil {
+		fn(err)
+		return
+	}
+	e.Logger.Notepad.handleError(err)
+}
+
+// ErrHookQueueFull is passed to the hook error handler for entries
+// dropped by AsyncHook
+var ErrHookQueueFull = errors.New("qlog: async hook queue is full")
+
+// AsyncHookOptions configures AsyncHook
+type AsyncHookOptions struct {
+	// QueueSize is the number of queued entries, entries are dropped
+	// when the queue is full
+	QueueSize int
+}
+
+func defaultAsyncHookOptions() *AsyncHookOptions {
+	return &AsyncHookOptions{
+		QueueSize: 1024,
+	}
+}
+
+// AsyncHook fires hook in a background goroutine with copies of
+// entries, so the hook can not change fields written by outputs. It has
+// the levels and priority of the hook. Close stops the goroutine after
+// queued entries are fired.
+type AsyncHook struct {
+	hook    Hook
+	queue   chan *Entry
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	dropped uint64
+}
+
+// NewAsyncHook returns AsyncHook firing h
+func NewAsyncHook(h Hook, opts ...func(*AsyncHookOptions) error) (*AsyncHook, error) {
+	options := defaultAsyncHookOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	if options.QueueSize < 1 {
+		return nil, errors.New("qlog: async hook queue size is less than 1")
+	}
+	a := &AsyncHook{
+		hook:  h,
+		queue: make(chan *Entry, options.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+func (a *AsyncHook) Levels() LevelMask { return a.hook.Levels() }
+
+func (a *AsyncHook) Priority() int { return hookPriority(a.hook) }
+
+// Fire queues copy of e. It returns ErrHookQueueFull if the queue is
+// full.
+func (a *AsyncHook) Fire(e *Entry) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		atomic.AddUint64(&a.dropped, 1)
+		return errors.New("qlog: async hook is closed")
+	}
+	select {
+	case a.queue <- e.clone():
+		return nil
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+		return ErrHookQueueFull
+	}
+}
+
+func (a *AsyncHook) run() {
+	defer close(a.done)
+	for e := range a.queue {
+		if err := fireHook(a.hook, e); err != nil {
+			handleHookError(e, err)
+		}
+	}
+}
+
+// Dropped returns the number of entries dropped because the queue was
+// full or the hook was closed
+func (a *AsyncHook) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Close fires queued entries and stops the hook
+func (a *AsyncHook) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return nil
+}
+
+// clone returns copy of entry which is not returned to the pool
+func (e *Entry) clone() *Entry {
+	c := &Entry{
+		Logger:   e.Logger,
+		Data:     copyFields(e.Data),
+		Time:     e.Time,
+		Message:  append([]byte(nil), e.Message...),
+		ErrorFld: e.ErrorFld,
+	}
+	c.bufferTime = append([]byte(nil), e.bufferTime...)
+	return c
+}
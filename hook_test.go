@@ -0,0 +1,147 @@
+package qlog_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+// levelHook records messages at its levels
+type levelHook struct {
+	mask qlog.LevelMask
+	name string
+	log  *[]string
+}
+
+func (h levelHook) Levels() qlog.LevelMask { return h.mask }
+
+func (h levelHook) Fire(e *qlog.Entry) error {
+	*h.log = append(*h.log, h.name+" "+string(e.Message))
+	return nil
+}
+
+func hookNotepad(out *bytes.Buffer, errs *[]error) *qlog.Notepad {
+	return qlog.New("app", qlog.DebugLevel, func(c *qlog.LogConfig) error {
+		c.HookErrorHandler = func(err error) { *errs = append(*errs, err) }
+		return nil
+	}).SetOutput(qlog.MustTemplate("${message} ${fields}\n", func(o *qlog.TemplateOptions) error {
+		o.OutHandle, o.ErrHandle, o.OutLevel = out, out, qlog.DebugLevel
+		return nil
+	}))
+}
+
+func TestHook_LevelsAndPriority(t *testing.T) {
+	var (
+		out  bytes.Buffer
+		errs []error
+		log  []string
+	)
+	np := hookNotepad(&out, &errs)
+	np.AddHooks(qlog.InfoLevel,
+		levelHook{qlog.AllLevels, "a", &log},
+		qlog.WithPriority(levelHook{qlog.LevelsFrom(qlog.ErrorLevel), "err", &log}, 10),
+		levelHook{qlog.Levels(qlog.DebugLevel, qlog.WarnLevel), "b", &log},
+		qlog.WithPriority(levelHook{qlog.AllLevels, "late", &log}, -1))
+	np.Debug("d")
+	np.Info("i")
+	np.Warn("w")
+	np.Error("e")
+	assert.Equal(t, []string{"a i", "late i", "a w", "b w", "late w", "err e", "a e", "late e"}, log)
+	assert.Empty(t, errs)
+
+	assert.True(t, qlog.LevelsFrom(qlog.WarnLevel).Has(qlog.FatalLevel))
+	assert.False(t, qlog.LevelsFrom(qlog.WarnLevel).Has(qlog.InfoLevel))
+	assert.Equal(t, qlog.AllLevels, qlog.LevelsFrom(qlog.DebugLevel))
+}
+
+func TestHook_FieldsAndErrors(t *testing.T) {
+	var (
+		out  bytes.Buffer
+		errs []error
+	)
+	np := hookNotepad(&out, &errs)
+	np.AddHooks(qlog.DebugLevel,
+		qlog.HookFunc(func(e *qlog.Entry) error {
+			e.Fields(qlog.F{Key: "host", Value: "h1"})
+			return nil
+		}),
+		qlog.HookFunc(func(e *qlog.Entry) error {
+			return errors.New("alert failed")
+		}),
+		qlog.HookFunc(func(e *qlog.Entry) error {
+			panic("broken hook")
+		}))
+	np.INFO.Fields(qlog.F{Key: "n", Value: 1}).Msg("served")
+	assert.Equal(t, "served {\"n\":1,\"host\":\"h1\"}\n", out.String())
+	if assert.Len(t, errs, 2) {
+		assert.EqualError(t, errs[0], "alert failed")
+		assert.Contains(t, errs[1].Error(), "panic: broken hook")
+	}
+}
+
+func TestHook_LazyAndRedacted(t *testing.T) {
+	var (
+		out  bytes.Buffer
+		seen []string
+	)
+	np := qlog.New("", qlog.InfoLevel, qlog.Redact(qlog.NewRedactor().Emails(qlog.MaskFull))).
+		SetOutput(qlog.MustTemplate("${message} ${fields}\n", func(o *qlog.TemplateOptions) error {
+			o.OutHandle, o.ErrHandle = &out, &out
+			return nil
+		}))
+	np.AddHooks(qlog.InfoLevel, qlog.HookFunc(func(e *qlog.Entry) error {
+		seen = append(seen, string(e.Message))
+		e.Fields(qlog.F{Key: "n", Value: func() interface{} { return 2 }},
+			qlog.F{Key: "owner", Value: "bob@example.com"})
+		e.Message = append(e.Message, " by bob@example.com"...)
+		return nil
+	}))
+	np.INFO.Fields(qlog.F{Key: "m", Value: func() interface{} { return 1 }}).Msg("sent to jane@example.com")
+	assert.Equal(t, []string{"sent to [REDACTED]"}, seen)
+	assert.Equal(t, "sent to [REDACTED] by [REDACTED] {\"m\":1,\"n\":2,\"owner\":\"[REDACTED]\"}\n", out.String())
+}
+
+func TestAsyncHook(t *testing.T) {
+	var (
+		out     bytes.Buffer
+		errs    []error
+		mu      sync.Mutex
+		fired   []string
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+	)
+	async, err := qlog.NewAsyncHook(qlog.WithPriority(qlog.HookFunc(func(e *qlog.Entry) error {
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		fired = append(fired, string(e.Message)+" "+e.Data[0].Buffer.String())
+		mu.Unlock()
+		e.Fields(qlog.F{Key: "ignored", Value: true})
+		return nil
+	}), 5), func(o *qlog.AsyncHookOptions) error {
+		o.QueueSize = 1
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	np := hookNotepad(&out, &errs)
+	np.AddHooks(qlog.InfoLevel, async)
+	np.INFO.Fields(qlog.F{Key: "n", Value: 1}).Msg("first")
+	<-started
+	np.INFO.Fields(qlog.F{Key: "n", Value: 2}).Msg("second")
+	np.INFO.Fields(qlog.F{Key: "n", Value: 3}).Msg("dropped")
+	close(release)
+	assert.NoError(t, async.Close())
+
+	assert.Equal(t, []string{"first 1", "second 2"}, fired)
+	assert.Equal(t, uint64(1), async.Dropped())
+	assert.Equal(t, []error{qlog.ErrHookQueueFull}, errs)
+	assert.False(t, strings.Contains(out.String(), "ignored"))
+	assert.Equal(t, 5, async.Priority())
+}
@@ -0,0 +1,302 @@
+package qlog
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// GCPLayout sets Json layout of Google Cloud Logging structured logs
+func GCPLayout(o *JsonOptions) error {
+	o.Layout = NewGCPEncoder()
+	return nil
+}
+
+// ECSLayout sets Json layout of Elastic Common Schema logs
+func ECSLayout(o *JsonOptions) error {
+	o.Layout = NewECSEncoder()
+	return nil
+}
+
+// EMFLayout sets Json layout of CloudWatch Embedded Metric Format with
+// metrics namespace
+func EMFLayout(namespace string) func(*JsonOptions) error {
+	return func(o *JsonOptions) error {
+		enc := NewEMFEncoder()
+		enc.Namespace = namespace
+		o.Layout = enc
+		return nil
+	}
+}
+
+// jsonBody encodes fields and closes entries like JSONEncoder
+type jsonBody struct{}
+
+func (jsonBody) AddField(dst []byte, f *Field) []byte {
+	return appendJsonField(dst, f.Key, f)
+}
+
+func (jsonBody) OpenObject(dst []byte, key string) []byte {
+	return append(appendJsonKey(dst, key), '{')
+}
+
+func (jsonBody) CloseObject(dst []byte) []byte {
+	return append(dst, '}')
+}
+
+func (jsonBody) End(dst []byte, e *Entry) []byte {
+	return append(dst, '}', '\n')
+}
+
+// appendJsonField appends json value of f under key
+func appendJsonField(dst []byte, key string, f *Field) []byte {
+	dst = appendJsonKey(dst, key)
+	if f.Buffer.Len() == 0 {
+		return append(dst, "null"...)
+	}
+	return append(dst, f.Buffer.Bytes()...)
+}
+
+// GCP special keys of structured logs
+const (
+	gcpSourceLocation = "logging.googleapis.com/sourceLocation"
+	gcpTrace          = "logging.googleapis.com/trace"
+	gcpSpanID         = "logging.googleapis.com/spanId"
+	gcpTraceSampled   = "logging.googleapis.com/trace_sampled"
+)
+
+var gcpSeverity = [...]string{
+	DebugLevel:    "DEBUG",
+	InfoLevel:     "INFO",
+	WarnLevel:     "WARNING",
+	ErrorLevel:    "ERROR",
+	CriticalLevel: "CRITICAL",
+	PanicLevel:    "ALERT",
+	FatalLevel:    "EMERGENCY",
+}
+
+// GCPEncoder encodes entries as Google Cloud Logging structured logs:
+// severity, RFC 3339 time, logger name, message and source location of
+// the log call. Trace, span and sampled fields are written under the
+// logging.googleapis.com keys which link entries to Cloud Trace.
+type GCPEncoder struct {
+	jsonBody
+	// ProjectID expands trace ids to projects/ProjectID/traces/id
+	ProjectID string
+	LogName   string
+	// TraceKey, SpanKey and SampledKey are field keys of trace id, span
+	// id and sampled flag
+	TraceKey   string
+	SpanKey    string
+	SampledKey string
+	// SourceLocation adds file, line and function of the log call
+	SourceLocation bool
+}
+
+// NewGCPEncoder returns GCPEncoder with source location
+func NewGCPEncoder() *GCPEncoder {
+	return &GCPEncoder{
+		LogName:        "logger",
+		TraceKey:       "trace_id",
+		SpanKey:        "span_id",
+		SampledKey:     "trace_sampled",
+		SourceLocation: true,
+	}
+}
+
+func (g *GCPEncoder) Begin(dst []byte, e *Entry) []byte {
+	severity := "DEFAULT"
+	if n := int(e.Logger.Level.n); n < len(gcpSeverity) {
+		severity = gcpSeverity[n]
+	}
+	dst = AppendString(appendJsonKey(append(dst, '{'), "severity"), severity)
+	dst = append(appendJsonKey(dst, "time"), '"')
+	dst = append(e.Time.UTC().AppendFormat(dst, time.RFC3339Nano), '"')
+	if g.LogName != "" && len(e.Logger.Notepad.Name) > 0 {
+		dst = appendRawKV(dst, g.LogName, e.Logger.Notepad.Name)
+	}
+	dst = AppendBytes(appendJsonKey(dst, "message"), e.Message)
+	if g.SourceLocation {
+		if f, ok := callerFrame(); ok {
+			dst = AppendString(appendJsonKey(append(appendJsonKey(dst, gcpSourceLocation), '{'), "file"), f.File)
+			dst = strconv.AppendInt(append(appendJsonKey(dst, "line"), '"'), int64(f.Line), 10)
+			dst = AppendString(appendJsonKey(append(dst, '"'), "function"), f.Function)
+			dst = append(dst, '}')
+		}
+	}
+	return dst
+}
+
+func (g *GCPEncoder) AddField(dst []byte, f *Field) []byte {
+	switch {
+	case f.Key == "" || f.Value == nil:
+		// empty keys disable trace fields
+	case f.Key == g.TraceKey:
+		id := fmt.Sprint(f.Value)
+		if g.ProjectID != "" {
+			id = "projects/" + g.ProjectID + "/traces/" + id
+		}
+		return AppendString(appendJsonKey(dst, gcpTrace), id)
+	case f.Key == g.SpanKey:
+		return AppendString(appendJsonKey(dst, gcpSpanID), fmt.Sprint(f.Value))
+	case f.Key == g.SampledKey:
+		return appendJsonField(dst, gcpTraceSampled, f)
+	}
+	return appendJsonField(dst, f.Key, f)
+}
+
+// ECSEncoder encodes entries as Elastic Common Schema logs with dotted
+// keys: @timestamp, log.level, message, ecs.version and log.logger. An
+// error field is written as error.message, error.type and
+// error.stack_trace if its %+v format has more than the message, a
+// stack trace field as error.stack_trace.
+type ECSEncoder struct {
+	jsonBody
+	Version string
+	// ErrorKey is the key of error fields, LogConfig.ErrorFieldName
+	ErrorKey      string
+	StackTraceKey string
+	// Origin adds log.origin with file, line and function of the log call
+	Origin bool
+}
+
+// NewECSEncoder returns ECSEncoder of ECS 1.6.0
+func NewECSEncoder() *ECSEncoder {
+	return &ECSEncoder{
+		Version:       "1.6.0",
+		ErrorKey:      "error",
+		StackTraceKey: "stacktrace",
+	}
+}
+
+func (c *ECSEncoder) Begin(dst []byte, e *Entry) []byte {
+	dst = append(appendJsonKey(append(dst, '{'), "@timestamp"), '"')
+	dst = append(e.Time.UTC().AppendFormat(dst, "2006-01-02T15:04:05.000Z07:00"), '"')
+	dst = appendRawKV(dst, "log.level", e.Logger.Level.ToBytes())
+	dst = AppendBytes(appendJsonKey(dst, "message"), e.Message)
+	dst = AppendString(appendJsonKey(dst, "ecs.version"), c.Version)
+	if len(e.Logger.Notepad.Name) > 0 {
+		dst = appendRawKV(dst, "log.logger", e.Logger.Notepad.Name)
+	}
+	if c.Origin {
+		if f, ok := callerFrame(); ok {
+			dst = append(appendJsonKey(dst, "log.origin"), '{')
+			dst = AppendString(appendJsonKey(append(appendJsonKey(dst, "file"), '{'), "name"), f.File)
+			dst = strconv.AppendInt(appendJsonKey(dst, "line"), int64(f.Line), 10)
+			dst = AppendString(appendJsonKey(append(dst, '}'), "function"), f.Function)
+			dst = append(dst, '}')
+		}
+	}
+	return dst
+}
+
+func (c *ECSEncoder) AddField(dst []byte, f *Field) []byte {
+	switch {
+	case f.Key == "":
+	case f.Key == c.ErrorKey:
+		err, ok := f.Value.(error)
+		if !ok || isNilPtr(err) {
+			break
+		}
+		msg := err.Error()
+		dst = AppendString(appendJsonKey(dst, "error.message"), msg)
+		dst = AppendString(appendJsonKey(dst, "error.type"), fmt.Sprintf("%T", err))
+		if st := fmt.Sprintf("%+v", err); st != msg {
+			dst = AppendString(appendJsonKey(dst, "error.stack_trace"), st)
+		}
+		return dst
+	case f.Key == c.StackTraceKey:
+		return appendJsonField(dst, "error.stack_trace", f)
+	}
+	return appendJsonField(dst, f.Key, f)
+}
+
+// Metric is a field value published as CloudWatch metric by EMF layout.
+// Other outputs encode its value.
+type Metric struct {
+	Value float64
+	// Unit is a CloudWatch unit like Milliseconds or Count, None if empty
+	Unit string
+}
+
+// MarshalJSON returns the metric value
+func (m Metric) MarshalJSON() ([]byte, error) {
+	return AppendFloat64(nil, m.Value), nil
+}
+
+// EMFEncoder encodes entries as json lines and adds CloudWatch Embedded
+// Metric Format metadata to entries with Metric fields. Dimensions are
+// sets of keys of string fields.
+type EMFEncoder struct {
+	jsonBody
+	Namespace   string
+	Dimensions  [][]string
+	LogName     string
+	LevelName   string
+	MessageName string
+}
+
+// NewEMFEncoder returns EMFEncoder with logger name dimension
+func NewEMFEncoder() *EMFEncoder {
+	return &EMFEncoder{
+		Namespace:   "qlog",
+		Dimensions:  [][]string{{"logger"}},
+		LogName:     "logger",
+		LevelName:   "level",
+		MessageName: "message",
+	}
+}
+
+func (m *EMFEncoder) Begin(dst []byte, e *Entry) []byte {
+	dst = append(dst, '{')
+	if m.LogName != "" {
+		dst = appendRawKV(dst, m.LogName, e.Logger.Notepad.Name)
+	}
+	if m.LevelName != "" {
+		dst = appendRawKV(dst, m.LevelName, e.Logger.Level.ToBytes())
+	}
+	if m.MessageName != "" {
+		dst = AppendBytes(appendJsonKey(dst, m.MessageName), e.Message)
+	}
+	return dst
+}
+
+func (m *EMFEncoder) End(dst []byte, e *Entry) []byte {
+	n := 0
+	for _, fields := range [3][]Field{e.Logger.Notepad.Context, e.Logger.Context, e.Data} {
+		for i := range fields {
+			metric, ok := fields[i].Value.(Metric)
+			if !ok || math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+				continue
+			}
+			if n == 0 {
+				dst = append(appendJsonKey(dst, "_aws"), '{')
+				dst = strconv.AppendInt(appendJsonKey(dst, "Timestamp"), e.Time.UnixNano()/int64(time.Millisecond), 10)
+				dst = append(appendJsonKey(dst, "CloudWatchMetrics"), `[{`...)
+				dst = AppendString(appendJsonKey(dst, "Namespace"), m.Namespace)
+				dst = append(appendJsonKey(dst, "Dimensions"), '[')
+				for j, set := range m.Dimensions {
+					if j > 0 {
+						dst = append(dst, ',')
+					}
+					dst = AppendStrings(dst, set)
+				}
+				dst = append(append(dst, ']'), `,"Metrics":[`...)
+			} else {
+				dst = append(dst, ',')
+			}
+			n++
+			unit := metric.Unit
+			if unit == "" {
+				unit = "None"
+			}
+			dst = AppendString(appendJsonKey(append(dst, '{'), "Name"), fields[i].Key)
+			dst = append(AppendString(appendJsonKey(dst, "Unit"), unit), '}')
+		}
+	}
+	if n > 0 {
+		dst = append(dst, "]}]}"...)
+	}
+	return append(dst, '}', '\n')
+}
@@ -0,0 +1,102 @@
+package qlog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+// stackError formats with stack trace for %+v like pkg/errors
+type stackError struct{ msg string }
+
+func (e stackError) Error() string { return e.msg }
+
+func (e stackError) Format(s fmt.State, verb rune) {
+	io.WriteString(s, e.msg)
+	if s.Flag('+') {
+		io.WriteString(s, "\nmain.run\n\tmain.go:10")
+	}
+}
+
+func layoutNotepad(out io.Writer, layout qlog.Encoder) *qlog.Notepad {
+	now := time.Date(2020, 1, 2, 10, 0, 0, 123456789, time.UTC)
+	return qlog.New("api", qlog.DebugLevel, qlog.Clock(func() time.Time { return now })).SetOutput(
+		qlog.MustJson(func(o *qlog.JsonOptions) error {
+			o.OutHandle, o.ErrHandle, o.OutLevel = out, out, qlog.DebugLevel
+			o.Layout = layout
+			return nil
+		}))
+}
+
+func TestGCPLayout(t *testing.T) {
+	out := &bytes.Buffer{}
+	enc := qlog.NewGCPEncoder()
+	enc.ProjectID, enc.SourceLocation = "proj", false
+	np := layoutNotepad(out, enc)
+	np.WARN.Fields(qlog.F{Key: "trace_id", Value: "abc"}, qlog.F{Key: "span_id", Value: "01"},
+		qlog.F{Key: "trace_sampled", Value: true}, qlog.F{Key: "n", Value: 1}).Msg("slow")
+	np.CRITICAL.Msg("down")
+	assert.Equal(t, `{"severity":"WARNING","time":"2020-01-02T10:00:00.123456789Z","logger":"api","message":"slow",`+
+		`"logging.googleapis.com/trace":"projects/proj/traces/abc","logging.googleapis.com/spanId":"01",`+
+		`"logging.googleapis.com/trace_sampled":true,"n":1}`+"\n"+
+		`{"severity":"CRITICAL","time":"2020-01-02T10:00:00.123456789Z","logger":"api","message":"down"}`+"\n",
+		out.String())
+
+	out.Reset()
+	np = qlog.New("api", qlog.InfoLevel).SetOutput(qlog.MustJson(qlog.GCPLayout, func(o *qlog.JsonOptions) error {
+		o.OutHandle = out
+		return nil
+	}))
+	_, file, line, _ := runtime.Caller(0)
+	np.Info("here")
+	var got struct {
+		Location map[string]string `json:"logging.googleapis.com/sourceLocation"`
+	}
+	if !assert.NoError(t, json.Unmarshal(out.Bytes(), &got)) {
+		return
+	}
+	assert.Equal(t, map[string]string{"file": file, "line": fmt.Sprint(line + 1),
+		"function": "github.com/karantin2020/qlog_test.TestGCPLayout"}, got.Location)
+}
+
+func TestECSLayout(t *testing.T) {
+	out := &bytes.Buffer{}
+	np := layoutNotepad(out, qlog.NewECSEncoder())
+	np.ERROR.Fields(qlog.F{Key: "error", Value: stackError{"boom"}}, qlog.F{Key: "user", Value: "u1"}).Msg("failed")
+	np.INFO.Fields(qlog.F{Key: "error", Value: errors.New("plain")}, qlog.F{Key: "stacktrace", Value: "a\nb"}).Msg("ok")
+	assert.Equal(t, `{"@timestamp":"2020-01-02T10:00:00.123Z","log.level":"error","message":"failed",`+
+		`"ecs.version":"1.6.0","log.logger":"api","error.message":"boom","error.type":"qlog_test.stackError",`+
+		`"error.stack_trace":"boom\nmain.run\n\tmain.go:10","user":"u1"}`+"\n"+
+		`{"@timestamp":"2020-01-02T10:00:00.123Z","log.level":"info","message":"ok",`+
+		`"ecs.version":"1.6.0","log.logger":"api","error.message":"plain","error.type":"*errors.errorString",`+
+		`"error.stack_trace":"a\nb"}`+"\n", out.String())
+}
+
+func TestEMFLayout(t *testing.T) {
+	out := &bytes.Buffer{}
+	enc := qlog.NewEMFEncoder()
+	enc.Namespace, enc.Dimensions = "shop", [][]string{{"logger"}, {"logger", "route"}}
+	np := layoutNotepad(out, enc).WithFields(qlog.F{Key: "route", Value: "/cart"})
+	np.INFO.Fields(qlog.F{Key: "latency", Value: qlog.Metric{Value: 12.5, Unit: "Milliseconds"}},
+		qlog.F{Key: "items", Value: qlog.Metric{Value: 3}}).Msg("done")
+	np.INFO.Msg("no metrics")
+	lines := strings.Split(out.String(), "\n")
+	if !assert.Len(t, lines, 3) {
+		return
+	}
+	assert.Equal(t, `{"logger":"api","level":"info","message":"done","route":"/cart","latency":12.5,"items":3,`+
+		`"_aws":{"Timestamp":1577959200123,"CloudWatchMetrics":[{"Namespace":"shop",`+
+		`"Dimensions":[["logger"],["logger","route"]],`+
+		`"Metrics":[{"Name":"latency","Unit":"Milliseconds"},{"Name":"items","Unit":"None"}]}]}}`, lines[0])
+	assert.Equal(t, `{"logger":"api","level":"info","message":"no metrics","route":"/cart"}`, lines[1])
+	assert.True(t, json.Valid([]byte(lines[0])))
+}
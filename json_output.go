@@ -2,7 +2,6 @@ package qlog
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"os"
 )
@@ -18,6 +17,8 @@ type JsonOptions struct {
 	MessageName   string
 	ErrorName     string
 	FieldsName    string
+	// Layout replaces JSONEncoder, see GCPLayout, ECSLayout and EMFLayout
+	Layout Encoder
 }
 
 var (
@@ -29,59 +30,38 @@ var (
 	comma       = []byte{','}
 )
 
-func Json(opts ...func(*JsonOptions) error) func(np *Notepad) {
+// Json returns notepad option adding outputs of JSONEncoder, or error
+// of an option or invalid levels
+func Json(opts ...func(*JsonOptions) error) (func(np *Notepad), error) {
 	options := defaultJsonOptions()
 	for _, fn := range opts {
-		_ = fn(options)
-	}
-	return func(np *Notepad) {
-		if options.OutLevel > _maxLevel || options.OutLevel < _minLevel || options.OutLevel < np.Level.n {
-			panic("OutLevel is out of range")
-		}
-		if options.ErrLevel > _maxLevel || options.ErrLevel < _minLevel || options.ErrLevel < np.Level.n {
-			panic("ErrLevel is out of range")
-		}
-		if options.OutLevel > options.ErrLevel {
-			panic("OutLevel is higher than errLevel")
-		}
-		jsonOut := func(wio io.Writer, topts *JsonOptions) Output {
-			return func(e *Entry) {
-				bb := bufferPool.Get().(*bytes.Buffer)
-				bb.Reset()
-				bb.Write(openBrac)
-				writeField(bb, Str2Bytes(topts.LogName), e.Logger.Notepad.Name)
-				// buf := newBuffer()
-				// buf.fb = strconv.AppendInt(buf.fb, e.Time.UnixNano(), 10)
-				writeFieldComma(bb, Str2Bytes(topts.TimestampName), e.bufferTime)
-				// buf.free()
-				writeFieldComma(bb, Str2Bytes(topts.LevelName), e.Logger.Level.ToBytes())
-				writeFieldComma(bb, Str2Bytes(topts.MessageName), e.Message)
-				// if e.ErrorFld != nil {
-				// 	writeField(bb, Str2Bytes(topts.ErrorName), Str2Bytes(e.ErrorFld.Error()))
-				// }
-
-				writeData(bb, e.Logger.Notepad.Context)
-				writeData(bb, e.Logger.Context)
-				writeData(bb, e.Data)
-
-				bb.Write(closeBrac)
-				if _, err := wio.Write(bb.Bytes()); err != nil {
-					panic(fmt.Sprintf("qlog json logging error: %s", err))
-				}
-				bufferPool.Put(bb)
-			}
-		}
-		for tlv, logger := range np.Loggers {
-			level := uint8(tlv)
-			switch {
-			case level >= options.ErrLevel:
-				(*logger).Output = append((*logger).Output, jsonOut(options.ErrHandle, options))
-
-			case level >= options.OutLevel:
-				(*logger).Output = append((*logger).Output, jsonOut(options.OutHandle, options))
-			}
+		if err := fn(options); err != nil {
+			return nil, err
 		}
 	}
+	var enc Encoder = &JSONEncoder{
+		LogName:       options.LogName,
+		TimestampName: options.TimestampName,
+		LevelName:     options.LevelName,
+		MessageName:   options.MessageName,
+	}
+	if options.Layout != nil {
+		enc = options.Layout
+	}
+	return Encode(enc, func(o *OutputOptions) error {
+		o.OutHandle, o.ErrHandle = AddSync(options.OutHandle), AddSync(options.ErrHandle)
+		o.OutLevel, o.ErrLevel = options.OutLevel, options.ErrLevel
+		return nil
+	})
+}
+
+// MustJson is like Json but panics if an option fails
+func MustJson(opts ...func(*JsonOptions) error) func(np *Notepad) {
+	fn, err := Json(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
 }
 
 func defaultJsonOptions() *JsonOptions {
@@ -99,6 +79,99 @@ func defaultJsonOptions() *JsonOptions {
 	}
 }
 
+// JSONEncoder encodes entries as json lines. Name, time, level and
+// message keys with empty names are omitted.
+type JSONEncoder struct {
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+}
+
+// NewJSONEncoder returns JSONEncoder with the Json output key names
+func NewJSONEncoder() *JSONEncoder {
+	o := defaultJsonOptions()
+	return &JSONEncoder{
+		LogName:       o.LogName,
+		TimestampName: o.TimestampName,
+		LevelName:     o.LevelName,
+		MessageName:   o.MessageName,
+	}
+}
+
+func (j *JSONEncoder) Begin(dst []byte, e *Entry) []byte {
+	dst = append(dst, '{')
+	if j.LogName != "" {
+		dst = appendRawKV(dst, j.LogName, e.Logger.Notepad.Name)
+	}
+	if j.TimestampName != "" {
+		dst = appendRawKV(dst, j.TimestampName, e.bufferTime)
+	}
+	if j.LevelName != "" {
+		dst = appendRawKV(dst, j.LevelName, e.Logger.Level.ToBytes())
+	}
+	if j.MessageName != "" {
+		dst = AppendBytes(appendJsonKey(dst, j.MessageName), e.Message)
+	}
+	return dst
+}
+
+func (j *JSONEncoder) AddField(dst []byte, f *Field) []byte {
+	dst = appendJsonKey(dst, f.Key)
+	if f.Buffer.Len() == 0 {
+		return append(dst, "null"...)
+	}
+	return append(dst, f.Buffer.Bytes()...)
+}
+
+func (j *JSONEncoder) OpenObject(dst []byte, key string) []byte {
+	return append(appendJsonKey(dst, key), '{')
+}
+
+func (j *JSONEncoder) CloseObject(dst []byte) []byte {
+	return append(dst, '}')
+}
+
+func (j *JSONEncoder) End(dst []byte, e *Entry) []byte {
+	return append(dst, '}', '\n')
+}
+
+// appendJsonKey appends object key with preceding comma unless it is
+// the first key of the object
+func appendJsonKey(dst []byte, key string) []byte {
+	return appendKeySep(dst, key, ':')
+}
+
+func appendKeySep(dst []byte, key string, sep byte) []byte {
+	if n := len(dst); n > 0 && dst[n-1] != '{' {
+		dst = append(dst, ',')
+	}
+	return append(AppendString(dst, key), sep)
+}
+
+// appendRawKV appends key with value quoted as is
+func appendRawKV(dst []byte, key string, value []byte) []byte {
+	dst = append(appendJsonKey(dst, key), '"')
+	return append(append(dst, value...), '"')
+}
+
+// writeJsonEntry writes e as json object without closing bracket
+func writeJsonEntry(bb *bytes.Buffer, e *Entry, logName, timestampName, levelName, messageName string) {
+	enc := JSONEncoder{
+		LogName:       logName,
+		TimestampName: timestampName,
+		LevelName:     levelName,
+		MessageName:   messageName,
+	}
+	buf := bytesPool.Get().(*[]byte)
+	*buf = enc.Begin((*buf)[:0], e)
+	*buf = encodeFields(&enc, *buf, e.Logger.Notepad.Context)
+	*buf = encodeFields(&enc, *buf, e.Logger.Context)
+	*buf = encodeFields(&enc, *buf, e.Data)
+	bb.Write(*buf)
+	bytesPool.Put(buf)
+}
+
 func writeField(w io.Writer, name, content []byte) {
 	_, _ = w.Write(quotes)
 	_, _ = w.Write(name)
@@ -112,12 +185,3 @@ func writeFieldComma(w io.Writer, name, content []byte) {
 	_, _ = w.Write(comma)
 	writeField(w, name, content)
 }
-
-func writeData(w io.Writer, data []Field) {
-	for i, _ := range data {
-		_, _ = w.Write(fieldsDelim)
-		_, _ = w.Write(Str2Bytes(data[i].Key))
-		_, _ = w.Write(kvDelim)
-		_, _ = w.Write(data[i].Buffer.Bytes())
-	}
-}
@@ -0,0 +1,33 @@
+package qlog_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJson_Golden(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Millisecond)
+	np := qlog.New("golden", qlog.InfoLevel, qlog.Clock(clock.Now)).
+		SetOutput(qlog.MustJson(func(o *qlog.JsonOptions) error {
+			o.OutHandle = out
+			o.ErrHandle = out
+			return nil
+		}))
+	np.AddField(qlog.F{Key: "app", Value: "api"})
+	np.Info("first")
+	e := np.WARN.Fields(qlog.F{Key: "dur", Value: 1500 * time.Millisecond})
+	e.Timestamp()
+	e.Msg("second")
+	np.Error("third")
+
+	assert.Equal(t, `{"n":"golden","t":"2018-01-02T03:04:05.000Z","l":"info","m":"first","app":"api"}
+{"n":"golden","t":"2018-01-02T03:04:05.001Z","l":"warn","m":"second","app":"api","dur":1500,"time":"2018-01-02T03:04:05.002Z"}
+{"n":"golden","t":"2018-01-02T03:04:05.003Z","l":"error","m":"third","app":"api"}
+`, out.String())
+}
@@ -0,0 +1,179 @@
+package qlog
+
+import (
+	"io"
+	"os"
+	"strconv"
+)
+
+// LEEFOptions configures IBM QRadar LEEF output
+type LEEFOptions struct {
+	ErrHandle io.Writer
+	OutHandle io.Writer
+	ErrLevel  uint8
+	OutLevel  uint8
+	// Vendor, Product and Version are device header fields
+	Vendor  string
+	Product string
+	Version string
+	// LEEFVersion is "1.0" or "2.0"
+	LEEFVersion string
+	// Delimiter separates attributes of LEEF 2.0, tab by default
+	Delimiter byte
+	// EventIDKey is the key of the field used as Event ID, the level name
+	// is used if the entry has no such field
+	EventIDKey string
+	// ExtensionKeys maps field keys to LEEF attribute keys
+	ExtensionKeys map[string]string
+}
+
+func defaultLEEFOptions() *LEEFOptions {
+	return &LEEFOptions{
+		ErrHandle:   os.Stderr,
+		OutHandle:   os.Stdout,
+		ErrLevel:    ErrorLevel,
+		OutLevel:    InfoLevel,
+		Vendor:      "qlog",
+		Product:     "qlog",
+		Version:     "1.0",
+		LEEFVersion: "1.0",
+		Delimiter:   '\t',
+		EventIDKey:  "event_id",
+	}
+}
+
+// LEEF returns notepad option adding outputs of LEEFEncoder, or error
+// of an option or invalid levels
+func LEEF(opts ...func(*LEEFOptions) error) (func(np *Notepad), error) {
+	options := defaultLEEFOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	enc := NewLEEFEncoder()
+	enc.Vendor, enc.Product, enc.Version = options.Vendor, options.Product, options.Version
+	enc.LEEFVersion, enc.Delimiter = options.LEEFVersion, options.Delimiter
+	enc.EventIDKey, enc.ExtensionKeys = options.EventIDKey, options.ExtensionKeys
+	return Encode(enc, func(o *OutputOptions) error {
+		o.OutHandle, o.ErrHandle = AddSync(options.OutHandle), AddSync(options.ErrHandle)
+		o.OutLevel, o.ErrLevel = options.OutLevel, options.ErrLevel
+		return nil
+	})
+}
+
+// MustLEEF is like LEEF but panics if an option fails
+func MustLEEF(opts ...func(*LEEFOptions) error) func(np *Notepad) {
+	fn, err := LEEF(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// LEEFEncoder encodes entries as LEEF lines
+//
+//	LEEF:1.0|Vendor|Product|Version|EventID|devTime=ms<tab>sev=severity<tab>cat=name<tab>msg=message...
+//
+// LEEF 2.0 lines have the delimiter after EventID. Fields are attributes
+// with keys mapped by ExtensionKeys, other keys are kept without
+// characters which are not letters or digits. Keys of nested objects are
+// prefixed by the object keys joined by underscores, e.g. parent_id. Line
+// breaks, delimiters and zero bytes in values are replaced by spaces.
+type LEEFEncoder struct {
+	Vendor        string
+	Product       string
+	Version       string
+	LEEFVersion   string
+	Delimiter     byte
+	EventIDKey    string
+	LogName       string
+	MessageName   string
+	ExtensionKeys map[string]string
+	// Severity is LEEF sev of levels
+	Severity [7]int
+}
+
+// NewLEEFEncoder returns LEEFEncoder with the LEEF output defaults
+func NewLEEFEncoder() *LEEFEncoder {
+	o := defaultLEEFOptions()
+	return &LEEFEncoder{
+		Vendor:      o.Vendor,
+		Product:     o.Product,
+		Version:     o.Version,
+		LEEFVersion: o.LEEFVersion,
+		Delimiter:   o.Delimiter,
+		EventIDKey:  o.EventIDKey,
+		LogName:     "cat",
+		MessageName: "msg",
+		Severity:    securitySeverity,
+	}
+}
+
+func (l *LEEFEncoder) delimiter() byte {
+	if l.LEEFVersion == "1.0" || l.Delimiter == 0 {
+		return '\t'
+	}
+	return l.Delimiter
+}
+
+func (l *LEEFEncoder) Begin(dst []byte, e *Entry) []byte {
+	dst = append(append(append(dst, "LEEF:"...), l.LEEFVersion...), '|')
+	for _, s := range [3]string{l.Vendor, l.Product, l.Version} {
+		dst = append(appendCEFHeader(dst, s), '|')
+	}
+	dst = append(appendEventID(dst, e, l.EventIDKey, appendCEFHeader), '|')
+	delim := l.delimiter()
+	if l.LEEFVersion != "1.0" {
+		if delim < 0x20 || delim >= 0x7f || delim == '|' {
+			dst = append(dst, 'x', hexDigits[delim>>4], hexDigits[delim&0xf])
+		} else {
+			dst = append(dst, delim)
+		}
+		dst = append(dst, '|')
+	}
+	dst = strconv.AppendInt(append(dst, "devTime="...), e.Time.UnixNano()/1e6, 10)
+	dst = strconv.AppendInt(append(append(dst, delim), "sev="...), int64(l.Severity[e.Logger.Level.n]), 10)
+	if l.LogName != "" && len(e.Logger.Notepad.Name) > 0 {
+		dst = l.appendValue(append(append(append(dst, delim), l.LogName...), '='), Bytes2Str(e.Logger.Notepad.Name))
+	}
+	if l.MessageName != "" {
+		dst = l.appendValue(append(append(append(dst, delim), l.MessageName...), '='), Bytes2Str(e.Message))
+	}
+	return dst
+}
+
+// appendValue appends value with line breaks, delimiters and zero bytes
+// replaced by spaces
+func (l *LEEFEncoder) appendValue(dst []byte, s string) []byte {
+	delim := l.delimiter()
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n', '\r', '\t', delim, securityObjectMark:
+			dst = append(dst, ' ')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+func (l *LEEFEncoder) AddField(dst []byte, f *Field) []byte {
+	key := securityKey(securityPrefix(dst), f.Key, l.EventIDKey, l.ExtensionKeys)
+	if key == "" {
+		return dst
+	}
+	return appendEscapedText(append(append(append(dst, l.delimiter()), key...), '='), f, l.appendValue)
+}
+
+func (l *LEEFEncoder) OpenObject(dst []byte, key string) []byte {
+	return openSecurityObject(dst, key)
+}
+
+func (l *LEEFEncoder) CloseObject(dst []byte) []byte {
+	return closeSecurityObject(dst)
+}
+
+func (l *LEEFEncoder) End(dst []byte, e *Entry) []byte {
+	return append(dst, '\n')
+}
@@ -2,6 +2,8 @@ package qlog
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // A Level is a logging priority. Higher levels are more important.
@@ -27,7 +29,8 @@ const (
 	CriticalLevel
 	// PanicLevel logs a message, then panics.
 	PanicLevel
-	// FatalLevel logs a message, then calls os.Exit(1).
+	// FatalLevel logs a message, runs fatal hooks, then calls os.Exit(1)
+	// (LogConfig.ExitFunc).
 	FatalLevel
 
 	_minLevel = DebugLevel
@@ -52,12 +55,42 @@ var (
 	_FATAL    = []byte("FATAL")
 )
 
+// ParseLevel parses level name (case-insensitive) or number
+func ParseLevel(s string) (uint8, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	case "critical":
+		return CriticalLevel, nil
+	case "panic":
+		return PanicLevel, nil
+	case "fatal":
+		return FatalLevel, nil
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 8)
+	if err != nil || uint8(n) > _maxLevel {
+		return 0, fmt.Errorf("qlog: unknown level %q", s)
+	}
+	return uint8(n), nil
+}
+
 func InitLevel(lvl uint8) Level {
 	l := Level{n: lvl}
 	l.b = l.ToBytes()
 	return l
 }
 
+// Uint8 returns numeric representation of the log level.
+func (l Level) Uint8() uint8 {
+	return l.n
+}
+
 // String returns a lower-case ASCII representation of the log level.
 func (l Level) String() string {
 	switch l.n {
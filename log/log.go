@@ -20,7 +20,7 @@ var (
 
 func init() {
 	defaultNotepad = qlog.New("", qlog.InfoLevel).
-		SetOutput(qlog.Template("${time}\t${LEVEL}\t${message}\t${fields}\n"))
+		SetOutput(qlog.MustTemplate("${time}\t${LEVEL}\t${message}\t${fields}\n"))
 	reloadDefaultNotepad()
 }
 
@@ -72,8 +72,6 @@ func reloadDefaultNotepad() {
 
 func SetLevel(lvl uint8) {
 	defaultNotepad.SetLevel(lvl)
-	defaultNotepad.Level = qlog.InitLevel(lvl)
-	reloadDefaultNotepad()
 }
 func WithFields(flds ...qlog.F) *qlog.Notepad {
 	return defaultNotepad.WithFields(flds...)
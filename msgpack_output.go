@@ -0,0 +1,552 @@
+package qlog
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MessagePackOptions configures MessagePack output
+type MessagePackOptions struct {
+	ErrHandle io.Writer
+	OutHandle io.Writer
+	ErrLevel  uint8
+	OutLevel  uint8
+	// Tag is the Fluentd tag, the notepad name if empty
+	Tag           string
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+}
+
+func defaultMessagePackOptions() *MessagePackOptions {
+	return &MessagePackOptions{
+		ErrHandle:   os.Stderr,
+		OutHandle:   os.Stdout,
+		ErrLevel:    ErrorLevel,
+		OutLevel:    InfoLevel,
+		LogName:     "name",
+		LevelName:   "level",
+		MessageName: "message",
+	}
+}
+
+// MessagePack returns notepad option adding outputs of
+// MessagePackEncoder, or error of an option or invalid levels
+func MessagePack(opts ...func(*MessagePackOptions) error) (func(np *Notepad), error) {
+	options := defaultMessagePackOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	enc := &MessagePackEncoder{
+		Tag:           options.Tag,
+		LogName:       options.LogName,
+		TimestampName: options.TimestampName,
+		LevelName:     options.LevelName,
+		MessageName:   options.MessageName,
+	}
+	return Encode(enc, func(o *OutputOptions) error {
+		o.OutHandle, o.ErrHandle = AddSync(options.OutHandle), AddSync(options.ErrHandle)
+		o.OutLevel, o.ErrLevel = options.OutLevel, options.ErrLevel
+		return nil
+	})
+}
+
+// MustMessagePack is like MessagePack but panics if an option fails
+func MustMessagePack(opts ...func(*MessagePackOptions) error) func(np *Notepad) {
+	fn, err := MessagePack(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// MessagePackEncoder encodes entries as Fluentd Forward protocol
+// messages [tag, time, record] in MessagePack. Time is EventTime
+// extension with nanoseconds. The record has name, level and message
+// keys, keys with empty names are omitted, and fields. Times, durations
+// and values without native encoding are encoded like their json field
+// value.
+type MessagePackEncoder struct {
+	Tag           string
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+}
+
+// NewMessagePackEncoder returns MessagePackEncoder with the MessagePack
+// output key names
+func NewMessagePackEncoder() *MessagePackEncoder {
+	o := defaultMessagePackOptions()
+	return &MessagePackEncoder{
+		LogName:     o.LogName,
+		LevelName:   o.LevelName,
+		MessageName: o.MessageName,
+	}
+}
+
+func (m *MessagePackEncoder) Begin(dst []byte, e *Entry) []byte {
+	dst = append(dst, msgpackFixArray|3)
+	if m.Tag != "" {
+		dst = appendMsgpackString(dst, m.Tag)
+	} else {
+		dst = appendMsgpackBytes(dst, e.Logger.Notepad.Name)
+	}
+	return m.appendRecord(appendEventTime(dst, e.Time), e)
+}
+
+// appendRecord opens record map with header fields
+func (m *MessagePackEncoder) appendRecord(dst []byte, e *Entry) []byte {
+	dst = appendMsgpackOpenMap(dst)
+	if m.LogName != "" {
+		dst = appendMsgpackBytes(appendMsgpackString(dst, m.LogName), e.Logger.Notepad.Name)
+	}
+	if m.TimestampName != "" {
+		dst = appendMsgpackBytes(appendMsgpackString(dst, m.TimestampName), e.bufferTime)
+	}
+	if m.LevelName != "" {
+		dst = appendMsgpackBytes(appendMsgpackString(dst, m.LevelName), e.Logger.Level.ToBytes())
+	}
+	if m.MessageName != "" {
+		dst = appendMsgpackBytes(appendMsgpackString(dst, m.MessageName), e.Message)
+	}
+	return dst
+}
+
+func (m *MessagePackEncoder) AddField(dst []byte, f *Field) []byte {
+	dst = appendMsgpackString(dst, f.Key)
+	if f.Value != nil || f.Buffer.Len() == 0 {
+		if b, ok := appendMsgpackValue(dst, f.Value, 0); ok {
+			return b
+		}
+	}
+	return appendMsgpackJSON(dst, f.Buffer.Bytes())
+}
+
+func (m *MessagePackEncoder) OpenObject(dst []byte, key string) []byte {
+	return appendMsgpackOpenMap(appendMsgpackString(dst, key))
+}
+
+// CloseObject counts pairs of the innermost open map, maps of the entry
+// are found by walking it from the record
+func (m *MessagePackEncoder) CloseObject(dst []byte) []byte {
+	closeMsgpackMap(dst, msgpackRecordStart(dst))
+	return dst
+}
+
+func (m *MessagePackEncoder) End(dst []byte, e *Entry) []byte {
+	closeMsgpackMap(dst, msgpackRecordStart(dst))
+	return dst
+}
+
+// msgpackRecordStart returns offset of record of [tag, time, record] or
+// [time, record]
+func msgpackRecordStart(b []byte) int {
+	i := 1
+	if b[0] == msgpackFixArray|3 {
+		i = skipMsgpack(b, i)
+	}
+	return skipMsgpack(b, i)
+}
+
+const (
+	msgpackFixMap   = 0x80
+	msgpackFixArray = 0x90
+	msgpackFixStr   = 0xa0
+	msgpackNil      = 0xc0
+	msgpackFalse    = 0xc2
+	msgpackTrue     = 0xc3
+	msgpackMap32    = 0xdf
+	msgpackArray32  = 0xdd
+)
+
+func appendMsgpackUint(dst []byte, n uint64) []byte {
+	switch {
+	case n < 128:
+		return append(dst, byte(n))
+	case n <= math.MaxUint8:
+		return append(dst, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return append(dst, 0xcd, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(dst, 0xce, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(dst, 0xcf, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackInt(dst []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return appendMsgpackUint(dst, uint64(n))
+	case n >= -32:
+		return append(dst, byte(n))
+	case n >= math.MinInt8:
+		return append(dst, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return append(dst, 0xd1, byte(n>>8), byte(n))
+	case n >= math.MinInt32:
+		return append(dst, 0xd2, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(dst, 0xd3, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackFloat64(dst []byte, f float64) []byte {
+	n := math.Float64bits(f)
+	return append(dst, 0xcb, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackFloat32(dst []byte, f float32) []byte {
+	n := math.Float32bits(f)
+	return append(dst, 0xca, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackStrHead(dst []byte, n int) []byte {
+	switch {
+	case n < 32:
+		return append(dst, msgpackFixStr|byte(n))
+	case n <= math.MaxUint8:
+		return append(dst, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		return append(dst, 0xda, byte(n>>8), byte(n))
+	}
+	return append(dst, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackString(dst []byte, s string) []byte {
+	return append(appendMsgpackStrHead(dst, len(s)), s...)
+}
+
+func appendMsgpackBytes(dst []byte, s []byte) []byte {
+	return append(appendMsgpackStrHead(dst, len(s)), s...)
+}
+
+func appendMsgpackBin(dst []byte, b []byte) []byte {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		dst = append(dst, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		dst = append(dst, 0xc5, byte(n>>8), byte(n))
+	default:
+		dst = append(dst, 0xc6, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(dst, b...)
+}
+
+func appendMsgpackArrayHead(dst []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(dst, msgpackFixArray|byte(n))
+	case n <= math.MaxUint16:
+		return append(dst, 0xdc, byte(n>>8), byte(n))
+	}
+	return append(dst, msgpackArray32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackMapHead(dst []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(dst, msgpackFixMap|byte(n))
+	case n <= math.MaxUint16:
+		return append(dst, 0xde, byte(n>>8), byte(n))
+	}
+	return append(dst, msgpackMap32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// appendMsgpackOpenMap appends map32 placeholder of an open map
+func appendMsgpackOpenMap(dst []byte) []byte {
+	return append(dst, msgpackMap32, 0xff, 0xff, 0xff, 0xff)
+}
+
+// appendEventTime appends Fluentd EventTime extension
+func appendEventTime(dst []byte, t time.Time) []byte {
+	sec, nsec := uint32(t.Unix()), uint32(t.Nanosecond())
+	return append(dst, 0xd7, 0x00, byte(sec>>24), byte(sec>>16), byte(sec>>8), byte(sec),
+		byte(nsec>>24), byte(nsec>>16), byte(nsec>>8), byte(nsec))
+}
+
+// isMsgpackOpen reports if map32 at i is an open map placeholder
+func isMsgpackOpen(b []byte, i int) bool {
+	return i+5 <= len(b) && b[i] == msgpackMap32 &&
+		b[i+1]&b[i+2]&b[i+3]&b[i+4] == 0xff
+}
+
+// closeMsgpackMap sets count of the innermost open map of the open map
+// at i. Pairs of the innermost open map continue to the end of b.
+func closeMsgpackMap(b []byte, i int) {
+	n := 0
+	for j := i + 5; j < len(b); n++ {
+		j = skipMsgpack(b, j)
+		if isMsgpackOpen(b, j) {
+			closeMsgpackMap(b, j)
+			return
+		}
+		j = skipMsgpack(b, j)
+	}
+	b[i+1], b[i+2], b[i+3], b[i+4] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+}
+
+// skipMsgpack returns offset after the item at i
+func skipMsgpack(b []byte, i int) int {
+	c := b[i]
+	be := func(j, n int) int {
+		v := 0
+		for _, x := range b[j : j+n] {
+			v = v<<8 | int(x)
+		}
+		return v
+	}
+	switch {
+	case c < 0x80 || c >= 0xe0 || c == msgpackNil || c == msgpackFalse || c == msgpackTrue:
+		return i + 1
+	case c < 0x90:
+		return skipMsgpackItems(b, i+1, 2*int(c&0x0f))
+	case c < 0xa0:
+		return skipMsgpackItems(b, i+1, int(c&0x0f))
+	case c < 0xc0:
+		return i + 1 + int(c&0x1f)
+	}
+	switch c {
+	case 0xc4, 0xd9:
+		return i + 2 + be(i+1, 1)
+	case 0xc5, 0xda:
+		return i + 3 + be(i+1, 2)
+	case 0xc6, 0xdb:
+		return i + 5 + be(i+1, 4)
+	case 0xc7:
+		return i + 3 + be(i+1, 1)
+	case 0xc8:
+		return i + 4 + be(i+1, 2)
+	case 0xc9:
+		return i + 6 + be(i+1, 4)
+	case 0xca, 0xce, 0xd2:
+		return i + 5
+	case 0xcb, 0xcf, 0xd3:
+		return i + 9
+	case 0xcc, 0xd0:
+		return i + 2
+	case 0xcd, 0xd1:
+		return i + 3
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return i + 2 + 1<<(c-0xd4)
+	case 0xdc:
+		return skipMsgpackItems(b, i+3, be(i+1, 2))
+	case 0xdd:
+		return skipMsgpackItems(b, i+5, be(i+1, 4))
+	case 0xde:
+		return skipMsgpackItems(b, i+3, 2*be(i+1, 2))
+	case msgpackMap32:
+		if isMsgpackOpen(b, i) {
+			return len(b)
+		}
+		return skipMsgpackItems(b, i+5, 2*be(i+1, 4))
+	}
+	return i + 1
+}
+
+func skipMsgpackItems(b []byte, i, n int) int {
+	for ; n > 0; n-- {
+		i = skipMsgpack(b, i)
+	}
+	return i
+}
+
+// appendMsgpackValue appends MessagePack encoding of v. It returns false
+// if v is encoded like its json value.
+func appendMsgpackValue(dst []byte, v interface{}, depth int) ([]byte, bool) {
+	switch val := v.(type) {
+	case string:
+		return appendMsgpackString(dst, val), true
+	case []byte:
+		return appendMsgpackBin(dst, val), true
+	case error:
+		return appendMsgpackString(dst, val.Error()), true
+	case bool:
+		if val {
+			return append(dst, msgpackTrue), true
+		}
+		return append(dst, msgpackFalse), true
+	case int:
+		return appendMsgpackInt(dst, int64(val)), true
+	case int8:
+		return appendMsgpackInt(dst, int64(val)), true
+	case int16:
+		return appendMsgpackInt(dst, int64(val)), true
+	case int32:
+		return appendMsgpackInt(dst, int64(val)), true
+	case int64:
+		return appendMsgpackInt(dst, val), true
+	case uint:
+		return appendMsgpackUint(dst, uint64(val)), true
+	case uint8:
+		return appendMsgpackUint(dst, uint64(val)), true
+	case uint16:
+		return appendMsgpackUint(dst, uint64(val)), true
+	case uint32:
+		return appendMsgpackUint(dst, uint64(val)), true
+	case uint64:
+		return appendMsgpackUint(dst, val), true
+	case float32:
+		return appendMsgpackFloat32(dst, val), true
+	case float64:
+		return appendMsgpackFloat64(dst, val), true
+	case nil:
+		return append(dst, msgpackNil), true
+	case []string:
+		dst = appendMsgpackArrayHead(dst, len(val))
+		for _, s := range val {
+			dst = appendMsgpackString(dst, s)
+		}
+		return dst, true
+	case []int:
+		dst = appendMsgpackArrayHead(dst, len(val))
+		for _, n := range val {
+			dst = appendMsgpackInt(dst, int64(n))
+		}
+		return dst, true
+	case []int64:
+		dst = appendMsgpackArrayHead(dst, len(val))
+		for _, n := range val {
+			dst = appendMsgpackInt(dst, n)
+		}
+		return dst, true
+	case []float64:
+		dst = appendMsgpackArrayHead(dst, len(val))
+		for _, f := range val {
+			dst = appendMsgpackFloat64(dst, f)
+		}
+		return dst, true
+	case map[string]string:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dst = appendMsgpackMapHead(dst, len(keys))
+		for _, k := range keys {
+			dst = appendMsgpackString(appendMsgpackString(dst, k), val[k])
+		}
+		return dst, true
+	case map[string]interface{}:
+		if depth >= maxDepth {
+			return dst, false
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dst = appendMsgpackMapHead(dst, len(keys))
+		ok := true
+		for _, k := range keys {
+			if dst, ok = appendMsgpackValue(appendMsgpackString(dst, k), val[k], depth+1); !ok {
+				break
+			}
+		}
+		return dst, ok
+	case json.Number:
+		return appendMsgpackNumber(dst, string(val)), true
+	case time.Time, time.Duration, []time.Time, []time.Duration, json.Marshaler:
+		return dst, false
+	case encoding.TextMarshaler:
+		if isNilPtr(val) {
+			return append(dst, msgpackNil), true
+		}
+		text, err := val.MarshalText()
+		if err != nil {
+			return appendMsgpackString(dst, fmt.Sprintf("marshaling error: %v", err)), true
+		}
+		return appendMsgpackBytes(dst, text), true
+	case fmt.Stringer:
+		if isNilPtr(val) {
+			return append(dst, msgpackNil), true
+		}
+		return appendMsgpackString(dst, val.String()), true
+	}
+	return dst, false
+}
+
+// appendMsgpackNumber appends json number as integer if it is one
+func appendMsgpackNumber(dst []byte, s string) []byte {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return appendMsgpackInt(dst, n)
+	}
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return appendMsgpackUint(dst, n)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return appendMsgpackString(dst, s)
+	}
+	return appendMsgpackFloat64(dst, f)
+}
+
+// appendMsgpackJSON appends json value converted to MessagePack, keeping
+// the order of object keys
+func appendMsgpackJSON(dst []byte, js []byte) []byte {
+	if len(js) == 0 {
+		return append(dst, msgpackNil)
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	type open struct {
+		pos, n int
+		obj    bool
+	}
+	var stack []open
+	start := len(dst)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return dst
+		}
+		if err != nil {
+			return appendMsgpackBytes(dst[:start], js)
+		}
+		// keys and values of objects are counted as items
+		if n := len(stack); n > 0 && tok != json.Delim('}') && tok != json.Delim(']') {
+			stack[n-1].n++
+		}
+		switch t := tok.(type) {
+		case json.Delim:
+			switch t {
+			case '{', '[':
+				stack = append(stack, open{pos: len(dst), obj: t == '{'})
+				dst = append(dst, msgpackArray32, 0, 0, 0, 0)
+				if t == '{' {
+					dst[len(dst)-5] = msgpackMap32
+				}
+			default:
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				n := top.n
+				if top.obj {
+					n /= 2
+				}
+				dst[top.pos+1], dst[top.pos+2], dst[top.pos+3], dst[top.pos+4] =
+					byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+			}
+		case string:
+			dst = appendMsgpackString(dst, t)
+		case json.Number:
+			dst = appendMsgpackNumber(dst, string(t))
+		case bool:
+			dst, _ = appendMsgpackValue(dst, t, 0)
+		case nil:
+			dst = append(dst, msgpackNil)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package qlog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	registryNames = struct {
+		sync.RWMutex
+		m map[string]*Notepad
+	}{m: make(map[string]*Notepad)}
+
+	nameLevels = struct {
+		sync.RWMutex
+		rules map[string]uint8
+	}{rules: make(map[string]uint8)}
+	// nameLevelsVersion is incremented on every change of rules and
+	// reloaded levels
+	nameLevelsVersion uint64 = 1
+)
+
+// Register adds np to the registry under its name and returns it. A
+// later notepad with the same name replaces the earlier one. Notepads
+// returned by Named are registered, Close removes a notepad.
+func Register(np *Notepad) *Notepad {
+	registryNames.Lock()
+	registryNames.m[string(np.Snapshot().Name)] = np
+	registryNames.Unlock()
+	return np
+}
+
+// unregister removes np from the registry
+func unregister(np *Notepad) {
+	registryNames.Lock()
+	for name, n := range registryNames.m {
+		if n == np {
+			delete(registryNames.m, name)
+		}
+	}
+	registryNames.Unlock()
+}
+
+// Lookup returns notepad registered by Register or Named with the
+// name, nil if there is none
+func Lookup(name string) *Notepad {
+	registryNames.RLock()
+	defer registryNames.RUnlock()
+	return registryNames.m[name]
+}
+
+// Names returns sorted names of registered notepads
+func Names() []string {
+	registryNames.RLock()
+	names := make([]string, 0, len(registryNames.m))
+	for name := range registryNames.m {
+		names = append(names, name)
+	}
+	registryNames.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// SetNameLevel overrides level of notepads named name and its dotted
+// descendants, e.g. "app.db" applies to "app.db" and "app.db.pool" but
+// not to "app.dbx". The longest matching name wins. Levels below the
+// notepad level log to the outputs of the notepad level.
+func SetNameLevel(name string, lvl uint8) {
+	chkLevel(lvl)
+	nameLevels.Lock()
+	nameLevels.rules[name] = lvl
+	atomic.AddUint64(&nameLevelsVersion, 1)
+	nameLevels.Unlock()
+}
+
+// ClearNameLevel removes level override of name
+func ClearNameLevel(name string) {
+	nameLevels.Lock()
+	delete(nameLevels.rules, name)
+	atomic.AddUint64(&nameLevelsVersion, 1)
+	nameLevels.Unlock()
+}
+
+// SetNameLevels replaces all level overrides with spec of comma
+// separated name=level pairs, e.g. "app.db=debug,app.http=warn".
+// Empty spec clears overrides.
+func SetNameLevels(spec string) error {
+	rules := make(map[string]uint8)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndexByte(item, '=')
+		if i <= 0 {
+			return fmt.Errorf("qlog: invalid name level %q", item)
+		}
+		lvl, err := ParseLevel(strings.TrimSpace(item[i+1:]))
+		if err != nil {
+			return err
+		}
+		rules[strings.TrimSpace(item[:i])] = lvl
+	}
+	setNameLevels(rules)
+	return nil
+}
+
+func setNameLevels(rules map[string]uint8) {
+	nameLevels.Lock()
+	nameLevels.rules = rules
+	atomic.AddUint64(&nameLevelsVersion, 1)
+	nameLevels.Unlock()
+}
+
+// mergeNameLevels removes overrides of stale names and sets overrides of
+// rules, other overrides are kept
+func mergeNameLevels(rules map[string]uint8, stale []string) {
+	nameLevels.Lock()
+	for _, name := range stale {
+		delete(nameLevels.rules, name)
+	}
+	for name, lvl := range rules {
+		nameLevels.rules[name] = lvl
+	}
+	atomic.AddUint64(&nameLevelsVersion, 1)
+	nameLevels.Unlock()
+}
+
+// NameLevels returns current level overrides
+func NameLevels() map[string]uint8 {
+	nameLevels.RLock()
+	defer nameLevels.RUnlock()
+	rules := make(map[string]uint8, len(nameLevels.rules))
+	for name, lvl := range nameLevels.rules {
+		rules[name] = lvl
+	}
+	return rules
+}
+
+// nameLevel returns level override for name
+func nameLevel(name []byte) (uint8, bool) {
+	nameLevels.RLock()
+	defer nameLevels.RUnlock()
+	if len(nameLevels.rules) == 0 || len(name) == 0 {
+		return 0, false
+	}
+	for n := string(name); ; {
+		if lvl, ok := nameLevels.rules[n]; ok {
+			return lvl, true
+		}
+		i := strings.LastIndexByte(n, '.')
+		if i < 0 {
+			return 0, false
+		}
+		n = n[:i]
+	}
+}
+
+// gateLevel returns the lowest enabled level of a snapshot. It is the
+// name level override if there is one, otherwise the Reloader level or
+// the notepad level. The value is cached in the snapshot until
+// overrides change.
+func (n *Notepad) gateLevel() uint8 {
+	ver := atomic.LoadUint64(&nameLevelsVersion)
+	if v := atomic.LoadUint64(n.gate); v>>8 == ver {
+		return uint8(v)
+	}
+	lvl, ok := nameLevel(n.Name)
+	switch {
+	case ok:
+	case n.reloadLevel != nil:
+		lvl = uint8(atomic.LoadUint32(n.reloadLevel))
+	default:
+		lvl = n.Level.n
+	}
+	atomic.StoreUint64(n.gate, ver<<8|uint64(lvl))
+	return lvl
+}
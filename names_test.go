@@ -0,0 +1,93 @@
+package qlog_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotepad_Named(t *testing.T) {
+	out := &bytes.Buffer{}
+	app := qlog.New("app", qlog.InfoLevel).SetOutput(qlog.MustTemplate("${name} ${LEVEL} ${message}\n",
+		func(o *qlog.TemplateOptions) error {
+			o.OutHandle, o.ErrHandle = out, out
+			return nil
+		}))
+	db := app.Named("db")
+	pool := db.Named("pool")
+	assert.Equal(t, "app.db.pool", string(pool.Snapshot().Name))
+	assert.Equal(t, db, qlog.Lookup("app.db"))
+	assert.Equal(t, pool, qlog.Lookup("app.db.pool"))
+	assert.Nil(t, qlog.Lookup("app.nope"))
+	assert.Contains(t, qlog.Names(), "app.db.pool")
+
+	pool.Info("opened")
+	assert.Equal(t, "app.db.pool INFO opened\n", out.String())
+
+	jout := &bytes.Buffer{}
+	named := qlog.New("", qlog.InfoLevel).SetOutput(qlog.MustJson(func(o *qlog.JsonOptions) error {
+		o.OutHandle = jout
+		return nil
+	})).Named("svc")
+	named.Info("json")
+	assert.True(t, strings.HasPrefix(jout.String(), `{"n":"svc",`))
+}
+
+func TestRegister(t *testing.T) {
+	np := qlog.New("registry", qlog.InfoLevel)
+	assert.Nil(t, qlog.Lookup("registry"))
+	assert.Equal(t, np, qlog.Register(np))
+	assert.Equal(t, np, qlog.Lookup("registry"))
+	child := np.Named("child")
+	assert.Equal(t, child, qlog.Lookup("registry.child"))
+
+	assert.NoError(t, child.Close())
+	assert.Nil(t, qlog.Lookup("registry.child"))
+	assert.Equal(t, np, qlog.Lookup("registry"))
+	np.SetName("renamed")
+	assert.NoError(t, np.Close())
+	assert.Nil(t, qlog.Lookup("registry"))
+	assert.NotContains(t, qlog.Names(), "registry")
+}
+
+func TestSetNameLevel(t *testing.T) {
+	defer qlog.SetNameLevels("")
+	out := &bytes.Buffer{}
+	app := qlog.New("levels", qlog.InfoLevel).SetOutput(qlog.MustTemplate("${name} ${LEVEL} ${message}\n",
+		func(o *qlog.TemplateOptions) error {
+			o.OutHandle, o.ErrHandle = out, out
+			return nil
+		}))
+	db, dbx, http := app.Named("db"), app.Named("dbx"), app.Named("http")
+	pool := db.Named("pool")
+	assert.False(t, pool.DEBUG.Enabled())
+
+	assert.NoError(t, qlog.SetNameLevels("levels.db=debug, levels.http=warn"))
+	assert.True(t, pool.DEBUG.Enabled())
+	assert.False(t, dbx.DEBUG.Enabled())
+	pool.Debug("pool debug")
+	db.WithFields(qlog.F{Key: "k", Value: 1}).Debug("child debug")
+	dbx.Debug("dropped")
+	http.Info("dropped")
+	http.Warn("http warn")
+	app.Debug("dropped")
+
+	qlog.SetNameLevel("levels.db.pool", qlog.ErrorLevel)
+	pool.Warn("dropped")
+	db.Debug("db debug")
+	qlog.ClearNameLevel("levels.db")
+	db.Debug("dropped")
+	assert.Equal(t, map[string]uint8{"levels.http": qlog.WarnLevel, "levels.db.pool": qlog.ErrorLevel},
+		qlog.NameLevels())
+
+	assert.Equal(t, "levels.db.pool DEBUG pool debug\n"+
+		"levels.db DEBUG child debug\n"+
+		"levels.http WARN http warn\n"+
+		"levels.db DEBUG db debug\n", out.String())
+
+	assert.Error(t, qlog.SetNameLevels("levels.db"))
+	assert.Error(t, qlog.SetNameLevels("levels.db=loud"))
+}
@@ -0,0 +1,317 @@
+package qlog
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// ProtobufOptions configures protobuf output
+type ProtobufOptions struct {
+	ErrHandle io.Writer
+	OutHandle io.Writer
+	ErrLevel  uint8
+	OutLevel  uint8
+}
+
+func defaultProtobufOptions() *ProtobufOptions {
+	return &ProtobufOptions{
+		ErrHandle: os.Stderr,
+		OutHandle: os.Stdout,
+		ErrLevel:  ErrorLevel,
+		OutLevel:  InfoLevel,
+	}
+}
+
+// Protobuf returns notepad option adding outputs of ProtobufEncoder,
+// or error of an option or invalid levels
+func Protobuf(opts ...func(*ProtobufOptions) error) (func(np *Notepad), error) {
+	options := defaultProtobufOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	return Encode(ProtobufEncoder{}, func(o *OutputOptions) error {
+		o.OutHandle, o.ErrHandle = AddSync(options.OutHandle), AddSync(options.ErrHandle)
+		o.OutLevel, o.ErrLevel = options.OutLevel, options.ErrLevel
+		return nil
+	})
+}
+
+// MustProtobuf is like Protobuf but panics if an option fails
+func MustProtobuf(opts ...func(*ProtobufOptions) error) func(np *Notepad) {
+	fn, err := Protobuf(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// ProtobufEncoder encodes entries as LogEntry protobuf messages, each
+// prefixed with its varint length:
+//
+//	message LogEntry {
+//	  int64 time_unix_nano = 1;
+//	  uint32 level = 2;
+//	  string name = 3;
+//	  string message = 4;
+//	  repeated Field fields = 5;
+//	}
+//
+//	message Field {
+//	  string key = 1;
+//	  oneof value {
+//	    string string_value = 2;
+//	    sint64 int_value = 3;
+//	    uint64 uint_value = 4;
+//	    double double_value = 6;
+//	    bool bool_value = 7;
+//	    bytes bytes_value = 8;
+//	    int64 time_unix_nano = 9;
+//	    int64 duration_nanos = 10;
+//	    string json_value = 11;
+//	  }
+//	  repeated Field fields = 5;
+//	}
+//
+// Null values have no value, nested objects have fields and other values
+// are json_value with their json field value.
+type ProtobufEncoder struct{}
+
+// Protobuf wire types and field numbers of LogEntry and Field
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+
+	pbEntryTime    = 1
+	pbEntryLevel   = 2
+	pbEntryName    = 3
+	pbEntryMessage = 4
+	pbFields       = 5
+
+	pbKey      = 1
+	pbString   = 2
+	pbInt      = 3
+	pbUint     = 4
+	pbDouble   = 6
+	pbBool     = 7
+	pbBytesVal = 8
+	pbTime     = 9
+	pbDuration = 10
+	pbJSON     = 11
+)
+
+func (ProtobufEncoder) Begin(dst []byte, e *Entry) []byte {
+	if !e.Time.IsZero() {
+		dst = appendPbVarint(appendPbTag(dst, pbEntryTime, pbVarint), uint64(e.Time.UnixNano()))
+	}
+	if e.Logger.Level.n != 0 {
+		dst = appendPbVarint(appendPbTag(dst, pbEntryLevel, pbVarint), uint64(e.Logger.Level.n))
+	}
+	if len(e.Logger.Notepad.Name) > 0 {
+		dst = appendPbBytes(appendPbTag(dst, pbEntryName, pbBytes), e.Logger.Notepad.Name)
+	}
+	if len(e.Message) > 0 {
+		dst = appendPbBytes(appendPbTag(dst, pbEntryMessage, pbBytes), e.Message)
+	}
+	return dst
+}
+
+func (ProtobufEncoder) AddField(dst []byte, f *Field) []byte {
+	dst = appendPbTag(dst, pbFields, pbBytes)
+	start := len(dst)
+	// the length is one byte for most fields and is moved if it is longer
+	dst = append(dst, 0)
+	dst = appendPbString(appendPbTag(dst, pbKey, pbBytes), f.Key)
+	if f.Value != nil || f.Buffer.Len() == 0 {
+		if b, ok := appendPbValue(dst, f.Value); ok {
+			return fixPbLength(b, start)
+		}
+	}
+	dst = appendPbBytes(appendPbTag(dst, pbJSON, pbBytes), f.Buffer.Bytes())
+	return fixPbLength(dst, start)
+}
+
+// OpenObject appends field with placeholder length, the length is set
+// by CloseObject
+func (ProtobufEncoder) OpenObject(dst []byte, key string) []byte {
+	dst = append(appendPbTag(dst, pbFields, pbBytes), pbOpen...)
+	return appendPbString(appendPbTag(dst, pbKey, pbBytes), key)
+}
+
+// CloseObject sets length of the innermost open object, objects of the
+// entry are found by walking it from the start
+func (ProtobufEncoder) CloseObject(dst []byte) []byte {
+	open := -1
+	for i := 0; i < len(dst); {
+		tag, n := binary.Uvarint(dst[i:])
+		i += n
+		switch tag & 7 {
+		case pbVarint:
+			_, n = binary.Uvarint(dst[i:])
+			i += n
+		case pbFixed64:
+			i += 8
+		case pbFixed32:
+			i += 4
+		case pbBytes:
+			if tag>>3 == pbFields && i+len(pbOpen) <= len(dst) && string(dst[i:i+len(pbOpen)]) == string(pbOpen) {
+				// the open object continues to the end
+				open = i
+				i += len(pbOpen)
+				continue
+			}
+			l, n := binary.Uvarint(dst[i:])
+			i += n + int(l)
+		}
+	}
+	if open >= 0 {
+		putPbPadded(dst[open:], uint64(len(dst)-open-len(pbOpen)))
+	}
+	return dst
+}
+
+// End prefixes the message with its length
+func (ProtobufEncoder) End(dst []byte, e *Entry) []byte {
+	n := len(dst)
+	var buf [binary.MaxVarintLen64]byte
+	sz := binary.PutUvarint(buf[:], uint64(n))
+	dst = append(dst, buf[:sz]...)
+	copy(dst[sz:], dst[:n])
+	copy(dst, buf[:sz])
+	return dst
+}
+
+// pbOpen is length placeholder of open objects, a padded varint zero
+var pbOpen = []byte{0x80, 0x80, 0x80, 0x80, 0x00}
+
+func putPbPadded(b []byte, n uint64) {
+	for i := 0; i < 4; i++ {
+		b[i] = byte(n) | 0x80
+		n >>= 7
+	}
+	b[4] = byte(n)
+}
+
+// fixPbLength sets length of the message after one byte at start
+func fixPbLength(dst []byte, start int) []byte {
+	n := len(dst) - start - 1
+	if n < 0x80 {
+		dst[start] = byte(n)
+		return dst
+	}
+	var buf [binary.MaxVarintLen64]byte
+	sz := binary.PutUvarint(buf[:], uint64(n))
+	dst = append(dst, buf[1:sz]...)
+	copy(dst[start+sz:], dst[start+1:start+1+n])
+	copy(dst[start:], buf[:sz])
+	return dst
+}
+
+func appendPbVarint(dst []byte, n uint64) []byte {
+	for n >= 0x80 {
+		dst = append(dst, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(dst, byte(n))
+}
+
+func appendPbTag(dst []byte, field, wire int) []byte {
+	return appendPbVarint(dst, uint64(field<<3|wire))
+}
+
+func appendPbString(dst []byte, s string) []byte {
+	return append(appendPbVarint(dst, uint64(len(s))), s...)
+}
+
+func appendPbBytes(dst []byte, b []byte) []byte {
+	return append(appendPbVarint(dst, uint64(len(b))), b...)
+}
+
+func appendPbInt(dst []byte, n int64) []byte {
+	return appendPbVarint(appendPbTag(dst, pbInt, pbVarint), uint64(n<<1)^uint64(n>>63))
+}
+
+func appendPbUint(dst []byte, n uint64) []byte {
+	return appendPbVarint(appendPbTag(dst, pbUint, pbVarint), n)
+}
+
+func appendPbDouble(dst []byte, f float64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	return append(appendPbTag(dst, pbDouble, pbFixed64), b[:]...)
+}
+
+// appendPbValue appends Field value of v. It returns false if v is
+// encoded as json_value.
+func appendPbValue(dst []byte, v interface{}) ([]byte, bool) {
+	switch val := v.(type) {
+	case string:
+		return appendPbString(appendPbTag(dst, pbString, pbBytes), val), true
+	case []byte:
+		return appendPbBytes(appendPbTag(dst, pbBytesVal, pbBytes), val), true
+	case error:
+		return appendPbString(appendPbTag(dst, pbString, pbBytes), val.Error()), true
+	case bool:
+		n := uint64(0)
+		if val {
+			n = 1
+		}
+		return appendPbVarint(appendPbTag(dst, pbBool, pbVarint), n), true
+	case int:
+		return appendPbInt(dst, int64(val)), true
+	case int8:
+		return appendPbInt(dst, int64(val)), true
+	case int16:
+		return appendPbInt(dst, int64(val)), true
+	case int32:
+		return appendPbInt(dst, int64(val)), true
+	case int64:
+		return appendPbInt(dst, val), true
+	case uint:
+		return appendPbUint(dst, uint64(val)), true
+	case uint8:
+		return appendPbUint(dst, uint64(val)), true
+	case uint16:
+		return appendPbUint(dst, uint64(val)), true
+	case uint32:
+		return appendPbUint(dst, uint64(val)), true
+	case uint64:
+		return appendPbUint(dst, val), true
+	case float32:
+		return appendPbDouble(dst, float64(val)), true
+	case float64:
+		return appendPbDouble(dst, val), true
+	case nil:
+		return dst, true
+	case time.Time:
+		return appendPbVarint(appendPbTag(dst, pbTime, pbVarint), uint64(val.UnixNano())), true
+	case time.Duration:
+		return appendPbVarint(appendPbTag(dst, pbDuration, pbVarint), uint64(val)), true
+	case json.Marshaler:
+		return dst, false
+	case encoding.TextMarshaler:
+		if isNilPtr(val) {
+			return dst, true
+		}
+		text, err := val.MarshalText()
+		if err != nil {
+			return appendPbString(appendPbTag(dst, pbString, pbBytes), fmt.Sprintf("marshaling error: %v", err)), true
+		}
+		return appendPbBytes(appendPbTag(dst, pbString, pbBytes), text), true
+	case fmt.Stringer:
+		if isNilPtr(val) {
+			return dst, true
+		}
+		return appendPbString(appendPbTag(dst, pbString, pbBytes), val.String()), true
+	}
+	return dst, false
+}
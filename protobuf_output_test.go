@@ -0,0 +1,199 @@
+package qlog_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+// pbEntry is a decoded LogEntry message
+type pbEntry struct {
+	Time    int64
+	Level   uint64
+	Name    string
+	Message string
+	Fields  []pbField
+}
+
+// pbField is a decoded Field message, Value is nil for nested objects
+type pbField struct {
+	Key    string
+	Value  interface{}
+	Fields []pbField
+}
+
+// pbWalk calls fn with number, wire type and value of every field of
+// message b. Values of length delimited fields are []byte, other values
+// are uint64.
+func pbWalk(t *testing.T, b []byte, fn func(num, wire int, v interface{})) {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if !assert.True(t, n > 0, "invalid tag") {
+			t.FailNow()
+		}
+		b = b[n:]
+		num, wire := int(tag>>3), int(tag&7)
+		switch wire {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if !assert.True(t, n > 0, "invalid varint") {
+				t.FailNow()
+			}
+			b = b[n:]
+			fn(num, wire, v)
+		case 1:
+			fn(num, wire, binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if !assert.True(t, n > 0 && int(l) <= len(b)-n, "invalid length of field %d", num) {
+				t.FailNow()
+			}
+			fn(num, wire, b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", wire)
+		}
+	}
+}
+
+func pbDecodeField(t *testing.T, b []byte) pbField {
+	var f pbField
+	pbWalk(t, b, func(num, wire int, v interface{}) {
+		switch num {
+		case 1:
+			f.Key = string(v.([]byte))
+		case 2, 11:
+			f.Value = string(v.([]byte))
+		case 3:
+			n := v.(uint64)
+			f.Value = int64(n>>1) ^ -int64(n&1)
+		case 4:
+			f.Value = v.(uint64)
+		case 5:
+			f.Fields = append(f.Fields, pbDecodeField(t, v.([]byte)))
+		case 6:
+			f.Value = math.Float64frombits(v.(uint64))
+		case 7:
+			f.Value = v.(uint64) == 1
+		case 8:
+			f.Value = append([]byte(nil), v.([]byte)...)
+		case 9:
+			f.Value = time.Unix(0, int64(v.(uint64))).UTC()
+		case 10:
+			f.Value = time.Duration(v.(uint64))
+		}
+	})
+	return f
+}
+
+// pbDecode decodes length delimited LogEntry messages
+func pbDecode(t *testing.T, b []byte) []pbEntry {
+	var entries []pbEntry
+	for len(b) > 0 {
+		l, n := binary.Uvarint(b)
+		if !assert.True(t, n > 0 && int(l) <= len(b)-n, "invalid message length") {
+			t.FailNow()
+		}
+		var e pbEntry
+		pbWalk(t, b[n:n+int(l)], func(num, wire int, v interface{}) {
+			switch num {
+			case 1:
+				e.Time = int64(v.(uint64))
+			case 2:
+				e.Level = v.(uint64)
+			case 3:
+				e.Name = string(v.([]byte))
+			case 4:
+				e.Message = string(v.([]byte))
+			case 5:
+				e.Fields = append(e.Fields, pbDecodeField(t, v.([]byte)))
+			}
+		})
+		entries = append(entries, e)
+		b = b[n+int(l):]
+	}
+	return entries
+}
+
+func TestProtobuf_RoundTrip(t *testing.T) {
+	out := &bytes.Buffer{}
+	now := time.Date(2020, 1, 2, 10, 0, 0, 123, time.UTC)
+	long := strings.Repeat("x", 200)
+	np := qlog.New("app", qlog.DebugLevel, qlog.Clock(func() time.Time { return now })).SetOutput(
+		qlog.MustProtobuf(func(o *qlog.ProtobufOptions) error {
+			o.OutHandle, o.ErrHandle, o.OutLevel = out, out, qlog.DebugLevel
+			return nil
+		}))
+	np.DEBUG.Fields(
+		qlog.F{Key: "s", Value: "short"},
+		qlog.F{Key: "long", Value: long},
+		qlog.F{Key: "i", Value: -5},
+		qlog.F{Key: "u", Value: uint(7)},
+		qlog.F{Key: "f", Value: 1.5},
+		qlog.F{Key: "b", Value: true},
+		qlog.F{Key: "raw", Value: []byte{0, 1, 2}},
+		qlog.F{Key: "at", Value: now},
+		qlog.F{Key: "took", Value: 3 * time.Second},
+		qlog.F{Key: "none", Value: nil},
+		qlog.F{Key: "m", Value: map[string]int{"a": 1}},
+	).Msg(long)
+	np.WARN.Msg("second")
+
+	entries := pbDecode(t, out.Bytes())
+	if !assert.Len(t, entries, 2) {
+		return
+	}
+	assert.Equal(t, pbEntry{Time: now.UnixNano(), Name: "app", Message: long, Fields: []pbField{
+		{Key: "s", Value: "short"},
+		{Key: "long", Value: long},
+		{Key: "i", Value: int64(-5)},
+		{Key: "u", Value: uint64(7)},
+		{Key: "f", Value: 1.5},
+		{Key: "b", Value: true},
+		{Key: "raw", Value: []byte{0, 1, 2}},
+		{Key: "at", Value: now},
+		{Key: "took", Value: 3 * time.Second},
+		{Key: "none", Value: "null"},
+		{Key: "m", Value: `{"a":1}`},
+	}}, entries[0])
+	assert.Equal(t, pbEntry{Time: now.UnixNano(), Level: uint64(qlog.WarnLevel), Name: "app", Message: "second"},
+		entries[1])
+}
+
+func TestProtobuf_NestedObjects(t *testing.T) {
+	out := &bytes.Buffer{}
+	long := strings.Repeat("y", 300)
+	enc := qlog.NestFields(qlog.NestFields(qlog.ProtobufEncoder{}, "outer"), "inner")
+	np := qlog.New("", qlog.InfoLevel, qlog.Clock(func() time.Time { return time.Time{} })).SetOutput(
+		qlog.MustEncode(enc, func(o *qlog.OutputOptions) error {
+			o.OutHandle = qlog.AddSync(out)
+			return nil
+		}))
+	np.INFO.Fields(qlog.F{Key: "long", Value: long}, qlog.F{Key: "n", Value: 1}).Msg("nested")
+	np.INFO.Fields(qlog.F{Key: "k", Value: "v"}).Msg("small")
+
+	entries := pbDecode(t, out.Bytes())
+	if !assert.Len(t, entries, 2) {
+		return
+	}
+	assert.Equal(t, pbEntry{Level: uint64(qlog.InfoLevel), Message: "nested", Fields: []pbField{
+		{Key: "outer", Fields: []pbField{
+			{Key: "inner", Fields: []pbField{
+				{Key: "long", Value: long},
+				{Key: "n", Value: int64(1)},
+			}},
+		}},
+	}}, entries[0])
+	assert.Equal(t, pbEntry{Level: uint64(qlog.InfoLevel), Message: "small", Fields: []pbField{
+		{Key: "outer", Fields: []pbField{
+			{Key: "inner", Fields: []pbField{{Key: "k", Value: "v"}}},
+		}},
+	}}, entries[1])
+}
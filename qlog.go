@@ -5,6 +5,10 @@ import (
 	"fmt"
 	// "github.com/karantin2020/qlog/buffer"
 	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,9 +29,22 @@ type Logger struct {
 	Context []Field
 	// Enable flag
 	Enable bool
+
+	// borrowed loggers are below the notepad level and share outputs of
+	// the notepad level logger, see Notepad.derive
+	borrowed bool
 }
 
 // Notepad is where you leave a note!
+//
+// A notepad returned by New or WithFields is a handle to an immutable
+// snapshot of its configuration. Configuration methods build a new
+// snapshot and publish it atomically, so they are safe to call while
+// other goroutines log. The handle loggers DEBUG...FATAL and LOG are
+// never nil and never change, they resolve the published snapshot when
+// an entry is created. Loggers below the notepad level are disabled and
+// drop entries. Other exported fields of a handle and its loggers are
+// not set, read the configuration from Snapshot.
 type Notepad struct {
 	DEBUG    *Logger
 	INFO     *Logger
@@ -55,6 +72,24 @@ type Notepad struct {
 	Loggers [7]**Logger
 	// Options set notebook configs
 	Options LogConfig
+
+	// closers are resources opened for the notepad outputs
+	closers []io.Closer
+	// counters are shared by snapshots, see OutputStats
+	counters []*outputCounter
+	// state is set for handles and nil for snapshots
+	state *notepadState
+	// gate caches level enabled by name level overrides, see gateLevel
+	gate *uint64
+	// reloadLevel is the level of the running Reloader config, it is
+	// shared by descendants of the live notepad
+	reloadLevel *uint32
+}
+
+// notepadState holds the published snapshot of a notepad handle
+type notepadState struct {
+	mu  sync.Mutex // serializes configuration changes
+	cur atomic.Value
 }
 
 type LogConfig struct {
@@ -89,6 +124,7 @@ type LogConfig struct {
 	TimeFieldFormat string // time.RFC3339
 
 	// TimestampFunc defines the function called to generate a timestamp.
+	// It is the clock used for entry time and Timestamp fields.
 	TimestampFunc func() time.Time // time.Now
 
 	// DurationFieldUnit defines the unit for time.Duration type fields added
@@ -101,19 +137,57 @@ type LogConfig struct {
 
 	// InterfaceMarshaler is used to marshal arbitrary data fields
 	InterfaceMarshaler func(v interface{}) ([]byte, error)
+
+	// ExitFunc is called by Fatal after the entry is written.
+	ExitFunc func(code int) // os.Exit
+
+	// PanicFunc is called by Panic (and Critical in development) after
+	// the entry is written.
+	PanicFunc func(msg string) // panic(msg)
+
+	// FatalHooks are called in order by Fatal before ExitFunc, e.g. to
+	// flush buffered outputs.
+	FatalHooks []func()
+
+	// Redactor masks sensitive values of fields and message text before
+	// hooks and outputs see the entry. Nil disables redaction.
+	Redactor *Redactor
+
+	// Sampler drops entries before they are processed, panic and fatal
+	// entries are always processed. Nil logs all entries.
+	Sampler Sampler
+
+	// ErrorHandler is called with errors of outputs and their options
+	// instead of panicking, e.g. write errors of a closed pipe or a full
+	// disk. Nil ignores them.
+	ErrorHandler func(error) // NewErrorHandler(os.Stderr, 10*time.Second, clock)
+
+	// HookErrorHandler is called with hook errors and panics, ErrorHandler
+	// is used if it is nil.
+	HookErrorHandler func(error)
 }
 
+// AddHook adds h to the logger if h fires at its level
 func (l *Logger) AddHook(h Hook) {
-	// fmt.Printf("Took hook: %#v\n", h)
-	// fmt.Printf("Took logger: %#v\n", l)
-	// fmt.Printf("Took logger hooks: %#v\n", l.Hooks)
-	l.Hooks = append(l.Hooks, h)
+	l.Notepad.update(func(s *Notepad) {
+		if sl := *s.Loggers[l.Level.n]; sl != nil {
+			sl.insertHook(h)
+		}
+	})
 }
 
 // New func returns new instance of notepad
 func New(name string, lvl uint8, opts ...func(*LogConfig) error) *Notepad {
 	chkLevel(lvl)
-	n := &Notepad{}
+	n := &Notepad{gate: new(uint64)}
+	var h *Notepad
+	// the default error handler follows the clock of the notepad
+	clock := func() time.Time {
+		if h != nil {
+			return h.Snapshot().Options.TimestampFunc()
+		}
+		return n.Options.TimestampFunc()
+	}
 	n.Name = []byte(name)
 	n.Loggers = [7]**Logger{&n.DEBUG, &n.INFO, &n.WARN, &n.ERROR, &n.CRITICAL, &n.PANIC, &n.FATAL}
 	n.Level = InitLevel(lvl)
@@ -132,54 +206,165 @@ func New(name string, lvl uint8, opts ...func(*LogConfig) error) *Notepad {
 		DurationFieldUnit:    time.Millisecond,
 		DurationFieldInteger: true,
 		InterfaceMarshaler:   json.Marshal,
+		ExitFunc:             os.Exit,
+		PanicFunc:            defaultPanic,
+		ErrorHandler:         NewErrorHandler(os.Stderr, 10*time.Second, clock),
 	}
+	var errs []error
 	for _, fn := range opts {
-		fn(&n.Options)
+		if err := fn(&n.Options); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, err := range errs {
+		n.handleError(err)
 	}
-	n.LOG = n.DEBUG
 	n.init()
-	return n
+	n.derive()
+	h = newHandle(n, nil)
+	return h
 }
 
-// init creates the Loggers for each level depending on the notepad levels.
+// init creates the Loggers for each level depending on the notepad level
+// and removes loggers below it. Existing loggers keep their outputs.
 func (n *Notepad) init() {
 	for t, logger := range n.Loggers {
 		level := uint8(t)
-		if level >= n.Level.n {
+		switch {
+		case level < n.Level.n:
+			*logger = nil
+		case *logger == nil:
 			*logger = NewLogger(n, level)
 			(*logger).Output = make([]Output, 0, 3)
 		}
 	}
+	n.LOG = n.DEBUG
 }
 
-func (n *Notepad) copy() *Notepad {
-	newnp := *n
-	newnp.Formatter = make([]Formatter, len(n.Formatter), cap(n.Formatter))
-	copy(newnp.Formatter, n.Formatter)
-	newnp.Context = make([]Field, 0, 7)
-	newnp.Context = append(newnp.Context, n.Context...)
-	// newnp.CtxBuffer = make([]*buffer.Buffer, len(n.CtxBuffer), cap(n.CtxBuffer))
-	// copy(newnp.CtxBuffer, n.CtxBuffer)
-	newnp.Loggers = [7]**Logger{&newnp.DEBUG,
-		&newnp.INFO, &newnp.WARN, &newnp.ERROR,
-		&newnp.CRITICAL, &newnp.PANIC, &newnp.FATAL}
-	for tl, logger := range n.Loggers {
+// newHandle returns notepad handle publishing snapshot s
+func newHandle(s *Notepad, closers []io.Closer) *Notepad {
+	h := &Notepad{closers: closers, state: &notepadState{}}
+	h.Loggers = [7]**Logger{&h.DEBUG, &h.INFO, &h.WARN, &h.ERROR,
+		&h.CRITICAL, &h.PANIC, &h.FATAL}
+	for t, logger := range h.Loggers {
+		*logger = &Logger{Notepad: h, Level: InitLevel(uint8(t))}
+	}
+	h.LOG = h.DEBUG
+	h.state.cur.Store(s)
+	return h
+}
+
+// Snapshot returns the published configuration of the notepad. The
+// snapshot must not be modified.
+func (np *Notepad) Snapshot() *Notepad {
+	if np.state == nil {
+		return np
+	}
+	return np.state.cur.Load().(*Notepad)
+}
+
+// update applies fn to a copy of the published snapshot and publishes
+// the result. Snapshots being configured, e.g. by output functions
+// passed to SetOutput, are changed in place.
+func (np *Notepad) update(fn func(s *Notepad)) {
+	if np.state == nil {
+		fn(np)
+		return
+	}
+	np.state.mu.Lock()
+	defer np.state.mu.Unlock()
+	s := np.Snapshot().clone()
+	s.strip()
+	fn(s)
+	s.derive()
+	np.state.cur.Store(s)
+}
+
+// strip removes borrowed loggers before snapshot is configured
+func (n *Notepad) strip() {
+	for t := uint8(0); t < n.Level.n; t++ {
+		*n.Loggers[t] = nil
+	}
+	n.LOG = n.DEBUG
+}
+
+// derive adds loggers below the notepad level. They share hooks and
+// outputs of the notepad level logger and are enabled by name level
+// overrides lowering the level.
+func (n *Notepad) derive() {
+	base := *n.Loggers[n.Level.n]
+	for t := uint8(0); t < n.Level.n; t++ {
+		l := base.clone()
+		l.Level = InitLevel(t)
+		l.Context = l.Context[:0]
+		l.borrowed = true
+		*n.Loggers[t] = l
+	}
+	n.LOG = n.DEBUG
+}
+
+// clone returns deep copy of snapshot
+func (n *Notepad) clone() *Notepad {
+	s := &Notepad{Level: n.Level, Name: n.Name, Options: n.Options, gate: new(uint64),
+		reloadLevel: n.reloadLevel}
+	s.Formatter = append(make([]Formatter, 0, len(n.Formatter)+1), n.Formatter...)
+	s.Context = copyFields(n.Context)
+	s.Options.FatalHooks = append([]func(){}, n.Options.FatalHooks...)
+	s.counters = append([]*outputCounter(nil), n.counters...)
+	s.Loggers = [7]**Logger{&s.DEBUG, &s.INFO, &s.WARN, &s.ERROR,
+		&s.CRITICAL, &s.PANIC, &s.FATAL}
+	for t, logger := range n.Loggers {
 		if *logger != nil {
-			*newnp.Loggers[tl] = (*logger).copy()
-			(*newnp.Loggers[tl]).Notepad = &newnp
+			l := (*logger).clone()
+			l.Notepad = s
+			*s.Loggers[t] = l
 		}
 	}
+	s.LOG = s.DEBUG
+	return s
+}
 
-	return &newnp
+// copyFields returns copy of fields not sharing their buffers
+func copyFields(src []Field) []Field {
+	dst := make([]Field, len(src), len(src)+7)
+	for i := range src {
+		dst[i].Key, dst[i].Value = src[i].Key, src[i].Value
+		dst[i].Buffer.Write(src[i].Buffer.Bytes())
+	}
+	return dst
+}
+
+// Close closes resources opened for the notepad outputs, e.g. files
+// opened by FromConfig, and removes the notepad from the registry.
+// Notepads derived with WithFields share the resources.
+func (np *Notepad) Close() error {
+	unregister(np)
+	var closers []io.Closer
+	np.lock(func() {
+		closers, np.closers = np.closers, nil
+	})
+	var err error
+	for _, c := range closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// addCloser registers resource closed by Close
+func (np *Notepad) addCloser(cs ...io.Closer) {
+	np.lock(func() {
+		np.closers = append(np.closers, cs...)
+	})
 }
 
-func (n *Notepad) free() {
-	for j := range n.Loggers {
-		(*n.Loggers[j]).free()
+func (np *Notepad) lock(fn func()) {
+	if np.state != nil {
+		np.state.mu.Lock()
+		defer np.state.mu.Unlock()
 	}
-	// for i := range n.CtxBuffer {
-	// 	n.CtxBuffer[i].Free()
-	// }
+	fn()
 }
 
 func TimeFormat(format string) func(*LogConfig) error {
@@ -189,54 +374,159 @@ func TimeFormat(format string) func(*LogConfig) error {
 	}
 }
 
+// Clock sets the function used as time source of the notepad
+func Clock(fn func() time.Time) func(*LogConfig) error {
+	return func(lc *LogConfig) error {
+		lc.TimestampFunc = fn
+		return nil
+	}
+}
+
+// ExitFunc sets the function called by Fatal instead of os.Exit
+func ExitFunc(fn func(code int)) func(*LogConfig) error {
+	return func(lc *LogConfig) error {
+		lc.ExitFunc = fn
+		return nil
+	}
+}
+
+// PanicFunc sets the function called by Panic instead of panic
+func PanicFunc(fn func(msg string)) func(*LogConfig) error {
+	return func(lc *LogConfig) error {
+		lc.PanicFunc = fn
+		return nil
+	}
+}
+
+// OnFatal adds hooks called by Fatal before exit
+func OnFatal(fns ...func()) func(*LogConfig) error {
+	return func(lc *LogConfig) error {
+		lc.FatalHooks = append(lc.FatalHooks, fns...)
+		return nil
+	}
+}
+
+func defaultPanic(msg string) {
+	panic(msg)
+}
+
 func (np *Notepad) SetTimeFormat(format string) *Notepad {
-	np.Options.TimeFieldFormat = format
+	np.update(func(s *Notepad) {
+		s.Options.TimeFieldFormat = format
+	})
+	return np
+}
+
+// SetClock sets the function used as time source of the notepad
+func (np *Notepad) SetClock(fn func() time.Time) *Notepad {
+	np.update(func(s *Notepad) {
+		s.Options.TimestampFunc = fn
+	})
 	return np
 }
 
+// AddFatalHook adds hook called by Fatal before exit
+func (np *Notepad) AddFatalHook(fn func()) *Notepad {
+	np.update(func(s *Notepad) {
+		s.Options.FatalHooks = append(s.Options.FatalHooks, fn)
+	})
+	return np
+}
+
+// SetName sets notepad name
+func (np *Notepad) SetName(name string) *Notepad {
+	np.update(func(s *Notepad) {
+		s.Name = []byte(name)
+	})
+	return np
+}
+
+// SetLevel sets notepad level. Loggers enabled by lowering the level
+// have no outputs until SetOutput is called.
 func (np *Notepad) SetLevel(lvl uint8) {
 	chkLevel(lvl)
-	np.Level = InitLevel(lvl)
-	np.init()
+	np.update(func(s *Notepad) {
+		s.Level = InitLevel(lvl)
+		s.init()
+	})
 }
 
+// AddHook adds h to the logger of lvl if h fires at lvl
 func (np *Notepad) AddHook(lvl uint8, h Hook) {
 	chkLevel(lvl)
-	(*np.Loggers[int(lvl)]).AddHook(h)
+	np.update(func(s *Notepad) {
+		if l := *s.Loggers[int(lvl)]; l != nil {
+			l.insertHook(h)
+		}
+	})
 }
 
+// AddHooks adds hooks to loggers of lvl and above at levels the hooks
+// fire
 func (np *Notepad) AddHooks(lvl uint8, hs ...Hook) *Notepad {
-	for t, logger := range np.Loggers {
-		if *logger != nil && uint8(t) >= lvl {
-			for _, h := range hs {
-				np.AddHook(np.Level.n, h)
-			}
+	chkLevel(lvl)
+	np.update(func(s *Notepad) {
+		s.addHooks(lvl, hs...)
+	})
+	return np
+}
+
+func (n *Notepad) addHooks(lvl uint8, hs ...Hook) {
+	for t, logger := range n.Loggers {
+		if *logger == nil || uint8(t) < lvl {
+			continue
+		}
+		for _, h := range hs {
+			(*logger).insertHook(h)
 		}
 	}
-	return np
 }
 
+// SetOutput applies output functions to a new snapshot of the notepad.
+// If a function panics nothing is changed.
 func (np *Notepad) SetOutput(fns ...func(*Notepad)) *Notepad {
-	for _, fn := range fns {
-		fn(np)
-	}
+	np.update(func(s *Notepad) {
+		for _, fn := range fns {
+			fn(s)
+		}
+	})
 	return np
 }
 
 func (np *Notepad) AddField(f F) {
-	AddField(f, &np.Context, &np.Options)
+	np.update(func(s *Notepad) {
+		AddField(f, &s.Context, &s.Options)
+	})
 }
 
 func (np *Notepad) WithFields(flds ...F) *Notepad {
-	newnp := np.copy()
+	var closers []io.Closer
+	np.lock(func() {
+		closers = np.closers
+	})
+	s := np.Snapshot().clone()
 	for _, f := range flds {
-		newnp.AddField(f)
+		AddField(f, &s.Context, &s.Options)
 	}
-	return newnp
+	return newHandle(s, closers)
+}
+
+// Named returns child notepad with name appended to the notepad name
+// with a dot, e.g. "app.db". The child is registered, see Lookup.
+func (np *Notepad) Named(name string) *Notepad {
+	child := np.WithFields()
+	if cur := np.Snapshot().Name; len(cur) > 0 {
+		name = string(cur) + "." + name
+	}
+	child.SetName(name)
+	return Register(child)
 }
 
 func (np *Notepad) Timestamp() *Notepad {
-	np.AddField(F{Key: np.Options.TimestampFieldName, Value: np.Options.TimestampFunc()})
+	np.update(func(s *Notepad) {
+		AddField(F{Key: s.Options.TimestampFieldName, Value: s.Options.TimestampFunc()},
+			&s.Context, &s.Options)
+	})
 	return np
 }
 
@@ -252,128 +542,156 @@ func NewLogger(np *Notepad, level uint8) *Logger {
 	return lgr
 }
 
-func (l *Logger) copy() *Logger {
+func (l *Logger) clone() *Logger {
 	newl := *l
-	newl.Context = make([]Field, 0, 7)
-	newl.Context = append(newl.Context, l.Context...)
-	// newl.CtxBuffer = make([]*buffer.Buffer, len(l.CtxBuffer), cap(l.CtxBuffer))
-	// copy(newl.CtxBuffer, l.CtxBuffer)
+	newl.Output = append(make([]Output, 0, len(l.Output)+1), l.Output...)
+	newl.Hooks = append(make([]Hook, 0, len(l.Hooks)+1), l.Hooks...)
+	newl.Context = copyFields(l.Context)
 	return &newl
 }
 
-func (l *Logger) free() {
-	// for i := range l.CtxBuffer {
-	// 	l.CtxBuffer[i].Free()
-	// }
+// current returns the published logger of a handle, nil if the logger
+// is disabled
+func (l *Logger) current() *Logger {
+	if l.Notepad.state == nil {
+		return l
+	}
+	s := l.Notepad.Snapshot()
+	if l.Level.n < s.gateLevel() {
+		return nil
+	}
+	return *s.Loggers[l.Level.n]
+}
+
+// Enabled reports whether entries of level are written
+func (np *Notepad) Enabled(lvl uint8) bool {
+	return lvl <= _maxLevel && (*np.Loggers[lvl]).Enabled()
+}
+
+// Enabled reports whether entries of the logger are written
+func (l *Logger) Enabled() bool {
+	return l != nil && l.current() != nil
 }
 
 func (l *Logger) AddField(f F) {
-	AddField(f, &l.Context, &l.Notepad.Options)
+	l.WithFields(f)
 }
 
 func (l *Logger) WithFields(flds ...F) *Logger {
-	for _, f := range flds {
-		l.AddField(f)
-	}
+	l.Notepad.update(func(s *Notepad) {
+		sl := *s.Loggers[l.Level.n]
+		if sl == nil {
+			return
+		}
+		for _, f := range flds {
+			AddField(f, &sl.Context, &s.Options)
+		}
+	})
 	return l
 }
 
 func (np *Notepad) Debug(msg string) {
-	if np.DEBUG == nil {
-		return
-	}
 	np.DEBUG.NewEntry().Debug(msg)
 }
 
 func (np *Notepad) Debugf(format string, a ...interface{}) {
+	if !np.Enabled(DebugLevel) {
+		return
+	}
 	np.Debug(fmt.Sprintf(format, a...))
 }
 
 func (np *Notepad) Info(msg string) {
-	if np.INFO == nil {
-		return
-	}
 	np.INFO.NewEntry().Info(msg)
 }
 
 func (np *Notepad) Infof(format string, a ...interface{}) {
+	if !np.Enabled(InfoLevel) {
+		return
+	}
 	np.Info(fmt.Sprintf(format, a...))
 }
 
 func (np *Notepad) Warn(msg string) {
-	if np.WARN == nil {
-		return
-	}
 	np.WARN.NewEntry().Warn(msg)
 }
 
 func (np *Notepad) Warnf(format string, a ...interface{}) {
+	if !np.Enabled(WarnLevel) {
+		return
+	}
 	np.Warn(fmt.Sprintf(format, a...))
 }
 
 func (np *Notepad) Error(msg string) {
-	if np.ERROR == nil {
-		return
-	}
 	np.ERROR.NewEntry().Error(msg)
 }
 
 func (np *Notepad) Errorf(format string, a ...interface{}) {
+	if !np.Enabled(ErrorLevel) {
+		return
+	}
 	np.Error(fmt.Sprintf(format, a...))
 }
 
 func (np *Notepad) Critical(msg string) {
-	if np.CRITICAL == nil {
-		return
-	}
 	np.CRITICAL.NewEntry().Critical(msg)
 }
 
 func (np *Notepad) Criticalf(format string, a ...interface{}) {
+	if !np.Enabled(CriticalLevel) {
+		return
+	}
 	np.Critical(fmt.Sprintf(format, a...))
 }
 
 func (np *Notepad) Panic(msg string) {
-	if np.PANIC == nil {
-		return
-	}
 	np.PANIC.NewEntry().Panic(msg)
 }
 
 func (np *Notepad) Panicf(format string, a ...interface{}) {
+	if !np.Enabled(PanicLevel) {
+		return
+	}
 	np.Panic(fmt.Sprintf(format, a...))
 }
 
 func (np *Notepad) Fatal(msg string) {
-	if np.FATAL == nil {
-		return
-	}
 	np.FATAL.NewEntry().Fatal(msg)
 }
 
 func (np *Notepad) Fatalf(format string, a ...interface{}) {
+	if !np.Enabled(FatalLevel) {
+		return
+	}
 	np.Fatal(fmt.Sprintf(format, a...))
 }
 
 func (np *Notepad) Log(msg string) {
-	if np.LOG == nil {
-		return
-	}
 	np.LOG.NewEntry().Log(msg)
 }
 
 func (np *Notepad) Logf(format string, a ...interface{}) {
+	if !np.Enabled(DebugLevel) {
+		return
+	}
 	np.Log(fmt.Sprintf(format, a...))
 }
 
 func (l *Logger) Msg(msg string) {
 	e := l.NewEntry()
+	if e == nil {
+		return
+	}
 	// e.Level = l.Level
 	e.Message = append(e.Message, Str2Bytes(msg)...)
 	e.Process()
 }
 
 func (l *Logger) Msgf(format string, a ...interface{}) {
+	if !l.Enabled() {
+		return
+	}
 	l.Msg(fmt.Sprintf(format, a...))
 }
 
@@ -2,10 +2,15 @@ package qlog_test
 
 import (
 	"errors"
+	"io/ioutil"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestNew(t *testing.T) {
@@ -43,7 +48,7 @@ func TestNew(t *testing.T) {
 
 func TestNotepad_AddHook(t *testing.T) {
 	np := qlog.New("", qlog.InfoLevel)
-	hook := qlog.Hook(func(e *qlog.Entry) {})
+	hook := qlog.HookFunc(func(e *qlog.Entry) error { return nil })
 	tests := []struct {
 		name      string
 		fn        func() error
@@ -53,8 +58,7 @@ func TestNotepad_AddHook(t *testing.T) {
 			"New Hook add",
 			func() error {
 				np.AddHook(qlog.WarnLevel, hook)
-				// fmt.Printf("Took info hooks: %#v with len %d\n", np.INFO.Hooks, len(np.INFO.Hooks))
-				if len(np.INFO.Hooks) != 0 {
+				if len(np.Snapshot().INFO.Hooks) != 0 {
 					return errors.New("Wrong hook at Info level")
 				}
 				return nil
@@ -64,8 +68,7 @@ func TestNotepad_AddHook(t *testing.T) {
 		{
 			"New Hook add with error",
 			func() error {
-				// fmt.Printf("Took panic hooks: %#v with len %d\n", np.PANIC.Hooks, len(np.PANIC.Hooks))
-				if len(np.PANIC.Hooks) != 0 {
+				if len(np.Snapshot().PANIC.Hooks) != 0 {
 					return errors.New("Wrong hook at Panic level")
 				}
 				return nil
@@ -83,3 +86,148 @@ func TestNotepad_AddHook(t *testing.T) {
 		})
 	}
 }
+
+func TestNotepad_Disabled(t *testing.T) {
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel)
+	assert.False(t, np.DEBUG.Enabled())
+	assert.True(t, np.INFO.Enabled())
+	assert.Nil(t, np.DEBUG.NewEntry())
+	np.DEBUG.Fields(qlog.F{Key: "k", Value: 1}).Msg("dropped")
+	np.Debug("dropped")
+
+	np.SetLevel(qlog.WarnLevel)
+	assert.False(t, np.INFO.Enabled())
+	np.Info("dropped")
+	np.Warn("kept")
+	assert.Equal(t, 1, logs.Len())
+}
+
+// formatCounter counts calls of String
+type formatCounter int
+
+func (c *formatCounter) String() string {
+	*c++
+	return "formatted"
+}
+
+func TestNotepad_DisabledFormat(t *testing.T) {
+	np, logs := qlogtest.NewNotepad(t, qlog.WarnLevel)
+	var c formatCounter
+	np.Debugf("%v", &c)
+	np.Infof("%v", &c)
+	np.Logf("%v", &c)
+	np.INFO.Msgf("%v", &c)
+	np.DEBUG.Fields(qlog.F{Key: "k", Value: 1}).Msgf("%v", &c)
+	assert.Equal(t, formatCounter(0), c)
+	np.Warnf("%v", &c)
+	np.ERROR.Msgf("%v", &c)
+	assert.Equal(t, formatCounter(2), c)
+	assert.Equal(t, 2, logs.Len())
+}
+
+func TestNotepad_Snapshot(t *testing.T) {
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel)
+	child := np.WithFields(qlog.F{Key: "child", Value: true})
+	np.AddField(qlog.F{Key: "parent", Value: 1})
+	np.SetName("renamed")
+	child.Info("child")
+	np.Info("parent")
+
+	entries := logs.All()
+	if !assert.Len(t, entries, 2) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"child": true}, entries[0].ContextMap())
+	assert.Equal(t, "TestNotepad_Snapshot", entries[0].Name)
+	assert.Equal(t, map[string]interface{}{"parent": 1}, entries[1].ContextMap())
+	assert.Equal(t, "renamed", entries[1].Name)
+	assert.Equal(t, "renamed", string(np.Snapshot().Name))
+}
+
+func TestNotepad_HandleFields(t *testing.T) {
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel)
+	info := np.INFO
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			// handle fields are never written after New, reading them
+			// must not race with configuration changes
+			_, _, _ = np.Level, np.Name, np.Options.TimestampFieldName
+			_, _, _ = np.INFO.Output, np.INFO.Hooks, np.INFO.Enable
+			np.INFO.Enabled()
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		np.SetName("renamed")
+		np.SetLevel(uint8(i % 2))
+		np.AddField(qlog.F{Key: "i", Value: i})
+	}
+	close(stop)
+	wg.Wait()
+
+	assert.True(t, info == np.INFO)
+	assert.Nil(t, np.INFO.Output)
+	assert.Empty(t, np.Name)
+	np.Info("kept")
+	assert.Equal(t, 1, logs.Len())
+	assert.Equal(t, "renamed", string(np.Snapshot().Name))
+}
+
+func TestNotepad_ConcurrentConfig(t *testing.T) {
+	np := qlog.New("race", qlog.DebugLevel).SetOutput(qlog.MustJson(func(o *qlog.JsonOptions) error {
+		o.OutHandle, o.ErrHandle = ioutil.Discard, ioutil.Discard
+		return nil
+	}))
+	var (
+		wg    sync.WaitGroup
+		stop  = make(chan struct{})
+		hooks int64
+	)
+	np.AddHook(qlog.WarnLevel, qlog.HookFunc(func(e *qlog.Entry) error {
+		atomic.AddInt64(&hooks, 1)
+		return nil
+	}))
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			child := np.WithFields(qlog.F{Key: "worker", Value: w})
+			for {
+				np.INFO.Fields(qlog.F{Key: "n", Value: w}).Msg("parent")
+				child.Warn("child")
+				np.Debug("debug")
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}(w)
+	}
+	for i := 0; i < 100; i++ {
+		np.SetOutput(qlog.MustJson(func(o *qlog.JsonOptions) error {
+			o.OutHandle, o.ErrHandle = ioutil.Discard, ioutil.Discard
+			o.OutLevel = qlog.WarnLevel
+			return nil
+		}))
+		np.AddField(qlog.F{Key: "i", Value: i})
+		np.INFO.AddField(qlog.F{Key: "info", Value: i})
+		np.AddHook(qlog.ErrorLevel, qlog.HookFunc(func(e *qlog.Entry) error { return nil }))
+		np.SetTimeFormat("Unix")
+		np.SetLevel(uint8(i % 2))
+	}
+	close(stop)
+	wg.Wait()
+	assert.Len(t, np.Snapshot().INFO.Context, 1)
+	assert.NotZero(t, atomic.LoadInt64(&hooks))
+}
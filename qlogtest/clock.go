@@ -0,0 +1,43 @@
+package qlogtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a deterministic time source. Each call of Now returns the
+// current time and advances it by the step.
+type Clock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+// NewClock returns Clock starting at start and advancing by step
+func NewClock(start time.Time, step time.Duration) *Clock {
+	return &Clock{now: start, step: step}
+}
+
+// Now returns current clock time and advances the clock.
+// Use it as LogConfig.TimestampFunc.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	t := c.now
+	c.now = c.now.Add(c.step)
+	c.mu.Unlock()
+	return t
+}
+
+// Set sets current clock time
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// Add moves the clock by d
+func (c *Clock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
@@ -0,0 +1,232 @@
+// Package qlogtest provides an in-memory observer Output and helpers to
+// assert on what was logged.
+package qlogtest
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+)
+
+// LoggedEntry is a decoded copy of a processed qlog Entry
+type LoggedEntry struct {
+	Name    string
+	Level   uint8
+	Message string
+	Time    time.Time
+	// Fields merged from Notepad.Context, Logger.Context and Entry.Data
+	// in that order. Later keys override earlier ones.
+	Fields []qlog.F
+	Error  error
+}
+
+// Field returns value of the field with key
+func (e LoggedEntry) Field(key string) (interface{}, bool) {
+	for i := range e.Fields {
+		if e.Fields[i].Key == key {
+			return e.Fields[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// ContextMap returns entry fields as a map
+func (e LoggedEntry) ContextMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(e.Fields))
+	for i := range e.Fields {
+		m[e.Fields[i].Key] = e.Fields[i].Value
+	}
+	return m
+}
+
+// String returns one line text representation of the entry
+func (e LoggedEntry) String() string {
+	var b bytes.Buffer
+	b.WriteString(e.Time.Format(time.RFC3339Nano))
+	b.WriteByte('\t')
+	b.WriteString(qlog.InitLevel(e.Level).CapitalString())
+	if e.Name != "" {
+		b.WriteString("\t[" + e.Name + "]")
+	}
+	b.WriteByte('\t')
+	b.WriteString(e.Message)
+	for i := range e.Fields {
+		fmt.Fprintf(&b, "\t%s=%v", e.Fields[i].Key, e.Fields[i].Value)
+	}
+	if e.Error != nil {
+		b.WriteString("\terror=" + e.Error.Error())
+	}
+	return b.String()
+}
+
+// ObservedLogs is a concurrency-safe collection of observed entries
+type ObservedLogs struct {
+	mu   sync.RWMutex
+	logs []LoggedEntry
+}
+
+// Len returns the number of observed entries
+func (o *ObservedLogs) Len() int {
+	o.mu.RLock()
+	n := len(o.logs)
+	o.mu.RUnlock()
+	return n
+}
+
+// All returns a copy of all observed entries
+func (o *ObservedLogs) All() []LoggedEntry {
+	o.mu.RLock()
+	ret := make([]LoggedEntry, len(o.logs))
+	copy(ret, o.logs)
+	o.mu.RUnlock()
+	return ret
+}
+
+// TakeAll returns all observed entries and resets the collection
+func (o *ObservedLogs) TakeAll() []LoggedEntry {
+	o.mu.Lock()
+	ret := o.logs
+	o.logs = nil
+	o.mu.Unlock()
+	return ret
+}
+
+// FilterLevel returns entries logged at lvl
+func (o *ObservedLogs) FilterLevel(lvl uint8) *ObservedLogs {
+	return o.Filter(func(e LoggedEntry) bool {
+		return e.Level == lvl
+	})
+}
+
+// FilterMessage returns entries with the exact message
+func (o *ObservedLogs) FilterMessage(msg string) *ObservedLogs {
+	return o.Filter(func(e LoggedEntry) bool {
+		return e.Message == msg
+	})
+}
+
+// FilterMessageSnippet returns entries whose message contains snippet
+func (o *ObservedLogs) FilterMessageSnippet(snippet string) *ObservedLogs {
+	return o.Filter(func(e LoggedEntry) bool {
+		return strings.Contains(e.Message, snippet)
+	})
+}
+
+// FilterField returns entries having field with equal key and value
+func (o *ObservedLogs) FilterField(f qlog.F) *ObservedLogs {
+	return o.Filter(func(e LoggedEntry) bool {
+		v, ok := e.Field(f.Key)
+		return ok && reflect.DeepEqual(v, f.Value)
+	})
+}
+
+// FilterFieldKey returns entries having field with key
+func (o *ObservedLogs) FilterFieldKey(key string) *ObservedLogs {
+	return o.Filter(func(e LoggedEntry) bool {
+		_, ok := e.Field(key)
+		return ok
+	})
+}
+
+// Filter returns entries for which fn returns true
+func (o *ObservedLogs) Filter(fn func(LoggedEntry) bool) *ObservedLogs {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	filtered := &ObservedLogs{}
+	for _, e := range o.logs {
+		if fn(e) {
+			filtered.logs = append(filtered.logs, e)
+		}
+	}
+	return filtered
+}
+
+// String returns all entries one per line
+func (o *ObservedLogs) String() string {
+	var b bytes.Buffer
+	for _, e := range o.All() {
+		b.WriteString(e.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (o *ObservedLogs) add(e LoggedEntry) {
+	o.mu.Lock()
+	o.logs = append(o.logs, e)
+	o.mu.Unlock()
+}
+
+// DumpOnFailure prints observed entries with t.Log when the test fails
+func (o *ObservedLogs) DumpOnFailure(t testing.TB) {
+	t.Cleanup(func() {
+		if !t.Failed() || o.Len() == 0 {
+			return
+		}
+		t.Logf("captured logs:\n%s", o.String())
+	})
+}
+
+// New returns observer Output recording entries at lvl and above and
+// the collection it records to. Use it with Notepad.SetOutput.
+func New(lvl uint8) (func(*qlog.Notepad), *ObservedLogs) {
+	logs := &ObservedLogs{}
+	return func(np *qlog.Notepad) {
+		out := qlog.Output(func(e *qlog.Entry) {
+			logs.add(Decode(e))
+		})
+		for tlv, logger := range np.Loggers {
+			if *logger != nil && uint8(tlv) >= lvl {
+				(*logger).Output = append((*logger).Output, out)
+			}
+		}
+	}, logs
+}
+
+// NewNotepad returns Notepad named after the test with observer output
+// attached. Captured logs are printed only if the test fails.
+func NewNotepad(t testing.TB, lvl uint8, opts ...func(*qlog.LogConfig) error) (*qlog.Notepad, *ObservedLogs) {
+	out, logs := New(lvl)
+	np := qlog.New(t.Name(), lvl, opts...).SetOutput(out)
+	logs.DumpOnFailure(t)
+	return np, logs
+}
+
+// Decode copies e into LoggedEntry
+func Decode(e *qlog.Entry) LoggedEntry {
+	le := LoggedEntry{
+		Name:    string(e.Logger.Notepad.Name),
+		Level:   e.Logger.Level.Uint8(),
+		Message: string(e.Message),
+		Time:    e.Time,
+		Error:   e.ErrorFld,
+	}
+	n := len(e.Logger.Notepad.Context) + len(e.Logger.Context) + len(e.Data)
+	if n > 0 {
+		le.Fields = make([]qlog.F, 0, n)
+		le.Fields = merge(le.Fields, e.Logger.Notepad.Context)
+		le.Fields = merge(le.Fields, e.Logger.Context)
+		le.Fields = merge(le.Fields, e.Data)
+	}
+	return le
+}
+
+func merge(dst []qlog.F, data []qlog.Field) []qlog.F {
+next:
+	for i := range data {
+		for j := range dst {
+			if dst[j].Key == data[i].Key {
+				dst[j].Value = data[i].Value
+				continue next
+			}
+		}
+		dst = append(dst, qlog.F{Key: data[i].Key, Value: data[i].Value})
+	}
+	return dst
+}
@@ -0,0 +1,96 @@
+package qlogtest_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserver(t *testing.T) {
+	out, logs := qlogtest.New(qlog.InfoLevel)
+	np := qlog.New("obs", qlog.DebugLevel).SetOutput(out)
+	np.AddField(qlog.F{Key: "app", Value: "api"})
+	np.Debug("not observed")
+	np.INFO.Fields(qlog.F{Key: "user_id", Value: 42}).Msg("user logged in")
+	np.WithFields(qlog.F{Key: "app", Value: "worker"}).Warn("queue is slow")
+	np.Error("request failed")
+
+	all := logs.All()
+	if assert.Len(t, all, 3) {
+		assert.Equal(t, "obs", all[0].Name)
+		assert.Equal(t, qlog.InfoLevel, all[0].Level)
+		assert.Equal(t, "user logged in", all[0].Message)
+		assert.Equal(t, []qlog.F{{Key: "app", Value: "api"}, {Key: "user_id", Value: 42}}, all[0].Fields)
+		assert.False(t, all[0].Time.IsZero())
+		assert.Equal(t, map[string]interface{}{"app": "worker"}, all[1].ContextMap())
+		assert.Equal(t, errors.New("request failed"), all[2].Error)
+	}
+
+	assert.Equal(t, 1, logs.FilterLevel(qlog.WarnLevel).Len())
+	assert.Equal(t, 1, logs.FilterField(qlog.F{Key: "user_id", Value: 42}).Len())
+	assert.Equal(t, 0, logs.FilterField(qlog.F{Key: "user_id", Value: "42"}).Len())
+	assert.Equal(t, 2, logs.FilterField(qlog.F{Key: "app", Value: "api"}).Len())
+	assert.Equal(t, 3, logs.FilterFieldKey("app").Len())
+	assert.Equal(t, 1, logs.FilterMessageSnippet("slow").Len())
+	assert.Equal(t, 1, logs.FilterMessage("request failed").FilterLevel(qlog.ErrorLevel).Len())
+
+	assert.Len(t, logs.TakeAll(), 3)
+	assert.Equal(t, 0, logs.Len())
+}
+
+func TestObserver_Concurrent(t *testing.T) {
+	np, logs := qlogtest.NewNotepad(t, qlog.InfoLevel)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			for i := 0; i < 100; i++ {
+				np.INFO.Fields(qlog.F{Key: "i", Value: i}).Msg("concurrent")
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 800, logs.Len())
+	assert.Equal(t, 8, logs.FilterField(qlog.F{Key: "i", Value: 99}).Len())
+}
+
+type fakeTB struct {
+	testing.TB
+	failed   bool
+	cleanups []func()
+	logged   []string
+}
+
+func (f *fakeTB) Name() string      { return "fake" }
+func (f *fakeTB) Failed() bool      { return f.failed }
+func (f *fakeTB) Cleanup(fn func()) { f.cleanups = append(f.cleanups, fn) }
+func (f *fakeTB) Logf(s string, a ...interface{}) {
+	f.logged = append(f.logged, s)
+}
+
+func (f *fakeTB) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+func TestDumpOnFailure(t *testing.T) {
+	passed := &fakeTB{}
+	np, _ := qlogtest.NewNotepad(passed, qlog.InfoLevel)
+	np.Info("hidden")
+	passed.finish()
+	assert.Empty(t, passed.logged)
+
+	failed := &fakeTB{failed: true}
+	np, logs := qlogtest.NewNotepad(failed, qlog.InfoLevel)
+	np.Info("shown")
+	failed.finish()
+	assert.Len(t, failed.logged, 1)
+	assert.True(t, strings.Contains(logs.String(), "INFO\t[fake]\tshown"))
+}
@@ -0,0 +1,506 @@
+package qlog
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// RedactMode defines how a sensitive value is redacted
+type RedactMode uint8
+
+const (
+	// RedactFull replaces the value with Redactor.Mask
+	RedactFull RedactMode = iota
+	// RedactPartial masks the value keeping last Redaction.Keep chars
+	RedactPartial
+	// RedactHash replaces the value with salted SHA-256 hash
+	RedactHash
+	// RedactDrop removes the field. Matches in message text are fully masked
+	RedactDrop
+)
+
+// Redaction is a redaction strategy
+type Redaction struct {
+	Mode RedactMode
+	Keep int
+}
+
+var (
+	// MaskFull replaces values with Redactor.Mask
+	MaskFull = Redaction{Mode: RedactFull}
+	// HashSHA256 replaces values with "sha256:" and hex of salted hash
+	HashSHA256 = Redaction{Mode: RedactHash}
+	// DropField removes fields from the entry
+	DropField = Redaction{Mode: RedactDrop}
+)
+
+// MaskKeepLast masks values with '*' keeping last n chars
+func MaskKeepLast(n int) Redaction {
+	return Redaction{Mode: RedactPartial, Keep: n}
+}
+
+var (
+	// EmailPattern matches e-mail addresses
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// BearerTokenPattern matches HTTP bearer tokens
+	BearerTokenPattern = regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9\-._~+/]+=*`)
+	// CreditCardPattern matches credit card number candidates, they are
+	// redacted only if the Luhn checksum is valid
+	CreditCardPattern = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+	// AWSKeyPattern matches AWS access key ids
+	AWSKeyPattern = regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA)[0-9A-Z]{16}\b`)
+)
+
+type keyRule struct {
+	glob string
+	re   *regexp.Regexp
+	r    Redaction
+}
+
+type valueRule struct {
+	re    *regexp.Regexp
+	valid func(string) bool
+	r     Redaction
+}
+
+// Redactor masks sensitive field values and message text. Rules are
+// matched by exact key, key glob or regexp pattern, or value pattern.
+// Key rules take precedence over value rules. Redactor must not be
+// changed after it is set to a notepad.
+type Redactor struct {
+	// Mask replaces fully redacted values
+	Mask string
+
+	keys     map[string]Redaction
+	keyRules []keyRule
+	values   []valueRule
+	salt     []byte
+}
+
+// NewRedactor returns empty Redactor
+func NewRedactor() *Redactor {
+	return &Redactor{
+		Mask: "[REDACTED]",
+		keys: make(map[string]Redaction),
+	}
+}
+
+// Key adds rule redacting fields with key
+func (r *Redactor) Key(key string, red Redaction) *Redactor {
+	r.keys[key] = red
+	return r
+}
+
+// KeyGlob adds rule redacting fields with keys matching glob pattern
+// (see path.Match)
+func (r *Redactor) KeyGlob(pattern string, red Redaction) *Redactor {
+	r.keyRules = append(r.keyRules, keyRule{glob: pattern, r: red})
+	return r
+}
+
+// KeyRegexp adds rule redacting fields with keys matching re
+func (r *Redactor) KeyRegexp(re *regexp.Regexp, red Redaction) *Redactor {
+	r.keyRules = append(r.keyRules, keyRule{re: re, r: red})
+	return r
+}
+
+// Value adds rule redacting parts of values and message matching re
+func (r *Redactor) Value(re *regexp.Regexp, red Redaction) *Redactor {
+	r.values = append(r.values, valueRule{re: re, r: red})
+	return r
+}
+
+// Emails adds rule redacting e-mail addresses
+func (r *Redactor) Emails(red Redaction) *Redactor {
+	return r.Value(EmailPattern, red)
+}
+
+// BearerTokens adds rule redacting HTTP bearer tokens
+func (r *Redactor) BearerTokens(red Redaction) *Redactor {
+	return r.Value(BearerTokenPattern, red)
+}
+
+// CreditCards adds rule redacting credit card numbers with valid Luhn checksum
+func (r *Redactor) CreditCards(red Redaction) *Redactor {
+	r.values = append(r.values, valueRule{re: CreditCardPattern, valid: luhnValid, r: red})
+	return r
+}
+
+// AWSKeys adds rule redacting AWS access key ids
+func (r *Redactor) AWSKeys(red Redaction) *Redactor {
+	return r.Value(AWSKeyPattern, red)
+}
+
+// SetSalt sets the salt used by RedactHash. By default random salt is
+// generated when the redactor is set to a notepad.
+func (r *Redactor) SetSalt(salt []byte) *Redactor {
+	r.salt = append([]byte(nil), salt...)
+	return r
+}
+
+// withSalt returns redactor with salt, copying r if salt was not set
+func (r *Redactor) withSalt() *Redactor {
+	if r == nil || len(r.salt) > 0 {
+		return r
+	}
+	nr := *r
+	nr.salt = make([]byte, 16)
+	if _, err := rand.Read(nr.salt); err != nil {
+		panic("qlog: unable to generate redaction salt: " + err.Error())
+	}
+	return &nr
+}
+
+// Redact sets redactor to LogConfig
+func Redact(r *Redactor) func(*LogConfig) error {
+	return func(lc *LogConfig) error {
+		lc.Redactor = r.withSalt()
+		return nil
+	}
+}
+
+// SetRedactor sets redactor used by the notepad. Fields added before
+// are not redacted.
+func (np *Notepad) SetRedactor(r *Redactor) *Notepad {
+	r = r.withSalt()
+	np.update(func(s *Notepad) {
+		s.Options.Redactor = r
+	})
+	return np
+}
+
+func (r *Redactor) keyRedaction(key string) (Redaction, bool) {
+	if red, ok := r.keys[key]; ok {
+		return red, true
+	}
+	for i := range r.keyRules {
+		if r.keyRules[i].re != nil {
+			if r.keyRules[i].re.MatchString(key) {
+				return r.keyRules[i].r, true
+			}
+			continue
+		}
+		if ok, _ := path.Match(r.keyRules[i].glob, key); ok {
+			return r.keyRules[i].r, true
+		}
+	}
+	return Redaction{}, false
+}
+
+// RedactField returns redacted field. False is returned if the field
+// must be dropped.
+func (r *Redactor) RedactField(f F) (F, bool) {
+	return r.redactField(f, &LogConfig{Redactor: r})
+}
+
+// redactField redacts f, nested values are encoded with opts
+func (r *Redactor) redactField(f F, opts *LogConfig) (F, bool) {
+	if red, ok := r.keyRedaction(f.Key); ok {
+		if red.Mode == RedactDrop {
+			return f, false
+		}
+		f.Value = r.apply(red, valueString(f.Value))
+		return f, true
+	}
+	if len(r.values) == 0 && len(r.keyRules) == 0 && len(r.keys) == 0 {
+		return f, true
+	}
+	var keep bool
+	f.Value, keep = r.redactValue(f.Value, opts)
+	return f, keep
+}
+
+// RedactText returns s with all value rule matches redacted
+func (r *Redactor) RedactText(s string) string {
+	s, _ = r.redactString(s, true)
+	return s
+}
+
+func (r *Redactor) redactValue(v interface{}, opts *LogConfig) (interface{}, bool) {
+	switch val := v.(type) {
+	case nil, bool, float32, float64, int8, int16, int32, uint8, uint16, uint32,
+		time.Time, time.Duration, []time.Time, []time.Duration:
+		return v, true
+	case string:
+		return r.redactString(val, false)
+	case []byte:
+		s, keep := r.redactString(string(val), false)
+		return []byte(s), keep
+	case error:
+		if val == nil {
+			return v, true
+		}
+		msg := val.Error()
+		s, keep := r.redactString(msg, false)
+		if s == msg {
+			return v, keep
+		}
+		return errors.New(s), keep
+	case int:
+		return r.redactNumber(v, strconv.FormatInt(int64(val), 10))
+	case int64:
+		return r.redactNumber(v, strconv.FormatInt(val, 10))
+	case uint:
+		return r.redactNumber(v, strconv.FormatUint(uint64(val), 10))
+	case uint64:
+		return r.redactNumber(v, strconv.FormatUint(val, 10))
+	case []string:
+		res := make([]string, 0, len(val))
+		for i := range val {
+			if s, keep := r.redactString(val[i], false); keep {
+				res = append(res, s)
+			}
+		}
+		return res, true
+	case []error:
+		res := make([]error, 0, len(val))
+		for i := range val {
+			if err, keep := r.redactValue(val[i], opts); keep {
+				e, _ := err.(error)
+				res = append(res, e)
+			}
+		}
+		return res, true
+	}
+	if isFlat(v) {
+		return v, true
+	}
+	return r.redactNested(v, opts)
+}
+
+func (r *Redactor) redactNumber(v interface{}, s string) (interface{}, bool) {
+	rs, keep := r.redactString(s, false)
+	if rs == s {
+		return v, keep
+	}
+	return rs, keep
+}
+
+// redactNested walks JSON representation of v redacting nested keys and
+// values. v is encoded like field values are, so struct fields tagged
+// log:"-" and log:"redact" are removed and masked first.
+func (r *Redactor) redactNested(v interface{}, opts *LogConfig) (interface{}, bool) {
+	b := appendValue(nil, v, opts, 0)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return v, true
+	}
+	changed := false
+	tree, keep := r.redactTree(tree, &changed)
+	if !changed {
+		// Keep original value to preserve its encoding
+		return v, true
+	}
+	return tree, keep
+}
+
+func (r *Redactor) redactTree(v interface{}, changed *bool) (interface{}, bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, nv := range val {
+			if red, ok := r.keyRedaction(k); ok {
+				*changed = true
+				if red.Mode == RedactDrop {
+					delete(val, k)
+					continue
+				}
+				val[k] = r.apply(red, valueString(nv))
+				continue
+			}
+			nv, keep := r.redactTree(nv, changed)
+			if !keep {
+				delete(val, k)
+				continue
+			}
+			val[k] = nv
+		}
+		return val, true
+	case []interface{}:
+		res := val[:0]
+		for i := range val {
+			nv, keep := r.redactTree(val[i], changed)
+			if keep {
+				res = append(res, nv)
+			}
+		}
+		return res, true
+	case string:
+		s, keep := r.redactString(val, false)
+		if s != val || !keep {
+			*changed = true
+		}
+		return s, keep
+	case json.Number:
+		s, keep := r.redactString(string(val), false)
+		if s == string(val) && keep {
+			return val, true
+		}
+		*changed = true
+		return s, keep
+	}
+	return v, true
+}
+
+// redactString applies value rules to s. In text mode dropping rules
+// mask the match instead of dropping.
+func (r *Redactor) redactString(s string, text bool) (string, bool) {
+	for i := range r.values {
+		rule := &r.values[i]
+		loc := rule.re.FindStringIndex(s)
+		if loc == nil {
+			continue
+		}
+		if rule.r.Mode == RedactDrop && !text {
+			if rule.valid == nil || r.anyValid(rule, s) {
+				return "", false
+			}
+			continue
+		}
+		red := rule.r
+		if red.Mode == RedactDrop {
+			red = MaskFull
+		}
+		s = rule.re.ReplaceAllStringFunc(s, func(m string) string {
+			if rule.valid != nil && !rule.valid(m) {
+				return m
+			}
+			return r.apply(red, m)
+		})
+	}
+	return s, true
+}
+
+func (r *Redactor) anyValid(rule *valueRule, s string) bool {
+	for _, m := range rule.re.FindAllString(s, -1) {
+		if rule.valid(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) apply(red Redaction, s string) string {
+	switch red.Mode {
+	case RedactPartial:
+		n := utf8.RuneCountInString(s)
+		if n <= red.Keep {
+			return string(bytes.Repeat([]byte{'*'}, n))
+		}
+		runes := []rune(s)
+		for i := 0; i < n-red.Keep; i++ {
+			runes[i] = '*'
+		}
+		return string(runes)
+	case RedactHash:
+		h := sha256.New()
+		h.Write(r.salt)
+		h.Write([]byte(s))
+		sum := h.Sum(nil)
+		dst := make([]byte, 0, 7+2*len(sum))
+		dst = append(dst, "sha256:"...)
+		for _, b := range sum {
+			dst = append(dst, hexDigits[b>>4], hexDigits[b&0xF])
+		}
+		return string(dst)
+	default:
+		return r.Mask
+	}
+}
+
+// redactData redacts entry fields added with options of another
+// redactor
+func (r *Redactor) redactData(e *Entry, opts *LogConfig) {
+	for i := 0; i < len(e.Data); i++ {
+		if isLazy(e.Data[i].Value) {
+			continue
+		}
+		n := len(e.Data)
+		AddField(F{Key: e.Data[i].Key, Value: e.Data[i].Value}, &e.Data, opts)
+		if len(e.Data) < n {
+			i--
+		}
+	}
+}
+
+// redact applies redactor to message and error of the entry
+func (r *Redactor) redact(e *Entry) {
+	if len(r.values) == 0 {
+		return
+	}
+	msg := Bytes2Str(e.Message)
+	if s := r.RedactText(msg); s != msg {
+		e.Message = append(e.Message[:0], s...)
+	}
+	if e.ErrorFld != nil {
+		msg = e.ErrorFld.Error()
+		if s := r.RedactText(msg); s != msg {
+			e.ErrorFld = errors.New(s)
+		}
+	}
+}
+
+func valueString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case error:
+		if val == nil {
+			return "null"
+		}
+		return val.Error()
+	}
+	b := AppendInterface(nil, v, json.Marshal)
+	if len(b) > 1 && b[0] == '"' {
+		if s, err := strconv.Unquote(string(b)); err == nil {
+			return s
+		}
+	}
+	return string(b)
+}
+
+// isFlat reports whether v has no nested keys or strings to redact
+func isFlat(v interface{}) bool {
+	switch v.(type) {
+	case []bool, []int, []int8, []int16, []int32, []int64,
+		[]uint, []uint16, []uint32, []uint64, []float32, []float64:
+		return true
+	}
+	return false
+}
+
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
@@ -0,0 +1,327 @@
+package qlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReloadOptions configures Reloader
+type ReloadOptions struct {
+	// Interval is the config file polling interval, 0 disables polling
+	Interval time.Duration
+	// Signals trigger reload, e.g. syscall.SIGHUP
+	Signals []os.Signal
+	// OnError is called when reload fails, the running config is kept
+	OnError func(err error)
+	// OnReload is called after new config is applied
+	OnReload func(c *Config)
+}
+
+func defaultReloadOptions() *ReloadOptions {
+	return &ReloadOptions{
+		Interval: 5 * time.Second,
+		OnError: func(err error) {
+			fmt.Fprintf(os.Stderr, "qlog: config reload failed: %v\n", err)
+		},
+	}
+}
+
+// ReloadInterval sets config file polling interval
+func ReloadInterval(d time.Duration) func(*ReloadOptions) error {
+	return func(o *ReloadOptions) error {
+		if d < 0 {
+			return errors.New("qlog: negative reload interval")
+		}
+		o.Interval = d
+		return nil
+	}
+}
+
+// ReloadOnSignal reloads config when one of signals is received
+func ReloadOnSignal(sigs ...os.Signal) func(*ReloadOptions) error {
+	return func(o *ReloadOptions) error {
+		o.Signals = append(o.Signals, sigs...)
+		return nil
+	}
+}
+
+// Reloader keeps a live notepad configured by a config file. Levels,
+// outputs, hooks and sampling are swapped atomically on reload for the
+// notepad and all its WithFields descendants. Entries being written
+// during the swap are drained to the old outputs before they are closed.
+// Redaction rules of the config apply to the notepad and are replaced on
+// reload. Name, time format, field names and context are fixed at the
+// first load. Other LogConfig options set in code are not part of the
+// config and can not be reloaded.
+type Reloader struct {
+	path    string
+	opts    *ReloadOptions
+	np      *Notepad
+	current atomic.Value // *reloadGen
+	// level is the level of the running config, see gateLevel
+	level uint32
+
+	mu   sync.Mutex // serializes reloads
+	sum  [sha256.Size]byte
+	mod  time.Time
+	size int64
+
+	stop   chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+// reloadGen is a generation of outputs built from one config
+type reloadGen struct {
+	mu     sync.RWMutex
+	closed bool
+	np     *Notepad
+	config *Config
+}
+
+// NewReloader loads config file at path and starts watching it
+func NewReloader(path string, opts ...func(*ReloadOptions) error) (*Reloader, error) {
+	options := defaultReloadOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	r := &Reloader{path: path, opts: options}
+	c, err := r.read(true)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := buildGen(c)
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(gen)
+	r.level = uint32(c.Level)
+	r.np = New(c.Name, DebugLevel, c.applyLogConfig)
+	out := Output(r.forward)
+	r.np.SetOutput(func(s *Notepad) {
+		s.reloadLevel = &r.level
+		s.Options.Redactor = gen.np.Snapshot().Options.Redactor
+		for _, logger := range s.Loggers {
+			(*logger).Output = append((*logger).Output, out)
+		}
+		for _, k := range c.contextKeys() {
+			AddField(F{Key: k, Value: c.Context[k]}, &s.Context, &s.Options)
+		}
+	})
+	r.np.addCloser(r)
+	if options.Interval > 0 || len(options.Signals) > 0 {
+		r.stop, r.done = make(chan struct{}), make(chan struct{})
+		go r.watch(r.stop)
+	}
+	return r, nil
+}
+
+// Notepad returns the live notepad. Its loggers and the loggers of its
+// descendants are enabled from the level of the running config, which
+// changes on reload.
+func (r *Reloader) Notepad() *Notepad {
+	return r.np
+}
+
+// Config returns the running config
+func (r *Reloader) Config() *Config {
+	return r.current.Load().(*reloadGen).config
+}
+
+// Reload rereads config file and applies it even if it is unchanged
+func (r *Reloader) Reload() error {
+	return r.reload(true)
+}
+
+func (r *Reloader) reload(force bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return errors.New("qlog: reloader is closed")
+	}
+	c, err := r.read(force)
+	if err != nil || c == nil {
+		return err
+	}
+	gen, err := buildGen(c)
+	if err != nil {
+		return err
+	}
+	old := r.current.Load().(*reloadGen)
+	r.current.Store(gen)
+	r.setLevel(uint8(c.Level))
+	r.np.SetRedactor(gen.np.Snapshot().Options.Redactor)
+	// overrides removed from the file are cleared, others are kept
+	var stale []string
+	for name := range old.config.NameLevels {
+		if _, ok := c.NameLevels[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	if len(stale) > 0 {
+		mergeNameLevels(nil, stale)
+	}
+	err = old.drain()
+	if r.opts.OnReload != nil {
+		r.opts.OnReload(c)
+	}
+	return err
+}
+
+// setLevel sets level of the live notepad and its descendants
+func (r *Reloader) setLevel(lvl uint8) {
+	nameLevels.Lock()
+	atomic.StoreUint32(&r.level, uint32(lvl))
+	atomic.AddUint64(&nameLevelsVersion, 1)
+	nameLevels.Unlock()
+}
+
+// read parses config file, nil config is returned if file is unchanged
+func (r *Reloader) read(force bool) (*Config, error) {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+	if !force && fi.ModTime().Equal(r.mod) && fi.Size() == r.size {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	r.mod, r.size = fi.ModTime(), fi.Size()
+	sum := sha256.Sum256(data)
+	if !force && sum == r.sum {
+		return nil, nil
+	}
+	c, err := ParseConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	r.sum = sum
+	return c, nil
+}
+
+func (r *Reloader) watch(stop <-chan struct{}) {
+	defer close(r.done)
+	var tick <-chan time.Time
+	if r.opts.Interval > 0 {
+		t := time.NewTicker(r.opts.Interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	var sigs chan os.Signal
+	if len(r.opts.Signals) > 0 {
+		sigs = make(chan os.Signal, 1)
+		signal.Notify(sigs, r.opts.Signals...)
+		defer signal.Stop(sigs)
+	}
+	for {
+		var err error
+		select {
+		case <-stop:
+			return
+		case <-tick:
+			err = r.reload(false)
+		case <-sigs:
+			err = r.reload(true)
+		}
+		if err != nil && r.opts.OnError != nil {
+			r.opts.OnError(err)
+		}
+	}
+}
+
+// Close stops watching and closes outputs of the running config
+func (r *Reloader) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	stop := r.stop
+	r.stop = nil
+	r.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-r.done
+	}
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	return r.current.Load().(*reloadGen).drain()
+}
+
+// forward writes entry of the live notepad to the current generation
+func (r *Reloader) forward(e *Entry) {
+	for {
+		gen := r.current.Load().(*reloadGen)
+		// Drained generation is replaced before it is closed, unless
+		// the reloader itself is closed
+		if gen.write(e) || r.current.Load() == gen {
+			return
+		}
+	}
+}
+
+func buildGen(c *Config) (*reloadGen, error) {
+	np, err := c.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &reloadGen{np: np, config: c}, nil
+}
+
+// write processes entry like Entry.Process with generation sampler,
+// redactor, formatters, hooks and outputs. It returns false if
+// generation is already drained.
+func (g *reloadGen) write(e *Entry) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.closed {
+		return false
+	}
+	l := (*g.np.Loggers[e.Logger.Level.n]).current()
+	if l == nil {
+		return true
+	}
+	opts := &l.Notepad.Options
+	if s := opts.Sampler; s != nil && e.Logger.Level.n < PanicLevel && !s.Sample(e) {
+		return true
+	}
+	// entries of descendants derived before a reload have fields
+	// redacted by the previous rules
+	if r := opts.Redactor; r != nil && r != e.Logger.Notepad.Options.Redactor {
+		r.redactData(e, opts)
+	}
+	e.prepare(opts)
+	for _, frmt := range l.Notepad.Formatter {
+		frmt(e)
+	}
+	if len(l.Hooks) > 0 {
+		fireHooks(l.Hooks, e)
+		e.prepare(opts)
+	}
+	for i := range l.Output {
+		l.Output[i](e)
+	}
+	return true
+}
+
+// drain waits for entries being written and closes generation outputs
+func (g *reloadGen) drain() error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+	return g.np.Close()
+}
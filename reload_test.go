@@ -0,0 +1,201 @@
+package qlog_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeReloadConfig(t *testing.T, path, level, out string) {
+	cfg := fmt.Sprintf(`{"name": "svc", "level": %q, "outputs": [
+		{"type": "file", "path": %q, "format": "template",
+		 "template": "${LEVEL} ${message}\n", "level": %q}]}`, level, out, level)
+	if err := ioutil.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLog(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	cfg := filepath.Join(dir, "log.json")
+	first, second := filepath.Join(dir, "first.log"), filepath.Join(dir, "second.log")
+	writeReloadConfig(t, cfg, "info", first)
+
+	var reloads int
+	r, err := qlog.NewReloader(cfg, qlog.ReloadInterval(0), func(o *qlog.ReloadOptions) error {
+		o.OnReload = func(c *qlog.Config) { reloads++ }
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	np := r.Notepad()
+	child := np.WithFields(qlog.F{Key: "req", Value: 1})
+	np.Debug("hidden")
+	np.Info("one")
+	child.Warn("two")
+	assert.False(t, np.Enabled(qlog.DebugLevel))
+	assert.False(t, child.Enabled(qlog.DebugLevel))
+	assert.True(t, child.Enabled(qlog.InfoLevel))
+
+	writeReloadConfig(t, cfg, "debug", second)
+	assert.NoError(t, r.Reload())
+	assert.True(t, np.Enabled(qlog.DebugLevel))
+	assert.True(t, child.Enabled(qlog.DebugLevel))
+	np.Debug("three")
+	child.Info("four")
+	assert.Equal(t, 1, reloads)
+	assert.Equal(t, qlog.ConfigLevel(qlog.DebugLevel), r.Config().Level)
+
+	assert.NoError(t, ioutil.WriteFile(cfg, []byte(`{"level": "loud"}`), 0644))
+	assert.Error(t, r.Reload())
+	np.Info("five")
+
+	writeReloadConfig(t, cfg, "error", second)
+	assert.NoError(t, r.Reload())
+	assert.False(t, child.Enabled(qlog.WarnLevel))
+	child.Warn("dropped")
+
+	assert.NoError(t, np.Close())
+	np.Info("after close")
+	assert.Equal(t, "INFO one\nWARN two\n", readLog(t, first))
+	assert.Equal(t, "DEBUG three\nINFO four\nINFO five\n", readLog(t, second))
+}
+
+func TestReloader_NameLevels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer qlog.SetNameLevels("")
+	cfg := filepath.Join(dir, "log.json")
+	write := func(levels string) {
+		data := `{"name": "rl", "outputs": [{"type": "json", "out": "discard"}], "name_levels": {` + levels + `}}`
+		if err := ioutil.WriteFile(cfg, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`"rl.db": "debug", "rl.http": "warn"`)
+	qlog.SetNameLevel("other", qlog.ErrorLevel)
+	r, err := qlog.NewReloader(cfg, qlog.ReloadInterval(0))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	assert.Equal(t, map[string]uint8{"other": qlog.ErrorLevel, "rl.db": qlog.DebugLevel,
+		"rl.http": qlog.WarnLevel}, qlog.NameLevels())
+
+	write(`"rl.db": "info"`)
+	assert.NoError(t, r.Reload())
+	assert.Equal(t, map[string]uint8{"other": qlog.ErrorLevel, "rl.db": qlog.InfoLevel}, qlog.NameLevels())
+}
+
+func TestReloader_RedactAndHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	qlog.RegisterHook("test-reload-hook", func(opts qlog.ConfigOptions) (qlog.Hook, error) {
+		return qlog.HookFunc(func(e *qlog.Entry) error {
+			e.Fields(qlog.F{Key: "n", Value: func() interface{} { return 2 }})
+			e.Message = append(e.Message, " by bob@example.com"...)
+			return nil
+		}), nil
+	})
+	cfg, out := filepath.Join(dir, "log.json"), filepath.Join(dir, "out.log")
+	write := func(extra string) {
+		data := fmt.Sprintf(`{"name": "svc", "outputs": [{"type": "file", "path": %q, "format": "template",
+			"template": "${message} ${fields}\n"}]%s}`, out, extra)
+		if err := ioutil.WriteFile(cfg, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("")
+	r, err := qlog.NewReloader(cfg, qlog.ReloadInterval(0))
+	if !assert.NoError(t, err) {
+		return
+	}
+	np := r.Notepad()
+	defer np.Close()
+	child := np.WithFields(qlog.F{Key: "req", Value: 1})
+	child.INFO.Fields(qlog.F{Key: "password", Value: "p1"}).Msg("to jane@example.com")
+
+	write(`, "redact": {"keys": ["pass*"], "values": ["emails"]}, "hooks": [{"type": "test-reload-hook"}]`)
+	assert.NoError(t, r.Reload())
+	child.INFO.Fields(qlog.F{Key: "password", Value: "p2"}).Msg("to jane@example.com")
+	np.INFO.Fields(qlog.F{Key: "password", Value: "p3"}).Msg("plain")
+	assert.NoError(t, np.Close())
+	assert.Equal(t, "to jane@example.com {\"req\":1,\"password\":\"p1\"}\n"+
+		"to [REDACTED] by [REDACTED] {\"req\":1,\"password\":\"[REDACTED]\",\"n\":2}\n"+
+		"plain by [REDACTED] {\"password\":\"[REDACTED]\",\"n\":2}\n", readLog(t, out))
+
+	_, err = qlog.FromConfig(strings.NewReader(`{"redact": {"mode": "blur"}}`))
+	assert.EqualError(t, err, `qlog: unknown redact mode "blur"`)
+	_, err = qlog.FromConfig(strings.NewReader(`{"redact": {"values": ["phones"]}}`))
+	assert.EqualError(t, err, `qlog: unknown redact value pattern "phones"`)
+}
+
+func TestReloader_Concurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qlog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	cfg := filepath.Join(dir, "log.json")
+	writeReloadConfig(t, cfg, "info", filepath.Join(dir, "0.log"))
+	r, err := qlog.NewReloader(cfg, qlog.ReloadInterval(time.Millisecond),
+		func(o *qlog.ReloadOptions) error {
+			o.OnError = func(err error) { t.Error(err) }
+			return nil
+		})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	const workers, entries = 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(np *qlog.Notepad) {
+			defer wg.Done()
+			for i := 0; i < entries; i++ {
+				np.Info("entry")
+			}
+		}(r.Notepad().WithFields(qlog.F{Key: "w", Value: w}))
+	}
+	for i := 1; i <= 5; i++ {
+		writeReloadConfig(t, cfg, "info", filepath.Join(dir, fmt.Sprintf("%d.log", i)))
+		assert.NoError(t, r.Reload())
+	}
+	wg.Wait()
+	assert.NoError(t, r.Close())
+
+	var total int
+	for i := 0; i <= 5; i++ {
+		total += bytes.Count([]byte(readLog(t, filepath.Join(dir, fmt.Sprintf("%d.log", i)))), []byte("\n"))
+	}
+	assert.Equal(t, workers*entries, total)
+}
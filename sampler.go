@@ -0,0 +1,87 @@
+package qlog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Sampler decides whether an entry is processed. Dropped entries are
+// not seen by formatters, hooks or outputs. Panic and fatal entries are
+// never sampled.
+type Sampler interface {
+	Sample(e *Entry) bool
+}
+
+const _samplerSize = 4096
+
+type sampleCounter struct {
+	resetAt int64
+	n       uint64
+}
+
+func (c *sampleCounter) inc(t time.Time, tick time.Duration) uint64 {
+	tn := t.UnixNano()
+	resetAt := atomic.LoadInt64(&c.resetAt)
+	if resetAt > tn {
+		return atomic.AddUint64(&c.n, 1)
+	}
+	atomic.StoreUint64(&c.n, 1)
+	newResetAt := tn + int64(tick)
+	if !atomic.CompareAndSwapInt64(&c.resetAt, resetAt, newResetAt) {
+		// We raced with another goroutine trying to reset, and it also reset
+		// the counter to 1, so we need to reincrement the counter.
+		return atomic.AddUint64(&c.n, 1)
+	}
+	return 1
+}
+
+type countSampler struct {
+	tick       time.Duration
+	first      uint64
+	thereafter uint64
+	counts     [_maxLevel + 1][_samplerSize]sampleCounter
+}
+
+// NewSampler returns Sampler logging the first entries with the same
+// level and message each tick, and every thereafter-th entry after that.
+// Zero thereafter drops all entries after the first ones.
+func NewSampler(tick time.Duration, first, thereafter int) Sampler {
+	return &countSampler{
+		tick:       tick,
+		first:      uint64(first),
+		thereafter: uint64(thereafter),
+	}
+}
+
+func (s *countSampler) Sample(e *Entry) bool {
+	lvl := e.Logger.Level.n
+	if lvl > _maxLevel {
+		return true
+	}
+	n := s.counts[lvl][fnv32a(e.Message)%_samplerSize].inc(e.Time, s.tick)
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// Sampling sets sampler to LogConfig, see NewSampler
+func Sampling(tick time.Duration, first, thereafter int) func(*LogConfig) error {
+	return func(lc *LogConfig) error {
+		lc.Sampler = NewSampler(tick, first, thereafter)
+		return nil
+	}
+}
+
+func fnv32a(b []byte) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	hash := uint32(offset32)
+	for _, c := range b {
+		hash ^= uint32(c)
+		hash *= prime32
+	}
+	return hash
+}
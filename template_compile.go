@@ -0,0 +1,498 @@
+package qlog
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// template node kinds
+const (
+	nodeText uint8 = iota
+	nodeTag
+	nodeIf
+	nodeEnd
+)
+
+// template tags
+const (
+	tagCustom uint8 = iota
+	tagName
+	tagTime
+	tagLevel
+	tagMessage
+	tagError
+	tagFields
+	tagField
+	tagRest
+)
+
+type tmplNode struct {
+	kind uint8
+	tag  uint8
+	// src is the tag source without section prefix
+	src  string
+	text []byte
+	// key of tagField
+	key string
+	// fn renders tagCustom named name
+	fn   TagFunc
+	name string
+	// present is set for tagCustom never empty, see builtinPresent
+	present bool
+	def     []byte
+	hasDef  bool
+	// layout of tagTime, empty uses entry time
+	layout string
+	upper  bool
+	align  byte
+	width  int
+	// prec truncates value to prec runes, -1 keeps it
+	prec int
+	// neg renders section of nodeIf if the value is missing
+	neg bool
+	// end is index of nodeEnd closing nodeIf
+	end int
+}
+
+type compiledTemplate struct {
+	nodes []tmplNode
+	// used are keys of tagField excluded from tagRest
+	used []string
+	// hasError excludes error field from tagRest
+	hasError bool
+}
+
+// compileTemplate parses template of text and ${...} tags:
+//
+//	${tag}               value of tag
+//	${tag:<8} ${tag:.10} align left, right (>) or center (^) to width,
+//	                     truncate to precision
+//	${time:15:04:05}     time layout, also Unix, UnixMilli, UnixMicro, UnixNano
+//	${fields.key}        single field, ${fields.-} remaining fields
+//	${tag|default}       default of missing value, before format spec
+//	${?tag}...${/}       section rendered if value is present,
+//	${!tag}...${/}       if value is missing
+//	$${                  literal "${"
+func compileTemplate(src string, o *TemplateOptions) (*compiledTemplate, error) {
+	t := &compiledTemplate{}
+	var stack []int
+	s := src
+	for len(s) > 0 {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			t.addText(s)
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			t.addText(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		t.addText(s[:i])
+		s = s[i+2:]
+		j := strings.IndexByte(s, '}')
+		if j < 0 {
+			return nil, fmt.Errorf("qlog: template: unclosed tag at offset %d", len(src)-len(s)-2)
+		}
+		tag := s[:j]
+		s = s[j+1:]
+		if tag == "" {
+			return nil, fmt.Errorf("qlog: template: empty tag at offset %d", len(src)-len(s)-3)
+		}
+		switch tag[0] {
+		case '/':
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("qlog: template: unexpected ${%s}", tag)
+			}
+			open := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(tag) > 1 && tag[1:] != t.nodes[open].src {
+				return nil, fmt.Errorf("qlog: template: ${%s} closes ${%s}", tag, t.nodes[open].src)
+			}
+			t.nodes[open].end = len(t.nodes)
+			t.nodes = append(t.nodes, tmplNode{kind: nodeEnd})
+		case '?', '!':
+			n, err := parseTag(tag[1:], o)
+			if err != nil {
+				return nil, err
+			}
+			n.kind, n.neg = nodeIf, tag[0] == '!'
+			stack = append(stack, len(t.nodes))
+			t.addTag(n)
+		default:
+			n, err := parseTag(tag, o)
+			if err != nil {
+				return nil, err
+			}
+			t.addTag(n)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("qlog: template: unclosed section ${%s}", t.nodes[stack[len(stack)-1]].src)
+	}
+	return t, nil
+}
+
+// uses reports whether the template has custom tag name
+func (t *compiledTemplate) uses(name string) bool {
+	for i := range t.nodes {
+		if t.nodes[i].fn != nil && t.nodes[i].name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *compiledTemplate) addText(s string) {
+	if s == "" {
+		return
+	}
+	if n := len(t.nodes); n > 0 && t.nodes[n-1].kind == nodeText {
+		t.nodes[n-1].text = append(t.nodes[n-1].text, s...)
+		return
+	}
+	t.nodes = append(t.nodes, tmplNode{kind: nodeText, text: []byte(s)})
+}
+
+func (t *compiledTemplate) addTag(n tmplNode) {
+	switch n.tag {
+	case tagField:
+		t.used = append(t.used, n.key)
+	case tagError:
+		t.hasError = true
+	}
+	t.nodes = append(t.nodes, n)
+}
+
+// parseTag parses name[|default][:spec]
+func parseTag(src string, o *TemplateOptions) (tmplNode, error) {
+	n := tmplNode{kind: nodeTag, src: src, prec: -1}
+	name, rest := src, ""
+	if k := strings.IndexAny(src, ":|"); k >= 0 {
+		name, rest = src[:k], src[k:]
+	}
+	if rest != "" && rest[0] == '|' {
+		rest = rest[1:]
+		def := rest
+		if k := strings.IndexByte(rest, ':'); k >= 0 {
+			def, rest = rest[:k], rest[k:]
+		} else {
+			rest = ""
+		}
+		n.def, n.hasDef = []byte(def), true
+	}
+	if name == "" {
+		return n, fmt.Errorf("qlog: template: empty tag name in ${%s}", src)
+	}
+	r, _ := utf8.DecodeRuneInString(name)
+	n.upper = unicode.IsUpper(r)
+	base := strings.ToLower(name)
+	if k := strings.IndexByte(name, '.'); k >= 0 && strings.ToLower(name[:k]) == o.FieldsName {
+		base, n.key = o.FieldsName, name[k+1:]
+	}
+	switch base {
+	case o.LogName:
+		n.tag = tagName
+	case o.TimestampName:
+		n.tag = tagTime
+	case o.LevelName:
+		n.tag = tagLevel
+	case o.MessageName:
+		n.tag = tagMessage
+	case o.ErrorName:
+		n.tag = tagError
+	case o.FieldsName:
+		switch n.key {
+		case "":
+			n.tag = tagFields
+		case "-":
+			n.tag = tagRest
+		default:
+			n.tag = tagField
+		}
+	default:
+		fn, ok := o.Tags[base]
+		if !ok || fn == nil {
+			return n, fmt.Errorf("qlog: template: unknown tag ${%s}", src)
+		}
+		n.tag, n.fn, n.name, n.present = tagCustom, fn, base, o.present[base]
+	}
+	if rest == "" {
+		return n, nil
+	}
+	spec := rest[1:]
+	if n.tag == tagTime {
+		n.layout = spec
+		return n, nil
+	}
+	if err := n.parseSpec(spec); err != nil {
+		return n, fmt.Errorf("qlog: template: %v in ${%s}", err, src)
+	}
+	return n, nil
+}
+
+// parseSpec parses [<>^][width][.precision]
+func (n *tmplNode) parseSpec(spec string) error {
+	s := spec
+	if s != "" && (s[0] == '<' || s[0] == '>' || s[0] == '^') {
+		n.align, s = s[0], s[1:]
+	}
+	w := s
+	if k := strings.IndexByte(s, '.'); k >= 0 {
+		w = s[:k]
+		p, err := strconv.Atoi(s[k+1:])
+		if err != nil || p < 0 {
+			return fmt.Errorf("invalid precision %q", s[k+1:])
+		}
+		n.prec = p
+	}
+	if w != "" {
+		width, err := strconv.Atoi(w)
+		if err != nil || width < 0 {
+			return fmt.Errorf("invalid width %q", w)
+		}
+		n.width = width
+	}
+	return nil
+}
+
+func (t *TemplateEncoder) render(dst []byte, e *Entry, fields []byte) []byte {
+	nodes := t.t.nodes
+	for i := 0; i < len(nodes); i++ {
+		n := &nodes[i]
+		switch n.kind {
+		case nodeText:
+			dst = append(dst, n.text...)
+		case nodeIf:
+			if t.present(n, e, fields) == n.neg {
+				i = n.end
+			}
+		case nodeTag:
+			start := len(dst)
+			dst = t.appendTag(dst, n, e, fields)
+			if n.upper && n.tag != tagLevel {
+				dst = upperTail(dst, start)
+			}
+			if len(dst) == start && n.hasDef {
+				dst = append(dst, n.def...)
+			}
+			dst = padTail(dst, start, n)
+			if t.color {
+				dst = appendColored(dst, start, t.tagColor(n, e))
+			}
+		}
+	}
+	return dst
+}
+
+// tagColor returns theme color of tag
+func (t *TemplateEncoder) tagColor(n *tmplNode, e *Entry) string {
+	switch n.tag {
+	case tagLevel:
+		return t.theme.Levels[e.Logger.Level.n]
+	case tagTime:
+		return t.theme.Time
+	case tagName:
+		return t.theme.Name
+	case tagMessage:
+		if t.theme.Message == "" && e.Logger.Level.n >= ErrorLevel {
+			return t.theme.Levels[e.Logger.Level.n]
+		}
+		return t.theme.Message
+	case tagError:
+		return t.theme.Error
+	}
+	return ""
+}
+
+// present reports if tag value is not missing
+func (t *TemplateEncoder) present(n *tmplNode, e *Entry, fields []byte) bool {
+	switch n.tag {
+	case tagName:
+		return len(e.Logger.Notepad.Name) > 0
+	case tagTime, tagLevel:
+		return true
+	case tagMessage:
+		return len(e.Message) > 0
+	case tagError:
+		if e.ErrorFld != nil {
+			return true
+		}
+		_, ok := lookupField(e, e.Logger.Notepad.Options.ErrorFieldName)
+		return ok
+	case tagFields:
+		return len(fields) > 2
+	case tagField:
+		_, ok := lookupField(e, n.key)
+		return ok
+	case tagCustom:
+		if n.present {
+			return true
+		}
+		buf := bytesPool.Get().(*[]byte)
+		*buf = n.fn((*buf)[:0], e)
+		ok := len(*buf) > 0
+		bytesPool.Put(buf)
+		return ok
+	case tagRest:
+		for _, data := range [3][]Field{e.Logger.Notepad.Context, e.Logger.Context, e.Data} {
+			for i := range data {
+				if !t.isUsed(data[i].Key, e) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (t *TemplateEncoder) appendTag(dst []byte, n *tmplNode, e *Entry, fields []byte) []byte {
+	switch n.tag {
+	case tagCustom:
+		return n.fn(dst, e)
+	case tagName:
+		return append(dst, e.Logger.Notepad.Name...)
+	case tagTime:
+		if n.layout != "" {
+			return appendEntryTime(dst, e.Time, n.layout)
+		}
+		return append(dst, e.bufferTime...)
+	case tagLevel:
+		if n.upper {
+			return append(dst, e.Logger.Level.CapitalBytes()...)
+		}
+		return append(dst, e.Logger.Level.ToBytes()...)
+	case tagMessage:
+		return append(dst, e.Message...)
+	case tagError:
+		if e.ErrorFld != nil {
+			return append(dst, e.ErrorFld.Error()...)
+		}
+		if f, ok := lookupField(e, e.Logger.Notepad.Options.ErrorFieldName); ok {
+			return appendFieldText(dst, f)
+		}
+	case tagFields:
+		if len(fields) > 2 || !n.hasDef {
+			return append(dst, fields...)
+		}
+	case tagField:
+		if f, ok := lookupField(e, n.key); ok {
+			return appendFieldText(dst, f)
+		}
+	case tagRest:
+		start := len(dst)
+		dst = t.appendRest(append(dst, '{'), e)
+		if len(dst) == start+1 && n.hasDef {
+			return dst[:start]
+		}
+		return append(dst, '}')
+	}
+	return dst
+}
+
+// appendRest appends fields not rendered by other tags
+func (t *TemplateEncoder) appendRest(dst []byte, e *Entry) []byte {
+	for _, data := range [3][]Field{e.Logger.Notepad.Context, e.Logger.Context, e.Data} {
+		for i := range data {
+			if t.isUsed(data[i].Key, e) {
+				continue
+			}
+			dst = t.AddField(dst, &data[i])
+		}
+	}
+	return dst
+}
+
+func (t *TemplateEncoder) isUsed(key string, e *Entry) bool {
+	if t.t.hasError && key == e.Logger.Notepad.Options.ErrorFieldName {
+		return true
+	}
+	for _, k := range t.t.used {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupField returns field of entry, logger or notepad in this order
+func lookupField(e *Entry, key string) (*Field, bool) {
+	for _, data := range [3][]Field{e.Data, e.Logger.Context, e.Logger.Notepad.Context} {
+		for i := len(data) - 1; i >= 0; i-- {
+			if data[i].Key == key {
+				return &data[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// appendFieldText appends strings and errors unquoted, other values
+// json encoded
+func appendFieldText(dst []byte, f *Field) []byte {
+	switch v := f.Value.(type) {
+	case string:
+		return append(dst, v...)
+	case error:
+		if !isNilPtr(v) {
+			return append(dst, v.Error()...)
+		}
+	}
+	return append(dst, f.Buffer.Bytes()...)
+}
+
+// upperTail converts dst[start:] to upper case
+func upperTail(dst []byte, start int) []byte {
+	tail := dst[start:]
+	for i, c := range tail {
+		if c >= utf8.RuneSelf {
+			return append(dst[:start], bytes.ToUpper(tail)...)
+		}
+		if 'a' <= c && c <= 'z' {
+			tail[i] = c - 'a' + 'A'
+		}
+	}
+	return dst
+}
+
+// padTail truncates dst[start:] to precision and pads it to width
+func padTail(dst []byte, start int, n *tmplNode) []byte {
+	if n.prec < 0 && n.width == 0 {
+		return dst
+	}
+	count := utf8.RuneCount(dst[start:])
+	if n.prec >= 0 && count > n.prec {
+		k := start
+		for i := 0; i < n.prec; i++ {
+			_, size := utf8.DecodeRune(dst[k:])
+			k += size
+		}
+		dst, count = dst[:k], n.prec
+	}
+	pad := n.width - count
+	if pad <= 0 {
+		return dst
+	}
+	left := 0
+	switch n.align {
+	case '>':
+		left = pad
+	case '^':
+		left = pad / 2
+	}
+	end := len(dst)
+	for i := 0; i < pad; i++ {
+		dst = append(dst, ' ')
+	}
+	if left > 0 {
+		copy(dst[start+left:], dst[start:end])
+		for i := start; i < start+left; i++ {
+			dst[i] = ' '
+		}
+	}
+	return dst
+}
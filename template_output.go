@@ -3,14 +3,9 @@ package qlog
 import (
 	"bytes"
 	"fmt"
-
-	"github.com/karantin2020/fasttemplate"
 	// "github.com/karantin2020/qlog/buffer"
 	"io"
 	"os"
-	"strings"
-	"sync"
-	"unicode"
 	"unsafe"
 )
 
@@ -27,46 +22,31 @@ type TemplateOptions struct {
 	FieldsName      string
 	FieldsStyle     string
 	FieldsSeparator byte
-
-	upperTags map[string]bool
+	// Tags are custom tags by lower case name, built-in tags are pid,
+	// hostname, goroutine, seq, elapsed and caller
+	Tags map[string]TagFunc
+	// Color enables colors of level, time, name, message, error and field
+	// keys. It is decided for OutHandle and ErrHandle separately.
+	Color ColorMode
+	// Theme sets colors, nil is DefaultTheme
+	Theme *Theme
+
+	// elapsed is the built-in elapsed tag
+	elapsed *elapsedTag
+	// present are built-in tags present without rendering
+	present map[string]bool
 }
 
 var (
-	bPool *sync.Pool
-)
-
-var (
-	DefaultTemplate = Template("[${name}] ${time}\t${LEVEL}\t${message}\t${fields}\n")
-	ColorTemplate   = Template("[${name}] \x1b[36m${time}\x1b[0m\t\x1b[33m${LEVEL}\x1b[0m\t\x1b[32m${message}\x1b[0m\t${fields}\n")
+	DefaultTemplate = MustTemplate("[${name}] ${time}\t${LEVEL}\t${message}\t${fields}\n")
+	// ColorTemplate colors terminal outputs with DefaultTheme
+	ColorTemplate = MustTemplate("[${name}] ${time}\t${LEVEL}\t${message}\t${fields}\n",
+		func(o *TemplateOptions) error {
+			o.Color = ColorAuto
+			return nil
+		})
 )
 
-type iBuffer struct {
-	fb  []byte
-	fbt [256]byte
-}
-
-func init() {
-	bPool = &sync.Pool{
-		New: func() interface{} {
-			return new(iBuffer)
-		},
-	}
-}
-
-func newBuffer() *iBuffer {
-	buf := bPool.Get().(*iBuffer)
-	buf.reset()
-	return buf
-}
-
-func (b *iBuffer) reset() {
-	b.fb = b.fbt[:0]
-}
-
-func (b *iBuffer) free() {
-	bPool.Put(b)
-}
-
 type Pair struct {
 	Key   []byte
 	Value []byte
@@ -108,96 +88,118 @@ func (pl PairList) JSONByte(fb []byte, separator byte) []byte {
 	return fb
 }
 
-func Template(template string, opts ...func(*TemplateOptions) error) func(np *Notepad) {
-	options := defaultTemplateOptions()
-	for i := range opts {
-		_ = opts[i](options)
+// Template returns notepad option adding outputs of TemplateEncoder, or
+// error if template does not compile
+func Template(template string, opts ...func(*TemplateOptions) error) (func(np *Notepad), error) {
+	enc, err := NewTemplateEncoder(template, opts...)
+	if err != nil {
+		return nil, err
+	}
+	options := enc.opts
+	if err := checkLevels(options.OutLevel, options.ErrLevel); err != nil {
+		return nil, fmt.Errorf("qlog: output *qlog.TemplateEncoder: %v", err)
+	}
+	return func(np *Notepad) {
+		if enc.t.uses("elapsed") {
+			options.elapsed.setStart(np.Options.TimestampFunc())
+		}
+		routeOutputs(np, "*qlog.TemplateEncoder", options.OutLevel, options.ErrLevel, func(c *outputCounter) (Output, Output) {
+			return newOutput(enc.forWriter(options.OutHandle), AddSync(options.OutHandle), c),
+				newOutput(enc.forWriter(options.ErrHandle), AddSync(options.ErrHandle), c)
+		})
+	}, nil
+}
+
+// MustTemplate is Template panicking if template does not compile
+func MustTemplate(template string, opts ...func(*TemplateOptions) error) func(np *Notepad) {
+	fn, err := Template(template, opts...)
+	if err != nil {
+		panic(err.Error())
 	}
-	t, err := fasttemplate.NewTemplate(template, "${", "}")
-	emptyByteSlice := []byte{}
+	return fn
+}
+
+// TemplateEncoder renders entries with a template, see README for the
+// syntax. Tags name entry name, time, level, message, error, fields
+// object or a single field as set in TemplateOptions.
+type TemplateEncoder struct {
+	t     *compiledTemplate
+	opts  *TemplateOptions
+	color bool
+	theme *Theme
+}
 
-	// Assumption that all tags with starting upper case letter
-	// have all upper case letters
-	for k := range t.Tags {
-		for _, r := range t.Tags[k] {
-			if unicode.IsUpper(r) {
-				t.Tags[k] = strings.ToLower(t.Tags[k])
-				options.upperTags[t.Tags[k]] = true
-			}
-			break
+// NewTemplateEncoder compiles template and returns its encoder
+func NewTemplateEncoder(template string, opts ...func(*TemplateOptions) error) (*TemplateEncoder, error) {
+	options := defaultTemplateOptions()
+	for i := range opts {
+		if err := opts[i](options); err != nil {
+			return nil, err
 		}
-		continue
 	}
+	t, err := compileTemplate(template, options)
 	if err != nil {
-		panic("unexpected error when parsing template: " + err.Error())
+		return nil, err
 	}
-	return func(np *Notepad) {
-		if options.OutLevel > _maxLevel || options.OutLevel < _minLevel || options.OutLevel < np.Level.n {
-			panic("OutLevel is out of range")
-		}
-		if options.ErrLevel > _maxLevel || options.ErrLevel < _minLevel || options.ErrLevel < np.Level.n {
-			panic("ErrLevel is out of range")
-		}
-		if options.OutLevel > options.ErrLevel {
-			panic("OutLevel is higher than errLevel")
-		}
-		tmpOut := func(wio io.Writer, topts *TemplateOptions) Output {
-			return func(e *Entry) {
-				buf := newBuffer()
-				// buf.bb = buf.bb[:len(t.Tags)]
-				// fmt.Printf("\nStart\n%#v\n\n", buf.pl)
-				// fmt.Printf("%#v\n", e.Time)
-				// fmt.Printf("%s\n", e.bufferTime)
-				buf.fb = GetEntryFields(e, buf.fb, topts.FieldsSeparator)
-				_, err := t.ExecuteFunc(wio, func(w io.Writer, tag string) (int, error) {
-					upper := options.upperTags[tag]
-					var outBytes []byte
-					switch tag {
-					case topts.LogName:
-						outBytes = e.Logger.Notepad.Name
-					case topts.TimestampName:
-						outBytes = e.bufferTime
-					case topts.LevelName:
-						if upper {
-							outBytes = e.Logger.Level.CapitalBytes()
-							upper = false
-						} else {
-							outBytes = e.Logger.Level.ToBytes()
-						}
-					case topts.MessageName:
-						outBytes = e.Message
-					case topts.ErrorName:
-						outBytes = Str2Bytes(e.ErrorFld.Error())
-					case topts.FieldsName:
-						outBytes = buf.fb
-					default:
-						outBytes = emptyByteSlice
-					}
-					if upper {
-						return w.Write(bytes.ToUpper(outBytes))
-					}
-					return w.Write(outBytes)
-				})
-				buf.free()
-				if err != nil {
-					panic(fmt.Sprintf("qlog template logging error: %s", err))
-				}
-			}
-		}
-		for tlv, logger := range np.Loggers {
-			level := uint8(tlv)
-			switch {
-			case level >= options.ErrLevel:
-				(*logger).Output = append((*logger).Output, tmpOut(options.ErrHandle, options))
+	enc := &TemplateEncoder{t: t, opts: options, color: options.Color == ColorAlways, theme: options.Theme}
+	if enc.theme == nil {
+		enc.theme = &DefaultTheme
+	}
+	return enc, nil
+}
 
-			case level >= options.OutLevel:
-				(*logger).Output = append((*logger).Output, tmpOut(options.OutHandle, options))
-			}
-		}
+// forWriter returns encoder colored if colors are enabled for w
+func (t *TemplateEncoder) forWriter(w io.Writer) *TemplateEncoder {
+	enc := *t
+	enc.color = colorEnabled(w, t.opts.Color)
+	return &enc
+}
+
+// Begin opens fields object, the template is rendered in End
+func (t *TemplateEncoder) Begin(dst []byte, e *Entry) []byte {
+	return append(dst, '{')
+}
+
+func (t *TemplateEncoder) AddField(dst []byte, f *Field) []byte {
+	dst = t.appendKey(dst, f.Key)
+	if f.Buffer.Len() == 0 {
+		return append(dst, "null"...)
 	}
+	return append(dst, f.Buffer.Bytes()...)
+}
+
+func (t *TemplateEncoder) OpenObject(dst []byte, key string) []byte {
+	return append(t.appendKey(dst, key), '{')
+}
+
+func (t *TemplateEncoder) appendKey(dst []byte, key string) []byte {
+	if !t.color || t.theme.Key == "" {
+		return appendKeySep(dst, key, t.opts.FieldsSeparator)
+	}
+	if n := len(dst); n > 0 && dst[n-1] != '{' {
+		dst = append(dst, ',')
+	}
+	start := len(dst)
+	dst = appendColored(AppendString(dst, key), start, t.theme.Key)
+	return append(dst, t.opts.FieldsSeparator)
+}
+
+func (t *TemplateEncoder) CloseObject(dst []byte) []byte {
+	return append(dst, '}')
+}
+
+// End renders template replacing dst with the result
+func (t *TemplateEncoder) End(dst []byte, e *Entry) []byte {
+	fields := append(dst, '}')
+	out := bytesPool.Get().(*[]byte)
+	*out = t.render((*out)[:0], e, fields)
+	dst = append(fields[:0], *out...)
+	bytesPool.Put(out)
+	return dst
 }
 
 func defaultTemplateOptions() *TemplateOptions {
+	elapsed := &elapsedTag{}
 	return &TemplateOptions{
 		ErrHandle:       os.Stderr,
 		OutHandle:       os.Stdout,
@@ -211,35 +213,21 @@ func defaultTemplateOptions() *TemplateOptions {
 		ErrorName:       "error",
 		FieldsStyle:     "json",
 		FieldsSeparator: ':',
-		upperTags:       make(map[string]bool),
+		Tags:            builtinTags(elapsed),
+		elapsed:         elapsed,
+		present:         builtinPresent(),
 	}
 }
 
+// GetEntryFields appends json object of notepad, logger and entry
+// fields using sep as key-value separator
 func GetEntryFields(e *Entry, buf []byte, sep byte) []byte {
-
-	// fmt.Printf("%#v\n", e.Logger.Notepad.Context)
-	// fmt.Printf("%#v\n", e.Logger.Context)
-	// fmt.Printf("%#v\n", e.Data)
+	enc := TemplateEncoder{opts: &TemplateOptions{FieldsSeparator: sep}}
 	buf = append(buf, '{')
-	buf = addFld(e.Logger.Notepad.Context, buf, sep)
-	buf = addFld(e.Logger.Context, buf, sep)
-	buf = addFld(e.Data, buf, sep)
-	buf = append(buf, '}')
-	return buf
-}
-
-func addFld(data []Field, buf []byte, sep byte) []byte {
-	for i, _ := range data {
-		buf = append(buf, '"')
-		buf = append(buf, Str2Bytes(data[i].Key)...)
-		buf = append(buf, '"')
-		buf = append(buf, sep)
-		buf = append(buf, data[i].Buffer.Bytes()...)
-		if i < len(data)-1 {
-			buf = append(buf, ',')
-		}
-	}
-	return buf
+	buf = encodeFields(&enc, buf, e.Logger.Notepad.Context)
+	buf = encodeFields(&enc, buf, e.Logger.Context)
+	buf = encodeFields(&enc, buf, e.Data)
+	return append(buf, '}')
 }
 
 // func stringInSlice(a string, list []string) (int, bool) {
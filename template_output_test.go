@@ -0,0 +1,136 @@
+package qlog_test
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karantin2020/qlog"
+	"github.com/karantin2020/qlog/qlogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate_Golden(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	np := qlog.New("golden", qlog.InfoLevel, qlog.Clock(clock.Now), qlog.TimeFormat("15:04:05")).
+		SetOutput(qlog.MustTemplate("[${name}] ${time}\t${LEVEL}\t${message}\t${fields}\n",
+			func(o *qlog.TemplateOptions) error {
+				o.OutHandle = out
+				o.ErrHandle = out
+				return nil
+			}))
+	np.Info("first")
+	np.INFO.Fields(qlog.F{Key: "k", Value: "v"}).Msg("second")
+
+	assert.Equal(t, "[golden] 03:04:05\tINFO\tfirst\t{}\n"+
+		"[golden] 03:04:06\tINFO\tsecond\t{\"k\":\"v\"}\n", out.String())
+}
+
+func TestTemplate_Syntax(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"align", "[${level:<6}][${LEVEL:>6}][${name:^8}]", "[warn  ][  WARN][ golden ]"},
+		{"truncate", "${name:.3}|${message:>6.2}", "gol|    us"},
+		{"time layout", "${time:15:04:05.000} ${time:Unix}", "03:04:05.006 1514862245"},
+		{"field", "${fields.user_id}/${fields.n:>3}/${FIELDS.user_id}", "john doe/ 42/JOHN DOE"},
+		{"rest", "${fields.user_id} ${fields.-}", "john doe {\"app\":\"api\",\"n\":42}"},
+		{"default", "${fields.missing|none} ${error|ok:>4}", "none   ok"},
+		{"mismatched close", "${?fields.user_id}user=${fields.user_id}${/}${!error}no error${/fields}", ""},
+		{"sections", "${?fields.user_id}user=${fields.user_id}${/}${?error} err${/error}${!error} no error${/}", "user=john doe no error"},
+		{"nested", "${?fields.n}n${?fields.missing}m${/}${/}", "n"},
+		{"escape", "$${message} ${message}", "${message} user created"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			fn, err := qlog.Template(tt.template+"\n", func(o *qlog.TemplateOptions) error {
+				o.OutHandle, o.ErrHandle = out, out
+				return nil
+			})
+			if tt.want == "" {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 6000000, time.UTC), time.Second)
+			np := qlog.New("golden", qlog.InfoLevel, qlog.Clock(clock.Now)).SetOutput(fn)
+			np.AddField(qlog.F{Key: "app", Value: "api"})
+			np.WARN.Fields(qlog.F{Key: "user_id", Value: "john doe"}, qlog.F{Key: "n", Value: 42}).Msg("user created")
+			assert.Equal(t, tt.want+"\n", out.String())
+		})
+	}
+}
+
+func TestTemplate_Errors(t *testing.T) {
+	for _, tmpl := range []string{
+		"${message",
+		"${}",
+		"${?error}err",
+		"text${/}",
+		"${level:<x}",
+		"${level:.-1}",
+		"${|default}",
+	} {
+		_, err := qlog.Template(tmpl)
+		assert.Error(t, err, tmpl)
+	}
+	assert.Panics(t, func() { qlog.MustTemplate("${message") })
+}
+
+func TestTemplate_SeqElapsed(t *testing.T) {
+	out := &bytes.Buffer{}
+	clock := qlogtest.NewClock(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), time.Second)
+	np := qlog.New("tags", qlog.InfoLevel, qlog.Clock(clock.Now)).SetOutput(
+		qlog.MustTemplate("${?seq}${seq} ${/}${?elapsed}${elapsed}${/}\n",
+			func(o *qlog.TemplateOptions) error {
+				o.OutHandle, o.ErrHandle = out, out
+				return nil
+			}))
+	clock.Set(time.Date(2018, 1, 2, 3, 4, 10, 0, time.UTC))
+	np.Info("first")
+	np.Info("second")
+	assert.Equal(t, "1 5s\n2 6s\n", out.String())
+}
+
+func TestTemplate_Tags(t *testing.T) {
+	out := &bytes.Buffer{}
+	fn, err := qlog.Template("${seq:>3} ${pid} ${caller} ${REGION|none}${?region} ${region}${/}\n",
+		func(o *qlog.TemplateOptions) error {
+			o.OutHandle, o.ErrHandle = out, out
+			return nil
+		},
+		qlog.TemplateTag("Region", func(dst []byte, e *qlog.Entry) []byte {
+			if len(e.Message) > 5 {
+				return dst
+			}
+			return append(dst, "eu-west"...)
+		}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	np := qlog.New("tags", qlog.InfoLevel).SetOutput(fn)
+	np.Info("first")
+	np.Info("second")
+
+	pid := strconv.Itoa(os.Getpid())
+	lines := strings.Split(out.String(), "\n")
+	if !assert.Len(t, lines, 3) {
+		return
+	}
+	assert.Regexp(t, `^  1 `+pid+` qlog/template_output_test.go:\d+ EU-WEST eu-west$`, lines[0])
+	assert.Regexp(t, `^  2 `+pid+` qlog/template_output_test.go:\d+ none$`, lines[1])
+
+	_, err = qlog.Template("${mesage}")
+	assert.EqualError(t, err, "qlog: template: unknown tag ${mesage}")
+	_, err = qlog.Template("${?goroutine}${goroutine}${/} ${hostname} ${elapsed}")
+	assert.NoError(t, err)
+}
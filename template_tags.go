@@ -0,0 +1,164 @@
+package qlog
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TagFunc renders custom template tag of entry e appending to dst
+type TagFunc func(dst []byte, e *Entry) []byte
+
+// TemplateTag registers custom template tag rendered by fn. Tag names
+// are case-insensitive. Presence checks ${?name} call fn, so tags with
+// side effects should not be checked.
+func TemplateTag(name string, fn TagFunc) func(*TemplateOptions) error {
+	return func(o *TemplateOptions) error {
+		if o.Tags == nil {
+			o.Tags = make(map[string]TagFunc)
+		}
+		name = strings.ToLower(name)
+		o.Tags[name] = fn
+		delete(o.present, name)
+		return nil
+	}
+}
+
+// builtinTags returns tags available in every template:
+//
+//	pid        process id
+//	hostname   host name
+//	goroutine  id of goroutine writing the entry
+//	seq        number of the entry written by the template, from 1
+//	elapsed    time since the template was added to a notepad, by the
+//	           notepad clock
+//	caller     dir/file.go:line of the first caller outside of qlog
+func builtinTags(elapsed *elapsedTag) map[string]TagFunc {
+	return map[string]TagFunc{
+		"pid":       PidTag(),
+		"hostname":  HostnameTag(),
+		"goroutine": GoroutineTag,
+		"seq":       SeqTag(),
+		"elapsed":   elapsed.render,
+		"caller":    CallerTag,
+	}
+}
+
+// builtinPresent returns built-in tags which are never empty. Presence
+// checks don't render them, so ${?seq} does not advance the sequence.
+func builtinPresent() map[string]bool {
+	return map[string]bool{"pid": true, "goroutine": true, "seq": true, "elapsed": true}
+}
+
+// PidTag returns tag rendering process id
+func PidTag() TagFunc {
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	return func(dst []byte, e *Entry) []byte {
+		return append(dst, pid...)
+	}
+}
+
+// HostnameTag returns tag rendering host name
+func HostnameTag() TagFunc {
+	host, _ := os.Hostname()
+	return func(dst []byte, e *Entry) []byte {
+		return append(dst, host...)
+	}
+}
+
+// GoroutineTag renders id of the current goroutine
+func GoroutineTag(dst []byte, e *Entry) []byte {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	// "goroutine 18 [running]:..."
+	b = b[len("goroutine "):]
+	for i, c := range b {
+		if c == ' ' {
+			return append(dst, b[:i]...)
+		}
+	}
+	return dst
+}
+
+// SeqTag returns tag rendering sequence number of entries written with
+// it, starting from 1
+func SeqTag() TagFunc {
+	var seq uint64
+	return func(dst []byte, e *Entry) []byte {
+		return strconv.AppendUint(dst, atomic.AddUint64(&seq, 1), 10)
+	}
+}
+
+// ElapsedTag returns tag rendering duration from start to entry time
+func ElapsedTag(start time.Time) TagFunc {
+	return func(dst []byte, e *Entry) []byte {
+		return append(dst, e.Time.Sub(start).String()...)
+	}
+}
+
+// elapsedTag renders duration since start. Start is set from the
+// notepad clock when the template is added to a notepad, or is the time
+// of the first entry otherwise.
+type elapsedTag struct {
+	start int64 // unix nanoseconds, 0 until set
+}
+
+func (t *elapsedTag) setStart(now time.Time) {
+	atomic.CompareAndSwapInt64(&t.start, 0, now.UnixNano())
+}
+
+func (t *elapsedTag) render(dst []byte, e *Entry) []byte {
+	t.setStart(e.Time)
+	d := time.Duration(e.Time.UnixNano() - atomic.LoadInt64(&t.start))
+	return append(dst, d.String()...)
+}
+
+// qlogPkg is the import path of the package with trailing dot
+var qlogPkg = reflect.TypeOf(Notepad{}).PkgPath() + "."
+
+// CallerTag renders dir/file.go:line of the first caller outside of
+// qlog and its adapters
+func CallerTag(dst []byte, e *Entry) []byte {
+	f, ok := callerFrame()
+	if !ok {
+		return dst
+	}
+	return strconv.AppendInt(append(append(dst, shortPath(f.File)...), ':'), int64(f.Line), 10)
+}
+
+// callerFrame returns the first frame outside of qlog and its adapters
+func callerFrame() (runtime.Frame, bool) {
+	var pcs [32]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	for {
+		f, more := frames.Next()
+		if !isQlogFrame(f.Function) {
+			return f, true
+		}
+		if !more {
+			return f, false
+		}
+	}
+}
+
+func isQlogFrame(fn string) bool {
+	pkg := qlogPkg[:len(qlogPkg)-1]
+	return strings.HasPrefix(fn, qlogPkg) ||
+		strings.HasPrefix(fn, pkg+"/adapter/") || strings.HasPrefix(fn, pkg+"/log.")
+}
+
+// shortPath returns the last directory and the file name of path
+func shortPath(path string) string {
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 {
+		return path
+	}
+	if j := strings.LastIndexByte(path[:i], '/'); j >= 0 {
+		return path[j+1:]
+	}
+	return path
+}
@@ -0,0 +1,16 @@
+//go:build linux
+// +build linux
+
+package qlog
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// isTerminalFd reports if fd is a terminal using TCGETS ioctl
+func isTerminalFd(fd uintptr) bool {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	return errno == 0
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package qlog
+
+// isTerminalFd reports false, terminals are detected on linux only
+func isTerminalFd(fd uintptr) bool {
+	return false
+}
@@ -0,0 +1,156 @@
+package qlog
+
+import (
+	"io"
+	"os"
+)
+
+// TextOptions configures Text output
+type TextOptions struct {
+	ErrHandle     io.Writer
+	OutHandle     io.Writer
+	ErrLevel      uint8
+	OutLevel      uint8
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+}
+
+func defaultTextOptions() *TextOptions {
+	return &TextOptions{
+		ErrHandle:     os.Stderr,
+		OutHandle:     os.Stdout,
+		ErrLevel:      ErrorLevel,
+		OutLevel:      InfoLevel,
+		LogName:       "name",
+		TimestampName: "time",
+		LevelName:     "level",
+		MessageName:   "message",
+	}
+}
+
+// Text returns notepad option adding outputs of TextEncoder, or error
+// of an option or invalid levels
+func Text(opts ...func(*TextOptions) error) (func(np *Notepad), error) {
+	options := defaultTextOptions()
+	for _, fn := range opts {
+		if err := fn(options); err != nil {
+			return nil, err
+		}
+	}
+	enc := &TextEncoder{
+		LogName:       options.LogName,
+		TimestampName: options.TimestampName,
+		LevelName:     options.LevelName,
+		MessageName:   options.MessageName,
+	}
+	return Encode(enc, func(o *OutputOptions) error {
+		o.OutHandle, o.ErrHandle = AddSync(options.OutHandle), AddSync(options.ErrHandle)
+		o.OutLevel, o.ErrLevel = options.OutLevel, options.ErrLevel
+		return nil
+	})
+}
+
+// MustText is like Text but panics if an option fails
+func MustText(opts ...func(*TextOptions) error) func(np *Notepad) {
+	fn, err := Text(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// TextEncoder encodes entries as logfmt lines of key=value pairs, e.g.
+//
+//	time=1514862245 level=info name=app message="user created" id=42
+//
+// Strings are quoted only if they contain spaces, quotes, '=' or
+// control characters. Nested objects are written as key={k=v k=v}. Keys
+// with empty names are omitted.
+type TextEncoder struct {
+	LogName       string
+	TimestampName string
+	LevelName     string
+	MessageName   string
+}
+
+// NewTextEncoder returns TextEncoder with the Text output key names
+func NewTextEncoder() *TextEncoder {
+	o := defaultTextOptions()
+	return &TextEncoder{
+		LogName:       o.LogName,
+		TimestampName: o.TimestampName,
+		LevelName:     o.LevelName,
+		MessageName:   o.MessageName,
+	}
+}
+
+func (t *TextEncoder) Begin(dst []byte, e *Entry) []byte {
+	if t.TimestampName != "" {
+		dst = appendTextValue(appendTextKey(dst, t.TimestampName), e.bufferTime)
+	}
+	if t.LevelName != "" {
+		dst = append(appendTextKey(dst, t.LevelName), e.Logger.Level.ToBytes()...)
+	}
+	if t.LogName != "" && len(e.Logger.Notepad.Name) > 0 {
+		dst = appendTextValue(appendTextKey(dst, t.LogName), e.Logger.Notepad.Name)
+	}
+	if t.MessageName != "" {
+		dst = appendTextValue(appendTextKey(dst, t.MessageName), e.Message)
+	}
+	return dst
+}
+
+func (t *TextEncoder) AddField(dst []byte, f *Field) []byte {
+	dst = appendTextKey(dst, f.Key)
+	v := f.Buffer.Bytes()
+	switch {
+	case len(v) == 0:
+		return append(dst, "null"...)
+	case v[0] == '"':
+		if s, ok := f.Value.(string); ok && !needsQuote(Str2Bytes(s)) {
+			return append(dst, s...)
+		}
+	}
+	return append(dst, v...)
+}
+
+func (t *TextEncoder) OpenObject(dst []byte, key string) []byte {
+	return append(appendTextKey(dst, key), '{')
+}
+
+func (t *TextEncoder) CloseObject(dst []byte) []byte {
+	return append(dst, '}')
+}
+
+func (t *TextEncoder) End(dst []byte, e *Entry) []byte {
+	return append(dst, '\n')
+}
+
+func appendTextKey(dst []byte, key string) []byte {
+	if n := len(dst); n > 0 && dst[n-1] != '{' {
+		dst = append(dst, ' ')
+	}
+	return append(append(dst, key...), '=')
+}
+
+// appendTextValue appends v, quoted if needed
+func appendTextValue(dst, v []byte) []byte {
+	if needsQuote(v) {
+		return AppendBytes(dst, v)
+	}
+	return append(dst, v...)
+}
+
+func needsQuote(v []byte) bool {
+	if len(v) == 0 {
+		return true
+	}
+	for _, c := range v {
+		if c <= ' ' || c == '"' || c == '=' || c == '\\' || c == '{' || c == '}' || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
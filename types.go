@@ -1,16 +1,6 @@
 package qlog
 
-import (
-	"io"
-)
-
-type FormatWriter interface {
-	io.Writer
-	Format(*Entry)
-}
-
 type Formatter func(*Entry)
-type Hook func(*Entry)
 type Output func(*Entry) // func(http.Handler) http.Handler
 
 // FlatMapS structure to store map[string]string-like data